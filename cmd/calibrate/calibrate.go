@@ -0,0 +1,179 @@
+// The calibrate command times bytecode instructions and core builtins on
+// the host and writes a step cost model which makes the steps counted by
+// Starlark threads roughly proportional to the time taken. The model may be
+// loaded with starlark.ReadStepCostModel and applied to a thread with
+// Thread.SetStepCostModel.
+//
+// Each instruction or builtin is timed by running a loop whose body uses it,
+// and subtracting the time taken by a similar loop which does not. The unit
+// of cost is the mean time taken per step by the baseline loop, so that
+// unweighted programs keep roughly the same step counts. As timings vary
+// with load, calibrate should be run on an otherwise idle host.
+//
+// Usage:
+//
+//	calibrate [-o model.json] [-n iterations] [-runs runs]
+package main // import "github.com/canonical/starlark/cmd/calibrate"
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+var (
+	output     = flag.String("o", "", "write model to `file` rather than standard output")
+	iterations = flag.Int("n", 1_000_000, "number of loop `iterations` per timing")
+	runs       = flag.Int("runs", 5, "number of timings of each case, of which the fastest is used")
+)
+
+// A calibration measures the cost of an instruction or builtin by timing a
+// statement which uses it against a baseline which does not.
+type calibration struct {
+	name     string
+	builtin  bool
+	setup    string
+	stmt     string
+	baseline string
+}
+
+var calibrations = []calibration{
+	{name: "plus", setup: "a, b = 1, 2", stmt: "a + b", baseline: "a; b"},
+	{name: "minus", setup: "a, b = 1, 2", stmt: "a - b", baseline: "a; b"},
+	{name: "star", setup: "a, b = 3, 5", stmt: "a * b", baseline: "a; b"},
+	{name: "slash", setup: "a, b = 3.0, 5.0", stmt: "a / b", baseline: "a; b"},
+	{name: "slashslash", setup: "a, b = 3, 5", stmt: "a // b", baseline: "a; b"},
+	{name: "percent", setup: "a, b = 3, 5", stmt: "a % b", baseline: "a; b"},
+	{name: "lt", setup: "a, b = 1, 2", stmt: "a < b", baseline: "a; b"},
+	{name: "eql", setup: "a, b = 1, 2", stmt: "a == b", baseline: "a; b"},
+	{name: "not", setup: "a = 1", stmt: "not a", baseline: "a"},
+	{name: "uminus", setup: "a = 1", stmt: "-a", baseline: "a"},
+	{name: "in", setup: "a, l = 3, [1, 2, 3]", stmt: "a in l", baseline: "a; l"},
+	{name: "index", setup: "a, l = 0, [1, 2, 3]", stmt: "l[a]", baseline: "l; a"},
+	{name: "slice", setup: "l = [1, 2, 3]", stmt: "l[1:2]", baseline: "l"},
+	{name: "attr", setup: `s = "abc"`, stmt: "s.upper", baseline: "s"},
+	{name: "makelist", setup: "a = 1", stmt: "[a]", baseline: "a"},
+	{name: "maketuple", setup: "a, b = 1, 2", stmt: "(a, b)", baseline: "a; b"},
+	{name: "makedict", setup: "a = 1", stmt: "{}", baseline: "a"},
+	{name: "call", setup: "def f(): pass", stmt: "f()", baseline: "f"},
+
+	{name: "len", builtin: true, setup: "l = [1, 2, 3]", stmt: "len(l)", baseline: "l"},
+	{name: "str", builtin: true, setup: "a = 123", stmt: "str(a)", baseline: "a"},
+	{name: "int", builtin: true, setup: `s = "123"`, stmt: "int(s)", baseline: "s"},
+	{name: "range", builtin: true, setup: "a = 10", stmt: "range(a)", baseline: "a"},
+	{name: "sorted", builtin: true, setup: "l = [3, 1, 2]", stmt: "sorted(l)", baseline: "l"},
+	{name: "list.append", builtin: true, setup: "l = []", stmt: "l.append(1)", baseline: "l"},
+	{name: "dict.get", builtin: true, setup: `d = {"a": 1}`, stmt: `d.get("a")`, baseline: "d"},
+	{name: "string.upper", builtin: true, setup: `s = "abc"`, stmt: "s.upper()", baseline: "s"},
+	{name: "string.split", builtin: true, setup: `s = "a b c"`, stmt: "s.split()", baseline: "s"},
+}
+
+func main() {
+	log.SetPrefix("calibrate: ")
+	log.SetFlags(0)
+	flag.Parse()
+
+	model, err := calibrate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := model.Write(w); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func calibrate() (*starlark.StepCostModel, error) {
+	// The unit of cost is the time per step of the simplest loop.
+	unit, err := measure("", "a", "a = 1")
+	if err != nil {
+		return nil, err
+	}
+	nsPerStep := unit.nanos / unit.steps
+	log.Printf("%.2fns per step", nsPerStep)
+
+	model := &starlark.StepCostModel{
+		Opcodes:  make(map[string]int64),
+		Builtins: make(map[string]int64),
+	}
+	for _, c := range calibrations {
+		with, err := measure(c.name, c.stmt, c.setup)
+		if err != nil {
+			return nil, err
+		}
+		without, err := measure(c.name, c.baseline, c.setup)
+		if err != nil {
+			return nil, err
+		}
+		steps := int64(math.Round((with.nanos - without.nanos) / nsPerStep))
+
+		if c.builtin {
+			// The builtin's call already counts the steps of its own
+			// instructions and any it adds itself.
+			extra := steps - int64(math.Round(with.steps-without.steps))
+			if extra < 0 {
+				extra = 0
+			}
+			model.Builtins[c.name] = extra
+		} else {
+			// Every instruction costs at least a step.
+			if steps < 1 {
+				steps = 1
+			}
+			model.Opcodes[c.name] = steps
+		}
+	}
+	return model, nil
+}
+
+// A timing records the mean time taken and steps counted per iteration of
+// a loop.
+type timing struct {
+	nanos, steps float64
+}
+
+// measure times a loop whose body is stmt after running setup, returning
+// the fastest of several runs.
+func measure(name, stmt, setup string) (timing, error) {
+	src := fmt.Sprintf("def bench(n):\n\t%s\n\tfor _ in range(n):\n\t\t%s\n", setup, stmt)
+	globals, err := starlark.ExecFile(&starlark.Thread{}, name+".star", src, nil)
+	if err != nil {
+		return timing{}, err
+	}
+	bench := globals["bench"]
+	n := starlark.MakeInt(*iterations)
+
+	best := timing{nanos: math.Inf(1)}
+	for i := 0; i < *runs; i++ {
+		thread := &starlark.Thread{}
+		start := time.Now()
+		if _, err := starlark.Call(thread, bench, starlark.Tuple{n}, nil); err != nil {
+			return timing{}, fmt.Errorf("%s: %v", name, err)
+		}
+		elapsed := time.Since(start)
+		steps, _ := thread.Steps()
+
+		t := timing{
+			nanos: float64(elapsed.Nanoseconds()) / float64(*iterations),
+			steps: float64(steps) / float64(*iterations),
+		}
+		if t.nanos < best.nanos {
+			best = t
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,223 @@
+// The safetylint command reports Go builtins which declare a safety they do
+// not respect, such as IOSafe builtins which open files. It is run by go vet:
+//
+//	go install github.com/canonical/starlark/cmd/safetylint
+//	go vet -vettool=$(which safetylint) ./...
+//
+// See package github.com/canonical/starlark/safetylint for the checks made.
+package main // import "github.com/canonical/starlark/cmd/safetylint"
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/canonical/starlark/safetylint"
+)
+
+var (
+	version    = flag.String("V", "", "print version and exit")
+	printFlags = flag.Bool("flags", false, "print flags as JSON and exit")
+	jsonOutput = flag.Bool("json", false, "print diagnostics as JSON")
+)
+
+// config is the description of a package which go vet passes to its
+// vettool, as a JSON file.
+type config struct {
+	ID                        string
+	Compiler                  string
+	Dir                       string
+	ImportPath                string
+	GoFiles                   []string
+	ImportMap                 map[string]string
+	PackageFile               map[string]string
+	VetxOnly                  bool
+	VetxOutput                string
+	SucceedOnTypecheckFailure bool
+	Stdout                    string
+}
+
+func main() {
+	log.SetPrefix("safetylint: ")
+	log.SetFlags(0)
+	flag.Parse()
+
+	switch {
+	case *version != "":
+		printVersion()
+		return
+	case *printFlags:
+		// No flags are accepted beyond those which go vet requires.
+		fmt.Println("[]")
+		return
+	}
+	if flag.NArg() != 1 || filepath.Ext(flag.Arg(0)) != ".cfg" {
+		log.Fatal("usage: go vet -vettool=$(which safetylint) [package...]")
+	}
+
+	cfg, err := readConfig(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// No facts are exported, but go vet expects the file to be written.
+	if cfg.VetxOutput != "" {
+		if err := os.WriteFile(cfg.VetxOutput, nil, 0666); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cfg.VetxOnly {
+		return
+	}
+
+	fset := token.NewFileSet()
+	diagnostics, err := check(fset, cfg)
+	if err != nil && cfg.SucceedOnTypecheckFailure {
+		return
+	}
+	if *jsonOutput {
+		if err := printJSON(cfg, fset, diagnostics, err); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, diag := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", fset.Position(diag.Pos), diag.Message)
+	}
+	if len(diagnostics) > 0 {
+		os.Exit(1)
+	}
+}
+
+// printJSON prints the diagnostics for the package described by cfg, or
+// the error which prevented them from being found, in the form which go vet
+// expects: a map from package ID to analysis name to either a list of
+// diagnostics or an error. They are written to the file named by cfg.Stdout,
+// if any, and otherwise to the standard output.
+func printJSON(cfg *config, fset *token.FileSet, diagnostics []safetylint.Diagnostic, err error) error {
+	type jsonDiagnostic struct {
+		Posn    string `json:"posn"`
+		End     string `json:"end"`
+		Message string `json:"message"`
+	}
+	type jsonError struct {
+		Err string `json:"error"`
+	}
+
+	var result interface{}
+	if err != nil {
+		result = jsonError{err.Error()}
+	} else if len(diagnostics) > 0 {
+		diags := make([]jsonDiagnostic, 0, len(diagnostics))
+		for _, diag := range diagnostics {
+			posn := fset.Position(diag.Pos).String()
+			diags = append(diags, jsonDiagnostic{Posn: posn, End: posn, Message: diag.Message})
+		}
+		result = diags
+	}
+
+	var data []byte
+	if result != nil {
+		tree := map[string]map[string]interface{}{cfg.ID: {"safetylint": result}}
+		if data, err = json.Marshal(tree); err != nil {
+			return err
+		}
+	}
+	if cfg.Stdout != "" {
+		return os.WriteFile(cfg.Stdout, data, 0666)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// printVersion prints the version line by which go vet caches results,
+// which must change whenever the executable does.
+func printVersion() {
+	progname, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(progname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s version devel comments-go-here buildID=%02x\n", filepath.Base(progname), string(h.Sum(nil)))
+}
+
+func readConfig(filename string) (*config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(config)
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// check type-checks the package described by cfg and runs the safety
+// checks over it.
+func check(fset *token.FileSet, cfg *config) ([]safetylint.Diagnostic, error) {
+	var files []*ast.File
+	for _, name := range cfg.GoFiles {
+		file, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	compiled := importer.ForCompiler(fset, cfg.Compiler, func(path string) (io.ReadCloser, error) {
+		file, ok := cfg.PackageFile[path]
+		if !ok {
+			return nil, fmt.Errorf("no package file for %q", path)
+		}
+		return os.Open(file)
+	})
+	imports := importerFunc(func(path string) (*types.Package, error) {
+		resolved, ok := cfg.ImportMap[path]
+		if !ok {
+			return nil, fmt.Errorf("cannot resolve import %q", path)
+		}
+		return compiled.Import(resolved)
+	})
+
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	tc := &types.Config{Importer: imports, Sizes: types.SizesFor(cfg.Compiler, build.Default.GOARCH)}
+	pkg, err := tc.Check(cfg.ImportPath, fset, files, info)
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics := safetylint.Check(pkg, files, info, safetylint.DefaultRules)
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Pos < diagnostics[j].Pos })
+	return diagnostics, nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
@@ -0,0 +1,113 @@
+// The stardiff command runs a corpus of Starlark scripts and compares the
+// results with those of another implementation, to detect unintended
+// divergence from go.starlark.net.
+//
+// Usage:
+//
+//	stardiff [-o transcript] [-against reference] file.star...
+//
+// Each script is run in a fresh thread with no predeclared values other than
+// the universe. Scripts are identified in transcripts by their base name.
+// With -o, the results are written as a transcript. With -against, they are
+// compared with a reference transcript produced by another implementation
+// and each divergence is reported, in which case the exit status is 1.
+//
+// A reference transcript for go.starlark.net is produced by the program in
+// the upstream directory. It is a separate module, so that this one does not
+// depend on go.starlark.net:
+//
+//	cd upstream && go get go.starlark.net/starlark && go run . -o ../upstream.json ../testdata/*.star
+//	go run . -against upstream.json testdata/*.star
+package main // import "github.com/canonical/starlark/cmd/stardiff"
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/starlark/internal/difftest"
+	"github.com/canonical/starlark/starlark"
+)
+
+var (
+	output  = flag.String("o", "", "write transcript to `file`")
+	against = flag.String("against", "", "compare results with the transcript in `file`")
+)
+
+func main() {
+	log.SetPrefix("stardiff: ")
+	log.SetFlags(0)
+	flag.Parse()
+
+	results := make([]difftest.Result, 0, flag.NArg())
+	for _, file := range flag.Args() {
+		results = append(results, run(file))
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := difftest.WriteTranscript(f, results); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *against != "" {
+		f, err := os.Open(*against)
+		if err != nil {
+			log.Fatal(err)
+		}
+		want, err := difftest.ReadTranscript(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		diffs := difftest.Diff(results, want)
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// run runs a single script.
+func run(file string) difftest.Result {
+	result := difftest.Result{File: filepath.Base(file)}
+
+	var out strings.Builder
+	thread := &starlark.Thread{
+		Name: "stardiff",
+		Print: func(_ *starlark.Thread, msg string) {
+			out.WriteString(msg)
+			out.WriteByte('\n')
+		},
+	}
+	globals, err := starlark.ExecFile(thread, file, nil, nil)
+	result.Output = out.String()
+	if err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			result.Error = evalErr.Msg
+		} else {
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Globals = make(map[string]string, len(globals))
+	for name, value := range globals {
+		result.Globals[name] = value.String()
+	}
+	return result
+}
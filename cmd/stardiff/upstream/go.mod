@@ -0,0 +1,7 @@
+module github.com/canonical/starlark/cmd/stardiff/upstream
+
+go 1.18
+
+require github.com/canonical/starlark v0.0.0
+
+replace github.com/canonical/starlark => ../../..
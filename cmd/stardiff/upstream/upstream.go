@@ -0,0 +1,82 @@
+// The upstream command runs a corpus of Starlark scripts with
+// go.starlark.net and writes a transcript of the results, for comparison by
+// stardiff with those of this implementation.
+//
+// Usage:
+//
+//	upstream -o transcript file.star...
+//
+// This program must run scripts exactly as stardiff does. It is a separate
+// module so that go.starlark.net is not a dependency of this one; add it
+// with go get go.starlark.net/starlark before building.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/starlark/internal/difftest"
+	"go.starlark.net/starlark"
+)
+
+var output = flag.String("o", "", "write transcript to `file`")
+
+func main() {
+	log.SetPrefix("upstream: ")
+	log.SetFlags(0)
+	flag.Parse()
+
+	results := make([]difftest.Result, 0, flag.NArg())
+	for _, file := range flag.Args() {
+		results = append(results, run(file))
+	}
+
+	if *output == "" {
+		log.Fatal("no transcript file given")
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := difftest.WriteTranscript(f, results); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs a single script.
+func run(file string) difftest.Result {
+	result := difftest.Result{File: filepath.Base(file)}
+
+	var out strings.Builder
+	thread := &starlark.Thread{
+		Name: "stardiff",
+		Print: func(_ *starlark.Thread, msg string) {
+			out.WriteString(msg)
+			out.WriteByte('\n')
+		},
+	}
+	globals, err := starlark.ExecFile(thread, file, nil, nil)
+	result.Output = out.String()
+	if err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			result.Error = evalErr.Msg
+		} else {
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Globals = make(map[string]string, len(globals))
+	for name, value := range globals {
+		result.Globals[name] = value.String()
+	}
+	return result
+}
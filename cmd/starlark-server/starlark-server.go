@@ -0,0 +1,258 @@
+// The starlark-server command serves a JSON-over-HTTP API for evaluating
+// Starlark programs under resource limits.
+//
+// Each request to /eval submits the source of a Starlark file together with
+// a set of JSON-encoded inputs. The inputs are made available to the program
+// as predeclared globals and, once execution completes, the requested globals
+// are JSON-encoded and returned. Every request runs on its own thread which
+// requires full safety and is bounded by a step budget, an allocation budget
+// and a timeout.
+//
+// Request:
+//
+//	{
+//	    "filename": "main.star",        // optional, used in error messages
+//	    "source": "y = x + 1",
+//	    "inputs": {"x": 41},            // optional
+//	    "outputs": ["y"],               // optional, defaults to all public globals
+//	    "max_steps": 10000,             // optional, capped by -max_steps
+//	    "max_allocs": 1000000,          // optional, capped by -max_allocs
+//	    "timeout_ms": 100               // optional, capped by -timeout
+//	}
+//
+// Response:
+//
+//	{
+//	    "outputs": {"y": 42},
+//	    "steps": 4,
+//	    "allocs": 64,
+//	    "error": "..."                  // present only on failure
+//	}
+//
+// Server metrics are published through expvar at /debug/vars.
+package main // import "github.com/canonical/starlark/cmd/starlark-server"
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	starlarkjson "github.com/canonical/starlark/lib/json"
+	"github.com/canonical/starlark/lib/math"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/syntax"
+)
+
+// flags
+var (
+	addr           = flag.String("addr", "localhost:8080", "listen on `address`")
+	maxSteps       = flag.Int64("max_steps", 1_000_000, "maximum steps a single request may execute")
+	maxAllocs      = flag.Int64("max_allocs", 64<<20, "maximum bytes a single request may allocate")
+	maxSourceBytes = flag.Int64("max_source", 1<<20, "maximum size in bytes of a request body")
+	timeout        = flag.Duration("timeout", 5*time.Second, "maximum wall time of a single request")
+)
+
+// metrics
+var (
+	requestsTotal    = expvar.NewInt("requests_total")
+	requestsFailed   = expvar.NewInt("requests_failed")
+	requestsRejected = expvar.NewInt("requests_rejected")
+	stepsTotal       = expvar.NewInt("steps_total")
+	allocsTotal      = expvar.NewInt("allocs_total")
+	evalNanosTotal   = expvar.NewInt("eval_nanoseconds_total")
+)
+
+const safe = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+
+var fileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+var (
+	jsonEncode = starlarkjson.Module.Members["encode"]
+	jsonDecode = starlarkjson.Module.Members["decode"]
+)
+
+type evalRequest struct {
+	Filename  string                     `json:"filename"`
+	Source    string                     `json:"source"`
+	Inputs    map[string]json.RawMessage `json:"inputs"`
+	Outputs   []string                   `json:"outputs"`
+	MaxSteps  int64                      `json:"max_steps"`
+	MaxAllocs int64                      `json:"max_allocs"`
+	TimeoutMS int64                      `json:"timeout_ms"`
+}
+
+type evalResponse struct {
+	Outputs map[string]json.RawMessage `json:"outputs,omitempty"`
+	Steps   int64                      `json:"steps"`
+	Allocs  int64                      `json:"allocs"`
+	Error   string                     `json:"error,omitempty"`
+}
+
+func main() {
+	log.SetPrefix("starlark-server: ")
+	log.SetFlags(log.LstdFlags)
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		log.Print("unexpected arguments")
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eval", handleEval)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func handleEval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		requestsRejected.Add(1)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req evalRequest
+	body := http.MaxBytesReader(w, r.Body, *maxSourceBytes)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		requestsRejected.Add(1)
+		if isBodyTooLarge(err) {
+			msg := fmt.Sprintf("request body exceeds %d bytes", *maxSourceBytes)
+			http.Error(w, msg, http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	requestsTotal.Add(1)
+	resp := eval(r.Context(), &req)
+	if resp.Error != "" {
+		requestsFailed.Add(1)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("cannot write response: %v", err)
+	}
+}
+
+// limit returns requested if it is positive and no greater than max,
+// otherwise max.
+func limit(requested, max int64) int64 {
+	if requested > 0 && requested < max {
+		return requested
+	}
+	return max
+}
+
+func eval(ctx context.Context, req *evalRequest) *evalResponse {
+	resp := &evalResponse{}
+
+	filename := req.Filename
+	if filename == "" {
+		filename = "<request>"
+	}
+
+	requestTimeout := *timeout
+	if req.TimeoutMS > 0 {
+		if d := time.Duration(req.TimeoutMS) * time.Millisecond; d < requestTimeout {
+			requestTimeout = d
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	thread := &starlark.Thread{Name: "eval " + filename}
+	thread.SetParentContext(ctx)
+	thread.RequireSafety(safe)
+	thread.SetMaxSteps(limit(req.MaxSteps, *maxSteps))
+	thread.SetMaxAllocs(limit(req.MaxAllocs, *maxAllocs))
+	thread.Print = func(*starlark.Thread, string) {}
+
+	start := time.Now()
+	defer func() {
+		evalNanosTotal.Add(int64(time.Since(start)))
+		if steps, ok := thread.Steps(); ok {
+			resp.Steps = steps
+			stepsTotal.Add(steps)
+		}
+		if allocs, ok := thread.Allocs(); ok {
+			resp.Allocs = allocs
+			allocsTotal.Add(allocs)
+		}
+	}()
+
+	predeclared := starlark.StringDict{
+		"json": starlarkjson.Module,
+		"math": math.Module,
+	}
+	for name, raw := range req.Inputs {
+		if _, ok := predeclared[name]; ok {
+			resp.Error = fmt.Sprintf("input %s shadows a predeclared module", name)
+			return resp
+		}
+		v, err := starlark.Call(thread, jsonDecode, starlark.Tuple{starlark.String(raw)}, nil)
+		if err != nil {
+			resp.Error = fmt.Sprintf("input %s: %v", name, err)
+			return resp
+		}
+		v.Freeze()
+		predeclared[name] = v
+	}
+
+	globals, err := starlark.ExecFileOptions(fileOptions, thread, filename, req.Source, predeclared)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			resp.Error = evalErr.Backtrace()
+		} else {
+			resp.Error = err.Error()
+		}
+		return resp
+	}
+
+	names := req.Outputs
+	if names == nil {
+		for _, name := range globals.Keys() {
+			if strings.HasPrefix(name, "_") {
+				continue
+			}
+			if _, ok := globals[name].(starlark.Callable); ok {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	outputs := make(map[string]json.RawMessage, len(names))
+	for _, name := range names {
+		v, ok := globals[name]
+		if !ok {
+			resp.Error = fmt.Sprintf("output %s is not defined", name)
+			return resp
+		}
+		encoded, err := starlark.Call(thread, jsonEncode, starlark.Tuple{v}, nil)
+		if err != nil {
+			resp.Error = fmt.Sprintf("output %s: %v", name, err)
+			return resp
+		}
+		outputs[name] = json.RawMessage(encoded.(starlark.String))
+	}
+	resp.Outputs = outputs
+	return resp
+}
@@ -0,0 +1,11 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+// isBodyTooLarge reports whether err was returned by a reader created by
+// http.MaxBytesReader because the request body exceeded its limit. Before
+// Go 1.19, this error can only be recognised by its message.
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
@@ -0,0 +1,16 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// isBodyTooLarge reports whether err was returned by a reader created by
+// http.MaxBytesReader because the request body exceeded its limit.
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
@@ -22,16 +22,20 @@ import (
 	"github.com/canonical/starlark/repl"
 	"github.com/canonical/starlark/resolve"
 	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/syntax"
 	"golang.org/x/term"
 )
 
 // flags
 var (
-	cpuprofile = flag.String("cpuprofile", "", "gather Go CPU profile in this file")
-	memprofile = flag.String("memprofile", "", "gather Go memory profile in this file")
-	profile    = flag.String("profile", "", "gather Starlark time profile in this file")
-	showenv    = flag.Bool("showenv", false, "on success, print final global environment")
-	execprog   = flag.String("c", "", "execute program `prog`")
+	cpuprofile  = flag.String("cpuprofile", "", "gather Go CPU profile in this file")
+	memprofile  = flag.String("memprofile", "", "gather Go memory profile in this file")
+	profile     = flag.String("profile", "", "gather Starlark time profile in this file")
+	showenv     = flag.Bool("showenv", false, "on success, print final global environment")
+	execprog    = flag.String("c", "", "execute program `prog`")
+	strbuilder  = flag.Bool("strbuilder", false, "allow strbuilder data type")
+	sliceassign = flag.Bool("sliceassign", false, "allow assignment to and deletion of list slices")
+	starassign  = flag.Bool("starassign", false, "allow starred targets in assignments and for loops")
 )
 
 func init() {
@@ -90,7 +94,11 @@ func doMain() int {
 		}()
 	}
 
-	thread := &starlark.Thread{Load: repl.MakeLoad()}
+	opts := syntax.LegacyFileOptions()
+	opts.StrBuilder = *strbuilder
+	opts.SliceAssign = *sliceassign
+	opts.StarAssign = *starassign
+	thread := &starlark.Thread{Load: repl.MakeLoadOptions(opts)}
 	globals := make(starlark.StringDict)
 
 	// Ideally this statement would update the predeclared environment.
@@ -115,7 +123,7 @@ func doMain() int {
 			filename = flag.Arg(0)
 		}
 		thread.Name = "exec " + filename
-		globals, err = starlark.ExecFile(thread, filename, src, nil)
+		globals, err = starlark.ExecFileOptions(opts, thread, filename, src, nil)
 		if err != nil {
 			repl.PrintError(err)
 			return 1
@@ -126,7 +134,7 @@ func doMain() int {
 			fmt.Println("Welcome to Starlark (github.com/canonical/starlark)")
 		}
 		thread.Name = "REPL"
-		repl.REPL(thread, globals)
+		repl.REPLOptions(opts, thread, globals)
 		if stdinIsTerminal {
 			fmt.Println()
 		}
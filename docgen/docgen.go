@@ -0,0 +1,178 @@
+// Package docgen generates reference documentation for Starlark modules
+// from their docstrings and comments.
+package docgen // import "github.com/canonical/starlark/docgen"
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+	"github.com/canonical/starlark/syntax"
+)
+
+// Markdown writes Markdown reference documentation for the given file to w.
+//
+// The document is headed by the file's docstring, followed by a section for
+// each public top-level function and for each public global which is
+// assigned once at top level. A function is documented by its docstring or,
+// failing that, by its leading comments; a global is documented by its
+// leading comments. Names beginning with an underscore are omitted.
+//
+// Comments are available only if the file was parsed with
+// syntax.RetainComments.
+func Markdown(w io.Writer, f *syntax.File) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n", path.Base(f.Path))
+	if doc, ok := f.DocString(); ok {
+		fmt.Fprintf(&b, "\n%s\n", trimDoc(doc.Value))
+	}
+
+	var funcs []*syntax.DefStmt
+	var globals []*syntax.AssignStmt
+	assigned := make(map[string]int)
+	for _, stmt := range f.Stmts {
+		switch stmt := stmt.(type) {
+		case *syntax.DefStmt:
+			if isPublic(stmt.Name.Name) {
+				funcs = append(funcs, stmt)
+			}
+		case *syntax.AssignStmt:
+			if id, ok := stmt.LHS.(*syntax.Ident); ok && isPublic(id.Name) {
+				if assigned[id.Name] == 0 {
+					globals = append(globals, stmt)
+				}
+				assigned[id.Name]++
+			}
+		}
+	}
+
+	if len(funcs) > 0 {
+		b.WriteString("\n## Functions\n")
+		for _, def := range funcs {
+			fmt.Fprintf(&b, "\n### `%s(%s)`\n", def.Name.Name, params(def.Params))
+			if doc, ok := def.DocString(); ok {
+				fmt.Fprintf(&b, "\n%s\n", trimDoc(doc.Value))
+			} else if comments := syntax.LeadingComments(def); len(comments) > 0 {
+				fmt.Fprintf(&b, "\n%s\n", syntax.CommentText(comments))
+			}
+		}
+	}
+
+	var documented []*syntax.AssignStmt
+	for _, stmt := range globals {
+		if assigned[stmt.LHS.(*syntax.Ident).Name] == 1 {
+			documented = append(documented, stmt)
+		}
+	}
+	if len(documented) > 0 {
+		b.WriteString("\n## Globals\n")
+		for _, stmt := range documented {
+			fmt.Fprintf(&b, "\n### `%s`\n", stmt.LHS.(*syntax.Ident).Name)
+			if comments := syntax.LeadingComments(stmt); len(comments) > 0 {
+				fmt.Fprintf(&b, "\n%s\n", syntax.CommentText(comments))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ModuleMarkdown writes Markdown reference documentation for the builtins
+// of a Go module to w, so that script authors can see what each builtin
+// declares about its safety and, if it declares a starlark.CostSpec, how its
+// cost grows with its input. Builtins are listed in order of name.
+func ModuleMarkdown(w io.Writer, module *starlarkstruct.Module) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n", module.Name)
+
+	var builtins []*starlark.Builtin
+	for _, name := range module.Members.Keys() {
+		if builtin, ok := module.Members[name].(*starlark.Builtin); ok {
+			builtins = append(builtins, builtin)
+		}
+	}
+	sort.Slice(builtins, func(i, j int) bool { return builtins[i].Name() < builtins[j].Name() })
+
+	if len(builtins) > 0 {
+		b.WriteString("\n## Builtins\n")
+		for _, builtin := range builtins {
+			fmt.Fprintf(&b, "\n### `%s`\n", builtin.Name())
+			fmt.Fprintf(&b, "\n- Safety: %v\n", builtin.Safety())
+			if cost, ok := builtin.Cost(); ok {
+				fmt.Fprintf(&b, "- Cost: %v\n", cost)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func isPublic(name string) bool { return !strings.HasPrefix(name, "_") }
+
+// params renders a parameter list. Default values other than literals and
+// identifiers are elided.
+func params(params []syntax.Expr) string {
+	strs := make([]string, len(params))
+	for i, param := range params {
+		strs[i] = param1(param)
+	}
+	return strings.Join(strs, ", ")
+}
+
+func param1(param syntax.Expr) string {
+	switch param := param.(type) {
+	case *syntax.Ident:
+		return param.Name
+	case *syntax.BinaryExpr: // name=default
+		return param1(param.X) + "=" + defaultValue(param.Y)
+	case *syntax.UnaryExpr: // *, *args or **kwargs
+		if param.X == nil {
+			return param.Op.String()
+		}
+		return param.Op.String() + param1(param.X)
+	}
+	return "?"
+}
+
+func defaultValue(e syntax.Expr) string {
+	switch e := e.(type) {
+	case *syntax.Literal:
+		return e.Raw
+	case *syntax.Ident:
+		return e.Name
+	}
+	return "..."
+}
+
+// trimDoc removes the common indentation from the lines of a docstring, as
+// well as any leading or trailing blank lines.
+func trimDoc(doc string) string {
+	lines := strings.Split(doc, "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) >= indent && indent > 0 {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = strings.TrimLeft(lines[i], " \t")
+		}
+	}
+	lines[0] = strings.TrimLeft(lines[0], " \t")
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,113 @@
+package docgen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/docgen"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+	"github.com/canonical/starlark/syntax"
+)
+
+func TestMarkdown(t *testing.T) {
+	const src = `"""Utilities for greeting people.
+
+    Nothing more.
+    """
+
+# The default greeting.
+GREETING = "hello"
+
+# Reassigned, so not documented.
+counter = 0
+counter = 1
+
+def greet(name, punctuation = "!", *args, loud = False, **kwargs):
+    """Returns a greeting for name.
+
+    If loud is set, the greeting is shouted.
+    """
+    return GREETING + " " + name + punctuation
+
+# Returns a farewell.
+def farewell(name, style = {}):
+    return "bye " + name
+
+def _private():
+    pass
+`
+	f, err := (&syntax.FileOptions{}).Parse("lib/greet.star", src, syntax.RetainComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := docgen.Markdown(&b, f); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "# greet.star\n" +
+		"\n" +
+		"Utilities for greeting people.\n" +
+		"\n" +
+		"Nothing more.\n" +
+		"\n" +
+		"## Functions\n" +
+		"\n" +
+		"### `greet(name, punctuation=\"!\", *args, loud=False, **kwargs)`\n" +
+		"\n" +
+		"Returns a greeting for name.\n" +
+		"\n" +
+		"If loud is set, the greeting is shouted.\n" +
+		"\n" +
+		"### `farewell(name, style=...)`\n" +
+		"\n" +
+		"Returns a farewell.\n" +
+		"\n" +
+		"## Globals\n" +
+		"\n" +
+		"### `GREETING`\n" +
+		"\n" +
+		"The default greeting.\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestModuleMarkdown(t *testing.T) {
+	noop := func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	}
+	clone := starlark.NewBuiltinWithSafety("copy", starlark.CPUSafe|starlark.MemSafe, noop)
+	clone.DeclareCost(starlark.CostSpec{StepsPerElement: 1, AllocsPerByte: 1})
+	module := &starlarkstruct.Module{
+		Name: "bytes",
+		Members: starlark.StringDict{
+			"copy":    clone,
+			"unsafe":  starlark.NewBuiltin("unsafe", noop),
+			"MAX_LEN": starlark.MakeInt(10),
+		},
+	}
+
+	var b strings.Builder
+	if err := docgen.ModuleMarkdown(&b, module); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "# bytes\n" +
+		"\n" +
+		"## Builtins\n" +
+		"\n" +
+		"### `copy`\n" +
+		"\n" +
+		"- Safety: (CPUSafe|MemSafe)\n" +
+		"- Cost: 1 step per element, 1 byte per input byte\n" +
+		"\n" +
+		"### `unsafe`\n" +
+		"\n" +
+		"- Safety: NotSafe\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -116,3 +116,42 @@ func disassemble(f *Funcode) string {
 	}
 	return out.String()
 }
+
+// TestConstantSharing ensures that identical constants used by
+// different functions of a program share a single pool entry.
+func TestConstantSharing(t *testing.T) {
+	const src = `
+def f(): return "shared"
+def g(): return "shared"
+def h(): return b"shared"
+x = "shared"
+`
+	opts := syntax.LegacyFileOptions()
+	f, err := opts.Parse("in.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(f, func(string) bool { return false }, func(string) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	module := f.Module.(*resolve.Module)
+	prog := File(opts, f.Stmts, syntax.MakePosition(&f.Path, 1, 1), "<toplevel>", module.Locals, module.Globals)
+
+	var nstrings, nbytes int
+	for _, c := range prog.Constants {
+		switch c {
+		case "shared":
+			nstrings++
+		case Bytes("shared"):
+			nbytes++
+		}
+	}
+	if nstrings != 1 || nbytes != 1 {
+		t.Errorf("got %d string and %d bytes constants, want 1 of each: %v", nstrings, nbytes, prog.Constants)
+	}
+	for _, fn := range prog.Functions[:2] {
+		if got, want := disassemble(fn), `constant "shared"; return`; got != want {
+			t.Errorf("%s generated <<%s>>, want <<%s>>", fn.Name, got, want)
+		}
+	}
+}
@@ -46,7 +46,7 @@ var Disassemble = false
 const debug = false // make code generation verbose, for debugging the compiler
 
 // Increment this to force recompilation of saved bytecode files.
-const Version = 14
+const Version = 17
 
 type Opcode uint8
 
@@ -109,9 +109,12 @@ const (
 	SETDICTUNIQ  // dict key value SETDICTUNIQ  -
 	APPEND       //      list elem APPEND       -
 	SLICE        //   x lo hi step SLICE        slice
+	SETSLICE     //  x lo hi values SETSLICE     -
+	DELSLICE     //        x lo hi DELSLICE     -
 	INPLACE_ADD  //            x y INPLACE_ADD  z      where z is x+y or x.extend(y)
 	INPLACE_PIPE //            x y INPLACE_PIPE z      where z is x|y
 	MAKEDICT     //              - MAKEDICT     dict
+	RESERVE      //  dict iterable RESERVE      dict iterable  [presizes dict for iterable's elements]
 
 	// --- opcodes with an argument must go below this line ---
 
@@ -140,6 +143,9 @@ const (
 	SETFIELD     //               x y SETFIELD<name>      -           x.name = y
 	UNPACK       //          iterable UNPACK<n>           vn ... v1
 
+	// n>>8 is #targets before the starred target and n&0xff is #targets after it.
+	UNPACKSTAR // iterable UNPACKSTAR<n> vn ... rest ... v1   rest is a list
+
 	// n>>8 is #positional args and n&0xff is #named args (pairs).
 	CALL        // fn positional named                CALL<n>        result
 	CALL_VAR    // fn positional named *args          CALL_VAR<n>    result
@@ -163,6 +169,7 @@ var opcodeNames = [...]string{
 	CIRCUMFLEX:   "circumflex",
 	CJMP:         "cjmp",
 	CONSTANT:     "constant",
+	DELSLICE:     "delslice",
 	DUP2:         "dup2",
 	DUP:          "dup",
 	EQL:          "eql",
@@ -203,6 +210,7 @@ var opcodeNames = [...]string{
 	PLUS:         "plus",
 	POP:          "pop",
 	PREDECLARED:  "predeclared",
+	RESERVE:      "reserve",
 	RETURN:       "return",
 	SETDICT:      "setdict",
 	SETDICTUNIQ:  "setdictuniq",
@@ -211,6 +219,7 @@ var opcodeNames = [...]string{
 	SETINDEX:     "setindex",
 	SETLOCAL:     "setlocal",
 	SETLOCALCELL: "setlocalcell",
+	SETSLICE:     "setslice",
 	SLASH:        "slash",
 	SLASHSLASH:   "slashslash",
 	SLICE:        "slice",
@@ -220,6 +229,7 @@ var opcodeNames = [...]string{
 	UMINUS:       "uminus",
 	UNIVERSAL:    "universal",
 	UNPACK:       "unpack",
+	UNPACKSTAR:   "unpackstar",
 	UPLUS:        "uplus",
 }
 
@@ -238,6 +248,7 @@ var stackEffect = [...]int8{
 	CIRCUMFLEX:   -1,
 	CJMP:         -1,
 	CONSTANT:     +1,
+	DELSLICE:     -3,
 	DUP2:         +2,
 	DUP:          +1,
 	EQL:          -1,
@@ -277,6 +288,7 @@ var stackEffect = [...]int8{
 	PLUS:         -1,
 	POP:          -1,
 	PREDECLARED:  +1,
+	RESERVE:      0,
 	RETURN:       -1,
 	SETLOCALCELL: -1,
 	SETDICT:      -3,
@@ -285,6 +297,7 @@ var stackEffect = [...]int8{
 	SETGLOBAL:    -1,
 	SETINDEX:     -3,
 	SETLOCAL:     -1,
+	SETSLICE:     -4,
 	SLASH:        -1,
 	SLASHSLASH:   -1,
 	SLICE:        -3,
@@ -293,11 +306,12 @@ var stackEffect = [...]int8{
 	UMINUS:       0,
 	UNIVERSAL:    +1,
 	UNPACK:       variableStackEffect,
+	UNPACKSTAR:   variableStackEffect,
 	UPLUS:        0,
 }
 
 func (op Opcode) String() string {
-	if op < OpcodeMax {
+	if op <= OpcodeMax {
 		if name := opcodeNames[op]; name != "" {
 			return name
 		}
@@ -724,6 +738,8 @@ func (insn *insn) stackeffect() int {
 			se = 1 - arg
 		case UNPACK:
 			se = arg - 1
+		case UNPACKSTAR:
+			se = int(arg>>8 + arg&0xff)
 		default:
 			panic(insn.op)
 		}
@@ -890,6 +906,8 @@ func PrintOp(fn *Funcode, pc uint32, op Opcode, arg uint32) {
 		comment = fn.FreeVars[arg].Name
 	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
 		comment = fmt.Sprintf("%d pos, %d named", arg>>8, arg&0xff)
+	case UNPACKSTAR:
+		comment = fmt.Sprintf("%d before, %d after", arg>>8, arg&0xff)
 	default:
 		// JMP, CJMP, ITERJMP, MAKETUPLE, MAKELIST, LOAD, UNPACK:
 		// arg is just a number
@@ -967,6 +985,9 @@ func (pcomp *pcomp) nameIndex(name string) uint32 {
 
 // constantIndex returns the index of the specified constant
 // within the constant pool, adding it if necessary.
+// The pool is shared by all functions of the program, so identical
+// constants used in different functions are stored, and later
+// converted to Starlark values, only once.
 func (pcomp *pcomp) constantIndex(v interface{}) uint32 {
 	index, ok := pcomp.constants[v]
 	if !ok {
@@ -1057,6 +1078,15 @@ func (fcomp *fcomp) stmt(stmt syntax.Stmt) {
 		fcomp.expr(stmt.X)
 		fcomp.emit(POP)
 
+	case *syntax.DelStmt:
+		// Resolver invariant: the operand is a slice without a step.
+		x := unparen(stmt.X).(*syntax.SliceExpr)
+		fcomp.expr(x.X)
+		fcomp.sliceBound(x.Lo)
+		fcomp.sliceBound(x.Hi)
+		fcomp.setPos(x.Lbrack)
+		fcomp.emit(DELSLICE)
+
 	case *syntax.BranchStmt:
 		// Resolver invariant: break/continue appear only within loops.
 		switch stmt.Token {
@@ -1271,6 +1301,17 @@ func (fcomp *fcomp) assign(pos syntax.Position, lhs syntax.Expr) {
 		fcomp.setPos(lhs.Lbrack)
 		fcomp.emit(SETINDEX)
 
+	case *syntax.SliceExpr:
+		// x[lo:hi] = rhs
+		fcomp.expr(lhs.X)
+		fcomp.emit(EXCH)
+		fcomp.sliceBound(lhs.Lo)
+		fcomp.emit(EXCH)
+		fcomp.sliceBound(lhs.Hi)
+		fcomp.emit(EXCH)
+		fcomp.setPos(lhs.Lbrack)
+		fcomp.emit(SETSLICE)
+
 	case *syntax.DotExpr:
 		// x.f = rhs
 		fcomp.expr(lhs.X)
@@ -1278,6 +1319,10 @@ func (fcomp *fcomp) assign(pos syntax.Position, lhs syntax.Expr) {
 		fcomp.setPos(lhs.Dot)
 		fcomp.emit1(SETFIELD, fcomp.pcomp.nameIndex(lhs.Name.Name))
 
+	case *syntax.UnaryExpr:
+		// *x in x, *y = rhs
+		fcomp.assign(pos, lhs.X)
+
 	default:
 		panic(lhs)
 	}
@@ -1285,7 +1330,18 @@ func (fcomp *fcomp) assign(pos syntax.Position, lhs syntax.Expr) {
 
 func (fcomp *fcomp) assignSequence(pos syntax.Position, lhs []syntax.Expr) {
 	fcomp.setPos(pos)
-	fcomp.emit1(UNPACK, uint32(len(lhs)))
+	starred := -1
+	for i, x := range lhs {
+		if unop, ok := x.(*syntax.UnaryExpr); ok && unop.Op == syntax.STAR {
+			starred = i
+		}
+	}
+	if starred >= 0 {
+		// x, *y, z = rhs
+		fcomp.emit1(UNPACKSTAR, uint32(starred<<8|(len(lhs)-starred-1)))
+	} else {
+		fcomp.emit1(UNPACK, uint32(len(lhs)))
+	}
 	for i := range lhs {
 		fcomp.assign(pos, lhs[i])
 	}
@@ -1340,21 +1396,9 @@ func (fcomp *fcomp) expr(e syntax.Expr) {
 	case *syntax.SliceExpr:
 		fcomp.setPos(e.Lbrack)
 		fcomp.expr(e.X)
-		if e.Lo != nil {
-			fcomp.expr(e.Lo)
-		} else {
-			fcomp.emit(NONE)
-		}
-		if e.Hi != nil {
-			fcomp.expr(e.Hi)
-		} else {
-			fcomp.emit(NONE)
-		}
-		if e.Step != nil {
-			fcomp.expr(e.Step)
-		} else {
-			fcomp.emit(NONE)
-		}
+		fcomp.sliceBound(e.Lo)
+		fcomp.sliceBound(e.Hi)
+		fcomp.sliceBound(e.Step)
 		fcomp.emit(SLICE)
 
 	case *syntax.Comprehension:
@@ -1593,6 +1637,16 @@ func add(code rune, args []summand) syntax.Expr {
 	panic(code)
 }
 
+// sliceBound emits code for an optional operand of a slice expression,
+// pushing None if it is absent.
+func (fcomp *fcomp) sliceBound(e syntax.Expr) {
+	if e != nil {
+		fcomp.expr(e)
+	} else {
+		fcomp.emit(NONE)
+	}
+}
+
 func unparen(e syntax.Expr) syntax.Expr {
 	if p, ok := e.(*syntax.ParenExpr); ok {
 		return unparen(p.X)
@@ -1785,6 +1839,11 @@ func (fcomp *fcomp) comprehension(comp *syntax.Comprehension, clauseIndex int) {
 
 		fcomp.expr(clause.X)
 		fcomp.setPos(clause.For)
+		if comp.Curly && len(comp.Clauses) == 1 {
+			// {k: v for vars in x} has an entry for each element of x,
+			// unless keys are repeated.
+			fcomp.emit(RESERVE)
+		}
 		fcomp.emit(ITERPUSH)
 		fcomp.jump(head)
 
@@ -242,13 +242,13 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 
 	loads := d.bindings()
 
-	names := make([]string, d.int())
+	names := make([]string, d.count())
 	for i := range names {
 		names[i] = d.string()
 	}
 
 	// constants
-	constants := make([]interface{}, d.int())
+	constants := make([]interface{}, d.count())
 	for i := range constants {
 		var c interface{}
 		switch d.int() {
@@ -268,7 +268,7 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 
 	globals := d.bindings()
 	toplevel := d.function()
-	funcs := make([]*Funcode, d.int())
+	funcs := make([]*Funcode, d.count())
 	for i := range funcs {
 		funcs[i] = d.function()
 	}
@@ -292,6 +292,11 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 		return nil, fmt.Errorf("internal error: unconsumed data during decoding")
 	}
 
+	// The data may come from an untrusted cache.
+	if err := prog.Verify(); err != nil {
+		return nil, err
+	}
+
 	return prog, nil
 }
 
@@ -311,6 +316,17 @@ func (d *decoder) int64() int64 {
 	return x
 }
 
+// count decodes the number of elements of a sequence. As each element is
+// encoded in at least one byte, a count exceeding the remaining data is
+// rejected before space is allocated for it.
+func (d *decoder) count() int {
+	n := d.int()
+	if n < 0 || n > len(d.p) {
+		panic(fmt.Sprintf("invalid count %d", n))
+	}
+	return n
+}
+
 func (d *decoder) uint64() uint64 {
 	x, len := binary.Uvarint(d.p[:])
 	d.p = d.p[len:]
@@ -347,7 +363,7 @@ func (d *decoder) binding() Binding {
 }
 
 func (d *decoder) bindings() []Binding {
-	bindings := make([]Binding, d.int())
+	bindings := make([]Binding, d.count())
 	for i := range bindings {
 		bindings[i] = d.binding()
 	}
@@ -355,7 +371,7 @@ func (d *decoder) bindings() []Binding {
 }
 
 func (d *decoder) ints() []int {
-	ints := make([]int, d.int())
+	ints := make([]int, d.count())
 	for i := range ints {
 		ints[i] = d.int()
 	}
@@ -368,7 +384,7 @@ func (d *decoder) function() *Funcode {
 	id := d.binding()
 	doc := d.string()
 	code := d.bytes()
-	pclinetab := make([]uint16, d.int())
+	pclinetab := make([]uint16, d.count())
 	for i := range pclinetab {
 		pclinetab[i] = uint16(d.int())
 	}
@@ -0,0 +1,467 @@
+package compile
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// maxVerifiedStack bounds the operand stack size which Verify accepts, so
+// that a program cannot make the interpreter allocate an arbitrarily large
+// stack for a function however few instructions it has.
+const maxVerifiedStack = 1 << 16
+
+// Verify checks that the program is well formed: that each instruction is
+// valid, that its operands index existing constants, names, locals, free
+// variables, globals and functions, that each jump targets an instruction,
+// that the operand and iterator stacks never underflow, that the operand
+// stack never exceeds its declared size, and that the values which some
+// instructions require to be of a particular type always are. Executing a
+// program which passes Verify can fail only with an ordinary error, not by
+// crashing the interpreter.
+//
+// Programs produced by the compiler are always well formed, so Verify is
+// only needed for those from untrusted sources; DecodeProgram applies it.
+func (prog *Program) Verify() error {
+	for i, c := range prog.Constants {
+		switch c := c.(type) {
+		case string, Bytes, int64, float64:
+		case *big.Int:
+			if c == nil {
+				return fmt.Errorf("invalid program: malformed constant %d", i)
+			}
+		default:
+			return fmt.Errorf("invalid program: constant %d has unknown type %T", i, c)
+		}
+	}
+
+	if prog.Toplevel == nil {
+		return fmt.Errorf("invalid program: no toplevel function")
+	}
+	if len(prog.Toplevel.FreeVars) > 0 {
+		return fmt.Errorf("invalid program: toplevel function has free variables")
+	}
+	if err := prog.verifyFunc(prog.Toplevel); err != nil {
+		return err
+	}
+	for _, fn := range prog.Functions {
+		if err := prog.verifyFunc(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A verifyKind is the type of a value on the operand stack, as far as the
+// verifier is concerned.
+type verifyKind struct {
+	tag verifyTag
+
+	// For tuples, len is the length of the tuple and cells is the number
+	// of its trailing elements which are cells.
+	len, cells int
+}
+
+type verifyTag uint8
+
+const (
+	kindAny verifyTag = iota
+	kindCell
+	kindDict
+	kindList
+	kindString
+	kindTuple
+)
+
+// A verifyState is the abstract state of the interpreter before an
+// instruction.
+type verifyState struct {
+	stack []verifyKind // kinds of values on the operand stack
+	iters int          // depth of the iterator stack
+}
+
+// join merges other into state, reporting whether state changed.
+func (state *verifyState) join(other *verifyState) (changed bool, err error) {
+	if len(state.stack) != len(other.stack) {
+		return false, fmt.Errorf("inconsistent operand stack depth (%d != %d)", len(state.stack), len(other.stack))
+	}
+	if state.iters != other.iters {
+		return false, fmt.Errorf("inconsistent iterator stack depth (%d != %d)", state.iters, other.iters)
+	}
+	for i, kind := range other.stack {
+		if state.stack[i] != kind && state.stack[i].tag != kindAny {
+			state.stack[i] = verifyKind{tag: kindAny}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+type verifyInsn struct {
+	pc   uint32
+	op   Opcode
+	arg  uint32
+	next uint32 // pc of the following instruction
+}
+
+// verifyFunc checks that fn, a function of prog, is well formed.
+func (prog *Program) verifyFunc(fn *Funcode) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("invalid program: function %s: %v", fn.Name, err)
+		}
+	}()
+
+	if fn.Prog != prog {
+		return fmt.Errorf("belongs to another program")
+	}
+	// NumParams includes *args and **kwargs, which follow the others.
+	ordinary := fn.NumParams
+	if fn.HasVarargs {
+		ordinary--
+	}
+	if fn.HasKwargs {
+		ordinary--
+	}
+	if ordinary < 0 || fn.NumKwonlyParams < 0 || fn.NumKwonlyParams > ordinary {
+		return fmt.Errorf("invalid parameter counts")
+	}
+	if fn.NumParams > len(fn.Locals) {
+		return fmt.Errorf("%d parameters but %d locals", fn.NumParams, len(fn.Locals))
+	}
+	if fn.MaxStack < 0 || fn.MaxStack > maxVerifiedStack {
+		return fmt.Errorf("invalid stack size %d", fn.MaxStack)
+	}
+	isCell := make([]bool, len(fn.Locals))
+	for _, index := range fn.Cells {
+		if index < 0 || index >= len(fn.Locals) {
+			return fmt.Errorf("cell index %d out of range", index)
+		}
+		if isCell[index] {
+			return fmt.Errorf("local %d is a cell more than once", index)
+		}
+		isCell[index] = true
+	}
+
+	insns, index, err := decodeInsns(fn.Code)
+	if err != nil {
+		return err
+	}
+
+	v := &funcVerifier{prog: prog, fn: fn, isCell: isCell}
+	states := make([]*verifyState, len(insns))
+	states[0] = &verifyState{}
+	worklist := []int{0}
+	for len(worklist) > 0 {
+		i := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		insn := insns[i]
+
+		succs, err := v.step(insn, states[i])
+		if err != nil {
+			return fmt.Errorf("pc %d (%s): %v", insn.pc, insn.op, err)
+		}
+		for _, succ := range succs {
+			j, ok := index[succ.pc]
+			if !ok {
+				if succ.pc == uint32(len(fn.Code)) {
+					return fmt.Errorf("pc %d (%s): execution falls off the end of the code", insn.pc, insn.op)
+				}
+				return fmt.Errorf("pc %d (%s): jump to %d, which is not an instruction", insn.pc, insn.op, succ.pc)
+			}
+			if states[j] == nil {
+				states[j] = succ.state
+				worklist = append(worklist, j)
+				continue
+			}
+			changed, err := states[j].join(succ.state)
+			if err != nil {
+				return fmt.Errorf("pc %d: %v", succ.pc, err)
+			}
+			if changed {
+				worklist = append(worklist, j)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeInsns decodes the instructions of code, returning them along with
+// the index of each instruction by pc.
+func decodeInsns(code []byte) ([]verifyInsn, map[uint32]int, error) {
+	if len(code) == 0 {
+		return nil, nil, fmt.Errorf("no code")
+	}
+	var insns []verifyInsn
+	index := make(map[uint32]int)
+	for pc := uint32(0); pc < uint32(len(code)); {
+		insn := verifyInsn{pc: pc, op: Opcode(code[pc])}
+		if insn.op > OpcodeMax || opcodeNames[insn.op] == "" {
+			return nil, nil, fmt.Errorf("pc %d: invalid opcode %d", pc, code[pc])
+		}
+		pc++
+		if insn.op >= OpcodeArgMin {
+			for s := uint(0); ; s += 7 {
+				if pc >= uint32(len(code)) {
+					return nil, nil, fmt.Errorf("pc %d (%s): truncated operand", insn.pc, insn.op)
+				}
+				if s > 28 {
+					return nil, nil, fmt.Errorf("pc %d (%s): operand too long", insn.pc, insn.op)
+				}
+				b := code[pc]
+				pc++
+				insn.arg |= uint32(b&0x7f) << s
+				if b < 0x80 {
+					break
+				}
+			}
+		}
+		insn.next = pc
+		index[insn.pc] = len(insns)
+		insns = append(insns, insn)
+	}
+	return insns, index, nil
+}
+
+type funcVerifier struct {
+	prog   *Program
+	fn     *Funcode
+	isCell []bool
+}
+
+type verifySucc struct {
+	pc    uint32
+	state *verifyState
+}
+
+// step checks that insn may execute in the given state, and returns the
+// states of its successors.
+func (v *funcVerifier) step(insn verifyInsn, in *verifyState) ([]verifySucc, error) {
+	op, arg := insn.op, insn.arg
+	fn, prog := v.fn, v.prog
+
+	checkIndex := func(what string, n int) error {
+		if uint64(arg) >= uint64(n) {
+			return fmt.Errorf("%s index %d out of range", what, arg)
+		}
+		return nil
+	}
+
+	// Check the operand.
+	var err error
+	switch op {
+	case CONSTANT:
+		err = checkIndex("constant", len(prog.Constants))
+	case MAKEFUNC:
+		err = checkIndex("function", len(prog.Functions))
+	case SETLOCAL, LOCAL, LOCALCELL, SETLOCALCELL:
+		err = checkIndex("local", len(fn.Locals))
+	case FREE, FREECELL:
+		err = checkIndex("free variable", len(fn.FreeVars))
+	case GLOBAL, SETGLOBAL:
+		err = checkIndex("global", len(prog.Globals))
+	case PREDECLARED, UNIVERSAL, ATTR, SETFIELD:
+		err = checkIndex("name", len(prog.Names))
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case SETLOCAL:
+		if v.isCell[arg] {
+			return nil, fmt.Errorf("local %d is a cell", arg)
+		}
+	case LOCALCELL, SETLOCALCELL:
+		if !v.isCell[arg] {
+			return nil, fmt.Errorf("local %d is not a cell", arg)
+		}
+	}
+
+	pops, pushes := stackUse(op, arg)
+	stack := in.stack
+	if pops > len(stack) {
+		return nil, fmt.Errorf("operand stack underflow")
+	}
+	if len(stack)-pops+pushes > fn.MaxStack {
+		return nil, fmt.Errorf("operand stack overflow")
+	}
+	popped := stack[len(stack)-pops:]
+
+	// Check the types of the operands which the interpreter assumes.
+	require := func(i int, tag verifyTag, what string) error {
+		if popped[i].tag != tag {
+			return fmt.Errorf("%s operand is not always a %s", what, kindNames[tag])
+		}
+		return nil
+	}
+	switch op {
+	case RESERVE:
+		err = require(0, kindDict, "first")
+	case SETDICT, SETDICTUNIQ:
+		err = require(0, kindDict, "first")
+	case APPEND:
+		err = require(0, kindList, "first")
+	case MAKEFUNC:
+		nfree := len(prog.Functions[arg].FreeVars)
+		if t := popped[0]; t.tag != kindTuple || t.len < nfree || t.cells < nfree {
+			err = fmt.Errorf("operand is not always a tuple ending in %d cells", nfree)
+		}
+	case LOAD:
+		for i := range popped {
+			if err = require(i, kindString, "each"); err != nil {
+				break
+			}
+		}
+	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
+		npos, nnamed := int(arg>>8), int(arg&0xff)
+		for i := 0; i < nnamed && err == nil; i++ {
+			err = require(1+npos+2*i, kindString, "keyword")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if (op == ITERPOP || op == ITERJMP) && in.iters == 0 {
+		return nil, fmt.Errorf("iterator stack underflow")
+	}
+
+	// Compute the successor state.
+	out := &verifyState{
+		stack: make([]verifyKind, len(stack)-pops, len(stack)-pops+pushes+1),
+		iters: in.iters,
+	}
+	copy(out.stack, stack)
+	unknown := verifyKind{tag: kindAny}
+	switch op {
+	case DUP, DUP2, EXCH, RESERVE:
+		// These rearrange the operands, preserving their types.
+		switch op {
+		case DUP:
+			out.stack = append(out.stack, popped[0], popped[0])
+		case DUP2:
+			out.stack = append(out.stack, popped[0], popped[1], popped[0], popped[1])
+		case EXCH:
+			out.stack = append(out.stack, popped[1], popped[0])
+		case RESERVE:
+			out.stack = append(out.stack, popped...)
+		}
+	case CONSTANT:
+		if _, ok := prog.Constants[arg].(string); ok {
+			out.stack = append(out.stack, verifyKind{tag: kindString})
+		} else {
+			out.stack = append(out.stack, unknown)
+		}
+	case MAKEDICT:
+		out.stack = append(out.stack, verifyKind{tag: kindDict})
+	case MAKELIST:
+		out.stack = append(out.stack, verifyKind{tag: kindList})
+	case MAKETUPLE:
+		cells := 0
+		for i := len(popped) - 1; i >= 0 && popped[i].tag == kindCell; i-- {
+			cells++
+		}
+		out.stack = append(out.stack, verifyKind{tag: kindTuple, len: len(popped), cells: cells})
+	case LOCAL:
+		if v.isCell[arg] {
+			out.stack = append(out.stack, verifyKind{tag: kindCell})
+		} else {
+			out.stack = append(out.stack, unknown)
+		}
+	case FREE:
+		out.stack = append(out.stack, verifyKind{tag: kindCell})
+	case ITERPUSH:
+		out.iters++
+	case ITERPOP:
+		out.iters--
+	default:
+		for i := 0; i < pushes; i++ {
+			out.stack = append(out.stack, unknown)
+		}
+	}
+
+	switch op {
+	case RETURN:
+		return nil, nil
+	case JMP:
+		return []verifySucc{{arg, out}}, nil
+	case CJMP:
+		return []verifySucc{{insn.next, out}, {arg, out.clone()}}, nil
+	case ITERJMP:
+		// The next element is pushed only if the iterator is not exhausted.
+		if len(out.stack)+1 > fn.MaxStack {
+			return nil, fmt.Errorf("operand stack overflow")
+		}
+		ok := out.clone()
+		ok.stack = append(ok.stack, unknown)
+		return []verifySucc{{insn.next, ok}, {arg, out}}, nil
+	default:
+		return []verifySucc{{insn.next, out}}, nil
+	}
+}
+
+var kindNames = [...]string{
+	kindAny:    "value",
+	kindCell:   "cell",
+	kindDict:   "dict",
+	kindList:   "list",
+	kindString: "string",
+	kindTuple:  "tuple",
+}
+
+func (state *verifyState) clone() *verifyState {
+	return &verifyState{
+		stack: append([]verifyKind(nil), state.stack...),
+		iters: state.iters,
+	}
+}
+
+// stackUse returns the number of operands popped and results pushed by an
+// instruction.
+func stackUse(op Opcode, arg uint32) (pops, pushes int) {
+	switch op {
+	case NOP, JMP, ITERPOP, ITERJMP:
+		return 0, 0
+	case NONE, TRUE, FALSE, MANDATORY, MAKEDICT, CONSTANT, LOCAL, FREE,
+		FREECELL, LOCALCELL, GLOBAL, PREDECLARED, UNIVERSAL:
+		return 0, 1
+	case POP, ITERPUSH, RETURN, CJMP, SETLOCAL, SETLOCALCELL, SETGLOBAL:
+		return 1, 0
+	case UPLUS, UMINUS, TILDE, NOT, MAKEFUNC, ATTR:
+		return 1, 1
+	case DUP:
+		return 1, 2
+	case DUP2:
+		return 2, 4
+	case EXCH, RESERVE:
+		return 2, 2
+	case APPEND, SETFIELD:
+		return 2, 0
+	case SETDICT, SETDICTUNIQ, SETINDEX, DELSLICE:
+		return 3, 0
+	case SETSLICE:
+		return 4, 0
+	case SLICE:
+		return 4, 1
+	case MAKETUPLE, MAKELIST:
+		return int(arg), 1
+	case LOAD:
+		return int(arg) + 1, int(arg)
+	case UNPACK:
+		return 1, int(arg)
+	case UNPACKSTAR:
+		return 1, int(arg>>8) + int(arg&0xff) + 1
+	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
+		pops = 1 + int(arg>>8) + 2*int(arg&0xff)
+		if op == CALL_VAR || op == CALL_VAR_KW {
+			pops++
+		}
+		if op == CALL_KW || op == CALL_VAR_KW {
+			pops++
+		}
+		return pops, 1
+	default:
+		// Binary operators, including comparisons and membership tests,
+		// and INDEX, INPLACE_ADD and INPLACE_PIPE.
+		return 2, 1
+	}
+}
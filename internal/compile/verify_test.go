@@ -0,0 +1,182 @@
+package compile_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/internal/compile"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/syntax"
+)
+
+// TestVerifyCompiled checks that the programs produced by the compiler
+// from the interpreter's test files are accepted by the verifier.
+func TestVerifyCompiled(t *testing.T) {
+	filenames, err := filepath.Glob("../../starlark/testdata/*.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &syntax.FileOptions{
+		Set:             true,
+		While:           true,
+		TopLevelControl: true,
+		GlobalReassign:  true,
+		SliceAssign:     true,
+		StarAssign:      true,
+		Recursion:       true,
+	}
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, chunk := range strings.Split(string(data), "\n---\n") {
+			_, prog, err := starlark.SourceProgramOptions(opts, filename, chunk, func(string) bool { return true })
+			if err != nil {
+				// Some chunks test static errors.
+				continue
+			}
+			buf := new(bytes.Buffer)
+			if err := prog.Write(buf); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := compile.DecodeProgram(buf.Bytes()); err != nil {
+				t.Errorf("%s: chunk %d: %v", filepath.Base(filename), i, err)
+			}
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	type function struct {
+		code     []byte
+		maxStack int
+		locals   int
+		cells    []int
+		freeVars int
+	}
+	const (
+		NONE     = byte(compile.NONE)
+		TRUE     = byte(compile.TRUE)
+		POP      = byte(compile.POP)
+		RETURN   = byte(compile.RETURN)
+		JMP      = byte(compile.JMP)
+		CJMP     = byte(compile.CJMP)
+		CONSTANT = byte(compile.CONSTANT)
+		APPEND   = byte(compile.APPEND)
+		MAKELIST = byte(compile.MAKELIST)
+		ITERPOP  = byte(compile.ITERPOP)
+		SETLOCAL = byte(compile.SETLOCAL)
+		LOCAL    = byte(compile.LOCAL)
+		TUPLE    = byte(compile.MAKETUPLE)
+		MAKEFUNC = byte(compile.MAKEFUNC)
+	)
+	tests := []struct {
+		name      string
+		toplevel  function
+		functions []function
+		err       string
+	}{{
+		name:     "valid",
+		toplevel: function{code: []byte{NONE, RETURN}, maxStack: 1},
+	}, {
+		name:     "invalid-opcode",
+		toplevel: function{code: []byte{0xff, RETURN}, maxStack: 1},
+		err:      "pc 0: invalid opcode 255",
+	}, {
+		name:     "truncated-operand",
+		toplevel: function{code: []byte{NONE, RETURN, CONSTANT, 0x80}, maxStack: 1},
+		err:      "pc 2 (constant): truncated operand",
+	}, {
+		name:     "constant-index",
+		toplevel: function{code: []byte{CONSTANT, 5, RETURN}, maxStack: 1},
+		err:      "pc 0 (constant): constant index 5 out of range",
+	}, {
+		name:     "underflow",
+		toplevel: function{code: []byte{POP, NONE, RETURN}, maxStack: 1},
+		err:      "pc 0 (pop): operand stack underflow",
+	}, {
+		name:     "overflow",
+		toplevel: function{code: []byte{NONE, NONE, POP, RETURN}, maxStack: 1},
+		err:      "pc 1 (none): operand stack overflow",
+	}, {
+		name:     "jump-target",
+		toplevel: function{code: []byte{NONE, JMP, 2, RETURN}, maxStack: 1},
+		err:      "pc 1 (jmp): jump to 2, which is not an instruction",
+	}, {
+		name:     "falls-off-end",
+		toplevel: function{code: []byte{NONE}, maxStack: 1},
+		err:      "pc 0 (none): execution falls off the end of the code",
+	}, {
+		name:     "inconsistent-depth",
+		toplevel: function{code: []byte{TRUE, CJMP, 4, NONE, NONE, RETURN}, maxStack: 2},
+		err:      "pc 4: inconsistent operand stack depth",
+	}, {
+		name:     "iterator-underflow",
+		toplevel: function{code: []byte{ITERPOP, NONE, RETURN}, maxStack: 1},
+		err:      "pc 0 (iterpop): iterator stack underflow",
+	}, {
+		name:     "append-to-list",
+		toplevel: function{code: []byte{MAKELIST, 0, NONE, APPEND, NONE, RETURN}, maxStack: 2},
+	}, {
+		name:     "append-to-non-list",
+		toplevel: function{code: []byte{NONE, NONE, APPEND, NONE, RETURN}, maxStack: 2},
+		err:      "pc 2 (append): first operand is not always a list",
+	}, {
+		name:     "set-cell",
+		toplevel: function{code: []byte{NONE, SETLOCAL, 0, NONE, RETURN}, maxStack: 1, locals: 1, cells: []int{0}},
+		err:      "pc 1 (setlocal): local 0 is a cell",
+	}, {
+		name:      "closure",
+		toplevel:  function{code: []byte{LOCAL, 0, TUPLE, 1, MAKEFUNC, 0, RETURN}, maxStack: 1, locals: 1, cells: []int{0}},
+		functions: []function{{code: []byte{NONE, RETURN}, maxStack: 1, freeVars: 1}},
+	}, {
+		name:      "closure-without-cell",
+		toplevel:  function{code: []byte{NONE, TUPLE, 1, MAKEFUNC, 0, RETURN}, maxStack: 1},
+		functions: []function{{code: []byte{NONE, RETURN}, maxStack: 1, freeVars: 1}},
+		err:       "pc 3 (makefunc): operand is not always a tuple ending in 1 cells",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog := &compile.Program{Constants: []interface{}{"c"}}
+			makeFunc := func(f function) *compile.Funcode {
+				fn := &compile.Funcode{
+					Prog:     prog,
+					Name:     "f",
+					Code:     f.code,
+					MaxStack: f.maxStack,
+					Locals:   make([]compile.Binding, f.locals),
+					Cells:    f.cells,
+					FreeVars: make([]compile.Binding, f.freeVars),
+				}
+				return fn
+			}
+			prog.Toplevel = makeFunc(test.toplevel)
+			for _, f := range test.functions {
+				prog.Functions = append(prog.Functions, makeFunc(f))
+			}
+
+			err := prog.Verify()
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error: got %q, want %q", err, test.err)
+			}
+
+			// Such programs are also rejected when loaded.
+			if _, err := starlark.CompiledProgram(bytes.NewReader(prog.Encode())); err == nil {
+				t.Error("CompiledProgram accepted invalid program")
+			}
+		})
+	}
+}
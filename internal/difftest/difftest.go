@@ -0,0 +1,125 @@
+// Package difftest compares the observable results of running Starlark
+// scripts with two implementations, such as this one and go.starlark.net.
+//
+// Each implementation runs a corpus of scripts and writes a transcript
+// recording, for each script, what it printed and either the final value of
+// each global or the error which stopped it. Transcripts are JSON, one result per line, so
+// that they can be produced by programs built against either
+// implementation and compared later.
+package difftest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A Result records the outcome of running a single script.
+type Result struct {
+	File string `json:"file"`
+
+	// Output is the text printed by the script.
+	Output string `json:"output,omitempty"`
+
+	// Globals maps the name of each global to the repr of its final
+	// value. It is empty if the script failed.
+	Globals map[string]string `json:"globals,omitempty"`
+
+	// Error is the message of the error which stopped the script, without
+	// its backtrace, or empty if the script succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// WriteTranscript writes results to w, one per line.
+func WriteTranscript(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	for _, result := range results {
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTranscript reads results written by WriteTranscript.
+func ReadTranscript(r io.Reader) ([]Result, error) {
+	var results []Result
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for line := 1; scanner.Scan(); line++ {
+		var result Result
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("transcript line %d: %v", line, err)
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Diff returns a description of each divergence between the results of the
+// same scripts in two transcripts, got and want. Scripts which appear in only
+// one transcript are reported as divergent.
+func Diff(got, want []Result) []string {
+	wantByFile := make(map[string]Result, len(want))
+	for _, result := range want {
+		wantByFile[result.File] = result
+	}
+
+	var diffs []string
+	seen := make(map[string]bool, len(got))
+	for _, g := range got {
+		seen[g.File] = true
+		w, ok := wantByFile[g.File]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: missing from reference transcript", g.File))
+			continue
+		}
+		diffs = append(diffs, diffResult(g, w)...)
+	}
+	for _, w := range want {
+		if !seen[w.File] {
+			diffs = append(diffs, fmt.Sprintf("%s: missing from transcript", w.File))
+		}
+	}
+	return diffs
+}
+
+func diffResult(got, want Result) []string {
+	var diffs []string
+	if got.Output != want.Output {
+		diffs = append(diffs, fmt.Sprintf("%s: output %q, want %q", got.File, got.Output, want.Output))
+	}
+	if got.Error != want.Error {
+		return append(diffs, fmt.Sprintf("%s: error %q, want %q", got.File, got.Error, want.Error))
+	}
+
+	names := make([]string, 0, len(got.Globals)+len(want.Globals))
+	for name := range got.Globals {
+		names = append(names, name)
+	}
+	for name := range want.Globals {
+		if _, ok := got.Globals[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		g, gotOK := got.Globals[name]
+		w, wantOK := want.Globals[name]
+		switch {
+		case !gotOK:
+			diffs = append(diffs, fmt.Sprintf("%s: global %s missing, want %s", got.File, name, w))
+		case !wantOK:
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected global %s = %s", got.File, name, g))
+		case g != w:
+			diffs = append(diffs, fmt.Sprintf("%s: global %s = %s, want %s", got.File, name, g, w))
+		}
+	}
+	return diffs
+}
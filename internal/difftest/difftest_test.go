@@ -0,0 +1,62 @@
+package difftest_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/starlark/internal/difftest"
+)
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	results := []difftest.Result{{
+		File:    "a.star",
+		Output:  "hello\n",
+		Globals: map[string]string{"x": "1", "y": `"two"`},
+	}, {
+		File:  "b.star",
+		Error: "division by zero",
+	}}
+
+	var buf bytes.Buffer
+	if err := difftest.WriteTranscript(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	read, err := difftest.ReadTranscript(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, results) {
+		t.Errorf("transcript did not round-trip: got %v, want %v", read, results)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := []difftest.Result{
+		{File: "same.star", Globals: map[string]string{"x": "1"}},
+		{File: "value.star", Globals: map[string]string{"x": "1", "y": "2"}},
+		{File: "error.star", Error: "division by zero"},
+		{File: "output.star", Output: "a\n", Globals: map[string]string{}},
+		{File: "missing.star"},
+	}
+	got := []difftest.Result{
+		{File: "same.star", Globals: map[string]string{"x": "1"}},
+		{File: "value.star", Globals: map[string]string{"x": "2", "z": "3"}},
+		{File: "error.star", Error: "floored division by zero"},
+		{File: "output.star", Output: "b\n", Globals: map[string]string{}},
+		{File: "extra.star"},
+	}
+
+	expected := []string{
+		`value.star: global x = 2, want 1`,
+		`value.star: global y missing, want 2`,
+		`value.star: unexpected global z = 3`,
+		`error.star: error "floored division by zero", want "division by zero"`,
+		`output.star: output "b\n", want "a\n"`,
+		`extra.star: missing from reference transcript`,
+		`missing.star: missing from transcript`,
+	}
+	if diffs := difftest.Diff(got, want); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("unexpected diffs:\ngot  %q\nwant %q", diffs, expected)
+	}
+}
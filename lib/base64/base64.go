@@ -0,0 +1,156 @@
+// Package base64 provides base64 encoding and decoding for Starlark
+// programs, as specified by RFC 4648.
+package base64 // import "github.com/canonical/starlark/lib/base64"
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module base64 is a Starlark module of base64 encoding functions.
+//
+// The module defines the following functions:
+//
+//	encode(data, urlsafe=False, padding=True) - Returns the base64 encoding of data, a string or bytes, as a
+//	                                            string. If urlsafe is true, the alphabet uses - and _ in place
+//	                                            of + and /. If padding is false, the output is not padded
+//	                                            with = characters.
+//	decode(s, urlsafe=False, padding=True) - Returns the bytes encoded by s, a string or bytes, which must use
+//	                                         the alphabet and padding given. Newline characters in s are
+//	                                         ignored.
+var Module = &starlarkstruct.Module{
+	Name: "base64",
+	Members: starlark.StringDict{
+		"decode": starlark.NewBuiltin("base64.decode", decode),
+		"encode": starlark.NewBuiltin("base64.encode", encode),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"decode": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"encode": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// encoding returns the encoding selected by the urlsafe and padding
+// arguments of encode and decode.
+func encoding(urlsafe, padding bool) *base64.Encoding {
+	switch {
+	case urlsafe && padding:
+		return base64.URLEncoding
+	case urlsafe:
+		return base64.RawURLEncoding
+	case padding:
+		return base64.StdEncoding
+	default:
+		return base64.RawStdEncoding
+	}
+}
+
+// unpackData returns the content of x, a string or bytes.
+func unpackData(b *starlark.Builtin, x starlark.Value) (string, error) {
+	switch x := x.(type) {
+	case starlark.String:
+		return string(x), nil
+	case starlark.Bytes:
+		return string(x), nil
+	default:
+		return "", fmt.Errorf("%s: got %s, want string or bytes", b.Name(), x.Type())
+	}
+}
+
+// chunkLen is the number of bytes of input encoded at a time by encode.
+// It is a multiple of 3, so that only the final chunk is padded.
+const chunkLen = 3 * 256
+
+func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	urlsafe, padding := false, true
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "data", &x, "urlsafe?", &urlsafe, "padding?", &padding); err != nil {
+		return nil, err
+	}
+	data, err := unpackData(b, x)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(len(data))); err != nil {
+		return nil, err
+	}
+
+	enc := encoding(urlsafe, padding)
+	sb := starlark.NewSafeStringBuilder(thread)
+	sb.Grow(enc.EncodedLen(len(data)))
+	if err := sb.Err(); err != nil {
+		return nil, err
+	}
+
+	// Encode the data a chunk at a time, so that it need not be copied.
+	var src [chunkLen]byte
+	var dst [chunkLen / 3 * 4]byte
+	for len(data) > 0 {
+		n := copy(src[:], data)
+		data = data[n:]
+		enc.Encode(dst[:], src[:n])
+		if _, err := sb.Write(dst[:enc.EncodedLen(n)]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := thread.AddAllocs(starlark.StringTypeOverhead); err != nil {
+		return nil, err
+	}
+	return starlark.String(sb.String()), nil
+}
+
+func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	urlsafe, padding := false, true
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "s", &x, "urlsafe?", &urlsafe, "padding?", &padding); err != nil {
+		return nil, err
+	}
+	s, err := unpackData(b, x)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+
+	// As newlines may appear anywhere in s, it is decoded in one go. This
+	// requires transient copies of the input and of the decoded bytes.
+	enc := encoding(urlsafe, padding)
+	maxLen := enc.DecodedLen(len(s))
+	transientSize := starlark.SafeAdd(
+		starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(len(s))),
+		starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(maxLen)),
+	)
+	if err := thread.AddAllocs(transientSize); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxLen)
+	n, err := enc.Decode(buf, []byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	sb := starlark.NewSafeStringBuilder(thread)
+	sb.Grow(n)
+	if _, err := sb.Write(buf[:n]); err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(starlark.StringTypeOverhead); err != nil {
+		return nil, err
+	}
+	return starlark.Bytes(sb.String()), nil
+}
@@ -0,0 +1,123 @@
+package base64_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/lib/base64"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range base64.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := base64.Safeties[name]; !ok {
+			t.Errorf("builtin base64.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin base64.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range base64.Safeties {
+		if _, ok := base64.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration base64.%s", name)
+		}
+	}
+}
+
+func TestBase64(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`base64.encode("")`, `""`},
+		{`base64.encode("hello")`, `"aGVsbG8="`},
+		{`base64.encode(b"\xfb\xff")`, `"+/8="`},
+		{`base64.encode(b"\xfb\xff", urlsafe=True)`, `"-_8="`},
+		{`base64.encode("hello", padding=False)`, `"aGVsbG8"`},
+		{`base64.decode("aGVsbG8=")`, `b"hello"`},
+		{`base64.decode(b"aGVs\nbG8=")`, `b"hello"`},
+		{`base64.decode("-_8=", urlsafe=True)`, `b"\xfb\xff"`},
+		{`base64.decode("aGVsbG8", padding=False)`, `b"hello"`},
+		{`base64.decode(base64.encode("x" * 1000)) == b"x" * 1000`, `True`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		result, err := starlark.Eval(thread, "base64_test.star", test.expr, starlark.StringDict{"base64": base64.Module})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := result.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestBase64Errors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`base64.encode(1)`, `base64.encode: got int, want string or bytes`},
+		{`base64.decode("aGVsbG8")`, `base64.decode: illegal base64 data at input byte 4`},
+		{`base64.decode("+/8=", urlsafe=True)`, `base64.decode: illegal base64 data at input byte 0`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		_, err := starlark.Eval(thread, "base64_test.star", test.expr, starlark.StringDict{"base64": base64.Module})
+		if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if got := err.Error(); got != test.want {
+			t.Errorf("%s: got error %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestBase64Resources(t *testing.T) {
+	data := strings.Repeat("hello, world! ", 100)
+	tests := []struct {
+		name string
+		arg  starlark.Value
+	}{
+		{"encode", starlark.String(data)},
+		{"decode", starlark.String(strings.Repeat("aGVsbG8g", 200))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := base64.Module.Members[test.name]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+			st.SetMinSteps(int64(len(test.arg.(starlark.String))))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, fn, starlark.Tuple{test.arg}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   base64.Module,
+		Safeties: base64.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   base64.Module,
+		Safeties: base64.Safeties,
+	}
+	suite.Benchmark(b)
+}
@@ -0,0 +1,3 @@
+package base64
+
+var Safeties = safeties
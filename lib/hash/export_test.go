@@ -0,0 +1,3 @@
+package hash
+
+var Safeties = safeties
@@ -0,0 +1,142 @@
+// Package hash provides cryptographic and checksum hash functions for
+// Starlark programs.
+package hash // import "github.com/canonical/starlark/lib/hash"
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module hash is a Starlark module of hash functions. Each function accepts
+// a string or bytes and takes time proportional to its length.
+//
+// The module defines the following functions:
+//
+//	crc32(data) - Returns the IEEE CRC-32 checksum of data as an int.
+//	md5(data) - Returns the MD5 digest of data as a string of hexadecimal digits.
+//	sha256(data) - Returns the SHA-256 digest of data as a string of hexadecimal digits.
+//	sha512(data) - Returns the SHA-512 digest of data as a string of hexadecimal digits.
+//
+// MD5 is not collision-resistant and should be used only for compatibility
+// with existing systems.
+var Module = &starlarkstruct.Module{
+	Name: "hash",
+	Members: starlark.StringDict{
+		"crc32":  starlark.NewBuiltin("hash.crc32", crc32Checksum),
+		"md5":    starlark.NewBuiltin("hash.md5", newDigest(md5.New)),
+		"sha256": starlark.NewBuiltin("hash.sha256", newDigest(sha256.New)),
+		"sha512": starlark.NewBuiltin("hash.sha512", newDigest(sha512.New)),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"crc32":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"md5":    starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"sha256": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"sha512": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// unpackData returns the content of x, a string or bytes.
+func unpackData(b *starlark.Builtin, x starlark.Value) (string, error) {
+	switch x := x.(type) {
+	case starlark.String:
+		return string(x), nil
+	case starlark.Bytes:
+		return string(x), nil
+	default:
+		return "", fmt.Errorf("%s: got %s, want string or bytes", b.Name(), x.Type())
+	}
+}
+
+// chunkLen is the number of bytes of input hashed at a time, so that the
+// input need not be copied.
+const chunkLen = 512
+
+type builtinFunc func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+// newDigest returns a builtin which returns the hexadecimal digest of its
+// argument under the hash returned by newHash.
+func newDigest(newHash func() hash.Hash) builtinFunc {
+	// The memory used by the hash state, the buffer through which input
+	// is passed to it, the raw digest and the resulting string are the same
+	// for every call.
+	h := newHash()
+	size := starlark.SafeAdd(
+		starlark.EstimateSize(h),
+		starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(chunkLen)),
+	)
+	size = starlark.SafeAdd(size, starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(h.Size())))
+	size = starlark.SafeAdd(size, starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(hex.EncodedLen(h.Size()))))
+	size = starlark.SafeAdd(size, starlark.StringTypeOverhead)
+
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		data, err := unpackData(b, x)
+		if err != nil {
+			return nil, err
+		}
+		if err := thread.AddSteps(starlark.SafeInt(len(data))); err != nil {
+			return nil, err
+		}
+		if err := thread.AddAllocs(size); err != nil {
+			return nil, err
+		}
+
+		h := newHash()
+		buf := make([]byte, chunkLen)
+		for len(data) > 0 {
+			n := copy(buf, data)
+			data = data[n:]
+			h.Write(buf[:n])
+		}
+		return starlark.String(hex.EncodeToString(h.Sum(nil))), nil
+	}
+}
+
+func crc32Checksum(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	data, err := unpackData(b, x)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(len(data))); err != nil {
+		return nil, err
+	}
+
+	var crc uint32
+	var buf [chunkLen]byte
+	for len(data) > 0 {
+		n := copy(buf[:], data)
+		data = data[n:]
+		crc = crc32.Update(crc, crc32.IEEETable, buf[:n])
+	}
+
+	result := starlark.Value(starlark.MakeUint64(uint64(crc)))
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
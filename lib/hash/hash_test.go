@@ -0,0 +1,107 @@
+package hash_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/lib/hash"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range hash.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := hash.Safeties[name]; !ok {
+			t.Errorf("builtin hash.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin hash.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range hash.Safeties {
+		if _, ok := hash.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration hash.%s", name)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`hash.crc32("")`, `0`},
+		{`hash.crc32("hello")`, `907060870`},
+		{`hash.crc32(b"hello")`, `907060870`},
+		{`hash.md5("hello")`, `"5d41402abc4b2a76b9719d911017c592"`},
+		{`hash.sha256("hello")`, `"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"`},
+		{`hash.sha512("")`, `"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"`},
+		{`hash.sha256("x" * 1000) == hash.sha256(b"x" * 1000)`, `True`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		result, err := starlark.Eval(thread, "hash_test.star", test.expr, starlark.StringDict{"hash": hash.Module})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := result.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestHashErrors(t *testing.T) {
+	thread := &starlark.Thread{}
+	_, err := starlark.Eval(thread, "hash_test.star", `hash.sha256(1)`, starlark.StringDict{"hash": hash.Module})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if expected := "hash.sha256: got int, want string or bytes"; err.Error() != expected {
+		t.Errorf("unexpected error: got %q, want %q", err, expected)
+	}
+}
+
+func TestHashResources(t *testing.T) {
+	data := starlark.String(strings.Repeat("hello, world! ", 100))
+
+	for _, name := range []string{"crc32", "md5", "sha256", "sha512"} {
+		t.Run(name, func(t *testing.T) {
+			fn := hash.Module.Members[name]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+			st.SetMinSteps(int64(len(data)))
+			st.SetMaxSteps(int64(len(data)))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, fn, starlark.Tuple{data}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   hash.Module,
+		Safeties: hash.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   hash.Module,
+		Safeties: hash.Safeties,
+	}
+	suite.Benchmark(b)
+}
@@ -0,0 +1,3 @@
+package hex
+
+var Safeties = safeties
@@ -0,0 +1,131 @@
+// Package hex provides hexadecimal encoding and decoding for Starlark
+// programs.
+package hex // import "github.com/canonical/starlark/lib/hex"
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module hex is a Starlark module of hexadecimal encoding functions.
+//
+// The module defines the following functions:
+//
+//	encode(data) - Returns the hexadecimal encoding of data, a string or bytes, as a string of lower-case
+//	               digits.
+//	decode(s) - Returns the bytes encoded by s, a string or bytes of hexadecimal digits of either case.
+var Module = &starlarkstruct.Module{
+	Name: "hex",
+	Members: starlark.StringDict{
+		"decode": starlark.NewBuiltin("hex.decode", decode),
+		"encode": starlark.NewBuiltin("hex.encode", encode),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"decode": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"encode": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// unpackData returns the content of x, a string or bytes.
+func unpackData(b *starlark.Builtin, x starlark.Value) (string, error) {
+	switch x := x.(type) {
+	case starlark.String:
+		return string(x), nil
+	case starlark.Bytes:
+		return string(x), nil
+	default:
+		return "", fmt.Errorf("%s: got %s, want string or bytes", b.Name(), x.Type())
+	}
+}
+
+// chunkLen is the number of bytes of input processed at a time, so that
+// the input need not be copied. It is even, so that no pair of digits
+// spans two chunks.
+const chunkLen = 512
+
+func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	data, err := unpackData(b, x)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(len(data))); err != nil {
+		return nil, err
+	}
+
+	sb := starlark.NewSafeStringBuilder(thread)
+	sb.Grow(hex.EncodedLen(len(data)))
+	if err := sb.Err(); err != nil {
+		return nil, err
+	}
+
+	var src [chunkLen]byte
+	var dst [2 * chunkLen]byte
+	for len(data) > 0 {
+		n := copy(src[:], data)
+		data = data[n:]
+		m := hex.Encode(dst[:], src[:n])
+		if _, err := sb.Write(dst[:m]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := thread.AddAllocs(starlark.StringTypeOverhead); err != nil {
+		return nil, err
+	}
+	return starlark.String(sb.String()), nil
+}
+
+func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	s, err := unpackData(b, x)
+	if err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+
+	sb := starlark.NewSafeStringBuilder(thread)
+	sb.Grow(hex.DecodedLen(len(s)))
+	if err := sb.Err(); err != nil {
+		return nil, err
+	}
+
+	var buf [chunkLen]byte
+	for len(s) > 0 {
+		n := copy(buf[:], s)
+		s = s[n:]
+		m, err := hex.Decode(buf[:], buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		if _, err := sb.Write(buf[:m]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := thread.AddAllocs(starlark.StringTypeOverhead); err != nil {
+		return nil, err
+	}
+	return starlark.Bytes(sb.String()), nil
+}
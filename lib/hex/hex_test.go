@@ -0,0 +1,120 @@
+package hex_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/lib/hex"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range hex.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := hex.Safeties[name]; !ok {
+			t.Errorf("builtin hex.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin hex.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range hex.Safeties {
+		if _, ok := hex.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration hex.%s", name)
+		}
+	}
+}
+
+func TestHex(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`hex.encode("")`, `""`},
+		{`hex.encode("hello")`, `"68656c6c6f"`},
+		{`hex.encode(b"\x00\xff")`, `"00ff"`},
+		{`hex.decode("68656c6c6f")`, `b"hello"`},
+		{`hex.decode(b"00FF")`, `b"\x00\xff"`},
+		{`hex.decode(hex.encode("x" * 1000)) == b"x" * 1000`, `True`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		result, err := starlark.Eval(thread, "hex_test.star", test.expr, starlark.StringDict{"hex": hex.Module})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := result.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestHexErrors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`hex.encode(1)`, `hex.encode: got int, want string or bytes`},
+		{`hex.decode("6g")`, `hex.decode: encoding/hex: invalid byte: U+0067 'g'`},
+		{`hex.decode("686")`, `hex.decode: encoding/hex: odd length hex string`},
+		{`hex.decode("00" * 300 + "6")`, `hex.decode: encoding/hex: odd length hex string`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		_, err := starlark.Eval(thread, "hex_test.star", test.expr, starlark.StringDict{"hex": hex.Module})
+		if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if got := err.Error(); got != test.want {
+			t.Errorf("%s: got error %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestHexResources(t *testing.T) {
+	data := strings.Repeat("hello, world! ", 100)
+	tests := []struct {
+		name string
+		arg  starlark.Value
+	}{
+		{"encode", starlark.String(data)},
+		{"decode", starlark.String(strings.Repeat("68656c6c6f20", 200))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := hex.Module.Members[test.name]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+			st.SetMinSteps(int64(len(test.arg.(starlark.String))))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, fn, starlark.Tuple{test.arg}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   hex.Module,
+		Safeties: hex.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   hex.Module,
+		Safeties: hex.Safeties,
+	}
+	suite.Benchmark(b)
+}
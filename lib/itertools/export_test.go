@@ -0,0 +1,3 @@
+package itertools
+
+var Safeties = safeties
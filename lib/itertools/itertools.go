@@ -0,0 +1,298 @@
+// Package itertools provides functions for grouping and reshaping sequences
+// in Starlark programs.
+package itertools // import "github.com/canonical/starlark/lib/itertools"
+
+import (
+	"fmt"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module itertools is a Starlark module of functions over iterables. Each
+// function runs in time linear in the number of elements it visits, in
+// addition to the time taken by any key function, and returns a new list.
+//
+// The module defines the following functions:
+//
+//	chunked(iterable, n) - Returns a list of lists of n consecutive elements of iterable. The final list holds
+//	                       the remaining elements, so may be shorter.
+//	flatten(iterable) - Returns a list of the elements of each element of iterable, which must themselves be
+//	                    iterable.
+//	groupby(iterable, key=None) - Returns a list of (key, elements) tuples, one for each run of consecutive
+//	                              elements with equal keys, where elements is a list of the run. If key is
+//	                              None, each element is its own key. To group all elements with equal keys,
+//	                              sort iterable by the same key first.
+//	unique(iterable, key=None) - Returns a list of the elements of iterable in order, omitting each element
+//	                             whose key equals that of an earlier element. If key is None, each element
+//	                             is its own key. Keys must be hashable.
+var Module = &starlarkstruct.Module{
+	Name: "itertools",
+	Members: starlark.StringDict{
+		"chunked": starlark.NewBuiltin("itertools.chunked", chunked),
+		"flatten": starlark.NewBuiltin("itertools.flatten", flatten),
+		"groupby": starlark.NewBuiltin("itertools.groupby", groupby),
+		"unique":  starlark.NewBuiltin("itertools.unique", unique),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"chunked": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"flatten": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"groupby": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"unique":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// listBuilder accumulates the elements of a list, declaring its memory to
+// the thread.
+type listBuilder struct {
+	thread   *starlark.Thread
+	elems    []starlark.Value
+	appender *starlark.SafeAppender
+}
+
+func newListBuilder(thread *starlark.Thread) *listBuilder {
+	lb := &listBuilder{thread: thread}
+	lb.appender = starlark.NewSafeAppender(thread, &lb.elems)
+	return lb
+}
+
+func (lb *listBuilder) append(x starlark.Value) error {
+	if err := lb.thread.CheckCollectionLen(len(lb.elems) + 1); err != nil {
+		return err
+	}
+	return lb.appender.Append(x)
+}
+
+func (lb *listBuilder) len() int { return len(lb.elems) }
+
+// list returns a list of the elements accumulated.
+func (lb *listBuilder) list() (*starlark.List, error) {
+	if err := lb.thread.AddAllocs(starlark.EstimateSize(&starlark.List{})); err != nil {
+		return nil, err
+	}
+	return starlark.NewList(lb.elems), nil
+}
+
+// keyOf returns the key of x under the key function, if non-nil.
+func keyOf(thread *starlark.Thread, key starlark.Callable, x starlark.Value) (starlark.Value, error) {
+	if key == nil {
+		return x, nil
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	return starlark.Call(thread, key, starlark.Tuple{x}, nil)
+}
+
+func chunked(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	var n int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "iterable", &iterable, "n", &n); err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("%s: n must be positive, got %d", b.Name(), n)
+	}
+
+	iter, err := starlark.SafeIterate(thread, iterable)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Done()
+
+	chunks := newListBuilder(thread)
+	chunk := newListBuilder(thread)
+	var x starlark.Value
+	for iter.Next(&x) {
+		if err := chunk.append(x); err != nil {
+			return nil, err
+		}
+		if chunk.len() == n {
+			list, err := chunk.list()
+			if err != nil {
+				return nil, err
+			}
+			if err := chunks.append(list); err != nil {
+				return nil, err
+			}
+			chunk = newListBuilder(thread)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if chunk.len() > 0 {
+		list, err := chunk.list()
+		if err != nil {
+			return nil, err
+		}
+		if err := chunks.append(list); err != nil {
+			return nil, err
+		}
+	}
+	return chunks.list()
+}
+
+func flatten(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &iterable); err != nil {
+		return nil, err
+	}
+
+	iter, err := starlark.SafeIterate(thread, iterable)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Done()
+
+	elems := newListBuilder(thread)
+	var x starlark.Value
+	for iter.Next(&x) {
+		inner, ok := x.(starlark.Iterable)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s element, want iterable", b.Name(), x.Type())
+		}
+		if err := flattenInto(thread, elems, inner); err != nil {
+			return nil, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return elems.list()
+}
+
+// flattenInto appends the elements of iterable to elems.
+func flattenInto(thread *starlark.Thread, elems *listBuilder, iterable starlark.Iterable) error {
+	iter, err := starlark.SafeIterate(thread, iterable)
+	if err != nil {
+		return err
+	}
+	defer iter.Done()
+
+	var x starlark.Value
+	for iter.Next(&x) {
+		if err := elems.append(x); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func groupby(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	var key starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "iterable", &iterable, "key?", &key); err != nil {
+		return nil, err
+	}
+
+	iter, err := starlark.SafeIterate(thread, iterable)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Done()
+
+	groups := newListBuilder(thread)
+	var groupKey starlark.Value
+	var group *listBuilder
+	endGroup := func() error {
+		list, err := group.list()
+		if err != nil {
+			return err
+		}
+		pairSize := starlark.SafeAdd(starlark.EstimateMakeSize(starlark.Tuple{}, starlark.SafeInt(2)), starlark.SliceTypeOverhead)
+		if err := thread.AddAllocs(pairSize); err != nil {
+			return err
+		}
+		return groups.append(starlark.Tuple{groupKey, list})
+	}
+
+	var x starlark.Value
+	for iter.Next(&x) {
+		k, err := keyOf(thread, key, x)
+		if err != nil {
+			return nil, err
+		}
+		if group != nil {
+			if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+				return nil, err
+			}
+			if eq, err := starlark.Equal(k, groupKey); err != nil {
+				return nil, err
+			} else if !eq {
+				if err := endGroup(); err != nil {
+					return nil, err
+				}
+				group = nil
+			}
+		}
+		if group == nil {
+			groupKey = k
+			group = newListBuilder(thread)
+		}
+		if err := group.append(x); err != nil {
+			return nil, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if group != nil {
+		if err := endGroup(); err != nil {
+			return nil, err
+		}
+	}
+	return groups.list()
+}
+
+func unique(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	var key starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "iterable", &iterable, "key?", &key); err != nil {
+		return nil, err
+	}
+
+	iter, err := starlark.SafeIterate(thread, iterable)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Done()
+
+	seen, err := starlark.NewDictWithBudget(thread, 0)
+	if err != nil {
+		return nil, err
+	}
+	elems := newListBuilder(thread)
+	var x starlark.Value
+	for iter.Next(&x) {
+		k, err := keyOf(thread, key, x)
+		if err != nil {
+			return nil, err
+		}
+		if _, found, err := seen.SafeGet(thread, k); err != nil {
+			return nil, err
+		} else if found {
+			continue
+		}
+		if err := seen.SafeSetKey(thread, k, starlark.None); err != nil {
+			return nil, err
+		}
+		if err := elems.append(x); err != nil {
+			return nil, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return elems.list()
+}
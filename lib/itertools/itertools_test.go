@@ -0,0 +1,136 @@
+package itertools_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/lib/itertools"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range itertools.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := itertools.Safeties[name]; !ok {
+			t.Errorf("builtin itertools.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin itertools.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range itertools.Safeties {
+		if _, ok := itertools.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration itertools.%s", name)
+		}
+	}
+}
+
+func TestItertools(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`itertools.chunked([], 2)`, `[]`},
+		{`itertools.chunked(range(5), 2)`, `[[0, 1], [2, 3], [4]]`},
+		{`itertools.chunked("ab".elems(), 5)`, `[["a", "b"]]`},
+		{`itertools.flatten([[1, 2], (3,), [], {4: 5}])`, `[1, 2, 3, 4]`},
+		{`itertools.groupby([1, 1, 2, 1])`, `[(1, [1, 1]), (2, [2]), (1, [1])]`},
+		{`itertools.groupby(["a", "bb", "cc", "d"], key=len)`, `[(1, ["a"]), (2, ["bb", "cc"]), (1, ["d"])]`},
+		{`itertools.groupby(sorted(["a", "bb", "c"], key=len), key=len)`, `[(1, ["a", "c"]), (2, ["bb"])]`},
+		{`itertools.unique([3, 1, 3, 2, 1])`, `[3, 1, 2]`},
+		{`itertools.unique(["a", "B", "A", "b"], key=lambda s: s.lower())`, `["a", "B"]`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		result, err := starlark.Eval(thread, "itertools_test.star", test.expr, starlark.StringDict{"itertools": itertools.Module})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := result.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestItertoolsErrors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`itertools.chunked([1], 0)`, `itertools.chunked: n must be positive, got 0`},
+		{`itertools.flatten([[1], 2])`, `itertools.flatten: got int element, want iterable`},
+		{`itertools.unique([[1], [1]])`, `unhashable type: list`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		_, err := starlark.Eval(thread, "itertools_test.star", test.expr, starlark.StringDict{"itertools": itertools.Module})
+		if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if got := err.Error(); !strings.Contains(got, test.want) {
+			t.Errorf("%s: got error %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestItertoolsResources(t *testing.T) {
+	const n = 100
+	elems := make([]starlark.Value, n)
+	nested := make([]starlark.Value, n)
+	for i := range elems {
+		elems[i] = starlark.MakeInt(i / 3)
+		nested[i] = starlark.Tuple{starlark.MakeInt(i), starlark.MakeInt(i)}
+	}
+	input := starlark.NewList(elems)
+	input.Freeze()
+	nestedInput := starlark.NewList(nested)
+	nestedInput.Freeze()
+
+	tests := []struct {
+		name string
+		args starlark.Tuple
+	}{
+		{"chunked", starlark.Tuple{input, starlark.MakeInt(7)}},
+		{"flatten", starlark.Tuple{nestedInput}},
+		{"groupby", starlark.Tuple{input}},
+		{"unique", starlark.Tuple{input}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := itertools.Module.Members[test.name]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+			st.SetMinSteps(n)
+			st.SetMaxSteps(8 * n)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, fn, test.args, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   itertools.Module,
+		Safeties: itertools.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   itertools.Module,
+		Safeties: itertools.Safeties,
+	}
+	suite.Benchmark(b)
+}
@@ -32,7 +32,7 @@ import (
 //	   indent,
 //	)
 //
-// def encode(x):
+// def encode(x, *, encoder=None, indent=None, sort_keys=True):
 //
 // The encode function accepts one required positional argument,
 // which it converts to JSON by cases:
@@ -46,13 +46,26 @@ import (
 //     It is an error to encode a non-finite floating-point value.
 //   - Starlark strings are encoded as JSON strings, using UTF-16 escapes.
 //   - a Starlark IterableMapping (e.g. dict) is encoded as a JSON object.
-//     It is an error if any key is not a string.
+//     It is an error if any key is not a string. Keys are sorted unless
+//     sort_keys is False, in which case they keep the mapping's order.
 //   - any other Starlark Iterable (e.g. list, tuple) is encoded as a JSON array.
 //   - a Starlark HasAttrs (e.g. struct) is encoded as a JSON object.
+//     Like keys, its attributes are sorted unless sort_keys is False.
 //
 // It an application-defined type matches more than one the cases describe above,
 // (e.g. it implements both Iterable and HasFields), the first case takes precedence.
-// Encoding any other value yields an error.
+//
+// Encoding any other value yields an error, unless the optional keyword-only
+// encoder parameter is a callable, in which case encoder(v) is called and its
+// result is encoded in place of v, as with the default parameter of Python's
+// json.dumps. The result must not itself require the encoder. The encoder is
+// called by the calling thread, so it is subject to the thread's required
+// safety and its budgets.
+//
+// By default, the encoding is compact. If the optional keyword-only indent
+// parameter is a string, the encoding is instead indented as if by
+// json.indent(json.encode(x), indent=indent), without first building the
+// compact form.
 //
 // def decode(x[, default]):
 //
@@ -63,6 +76,9 @@ import (
 //   - JSON objects are parsed as new unfrozen Starlark dicts.
 //   - JSON arrays are parsed as new unfrozen Starlark lists.
 //
+// Strings which must be unescaped are interned in the thread's string pool,
+// if it has one, so that repeated strings are stored only once.
+//
 // If x is not a valid JSON string, the behavior depends on the "default"
 // parameter: if present, Decode returns its value; otherwise, Decode fails.
 //
@@ -98,8 +114,33 @@ func init() {
 }
 
 func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var x starlark.Value
-	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+	var encoderArg, indentArg starlark.Value = starlark.None, starlark.None // keyword-only
+	sortKeys := true
+	if err := starlark.UnpackArgs(b.Name(), nil, kwargs,
+		"encoder?", &encoderArg,
+		"indent?", &indentArg,
+		"sort_keys?", &sortKeys,
+	); err != nil {
+		return nil, err
+	}
+	var encoder starlark.Callable
+	if encoderArg != starlark.None {
+		var ok bool
+		if encoder, ok = encoderArg.(starlark.Callable); !ok {
+			return nil, fmt.Errorf("%s: for parameter encoder: got %s, want callable or None", b.Name(), encoderArg.Type())
+		}
+	}
+	var indent string
+	indenting := indentArg != starlark.None
+	if indenting {
+		s, ok := indentArg.(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: for parameter indent: got %s, want string or None", b.Name(), indentArg.Type())
+		}
+		indent = string(s)
+	}
+	var x starlark.Value // positional-only
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, nil, 1, &x); err != nil {
 		return nil, err
 	}
 
@@ -123,10 +164,66 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 		return nil
 	}
 
+	// depth is the nesting depth of the array or object being emitted,
+	// which determines the indentation of its elements.
+	depth := 0
+	newline := func() error {
+		if err := buf.WriteByte('\n'); err != nil {
+			return err
+		}
+		for i := 0; i < depth; i++ {
+			if _, err := buf.WriteString(indent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// element starts the i'th element of an array or object.
+	element := func(i int) error {
+		if i > 0 {
+			if err := buf.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		if !indenting {
+			return nil
+		}
+		if i == 0 {
+			depth++
+		}
+		return newline()
+	}
+	// end ends an array or object of n elements with the closing bracket c.
+	// As with json.indent, empty arrays and objects are not broken over
+	// lines.
+	end := func(n int, c byte) error {
+		if indenting && n > 0 {
+			depth--
+			if err := newline(); err != nil {
+				return err
+			}
+		}
+		return buf.WriteByte(c)
+	}
+	colon := func() error {
+		if indenting {
+			_, err := buf.WriteString(": ")
+			return err
+		}
+		return buf.WriteByte(':')
+	}
+
 	path := make([]unsafe.Pointer, 0, 8)
 
+	// encoded records whether the value being emitted was returned by
+	// the encoder, which is not called again on such values.
+	encoded := false
+
 	var emit func(x starlark.Value) error
 	emit = func(x starlark.Value) error {
+		fromEncoder := encoded
+		encoded = false
+
 		// It is only necessary to push/pop the item when it might contain
 		// itself (i.e. the last three switch cases), but omitting it in the other
 		// cases did not show significant improvement on the benchmarks.
@@ -150,6 +247,13 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			if err != nil {
 				return err
 			}
+			if indenting {
+				indented := new(bytes.Buffer)
+				if err := json.Indent(indented, data, strings.Repeat(indent, depth), indent); err != nil {
+					return err
+				}
+				data = indented.Bytes()
+			}
 			if _, err := buf.Write(data); err != nil {
 				return err
 			}
@@ -202,27 +306,27 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 					return fmt.Errorf("%s has %s key, want string", x.Type(), item[0].Type())
 				}
 			}
-			sort.Slice(items, func(i, j int) bool {
-				return items[i][0].(starlark.String) < items[j][0].(starlark.String)
-			})
+			if sortKeys {
+				sort.Slice(items, func(i, j int) bool {
+					return items[i][0].(starlark.String) < items[j][0].(starlark.String)
+				})
+			}
 			for i, item := range items {
-				if i > 0 {
-					if err := buf.WriteByte(','); err != nil {
-						return err
-					}
+				if err := element(i); err != nil {
+					return err
 				}
 				k, _ := starlark.AsString(item[0])
 				if err := quote(k); err != nil {
 					return err
 				}
-				if err := buf.WriteByte(':'); err != nil {
+				if err := colon(); err != nil {
 					return err
 				}
 				if err := emit(item[1]); err != nil {
 					return fmt.Errorf("in %s key %s: %w", x.Type(), item[0], err)
 				}
 			}
-			if err := buf.WriteByte('}'); err != nil {
+			if err := end(len(items), '}'); err != nil {
 				return err
 			}
 
@@ -235,11 +339,10 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			}
 			defer iter.Done()
 			var elem starlark.Value
-			for i := 0; iter.Next(&elem); i++ {
-				if i > 0 {
-					if err := buf.WriteByte(','); err != nil {
-						return err
-					}
+			i := 0
+			for ; iter.Next(&elem); i++ {
+				if err := element(i); err != nil {
+					return err
 				}
 				if err := emit(elem); err != nil {
 					return fmt.Errorf("at %s index %d: %w", x.Type(), i, err)
@@ -248,7 +351,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			if err := iter.Err(); err != nil {
 				return err
 			}
-			if err := buf.WriteByte(']'); err != nil {
+			if err := end(i, ']'); err != nil {
 				return err
 			}
 
@@ -261,7 +364,9 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			// expected for the attributes to be a relatively small set.
 			var names []string
 			names = append(names, x.AttrNames()...)
-			sort.Strings(names)
+			if sortKeys {
+				sort.Strings(names)
+			}
 			if err := thread.AddSteps(starlark.SafeInt(len(names))); err != nil {
 				return err
 			}
@@ -281,27 +386,36 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 					// that the field doesn't exist.
 					return fmt.Errorf("missing attribute %s.%s (despite %q appearing in dir()", x.Type(), name, name)
 				}
-				if i > 0 {
-					if err := buf.WriteByte(','); err != nil {
-						return err
-					}
+				if err := element(i); err != nil {
+					return err
 				}
 				if err := quote(name); err != nil {
 					return err
 				}
-				if err := buf.WriteByte(':'); err != nil {
+				if err := colon(); err != nil {
 					return err
 				}
 				if err := emit(v); err != nil {
 					return fmt.Errorf("in field .%s: %w", name, err)
 				}
 			}
-			if err := buf.WriteByte('}'); err != nil {
+			if err := end(len(names), '}'); err != nil {
 				return err
 			}
 
 		default:
-			return fmt.Errorf("cannot encode %s as JSON", x.Type())
+			if encoder == nil {
+				return fmt.Errorf("cannot encode %s as JSON", x.Type())
+			}
+			if fromEncoder {
+				return fmt.Errorf("encoder returned %s, which cannot be encoded as JSON", x.Type())
+			}
+			v, err := starlark.Call(thread, encoder, starlark.Tuple{x}, nil)
+			if err != nil {
+				return err
+			}
+			encoded = true
+			return emit(v)
 		}
 		return nil
 	}
@@ -527,7 +641,8 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 				if err := json.Unmarshal([]byte(r), &r); err != nil {
 					fail("%s", err)
 				}
-				if err := thread.AddAllocs(starlark.EstimateSize(r)); err != nil {
+				var err error
+				if r, err = thread.InternString(r); err != nil {
 					failWith(err)
 				}
 			}
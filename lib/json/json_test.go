@@ -3,12 +3,15 @@ package json_test
 import (
 	"errors"
 	"fmt"
+	"io"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/canonical/starlark/lib/json"
 	"github.com/canonical/starlark/starlark"
 	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
 )
 
 type unsafeTestIterable struct {
@@ -294,6 +297,28 @@ func TestJsonEncodeAllocs(t *testing.T) {
 			st.KeepAlive(result)
 		})
 	})
+
+	t.Run("indented", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.RunThread(func(thread *starlark.Thread) {
+			// Each level of nesting indents all of the elements within it.
+			var nested starlark.Value = starlark.NewList(nil)
+			for i := 0; i < 10; i++ {
+				nested = starlark.NewList([]starlark.Value{nested, starlark.MakeInt(i)})
+			}
+			array := make(starlark.Tuple, st.N)
+			for i := 0; i < st.N; i++ {
+				array[i] = nested
+			}
+			kwargs := []starlark.Tuple{{starlark.String("indent"), starlark.String("    ")}}
+			result, err := starlark.Call(thread, json_encode, starlark.Tuple{array}, kwargs)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		})
+	})
 }
 
 func TestJsonEncodeCancellation(t *testing.T) {
@@ -356,6 +381,56 @@ func TestJsonEncodeCancellation(t *testing.T) {
 	})
 }
 
+func TestJsonEncodeEncoder(t *testing.T) {
+	json_encode, _ := json.Module.Attr("encode")
+	if json_encode == nil {
+		t.Fatal("no such method: json.encode")
+	}
+
+	unencodable := starlark.NewBuiltin("unencodable", nil)
+	encodeWith := func(thread *starlark.Thread, encoder starlark.Value) (starlark.Value, error) {
+		kwargs := []starlark.Tuple{{starlark.String("encoder"), encoder}}
+		return starlark.Call(thread, json_encode, starlark.Tuple{unencodable}, kwargs)
+	}
+	encoderImpl := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := thread.AddSteps(starlark.SafeInt(10)); err != nil {
+			return nil, err
+		}
+		return starlark.String("encoded"), nil
+	}
+
+	t.Run("safety-respected", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.TimeSafe)
+
+		encoder := starlark.NewBuiltin("encoder", encoderImpl)
+		if _, err := encodeWith(thread, encoder); err == nil {
+			t.Error("expected error")
+		} else if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		encoder = starlark.NewBuiltinWithSafety("encoder", starlark.TimeSafe, encoderImpl)
+		if result, err := encodeWith(thread, encoder); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if result != starlark.String(`"encoded"`) {
+			t.Errorf("unexpected result: %v", result)
+		}
+	})
+
+	t.Run("budget-respected", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(5)
+
+		encoder := starlark.NewBuiltinWithSafety("encoder", starlark.TimeSafe, encoderImpl)
+		if _, err := encodeWith(thread, encoder); err == nil {
+			t.Error("expected cancellation")
+		} else if !isStarlarkCancellation(err) {
+			t.Errorf("expected cancellation, got: %v", err)
+		}
+	})
+}
+
 func TestJsonDecodeSteps(t *testing.T) {
 	json_decode, _ := json.Module.Attr("decode")
 	if json_decode == nil {
@@ -490,6 +565,125 @@ func TestJsonDecodeAllocs(t *testing.T) {
 	})
 }
 
+func TestJsonDecodeStringPool(t *testing.T) {
+	json_decode, _ := json.Module.Attr("decode")
+	if json_decode == nil {
+		t.Fatal("no such method: json.decode")
+	}
+
+	key := strings.Repeat("caf\\u00e9", 100)
+	document := starlark.String("[" + strings.TrimSuffix(strings.Repeat(`{"`+key+`": 1},`, 100), ",") + "]")
+
+	decodeAllocs := func(pool *starlark.StringPool) int64 {
+		thread := &starlark.Thread{}
+		if pool != nil {
+			thread.SetStringPool(pool)
+		}
+		if _, err := starlark.Call(thread, json_decode, starlark.Tuple{document}, nil); err != nil {
+			t.Fatal(err)
+		}
+		allocs, _ := thread.Allocs()
+		return allocs
+	}
+
+	pool := starlark.NewStringPool()
+	unpooled, pooled := decodeAllocs(nil), decodeAllocs(pool)
+	if n := pool.Len(); n != 1 {
+		t.Errorf("incorrect pool length: expected 1 but got %d", n)
+	}
+	if saving := unpooled - pooled; saving < 99*int64(len("café")*100) {
+		t.Errorf("pool saved too little memory: %d bytes (%d vs %d)", saving, unpooled, pooled)
+	}
+}
+
+func TestSafeDecoder(t *testing.T) {
+	input := `{"a": [1, 2.5, "x"], "b": null} true 3825590844416`
+	thread := &starlark.Thread{}
+	dec := json.NewSafeDecoder(thread, strings.NewReader(input))
+
+	var got []string
+	for {
+		v, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v.String())
+	}
+	expected := []string{`{"a": [1, 2.5, "x"], "b": None}`, "True", "3825590844416"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect values: expected %v but got %v", expected, got)
+	}
+}
+
+func TestSafeDecoderAllocs(t *testing.T) {
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		document := `[{"Int": 48879, "Float": 1.4218e-1, "String": "tnetennba"}, [1, 2], true, null]`
+		for i := 0; i < st.N; i++ {
+			result, err := json.NewSafeDecoder(thread, strings.NewReader(document)).Decode()
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		}
+	})
+}
+
+// countingReader records the number of bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if len(p) > 64 {
+		p = p[:64]
+	}
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestSafeDecoderCancellation(t *testing.T) {
+	document := "[" + strings.TrimSuffix(strings.Repeat(`"tnetennba",`, 10000), ",") + "]"
+
+	t.Run("steps", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(1000)
+		r := &countingReader{r: strings.NewReader(document)}
+		_, err := json.NewSafeDecoder(thread, r).Decode()
+		if err == nil {
+			t.Fatal("expected cancellation")
+		}
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if r.n >= len(document) {
+			t.Errorf("decoder read whole document before cancellation")
+		}
+	})
+
+	t.Run("allocs", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(1000)
+		r := &countingReader{r: strings.NewReader(document)}
+		_, err := json.NewSafeDecoder(thread, r).Decode()
+		if err == nil {
+			t.Fatal("expected cancellation")
+		}
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if r.n >= len(document) {
+			t.Errorf("decoder read whole document before cancellation")
+		}
+	})
+}
+
 func TestJsonDecodeCancellation(t *testing.T) {
 	json_decode, _ := json.Module.Attr("decode")
 	if json_decode == nil {
@@ -647,3 +841,19 @@ func TestJsonIndentAllocs(t *testing.T) {
 		}
 	})
 }
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   json.Module,
+		Safeties: *json.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   json.Module,
+		Safeties: *json.Safeties,
+	}
+	suite.Benchmark(b)
+}
@@ -0,0 +1,156 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+// A SafeDecoder reads successive JSON values from an input stream and
+// converts them to Starlark values with the same representation as
+// json.decode.
+//
+// Unlike json.decode, a SafeDecoder does not need the whole document in
+// memory before it begins. The thread is charged a step for each byte read
+// from the stream and for the memory of each value as it is materialized, so
+// decoding stops part way through a document as soon as the thread's budget
+// is exceeded or the thread is cancelled.
+type SafeDecoder struct {
+	thread *starlark.Thread
+	dec    *json.Decoder
+}
+
+// NewSafeDecoder returns a decoder which reads from r on behalf of thread.
+func NewSafeDecoder(thread *starlark.Thread, r io.Reader) *SafeDecoder {
+	dec := json.NewDecoder(&stepReader{thread: thread, r: r})
+	dec.UseNumber()
+	return &SafeDecoder{thread: thread, dec: dec}
+}
+
+// stepReader charges its thread a step for each byte it reads.
+type stepReader struct {
+	thread *starlark.Thread
+	r      io.Reader
+}
+
+func (r *stepReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if err := r.thread.AddSteps(starlark.SafeInt(n)); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// Decode reads the next JSON value from the stream. At the end of the
+// stream, it returns io.EOF.
+func (d *SafeDecoder) Decode() (starlark.Value, error) {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return d.value(tok)
+}
+
+// value materializes the value which begins with tok.
+func (d *SafeDecoder) value(tok json.Token) (starlark.Value, error) {
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '[':
+			return d.list()
+		case '{':
+			return d.dict()
+		default:
+			return nil, fmt.Errorf("json: unexpected %q", rune(tok))
+		}
+	case string:
+		s, err := d.thread.InternString(tok)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.String(s), nil
+	case json.Number:
+		return d.number(string(tok))
+	case bool:
+		return starlark.Bool(tok), nil
+	case nil:
+		return starlark.None, nil
+	default:
+		return nil, fmt.Errorf("json: unexpected token %v", tok)
+	}
+}
+
+func (d *SafeDecoder) list() (starlark.Value, error) {
+	if err := d.thread.AddAllocs(starlark.EstimateSize(&starlark.List{})); err != nil {
+		return nil, err
+	}
+	var elems []starlark.Value
+	elemsAppender := starlark.NewSafeAppender(d.thread, &elems)
+	for d.dec.More() {
+		elem, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if err := elemsAppender.Append(elem); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := d.dec.Token(); err != nil { // ']'
+		return nil, err
+	}
+	return starlark.NewList(elems), nil
+}
+
+func (d *SafeDecoder) dict() (starlark.Value, error) {
+	dict := new(starlark.Dict)
+	if err := d.thread.AddAllocs(starlark.EstimateSize(dict)); err != nil {
+		return nil, err
+	}
+	for d.dec.More() {
+		key, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if err := dict.SafeSetKey(d.thread, key, value); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := d.dec.Token(); err != nil { // '}'
+		return nil, err
+	}
+	return dict, nil
+}
+
+// number converts a JSON number to an int or, if it has a fractional part
+// or exponent, to a float.
+func (d *SafeDecoder) number(num string) (starlark.Value, error) {
+	var res starlark.Value
+	if strings.ContainsAny(num, ".eE") {
+		x, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil, fmt.Errorf("json: invalid number: %s", num)
+		}
+		res = starlark.Float(x)
+	} else {
+		x, ok := new(big.Int).SetString(num, 10)
+		if !ok {
+			return nil, fmt.Errorf("json: invalid number: %s", num)
+		}
+		res = starlark.MakeBigInt(x)
+	}
+	if err := d.thread.AddAllocs(starlark.EstimateSize(res)); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -57,6 +57,10 @@ import (
 //
 //	gamma(x) - Returns the Gamma function of x.
 //
+//	isfinite(x) - Reports whether x is neither an infinity nor NaN.
+//	isinf(x) - Reports whether x is positive or negative infinity.
+//	isnan(x) - Reports whether x is NaN (not a number).
+//
 // All functions accept both int and float values as arguments.
 //
 // The module also defines approximations of the following constants:
@@ -101,6 +105,10 @@ var Module = &starlarkstruct.Module{
 
 		"gamma": newUnaryBuiltin("gamma", math.Gamma),
 
+		"isfinite": newPredicateBuiltin("isfinite", isfinite, true),
+		"isinf":    newPredicateBuiltin("isinf", isinf, false),
+		"isnan":    newPredicateBuiltin("isnan", math.IsNaN, false),
+
 		"e":  starlark.Float(math.E),
 		"pi": starlark.Float(math.Pi),
 	},
@@ -134,6 +142,9 @@ var safeties = map[string]starlark.SafetyFlags{
 	"tanh":      starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
 	"log":       starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
 	"gamma":     starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"isfinite":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"isinf":     starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"isnan":     starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
 }
 
 var floatSize = starlark.EstimateSize(starlark.Float(0))
@@ -193,6 +204,29 @@ func newBinaryBuiltin(name string, fn func(float64, float64) float64) *starlark.
 	})
 }
 
+// newPredicateBuiltin wraps a floating-point Go predicate
+// as a Starlark built-in that accepts int or float arguments.
+// Since ints are always finite, and converting a huge int to a float
+// would round it to infinity, the predicate is not applied to ints:
+// ofInt is returned instead.
+func newPredicateBuiltin(name string, fn func(float64) bool, ofInt bool) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(name, args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+
+		switch x := x.(type) {
+		case starlark.Int:
+			return starlark.Bool(ofInt), nil
+		case starlark.Float:
+			return starlark.Bool(fn(float64(x))), nil
+		}
+
+		return nil, fmt.Errorf("got %s, want float or int", x.Type())
+	})
+}
+
 //	log wraps the Log function
 //
 // as a Starlark built-in that accepts int or float arguments.
@@ -270,3 +304,11 @@ func degrees(x float64) float64 {
 func radians(x float64) float64 {
 	return 2 * math.Pi * x / 360
 }
+
+func isfinite(x float64) bool {
+	return !math.IsInf(x, 0) && !math.IsNaN(x)
+}
+
+func isinf(x float64) bool {
+	return math.IsInf(x, 0)
+}
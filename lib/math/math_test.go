@@ -7,6 +7,7 @@ import (
 	starlarkmath "github.com/canonical/starlark/lib/math"
 	"github.com/canonical/starlark/starlark"
 	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
 )
 
 func TestModuleSafeties(t *testing.T) {
@@ -554,3 +555,79 @@ func TestMathGammaSteps(t *testing.T) {
 func TestMathGammaAllocs(t *testing.T) {
 	testUnarySafety(t, "gamma", []float64{0, 1, 170})
 }
+
+func TestMathIsfiniteSteps(t *testing.T) {
+	testPredicateSteps(t, "isfinite")
+}
+
+func TestMathIsfiniteAllocs(t *testing.T) {
+	testPredicateAllocs(t, "isfinite")
+}
+
+func TestMathIsinfSteps(t *testing.T) {
+	testPredicateSteps(t, "isinf")
+}
+
+func TestMathIsinfAllocs(t *testing.T) {
+	testPredicateAllocs(t, "isinf")
+}
+
+func TestMathIsnanSteps(t *testing.T) {
+	testPredicateSteps(t, "isnan")
+}
+
+func TestMathIsnanAllocs(t *testing.T) {
+	testPredicateAllocs(t, "isnan")
+}
+
+var predicateInputs = []starlark.Value{
+	starlark.Float(0),
+	starlark.Float(math.Inf(1)),
+	starlark.Float(math.Inf(-1)),
+	starlark.Float(math.NaN()),
+	starlark.MakeInt(1),
+	starlark.MakeInt64(1 << 60),
+}
+
+func testPredicateSteps(t *testing.T, name string) {
+	testUnarySteps(t, name, predicateInputs)
+}
+
+func testPredicateAllocs(t *testing.T, name string) {
+	builtin, ok := starlarkmath.Module.Members[name]
+	if !ok {
+		t.Fatalf("no such builtin: math.%s", name)
+	}
+
+	for _, input := range predicateInputs {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.SetMaxAllocs(0)
+		st.RunThread(func(thread *starlark.Thread) {
+			args := starlark.Tuple{input}
+			for i := 0; i < st.N; i++ {
+				result, err := starlark.Call(thread, builtin, args, nil)
+				if err != nil {
+					st.Error(err)
+				}
+				st.KeepAlive(result)
+			}
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   starlarkmath.Module,
+		Safeties: *starlarkmath.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   starlarkmath.Module,
+		Safeties: *starlarkmath.Safeties,
+	}
+	suite.Benchmark(b)
+}
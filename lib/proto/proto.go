@@ -142,7 +142,7 @@ var Module = &starlarkstruct.Module{
 		"file":           starlark.NewBuiltin("proto.file", file),
 		"has":            starlark.NewBuiltin("proto.has", has),
 		"marshal":        starlark.NewBuiltin("proto.marshal", marshal),
-		"marshal_text":   starlark.NewBuiltin("proto.marshal_text", marshal),
+		"marshal_text":   starlark.NewBuiltin("proto.marshal_text", marshal_text),
 		"set_field":      starlark.NewBuiltin("proto.set_field", setFieldStarlark),
 		"get_field":      starlark.NewBuiltin("proto.get_field", getFieldStarlark),
 		"unmarshal":      starlark.NewBuiltin("proto.unmarshal", unmarshal),
@@ -156,14 +156,14 @@ var Module = &starlarkstruct.Module{
 	},
 }
 var safeties = map[string]starlark.SafetyFlags{
-	"file":           starlark.NotSafe,
-	"has":            starlark.NotSafe,
-	"marshal":        starlark.NotSafe,
-	"marshal_text":   starlark.NotSafe,
+	"file":           starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"has":            starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"marshal":        starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"marshal_text":   starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
 	"set_field":      starlark.NotSafe,
 	"get_field":      starlark.NotSafe,
-	"unmarshal":      starlark.NotSafe,
-	"unmarshal_text": starlark.NotSafe,
+	"unmarshal":      starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"unmarshal_text": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
 }
 
 func init() {
@@ -196,11 +196,17 @@ func file(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kw
 		return nil, fmt.Errorf("internal error: SetPool was not called")
 	}
 
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
 	desc, err := pool.FindFileByPath(filename)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := thread.AddAllocs(starlark.EstimateSize(FileDescriptor{})); err != nil {
+		return nil, err
+	}
 	return FileDescriptor{Desc: desc}, nil
 }
 
@@ -217,6 +223,9 @@ func has(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwa
 		return nil, fmt.Errorf("%s: got %s, want proto.Message", fn.Name(), x.Type())
 	}
 
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
 	var fdesc protoreflect.FieldDescriptor
 	switch field := field.(type) {
 	case starlark.String:
@@ -239,25 +248,58 @@ func has(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwa
 	return starlark.Bool(msg.msg.Has(fdesc)), nil
 }
 
-// marshal{,_text}(msg) encodes a Message value to binary or text form.
-func marshal(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+// decodeAllocsPerByte bounds the memory used by a decoded message for each
+// byte of its encoding. The worst case is a repeated field of empty
+// messages, each of which takes only two bytes to encode.
+const decodeAllocsPerByte = 128
+
+// marshal(msg) encodes a Message value to binary form.
+func marshal(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var m *Message
 	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &m); err != nil {
 		return nil, err
 	}
-	if fn.Name() == "proto.marshal" {
-		data, err := proto.Marshal(m.Message())
-		if err != nil {
-			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
-		}
-		return starlark.Bytes(data), nil
-	} else {
-		text, err := prototext.MarshalOptions{Indent: "  "}.Marshal(m.Message())
-		if err != nil {
-			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
-		}
-		return starlark.String(text), nil
+	size := proto.Size(m.Message())
+	if err := thread.AddSteps(starlark.SafeInt(size)); err != nil {
+		return nil, err
+	}
+	resultSize := starlark.SafeAdd(
+		starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(size)),
+		starlark.StringTypeOverhead,
+	)
+	if err := thread.AddAllocs(resultSize); err != nil {
+		return nil, err
 	}
+	data, err := proto.MarshalOptions{}.MarshalAppend(make([]byte, 0, size), m.Message())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.Bytes(data), nil
+}
+
+// marshal_text(msg) encodes a Message value to text form.
+func marshal_text(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var m *Message
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &m); err != nil {
+		return nil, err
+	}
+	// The length of the text form is not known in advance, but it is at
+	// least that of the binary form.
+	if err := thread.AddSteps(starlark.SafeInt(proto.Size(m.Message()))); err != nil {
+		return nil, err
+	}
+	text, err := prototext.MarshalOptions{Indent: "  "}.Marshal(m.Message())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	resultSize := starlark.SafeAdd(
+		starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(len(text))),
+		starlark.StringTypeOverhead,
+	)
+	if err := thread.AddAllocs(resultSize); err != nil {
+		return nil, err
+	}
+	return starlark.String(text), nil
 }
 
 // unmarshal(msg) decodes a binary protocol message to a Message.
@@ -267,6 +309,9 @@ func unmarshal(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tupl
 	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &desc, &data); err != nil {
 		return nil, err
 	}
+	if err := addDecodeCost(thread, len(data)); err != nil {
+		return nil, err
+	}
 	return unmarshalData(desc.Desc, []byte(data), true)
 }
 
@@ -277,9 +322,24 @@ func unmarshal_text(thread *starlark.Thread, fn *starlark.Builtin, args starlark
 	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &desc, &data); err != nil {
 		return nil, err
 	}
+	if err := addDecodeCost(thread, len(data)); err != nil {
+		return nil, err
+	}
 	return unmarshalData(desc.Desc, []byte(data), false)
 }
 
+// addDecodeCost charges the thread for decoding n bytes of encoded message.
+func addDecodeCost(thread *starlark.Thread, n int) error {
+	if err := thread.AddSteps(starlark.SafeInt(n)); err != nil {
+		return err
+	}
+	resultSize := starlark.SafeAdd(
+		starlark.EstimateSize(&Message{frozen: new(bool)}),
+		starlark.SafeMul(n, decodeAllocsPerByte),
+	)
+	return thread.AddAllocs(resultSize)
+}
+
 // set_field(msg, field, value) updates the value of a field.
 // It is typically used for extensions, which cannot be updated using msg.field = v notation.
 func setFieldStarlark(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -1,10 +1,18 @@
 package proto_test
 
 import (
+	"strings"
 	"testing"
 
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
 	"github.com/canonical/starlark/lib/proto"
 	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
 )
 
 func TestModuleSafeties(t *testing.T) {
@@ -28,28 +36,255 @@ func TestModuleSafeties(t *testing.T) {
 	}
 }
 
+// testFile describes the following message, which is not known to the
+// global type registry and so is represented dynamically:
+//
+//	message Test {
+//		repeated string strings = 1;
+//		repeated int64 ints = 2;
+//		repeated Test children = 3;
+//	}
+var testFile = func() protoreflect.FileDescriptor {
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    protobuf.String("test.proto"),
+		Package: protobuf.String("test"),
+		Syntax:  protobuf.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: protobuf.String("Test"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:   protobuf.String("strings"),
+				Number: protobuf.Int32(1),
+				Label:  repeated,
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}, {
+				Name:   protobuf.String("ints"),
+				Number: protobuf.Int32(2),
+				Label:  repeated,
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+			}, {
+				Name:     protobuf.String("children"),
+				Number:   protobuf.Int32(3),
+				Label:    repeated,
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: protobuf.String(".test.Test"),
+			}},
+		}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return fd
+}()
+
+var testDesc = proto.MessageDescriptor{Desc: testFile.Messages().ByName("Test")}
+
+// testEncodings returns binary encodings of test messages of n elements
+// which are expensive to decode relative to their size.
+func testEncodings(n int) map[string][]byte {
+	encodings := map[string][]byte{}
+	for _, field := range []struct {
+		name  string
+		tag   byte
+		entry []byte
+	}{
+		{"strings", 1<<3 | 2, []byte{0}},
+		{"children", 3<<3 | 2, []byte{0}},
+	} {
+		data := make([]byte, 0, n*(1+len(field.entry)))
+		for i := 0; i < n; i++ {
+			data = append(data, field.tag)
+			data = append(data, field.entry...)
+		}
+		encodings[field.name] = data
+	}
+	ints := []byte{2<<3 | 2}
+	ints = protowireAppendVarint(ints, uint64(n))
+	for i := 0; i < n; i++ {
+		ints = append(ints, 1)
+	}
+	encodings["ints"] = ints
+	return encodings
+}
+
+func protowireAppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func newTestThread() *starlark.Thread {
+	thread := &starlark.Thread{}
+	proto.SetPool(thread, protoregistry.GlobalFiles)
+	return thread
+}
+
 func TestProtoFileSteps(t *testing.T) {
+	file, _ := proto.Module.Attr("file")
+	st := startest.From(t)
+	st.RequireSafety(starlark.CPUSafe)
+	st.SetMinSteps(1)
+	st.SetMaxSteps(1)
+	st.RunThread(func(thread *starlark.Thread) {
+		proto.SetPool(thread, protoregistry.GlobalFiles)
+		for i := 0; i < st.N; i++ {
+			_, err := starlark.Call(thread, file, starlark.Tuple{starlark.String("google/protobuf/descriptor.proto")}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+		}
+	})
 }
 
 func TestProtoFileAllocs(t *testing.T) {
+	file, _ := proto.Module.Attr("file")
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		proto.SetPool(thread, protoregistry.GlobalFiles)
+		for i := 0; i < st.N; i++ {
+			result, err := starlark.Call(thread, file, starlark.Tuple{starlark.String("google/protobuf/descriptor.proto")}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		}
+	})
 }
 
 func TestProtoHasSteps(t *testing.T) {
+	has, _ := proto.Module.Attr("has")
+	msg, err := proto.Unmarshal(testDesc.Desc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := startest.From(t)
+	st.RequireSafety(starlark.CPUSafe)
+	st.SetMinSteps(1)
+	st.SetMaxSteps(1)
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			_, err := starlark.Call(thread, has, starlark.Tuple{msg, starlark.String("strings")}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+		}
+	})
 }
 
 func TestProtoHasAllocs(t *testing.T) {
+	has, _ := proto.Module.Attr("has")
+	msg, err := proto.Unmarshal(testDesc.Desc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			result, err := starlark.Call(thread, has, starlark.Tuple{msg, starlark.String("strings")}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		}
+	})
 }
 
 func TestProtoMarshalSteps(t *testing.T) {
+	marshal, _ := proto.Module.Attr("marshal")
+	for name, data := range testEncodings(100) {
+		t.Run(name, func(t *testing.T) {
+			msg, err := proto.Unmarshal(testDesc.Desc, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe)
+			st.SetMinSteps(int64(len(data)))
+			st.SetMaxSteps(int64(len(data)))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					_, err := starlark.Call(thread, marshal, starlark.Tuple{msg}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+				}
+			})
+		})
+	}
 }
 
 func TestProtoMarshalAllocs(t *testing.T) {
+	marshal, _ := proto.Module.Attr("marshal")
+	for name, data := range testEncodings(100) {
+		t.Run(name, func(t *testing.T) {
+			msg, err := proto.Unmarshal(testDesc.Desc, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, marshal, starlark.Tuple{msg}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
 }
 
 func TestProtoMarshalTextSteps(t *testing.T) {
+	marshal_text, _ := proto.Module.Attr("marshal_text")
+	for name, data := range testEncodings(100) {
+		t.Run(name, func(t *testing.T) {
+			msg, err := proto.Unmarshal(testDesc.Desc, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe)
+			st.SetMinSteps(int64(len(data)))
+			st.SetMaxSteps(int64(len(data)))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					_, err := starlark.Call(thread, marshal_text, starlark.Tuple{msg}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+				}
+			})
+		})
+	}
 }
 
 func TestProtoMarshalTextAllocs(t *testing.T) {
+	marshal_text, _ := proto.Module.Attr("marshal_text")
+	for name, data := range testEncodings(100) {
+		t.Run(name, func(t *testing.T) {
+			msg, err := proto.Unmarshal(testDesc.Desc, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, marshal_text, starlark.Tuple{msg}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
 }
 
 func TestProtoSetFieldSteps(t *testing.T) {
@@ -65,13 +300,80 @@ func TestProtoGetFieldAllocs(t *testing.T) {
 }
 
 func TestProtoUnmarshalSteps(t *testing.T) {
+	unmarshal, _ := proto.Module.Attr("unmarshal")
+	for name, data := range testEncodings(100) {
+		t.Run(name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe)
+			st.SetMinSteps(int64(len(data)))
+			st.SetMaxSteps(int64(len(data)))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					_, err := starlark.Call(thread, unmarshal, starlark.Tuple{testDesc, starlark.Bytes(data)}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+				}
+			})
+		})
+	}
 }
 
 func TestProtoUnmarshalAllocs(t *testing.T) {
+	unmarshal, _ := proto.Module.Attr("unmarshal")
+	for name, data := range testEncodings(100) {
+		t.Run(name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, unmarshal, starlark.Tuple{testDesc, starlark.Bytes(data)}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
 }
 
 func TestProtoUnmarshalTextSteps(t *testing.T) {
+	unmarshal_text, _ := proto.Module.Attr("unmarshal_text")
+	text := starlark.String(strings.Repeat(`children {} `, 100))
+	st := startest.From(t)
+	st.RequireSafety(starlark.CPUSafe)
+	st.SetMinSteps(int64(len(text)))
+	st.SetMaxSteps(int64(len(text)))
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			_, err := starlark.Call(thread, unmarshal_text, starlark.Tuple{testDesc, text}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+		}
+	})
 }
 
 func TestProtoUnmarshalTextAllocs(t *testing.T) {
+	unmarshal_text, _ := proto.Module.Attr("unmarshal_text")
+	for name, text := range map[string]string{
+		"strings":  strings.Repeat(`strings: "" `, 100),
+		"ints":     strings.Repeat(`ints: 1 `, 100),
+		"children": strings.Repeat(`children {} `, 100),
+	} {
+		t.Run(name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, unmarshal_text, starlark.Tuple{testDesc, starlark.String(text)}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
 }
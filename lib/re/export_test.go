@@ -0,0 +1,5 @@
+package re
+
+var Safeties = safeties
+var PatternMethods = patternMethods
+var PatternMethodSafeties = patternMethodSafeties
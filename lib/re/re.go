@@ -0,0 +1,401 @@
+// Package re provides regular expression matching for Starlark programs.
+package re // import "github.com/canonical/starlark/lib/re"
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module re is a Starlark module of regular expression functions. Patterns
+// use the RE2 syntax accepted by Go's regexp package, so matching takes time
+// linear in the length of the input. For more details, refer to
+// https://pkg.go.dev/regexp/syntax.
+//
+// The module defines the following functions:
+//
+//	compile(pattern) - Compiles pattern into a Pattern which may be used repeatedly.
+//	match(pattern, s) - If a prefix of s matches pattern, returns a tuple of the matched text followed by
+//	                    the text matched by each group, or None for groups which did not participate.
+//	                    Otherwise, returns None.
+//	findall(pattern, s) - Returns a list of all non-overlapping matches of pattern in s. If pattern has no
+//	                      groups, each element is the matched text; if it has one, each element is the text
+//	                      matched by that group; otherwise, each element is a tuple of the text matched by
+//	                      each group. Groups which did not participate match the empty string.
+//	sub(pattern, repl, s, count=0) - Returns s with non-overlapping matches of pattern replaced by repl.
+//	                                 Within repl, $1 or ${1} is replaced by the text matched by the first
+//	                                 group, ${name} by that matched by the named group, and $$ by $. If
+//	                                 count is positive, at most count matches are replaced.
+//
+// In each function, pattern may be a string or a Pattern.
+//
+// A Pattern has the methods match(s), findall(s) and sub(repl, s, count=0),
+// which behave as the functions of the same name, and the attribute pattern,
+// which holds the source of the regular expression.
+var Module = &starlarkstruct.Module{
+	Name: "re",
+	Members: starlark.StringDict{
+		"compile": starlark.NewBuiltin("compile", compile),
+		"findall": starlark.NewBuiltin("findall", findall),
+		"match":   starlark.NewBuiltin("match", match),
+		"sub":     starlark.NewBuiltin("sub", sub),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"compile": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"findall": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"match":   starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"sub":     starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// A Pattern is a compiled regular expression.
+type Pattern struct {
+	re *regexp.Regexp
+}
+
+var _ starlark.Value = &Pattern{}
+var _ starlark.HasSafeAttrs = &Pattern{}
+
+// Compile compiles a Pattern from the given regular expression.
+func Compile(expr string) (*Pattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{re: re}, nil
+}
+
+func (p *Pattern) String() string        { return fmt.Sprintf("re.compile(%q)", p.re.String()) }
+func (p *Pattern) Type() string          { return "re.pattern" }
+func (p *Pattern) Freeze()               {} // immutable
+func (p *Pattern) Truth() starlark.Bool  { return starlark.True }
+func (p *Pattern) Hash() (uint32, error) { return starlark.String(p.re.String()).Hash() }
+
+func (p *Pattern) Attr(name string) (starlark.Value, error) {
+	return p.SafeAttr(nil, name)
+}
+
+func (p *Pattern) SafeAttr(thread *starlark.Thread, name string) (starlark.Value, error) {
+	if name == "pattern" {
+		result := starlark.Value(starlark.String(p.re.String()))
+		if thread != nil {
+			if err := thread.AddAllocs(starlark.StringTypeOverhead); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	method := patternMethods[name]
+	if method == nil {
+		return nil, starlark.ErrNoAttr
+	}
+	if thread != nil {
+		if err := thread.AddAllocs(starlark.EstimateSize(&starlark.Builtin{})); err != nil {
+			return nil, err
+		}
+	}
+	b := starlark.NewBuiltin(name, method).BindReceiver(p)
+	b.DeclareSafety(patternMethodSafeties[name])
+	return b, nil
+}
+
+func (p *Pattern) AttrNames() []string {
+	names := make([]string, 0, len(patternMethods)+1)
+	for name := range patternMethods {
+		names = append(names, name)
+	}
+	names = append(names, "pattern")
+	sort.Strings(names)
+	return names
+}
+
+type builtinMethod func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+var patternMethods = map[string]builtinMethod{
+	"findall": patternFindall,
+	"match":   patternMatch,
+	"sub":     patternSub,
+}
+
+var patternMethodSafeties = map[string]starlark.SafetyFlags{
+	"findall": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"match":   starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"sub":     starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+// patternUnpacker unpacks a pattern given either as a string or as a
+// Pattern, compiling it if necessary.
+type patternUnpacker struct {
+	thread *starlark.Thread
+	re     *regexp.Regexp
+}
+
+var _ starlark.Unpacker = &patternUnpacker{}
+
+func (pu *patternUnpacker) Unpack(v starlark.Value) error {
+	switch v := v.(type) {
+	case *Pattern:
+		pu.re = v.re
+		return nil
+	case starlark.String:
+		if pu.thread != nil {
+			if err := pu.thread.AddSteps(starlark.SafeInt(len(v))); err != nil {
+				return err
+			}
+		}
+		re, err := regexp.Compile(string(v))
+		if err != nil {
+			return err
+		}
+		if pu.thread != nil {
+			if err := pu.thread.AddAllocs(compiledSize(re, string(v))); err != nil {
+				return err
+			}
+		}
+		pu.re = re
+		return nil
+	default:
+		return fmt.Errorf("got %s, want string or re.pattern", v.Type())
+	}
+}
+
+func compile(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var expr string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &expr); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(len(expr))); err != nil {
+		return nil, err
+	}
+	p, err := Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	size := starlark.SafeAdd(starlark.EstimateSize(&Pattern{}), compiledSize(p.re, expr))
+	if err := thread.AddAllocs(size); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// compiledSize estimates the memory used by re, compiled from expr. The
+// compiled program is not fully visible to EstimateSize, so allow a margin
+// proportional to the length of the expression.
+func compiledSize(re *regexp.Regexp, expr string) starlark.SafeInteger {
+	return starlark.SafeAdd(starlark.EstimateSize(re), starlark.SafeMul(len(expr), 16))
+}
+
+// firstFindAllBatch is the number of matches sought by the first search
+// made by findAll.
+const firstFindAllBatch = 16
+
+// findAll returns the locations of successive non-overlapping matches of re
+// in s, as re.FindAllStringSubmatchIndex(s, n) does, declaring the memory
+// used to hold them. As the number of matches is not known in advance, s is
+// searched for a batch of matches whose size doubles each time the batch is
+// filled, and the memory for each batch is declared before it is sought.
+// Each search after the first declares a step for each byte of s.
+func findAll(thread *starlark.Thread, re *regexp.Regexp, s string, n int) ([][]int, error) {
+	locSize := starlark.EstimateMakeSize([]int{}, starlark.SafeInt(2*(re.NumSubexp()+1)))
+	batch := firstFindAllBatch
+	for {
+		if n >= 0 && batch > n {
+			batch = n
+		}
+		batchSize := starlark.SafeAdd(
+			starlark.EstimateMakeSize([][]int{}, starlark.SafeInt(batch)),
+			starlark.SafeMul(locSize, batch),
+		)
+		if err := thread.AddAllocs(batchSize); err != nil {
+			return nil, err
+		}
+		locs := re.FindAllStringSubmatchIndex(s, batch)
+		if len(locs) < batch || batch == n {
+			return locs, nil
+		}
+		if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+			return nil, err
+		}
+		batch *= 2
+	}
+}
+
+func match(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	pattern := patternUnpacker{thread: thread}
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &pattern, &s); err != nil {
+		return nil, err
+	}
+	return doMatch(thread, pattern.re, s)
+}
+
+func patternMatch(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	return doMatch(thread, b.Receiver().(*Pattern).re, s)
+}
+
+func doMatch(thread *starlark.Thread, re *regexp.Regexp, s string) (starlark.Value, error) {
+	if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+	// A match starting at the beginning of s is always leftmost.
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil || loc[0] != 0 {
+		return starlark.None, nil
+	}
+
+	n := len(loc) / 2
+	resultSize := starlark.SafeAdd(
+		starlark.EstimateMakeSize(starlark.Tuple{starlark.String("")}, starlark.SafeInt(n)),
+		starlark.SliceTypeOverhead,
+	)
+	if err := thread.AddAllocs(resultSize); err != nil {
+		return nil, err
+	}
+	result := make(starlark.Tuple, n)
+	for i := range result {
+		if loc[2*i] < 0 {
+			result[i] = starlark.None
+		} else {
+			result[i] = starlark.String(s[loc[2*i]:loc[2*i+1]])
+		}
+	}
+	return result, nil
+}
+
+func findall(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	pattern := patternUnpacker{thread: thread}
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &pattern, &s); err != nil {
+		return nil, err
+	}
+	return doFindall(thread, pattern.re, s)
+}
+
+func patternFindall(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	return doFindall(thread, b.Receiver().(*Pattern).re, s)
+}
+
+func doFindall(thread *starlark.Thread, re *regexp.Regexp, s string) (starlark.Value, error) {
+	if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+	locs, err := findAll(thread, re, s, -1)
+	if err != nil {
+		return nil, err
+	}
+	groups := re.NumSubexp()
+
+	group := func(loc []int, i int) starlark.Value {
+		if loc[2*i] < 0 {
+			return starlark.String("")
+		}
+		return starlark.String(s[loc[2*i]:loc[2*i+1]])
+	}
+
+	resultSize := starlark.SafeAdd(
+		starlark.EstimateMakeSize([]starlark.Value{starlark.String("")}, starlark.SafeInt(len(locs))),
+		starlark.EstimateSize(&starlark.List{}),
+	)
+	if groups > 1 {
+		tupleSize := starlark.SafeAdd(
+			starlark.EstimateMakeSize(starlark.Tuple{starlark.String("")}, starlark.SafeInt(groups)),
+			starlark.SliceTypeOverhead,
+		)
+		resultSize = starlark.SafeAdd(resultSize, starlark.SafeMul(tupleSize, len(locs)))
+	}
+	if err := thread.AddAllocs(resultSize); err != nil {
+		return nil, err
+	}
+
+	elems := make([]starlark.Value, len(locs))
+	for i, loc := range locs {
+		switch groups {
+		case 0:
+			elems[i] = group(loc, 0)
+		case 1:
+			elems[i] = group(loc, 1)
+		default:
+			tuple := make(starlark.Tuple, groups)
+			for j := range tuple {
+				tuple[j] = group(loc, j+1)
+			}
+			elems[i] = tuple
+		}
+	}
+	return starlark.NewList(elems), nil
+}
+
+func sub(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	pattern := patternUnpacker{thread: thread}
+	var repl, s string
+	var count int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "repl", &repl, "s", &s, "count?", &count); err != nil {
+		return nil, err
+	}
+	return doSub(thread, pattern.re, repl, s, count)
+}
+
+func patternSub(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var repl, s string
+	var count int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "repl", &repl, "s", &s, "count?", &count); err != nil {
+		return nil, err
+	}
+	return doSub(thread, b.Receiver().(*Pattern).re, repl, s, count)
+}
+
+func doSub(thread *starlark.Thread, re *regexp.Regexp, repl, s string, count int) (starlark.Value, error) {
+	if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+	n := -1
+	if count > 0 {
+		n = count
+	}
+	locs, err := findAll(thread, re, s, n)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := starlark.NewSafeStringBuilder(thread)
+	var expanded []byte
+	last := 0
+	for _, loc := range locs {
+		if _, err := sb.WriteString(s[last:loc[0]]); err != nil {
+			return nil, err
+		}
+		expanded = re.ExpandString(expanded[:0], repl, s, loc)
+		if _, err := sb.Write(expanded); err != nil {
+			return nil, err
+		}
+		last = loc[1]
+	}
+	if _, err := sb.WriteString(s[last:]); err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(starlark.StringTypeOverhead); err != nil {
+		return nil, err
+	}
+	return starlark.String(sb.String()), nil
+}
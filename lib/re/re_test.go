@@ -0,0 +1,191 @@
+package re_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/lib/re"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range re.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := re.Safeties[name]; !ok {
+			t.Errorf("builtin re.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin re.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range re.Safeties {
+		if _, ok := re.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration re.%s", name)
+		}
+	}
+}
+
+func TestMethodSafetiesExist(t *testing.T) {
+	for name := range re.PatternMethods {
+		if _, ok := re.PatternMethodSafeties[name]; !ok {
+			t.Errorf("builtin pattern.%s has no safety declaration", name)
+		}
+	}
+	for name := range re.PatternMethodSafeties {
+		if _, ok := re.PatternMethods[name]; !ok {
+			t.Errorf("no method for safety declaration pattern.%s", name)
+		}
+	}
+}
+
+func TestRe(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`re.match("a(b)?(c)", "acd")`, `("ac", None, "c")`},
+		{`re.match("b", "ab")`, `None`},
+		{`re.compile("(\\w+)@(\\w+)").match("me@host")`, `("me@host", "me", "host")`},
+		{`re.findall("\\d+", "a1b22c333")`, `["1", "22", "333"]`},
+		{`re.findall("(\\d)\\d*", "a1b22c333")`, `["1", "2", "3"]`},
+		{`re.findall("(\\w)=(\\d)?", "a=1 b=")`, `[("a", "1"), ("b", "")]`},
+		{`re.sub("(\\w+)@(\\w+)", "$2 at ${1}", "me@host, you@there")`, `"host at me, there at you"`},
+		{`re.sub("a", "b", "aaa", count=2)`, `"bba"`},
+		{`re.compile("a").sub("b", "aaa", 1)`, `"baa"`},
+		{`re.compile("a+").pattern`, `"a+"`},
+		{`str(re.compile("a+"))`, `"re.compile(\"a+\")"`},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		result, err := starlark.Eval(thread, "re_test.star", test.expr, starlark.StringDict{"re": re.Module})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := result.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestReErrors(t *testing.T) {
+	tests := []struct {
+		expr, err string
+	}{
+		{`re.compile("(")`, "compile: error parsing regexp: missing closing )"},
+		{`re.match(1, "a")`, "match: for parameter 1: got int, want string or re.pattern"},
+		{`re.compile("a").nope`, "has no .nope field or method"},
+	}
+	for _, test := range tests {
+		thread := &starlark.Thread{}
+		_, err := starlark.Eval(thread, "re_test.star", test.expr, starlark.StringDict{"re": re.Module})
+		if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: unexpected error: got %v, want %s", test.expr, err, test.err)
+		}
+	}
+}
+
+func TestReCompileAllocs(t *testing.T) {
+	compile := re.Module.Members["compile"]
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			result, err := starlark.Call(thread, compile, starlark.Tuple{starlark.String("(a|b)*c+[def]")}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		}
+	})
+}
+
+func TestReSteps(t *testing.T) {
+	const input = "abc123def456ghi789"
+	pattern, err := re.Compile(`\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args starlark.Tuple
+	}{
+		{"match", starlark.Tuple{pattern, starlark.String(input)}},
+		{"findall", starlark.Tuple{pattern, starlark.String(input)}},
+		{"sub", starlark.Tuple{pattern, starlark.String(""), starlark.String(input)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := re.Module.Members[test.name]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe)
+			st.SetMinSteps(int64(len(input)))
+			st.SetMaxSteps(2 * int64(len(input)))
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					if _, err := starlark.Call(thread, fn, test.args, nil); err != nil {
+						st.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestReAllocs(t *testing.T) {
+	const input = "ab12cd345ef6789gh"
+	tests := []struct {
+		name string
+		args starlark.Tuple
+	}{
+		{"match", starlark.Tuple{starlark.String(`(\w)(\w)(\d)?`), starlark.String(input)}},
+		{"findall/groups=0", starlark.Tuple{starlark.String(`\d+`), starlark.String(input)}},
+		{"findall/groups=1", starlark.Tuple{starlark.String(`(\d)\d*`), starlark.String(input)}},
+		{"findall/groups=2", starlark.Tuple{starlark.String(`(\d)(\d*)`), starlark.String(input)}},
+		{"findall/many", starlark.Tuple{starlark.String(`\d`), starlark.String(strings.Repeat(input, 20))}},
+		{"sub", starlark.Tuple{starlark.String(`(\d+)`), starlark.String("<$1$1>"), starlark.String(input)}},
+		{"sub/many", starlark.Tuple{starlark.String(`\d`), starlark.String("-"), starlark.String(strings.Repeat(input, 20))}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := re.Module.Members[strings.Split(test.name, "/")[0]]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, fn, test.args, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   re.Module,
+		Safeties: re.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   re.Module,
+		Safeties: re.Safeties,
+	}
+	suite.Benchmark(b)
+}
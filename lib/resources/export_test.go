@@ -0,0 +1,3 @@
+package resources
+
+var Safeties = safeties
@@ -0,0 +1,72 @@
+// Package resources allows Starlark programs to inspect the resources left
+// to them, so that cooperative scripts may adapt their work, for example by
+// reducing detail, before reaching a hard limit.
+//
+// As it reveals the limits configured by the embedder, the module is
+// available to a script only if it is explicitly made so, for example as a
+// predeclared value or through the thread's Load function.
+package resources // import "github.com/canonical/starlark/lib/resources"
+
+import (
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module resources is a Starlark module which reports the resources left
+// to the calling thread.
+//
+// The module defines the following functions:
+//
+//	steps_remaining() - Returns the number of steps which may still be taken, or None if unlimited.
+//	memory_remaining() - Returns the number of bytes which may still be allocated, or None if unlimited.
+//
+// Both account for the limits of the thread and of any resource pool to which
+// it is attached.
+var Module = &starlarkstruct.Module{
+	Name: "resources",
+	Members: starlark.StringDict{
+		"memory_remaining": starlark.NewBuiltin("resources.memory_remaining", memoryRemaining),
+		"steps_remaining":  starlark.NewBuiltin("resources.steps_remaining", stepsRemaining),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"memory_remaining": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"steps_remaining":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+func stepsRemaining(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return remaining(thread, thread.RemainingSteps)
+}
+
+func memoryRemaining(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return remaining(thread, thread.RemainingAllocs)
+}
+
+// remaining returns the amount reported by query as a Starlark value.
+func remaining(thread *starlark.Thread, query func() (int64, bool)) (starlark.Value, error) {
+	n, limited := query()
+	if !limited {
+		return starlark.None, nil
+	}
+	result := starlark.Value(starlark.MakeInt64(n))
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
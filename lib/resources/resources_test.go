@@ -0,0 +1,145 @@
+package resources_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/lib/resources"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range resources.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := resources.Safeties[name]; !ok {
+			t.Errorf("builtin resources.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin resources.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range resources.Safeties {
+		if _, ok := resources.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration resources.%s", name)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	predeclared := starlark.StringDict{"resources": resources.Module}
+
+	t.Run("unlimited", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		for _, expr := range []string{"resources.steps_remaining()", "resources.memory_remaining()"} {
+			result, err := starlark.Eval(thread, "resources_test.star", expr, predeclared)
+			if err != nil {
+				t.Errorf("%s: %v", expr, err)
+			} else if result != starlark.None {
+				t.Errorf("%s: got %v, want None", expr, result)
+			}
+		}
+	})
+
+	t.Run("steps", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(1000)
+		if err := thread.AddSteps(starlark.SafeInt(100)); err != nil {
+			t.Fatal(err)
+		}
+		result, err := starlark.Eval(thread, "resources_test.star", "resources.steps_remaining()", predeclared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := starlark.AsInt32(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		steps, _ := thread.Steps()
+		if n <= 0 || int64(n) < 1000-steps || n > 900 {
+			t.Errorf("unexpected steps remaining: %d (used %d of 1000)", n, steps)
+		}
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(1 << 20)
+		result, err := starlark.Eval(thread, "resources_test.star", "resources.memory_remaining()", predeclared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := starlark.AsInt32(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n <= 0 || n > 1<<20 {
+			t.Errorf("unexpected memory remaining: %d", n)
+		}
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		pool := starlark.NewResourcePool(0, 5000)
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(1 << 20)
+		thread.SetResourcePool(pool)
+		if err := thread.AddAllocs(starlark.SafeInt(1000)); err != nil {
+			t.Fatal(err)
+		}
+		if remaining, limited := thread.RemainingAllocs(); !limited || remaining != 4000 {
+			t.Errorf("expected 4000 remaining, got %d (limited: %v)", remaining, limited)
+		}
+		if _, limited := thread.RemainingSteps(); limited {
+			t.Error("steps should not be limited")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Cancel("done")
+		if remaining, limited := thread.RemainingSteps(); !limited || remaining != 0 {
+			t.Errorf("expected nothing remaining, got %d (limited: %v)", remaining, limited)
+		}
+	})
+}
+
+func TestRemainingResources(t *testing.T) {
+	for _, name := range []string{"memory_remaining", "steps_remaining"} {
+		t.Run(name, func(t *testing.T) {
+			fn := resources.Module.Members[name]
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+			st.SetMaxSteps(0)
+			st.RunThread(func(thread *starlark.Thread) {
+				thread.SetMaxSteps(1 << 40)
+				thread.SetMaxAllocs(1 << 40)
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, fn, nil, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   resources.Module,
+		Safeties: resources.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   resources.Module,
+		Safeties: resources.Safeties,
+	}
+	suite.Benchmark(b)
+}
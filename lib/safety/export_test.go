@@ -0,0 +1,3 @@
+package safety
+
+var Safeties = safeties
@@ -0,0 +1,178 @@
+// Package safety allows Starlark programs to inspect the safety system
+// under which they run, so that scripts may assert the guarantees which
+// their execution context makes before relying on them.
+package safety // import "github.com/canonical/starlark/lib/safety"
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+	"github.com/canonical/starlark/syntax"
+)
+
+// Module safety is a Starlark module of safety flags and related functions.
+//
+// The module defines the following flags, which correspond to those of the
+// starlark package, and may be combined with | and &:
+//
+//	cpu - CPUSafe
+//	mem - MemSafe
+//	time - TimeSafe
+//	io - IOSafe
+//
+// Whether a set of flags contains another may be tested with in, for
+// example, safety.mem in safety.of(f).
+//
+// The module defines the following functions:
+//
+//	of(fn) - Returns the safety flags of the callable fn. For a Starlark function, these are the flags inferred from the callables it names; see starlark.Function.InferredSafety.
+//	require(flags) - Fails unless the calling thread requires all of the given safety flags.
+//	required() - Returns the safety flags required by the calling thread.
+var Module = &starlarkstruct.Module{
+	Name: "safety",
+	Members: starlark.StringDict{
+		"cpu":      Flags(starlark.CPUSafe),
+		"mem":      Flags(starlark.MemSafe),
+		"time":     Flags(starlark.TimeSafe),
+		"io":       Flags(starlark.IOSafe),
+		"of":       starlark.NewBuiltin("safety.of", of),
+		"require":  starlark.NewBuiltin("safety.require", require),
+		"required": starlark.NewBuiltin("safety.required", required),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"of":       starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"require":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"required": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// Flags is the Starlark representation of a set of safety flags.
+type Flags starlark.SafetyFlags
+
+var (
+	_ starlark.Comparable    = Flags(0)
+	_ starlark.HasSafeBinary = Flags(0)
+)
+
+// flagNames holds the names of the flags in the order of their bits.
+var flagNames = [...]string{"cpu", "mem", "time", "io"}
+
+func (f Flags) String() string {
+	var names []string
+	for i, name := range flagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, "safety."+name)
+		}
+	}
+	if len(names) == 0 {
+		return "safety_flags()"
+	}
+	return strings.Join(names, "|")
+}
+func (f Flags) Type() string          { return "safety_flags" }
+func (f Flags) Freeze()               {}
+func (f Flags) Truth() starlark.Bool  { return f != 0 }
+func (f Flags) Hash() (uint32, error) { return uint32(f), nil }
+
+func (f Flags) CompareSameType(op syntax.Token, y_ starlark.Value, depth int) (bool, error) {
+	y := y_.(Flags)
+	switch op {
+	case syntax.EQL:
+		return f == y, nil
+	case syntax.NEQ:
+		return f != y, nil
+	default:
+		return false, fmt.Errorf("%s %s %s not implemented", f.Type(), op, y.Type())
+	}
+}
+
+func (f Flags) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	return f.SafeBinary(nil, op, y, side)
+}
+
+func (f Flags) SafeBinary(thread *starlark.Thread, op syntax.Token, y_ starlark.Value, side starlark.Side) (starlark.Value, error) {
+	y, ok := y_.(Flags)
+	if !ok {
+		return nil, nil
+	}
+	var result starlark.Value
+	switch op {
+	case syntax.PIPE:
+		result = f | y
+	case syntax.AMP:
+		result = f & y
+	case syntax.IN:
+		set, subset := f, y
+		if side == starlark.Left {
+			set, subset = y, f
+		}
+		return starlark.Bool(starlark.SafetyFlags(set).Contains(starlark.SafetyFlags(subset))), nil
+	default:
+		return nil, nil
+	}
+	if thread != nil {
+		if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func of(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var fn starlark.Callable
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &fn); err != nil {
+		return nil, err
+	}
+	var flags starlark.SafetyFlags
+	switch fn := fn.(type) {
+	case *starlark.Function:
+		flags = fn.InferredSafety()
+	case starlark.SafetyAware:
+		flags = fn.Safety()
+	}
+	if flags.CheckValid() != nil {
+		// Invalid declarations make no guarantees.
+		flags = starlark.NotSafe
+	}
+	return makeFlags(thread, flags)
+}
+
+func require(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var flags Flags
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &flags); err != nil {
+		return nil, err
+	}
+	required := thread.RequiredSafety()
+	if missing := starlark.SafetyFlags(flags) &^ required; missing != 0 {
+		return nil, fmt.Errorf("%s: thread does not require %s", b.Name(), Flags(missing))
+	}
+	return starlark.None, nil
+}
+
+func required(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return makeFlags(thread, thread.RequiredSafety())
+}
+
+// makeFlags returns flags as a Starlark value, accounting for its memory.
+func makeFlags(thread *starlark.Thread, flags starlark.SafetyFlags) (starlark.Value, error) {
+	result := starlark.Value(Flags(flags))
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package safety_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/lib/safety"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range safety.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := safety.Safeties[name]; !ok {
+			t.Errorf("builtin safety.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin safety.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range safety.Safeties {
+		if _, ok := safety.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration safety.%s", name)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`safety.mem`, `safety.mem`},
+		{`safety.io | safety.cpu`, `safety.cpu|safety.io`},
+		{`(safety.io | safety.cpu) & safety.cpu`, `safety.cpu`},
+		{`safety.io & safety.cpu`, `safety_flags()`},
+		{`bool(safety.io & safety.cpu)`, `False`},
+		{`safety.cpu | safety.mem == safety.mem | safety.cpu`, `True`},
+		{`safety.mem in safety.cpu | safety.mem`, `True`},
+		{`safety.io in safety.cpu | safety.mem`, `False`},
+		{`type(safety.cpu)`, `"safety_flags"`},
+		{`{safety.cpu: 1}[safety.cpu]`, `1`},
+	}
+	predeclared := starlark.StringDict{"safety": safety.Module}
+	for _, test := range tests {
+		result, err := starlark.Eval(&starlark.Thread{}, "safety_test.star", test.expr, predeclared)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+		} else if got := result.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestOf(t *testing.T) {
+	impl := func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	}
+	predeclared := starlark.StringDict{
+		"safety":      safety.Module,
+		"unsafe":      starlark.NewBuiltin("unsafe", impl),
+		"memsafe":     starlark.NewBuiltinWithSafety("memsafe", starlark.MemSafe, impl),
+		"json_encode": starlark.NewBuiltinWithSafety("json_encode", starlark.CPUSafe|starlark.MemSafe|starlark.TimeSafe|starlark.IOSafe, impl),
+	}
+	const src = `
+def calls_memsafe():
+	memsafe()
+
+def calls_unsafe():
+	unsafe()
+
+results = [
+	safety.of(unsafe),
+	safety.of(memsafe),
+	safety.of(json_encode),
+	safety.of(calls_memsafe),
+	safety.of(calls_unsafe),
+	safety.of(lambda: None),
+]
+`
+	globals, err := starlark.ExecFile(&starlark.Thread{}, "safety_test.star", src, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[safety_flags(), safety.mem, safety.cpu|safety.mem|safety.time|safety.io, safety.mem, safety_flags(), safety.cpu|safety.mem|safety.time|safety.io]"
+	if got := globals["results"].String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := starlark.Eval(&starlark.Thread{}, "safety_test.star", "safety.of(1)", predeclared); err == nil {
+		t.Error("expected error")
+	} else if want := "safety.of: for parameter 1: got int, want callable"; err.Error() != want {
+		t.Errorf("unexpected error: got %q, want %q", err, want)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	predeclared := starlark.StringDict{"safety": safety.Module}
+
+	thread := &starlark.Thread{}
+	thread.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+
+	for _, expr := range []string{
+		"safety.require(safety.cpu)",
+		"safety.require(safety.cpu | safety.mem)",
+		"safety.require(safety.mem & safety.io)",
+	} {
+		if _, err := starlark.Eval(thread, "safety_test.star", expr, predeclared); err != nil {
+			t.Errorf("%s: %v", expr, err)
+		}
+	}
+
+	_, err := starlark.Eval(thread, "safety_test.star", "safety.require(safety.cpu | safety.io | safety.time)", predeclared)
+	if err == nil {
+		t.Error("expected error")
+	} else if want := "safety.require: thread does not require safety.time|safety.io"; !strings.Contains(err.Error(), want) {
+		t.Errorf("unexpected error: got %q, want %q", err, want)
+	}
+
+	result, err := starlark.Eval(thread, "safety_test.star", "safety.required()", predeclared)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := "safety.cpu|safety.mem"; result.String() != want {
+		t.Errorf("got %s, want %s", result, want)
+	}
+}
+
+func TestSafetyAllocs(t *testing.T) {
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.AddValue("safety", safety.Module)
+	st.AddValue("memsafe", starlark.NewBuiltinWithSafety("memsafe", starlark.MemSafe, nil))
+	st.RunString(`
+for _ in st.ntimes():
+	st.keep_alive(safety.of(memsafe), safety.required(), safety.cpu | safety.io)
+`)
+}
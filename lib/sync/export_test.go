@@ -0,0 +1,7 @@
+package sync
+
+var Safeties = safeties
+var LockMethods = lockMethods
+var LockMethodSafeties = lockMethodSafeties
+var QueueMethods = queueMethods
+var QueueMethodSafeties = queueMethodSafeties
@@ -0,0 +1,247 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+// A Queue is a bounded, first-in-first-out queue of frozen values which may
+// be shared between Starlark threads and host goroutines. Waiting to put or
+// get an element may be abandoned when a thread is cancelled.
+//
+// The storage for the elements of a queue is charged to the thread which
+// creates it.
+type Queue struct {
+	elems chan starlark.Value
+
+	closeOnce gosync.Once
+	closed    chan struct{}
+}
+
+var _ starlark.Value = &Queue{}
+var _ starlark.HasSafeAttrs = &Queue{}
+
+// ErrQueueClosed is returned when putting to a closed queue, or getting from
+// a closed and empty one.
+var ErrQueueClosed = errors.New("queue closed")
+
+// maxQueueSize bounds the capacity of a queue, below the size at which the
+// Go runtime refuses to allocate a channel.
+const maxQueueSize = 1 << 30
+
+// NewQueue returns a new, empty queue which can hold up to maxSize elements.
+// If thread is non-nil, the storage for the elements is charged to it.
+func NewQueue(thread *starlark.Thread, maxSize int) (*Queue, error) {
+	if maxSize < 1 {
+		return nil, fmt.Errorf("queue size must be positive")
+	}
+	if maxSize > maxQueueSize {
+		return nil, fmt.Errorf("queue size too large")
+	}
+	if thread != nil {
+		size := starlark.SafeAdd(
+			starlark.EstimateSize(&Queue{}),
+			starlark.EstimateMakeSize(make(chan starlark.Value), starlark.SafeInt(maxSize)),
+		)
+		size = starlark.SafeAdd(size, starlark.EstimateMakeSize(make(chan struct{}), starlark.SafeInt(0)))
+		if err := thread.AddAllocs(size); err != nil {
+			return nil, err
+		}
+	}
+	return &Queue{
+		elems:  make(chan starlark.Value, maxSize),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+// Put freezes v and appends it to the queue, waiting while the queue is full
+// until the context is done.
+func (q *Queue) Put(ctx context.Context, v starlark.Value) error {
+	return q.put(ctx, v, nil)
+}
+
+// Get removes and returns the element at the front of the queue, waiting
+// while the queue is empty until the context is done.
+func (q *Queue) Get(ctx context.Context) (starlark.Value, error) {
+	return q.get(ctx, nil)
+}
+
+// Close closes the queue. Elements already in the queue may still be
+// retrieved.
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() { close(q.closed) })
+}
+
+func (q *Queue) put(ctx context.Context, v starlark.Value, timeout <-chan time.Time) error {
+	select {
+	case <-q.closed:
+		return ErrQueueClosed
+	default:
+	}
+
+	v.Freeze()
+	select {
+	case q.elems <- v:
+		return nil
+	case <-q.closed:
+		return ErrQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return errTimeout
+	}
+}
+
+func (q *Queue) get(ctx context.Context, timeout <-chan time.Time) (starlark.Value, error) {
+	select {
+	case v := <-q.elems:
+		return v, nil
+	default:
+	}
+
+	select {
+	case v := <-q.elems:
+		return v, nil
+	case <-q.closed:
+		// Drain any element put concurrently with closing.
+		select {
+		case v := <-q.elems:
+			return v, nil
+		default:
+			return nil, ErrQueueClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		return nil, errTimeout
+	}
+}
+
+func (q *Queue) String() string        { return fmt.Sprintf("<queue %d/%d>", len(q.elems), cap(q.elems)) }
+func (q *Queue) Type() string          { return "queue" }
+func (q *Queue) Freeze()               {} // queues remain usable when frozen
+func (q *Queue) Truth() starlark.Bool  { return starlark.True }
+func (q *Queue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: queue") }
+
+func (q *Queue) Attr(name string) (starlark.Value, error) {
+	return q.SafeAttr(nil, name)
+}
+
+func (q *Queue) SafeAttr(thread *starlark.Thread, name string) (starlark.Value, error) {
+	method := queueMethods[name]
+	if method == nil {
+		return nil, starlark.ErrNoAttr
+	}
+	if thread != nil {
+		if err := thread.AddAllocs(starlark.EstimateSize(&starlark.Builtin{})); err != nil {
+			return nil, err
+		}
+	}
+	b := starlark.NewBuiltin(name, method).BindReceiver(q)
+	b.DeclareSafety(queueMethodSafeties[name])
+	return b, nil
+}
+
+func (q *Queue) AttrNames() []string {
+	names := make([]string, 0, len(queueMethods))
+	for name := range queueMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var queueMethods = map[string]builtinMethod{
+	"close": queueClose,
+	"get":   queueGet,
+	"put":   queuePut,
+}
+
+var queueMethodSafeties = map[string]starlark.SafetyFlags{
+	"close": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"get":   starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"put":   starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func newQueue(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var maxSize int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "maxsize", &maxSize); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	q, err := NewQueue(thread, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return q, nil
+}
+
+func queuePut(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	var timeout starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	timer, stop, err := newTimeout(b, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	q := b.Receiver().(*Queue)
+	if err := q.put(thread.Context(), x, timer); err != nil {
+		if err == errTimeout {
+			return starlark.False, nil
+		}
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.True, nil
+}
+
+func queueGet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var timeout starlark.Value = starlark.None
+	var dflt starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "timeout?", &timeout, "default?", &dflt); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	timer, stop, err := newTimeout(b, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	q := b.Receiver().(*Queue)
+	v, err := q.get(thread.Context(), timer)
+	if err != nil {
+		if err == errTimeout && dflt != nil {
+			return dflt, nil
+		}
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return v, nil
+}
+
+func queueClose(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	b.Receiver().(*Queue).Close()
+	return starlark.None, nil
+}
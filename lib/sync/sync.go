@@ -0,0 +1,295 @@
+// Package sync provides synchronisation primitives for Starlark programs
+// which coordinate with concurrent host operations.
+package sync // import "github.com/canonical/starlark/lib/sync"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+)
+
+// Module sync is a Starlark module of synchronisation primitives.
+// The module defines the following functions:
+//
+//	lock() - Returns a new, unlocked Lock.
+//	queue(maxsize) - Returns a new, empty Queue which holds at most maxsize elements.
+//
+// A Lock has the following methods:
+//
+//	acquire(timeout=None) - Blocks until the lock is acquired, returning True. If timeout is
+//	                        given, it is the maximum number of seconds to wait, after which
+//	                        False is returned. Waiting ends with an error if the thread is
+//	                        cancelled, for example when its wall-time budget is exhausted.
+//	                        Acquiring a lock already held by the calling thread is an error.
+//	release() - Releases a lock held by the calling thread.
+//	locked() - Reports whether the lock is held.
+//	call(fn, *args, **kwargs) - Acquires the lock, calls fn with the given arguments, then releases
+//	                            the lock, even if fn fails. Returns the result of fn.
+//
+// A Queue has the following methods:
+//
+//	put(x, timeout=None) - Freezes x and appends it to the queue, blocking while the queue is
+//	                       full. Returns True, or False if timeout seconds elapse first.
+//	get(timeout=None, default=?) - Removes and returns the element at the front of the queue,
+//	                               blocking while the queue is empty. If timeout seconds elapse
+//	                               first, returns default, or fails if no default was given, so
+//	                               that a timeout cannot be mistaken for a None element.
+//	close() - Closes the queue. Subsequent calls to put fail, and get fails once the queue is empty.
+//
+// As with acquire, waiting in put or get ends with an error if the thread is cancelled.
+var Module = &starlarkstruct.Module{
+	Name: "sync",
+	Members: starlark.StringDict{
+		"lock":  starlark.NewBuiltin("lock", newLock),
+		"queue": starlark.NewBuiltin("queue", newQueue),
+	},
+}
+var safeties = map[string]starlark.SafetyFlags{
+	"lock":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"queue": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func init() {
+	for name, safety := range safeties {
+		if v, ok := Module.Members[name]; ok {
+			if builtin, ok := v.(*starlark.Builtin); ok {
+				builtin.DeclareSafety(safety)
+			}
+		}
+	}
+}
+
+// A Lock is a mutual exclusion lock which may be shared between Starlark
+// threads and host goroutines. Unlike a sync.Mutex, waiting to acquire a
+// Lock may be abandoned when a thread is cancelled.
+//
+// The zero value is not usable; create locks with NewLock.
+type Lock struct {
+	sem chan struct{}
+
+	mu    gosync.Mutex
+	held  bool
+	owner *starlark.Thread // nil if held through Lock.Lock
+}
+
+var _ starlark.Value = &Lock{}
+var _ starlark.HasSafeAttrs = &Lock{}
+
+// NewLock returns a new, unlocked Lock.
+func NewLock() *Lock {
+	return &Lock{sem: make(chan struct{}, 1)}
+}
+
+// Lock acquires the lock on behalf of host code, waiting until it is
+// available or the context is done. As with a sync.Mutex, a lock held by
+// host code is not associated with a particular goroutine, so a second
+// call to Lock waits for the first holder to call Unlock.
+func (l *Lock) Lock(ctx context.Context) error {
+	return l.acquire(ctx, nil, nil)
+}
+
+// Unlock releases a lock acquired by Lock.
+func (l *Lock) Unlock() error {
+	return l.release(nil)
+}
+
+var errTimeout = errors.New("timeout")
+
+func (l *Lock) acquire(ctx context.Context, owner *starlark.Thread, timeout <-chan time.Time) error {
+	if owner != nil {
+		l.mu.Lock()
+		if l.held && l.owner == owner {
+			l.mu.Unlock()
+			return errors.New("lock already held by this thread")
+		}
+		l.mu.Unlock()
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return errTimeout
+	}
+
+	l.mu.Lock()
+	l.held = true
+	l.owner = owner
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *Lock) release(owner *starlark.Thread) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.held || l.owner != owner {
+		return errors.New("lock not held by this thread")
+	}
+	l.held = false
+	l.owner = nil
+	<-l.sem
+	return nil
+}
+
+func (l *Lock) String() string        { return "<lock>" }
+func (l *Lock) Type() string          { return "lock" }
+func (l *Lock) Freeze()               {} // locks remain usable when frozen
+func (l *Lock) Truth() starlark.Bool  { return starlark.True }
+func (l *Lock) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: lock") }
+
+func (l *Lock) Attr(name string) (starlark.Value, error) {
+	return l.SafeAttr(nil, name)
+}
+
+func (l *Lock) SafeAttr(thread *starlark.Thread, name string) (starlark.Value, error) {
+	method := lockMethods[name]
+	if method == nil {
+		return nil, starlark.ErrNoAttr
+	}
+	if thread != nil {
+		if err := thread.AddAllocs(starlark.EstimateSize(&starlark.Builtin{})); err != nil {
+			return nil, err
+		}
+	}
+	b := starlark.NewBuiltin(name, method).BindReceiver(l)
+	b.DeclareSafety(lockMethodSafeties[name])
+	return b, nil
+}
+
+func (l *Lock) AttrNames() []string {
+	names := make([]string, 0, len(lockMethods))
+	for name := range lockMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type builtinMethod func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+var lockMethods = map[string]builtinMethod{
+	"acquire": lockAcquire,
+	"call":    lockCall,
+	"locked":  lockLocked,
+	"release": lockRelease,
+}
+
+var lockMethodSafeties = map[string]starlark.SafetyFlags{
+	"acquire": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"call":    starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"locked":  starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+	"release": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe,
+}
+
+func newLock(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	lock := NewLock()
+	if err := thread.AddAllocs(starlark.EstimateSize(lock)); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func lockAcquire(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var timeout starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+
+	timer, stop, err := newTimeout(b, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	lock := b.Receiver().(*Lock)
+	if err := lock.acquire(thread.Context(), thread, timer); err != nil {
+		if err == errTimeout {
+			return starlark.False, nil
+		}
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.True, nil
+}
+
+// newTimeout returns a channel which receives after the given number of
+// seconds, or a nil channel if timeout is None. The returned function
+// releases the associated timer.
+func newTimeout(b *starlark.Builtin, timeout starlark.Value) (<-chan time.Time, func(), error) {
+	if timeout == starlark.None {
+		return nil, func() {}, nil
+	}
+	seconds, ok := starlark.AsFloat(timeout)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: for parameter timeout: got %s, want float or int", b.Name(), timeout.Type())
+	}
+	if seconds < 0 {
+		return nil, nil, fmt.Errorf("%s: timeout must be non-negative", b.Name())
+	}
+	t := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+	return t.C, func() { t.Stop() }, nil
+}
+
+func lockRelease(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	lock := b.Receiver().(*Lock)
+	if err := lock.release(thread); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func lockLocked(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+	lock := b.Receiver().(*Lock)
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	return starlark.Bool(lock.held), nil
+}
+
+func lockCall(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("%s: missing argument for fn", b.Name())
+	}
+	fn, ok := args[0].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: for parameter fn: got %s, want callable", b.Name(), args[0].Type())
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		return nil, err
+	}
+
+	lock := b.Receiver().(*Lock)
+	if err := lock.acquire(thread.Context(), thread, nil); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	defer lock.release(thread)
+
+	return starlark.Call(thread, fn, args[1:], kwargs)
+}
@@ -0,0 +1,384 @@
+package sync_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	gotime "time"
+
+	"github.com/canonical/starlark/lib/sync"
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+	"github.com/canonical/starlark/syntax"
+)
+
+func TestModuleSafeties(t *testing.T) {
+	for name, value := range sync.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := sync.Safeties[name]; !ok {
+			t.Errorf("builtin sync.%s has no safety declaration", name)
+		} else if actualSafety := builtin.Safety(); actualSafety != safety {
+			t.Errorf("builtin sync.%s has incorrect safety: expected %v but got %v", name, safety, actualSafety)
+		}
+	}
+	for name := range sync.Safeties {
+		if _, ok := sync.Module.Members[name]; !ok {
+			t.Errorf("no method for safety declaration sync.%s", name)
+		}
+	}
+}
+
+func TestMethodSafetiesExist(t *testing.T) {
+	for name := range sync.LockMethods {
+		if _, ok := sync.LockMethodSafeties[name]; !ok {
+			t.Errorf("builtin lock.%s has no safety declaration", name)
+		}
+	}
+	for name := range sync.LockMethodSafeties {
+		if _, ok := sync.LockMethods[name]; !ok {
+			t.Errorf("no method for safety declaration lock.%s", name)
+		}
+	}
+	for name := range sync.QueueMethods {
+		if _, ok := sync.QueueMethodSafeties[name]; !ok {
+			t.Errorf("builtin queue.%s has no safety declaration", name)
+		}
+	}
+	for name := range sync.QueueMethodSafeties {
+		if _, ok := sync.QueueMethods[name]; !ok {
+			t.Errorf("no method for safety declaration queue.%s", name)
+		}
+	}
+}
+
+func exec(thread *starlark.Thread, src string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	env := starlark.StringDict{"sync": sync.Module}
+	for name, value := range predeclared {
+		env[name] = value
+	}
+	opts := &syntax.FileOptions{TopLevelControl: true, GlobalReassign: true}
+	return starlark.ExecFileOptions(opts, thread, "sync_test.star", src, env)
+}
+
+func TestLock(t *testing.T) {
+	const src = `
+l = sync.lock()
+held_before = l.locked()
+acquired = l.acquire()
+held_during = l.locked()
+l.release()
+held_after = l.locked()
+result = l.call(lambda x, y: x + y, 1, y = 2)
+held_after_call = l.locked()
+`
+	globals, err := exec(&starlark.Thread{}, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]starlark.Value{
+		"held_before":     starlark.False,
+		"acquired":        starlark.True,
+		"held_during":     starlark.True,
+		"held_after":      starlark.False,
+		"result":          starlark.MakeInt(3),
+		"held_after_call": starlark.False,
+	} {
+		if got := globals[name]; got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLockMisuse(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{{
+		name: "reentrant",
+		src:  "l = sync.lock(); l.acquire(); l.acquire()",
+		err:  "acquire: lock already held by this thread",
+	}, {
+		name: "release-unheld",
+		src:  "sync.lock().release()",
+		err:  "release: lock not held by this thread",
+	}, {
+		name: "reentrant-call",
+		src:  "l = sync.lock(); l.call(l.acquire)",
+		err:  "acquire: lock already held by this thread",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := exec(&starlark.Thread{}, test.src, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error: got %v, want %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLockCallReleasesOnError(t *testing.T) {
+	lock := sync.NewLock()
+	_, err := exec(&starlark.Thread{}, "l.call(fail, 'oops')", starlark.StringDict{"l": lock})
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := lock.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockHostContention(t *testing.T) {
+	lock := sync.NewLock()
+	if err := lock.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		globals, err := exec(&starlark.Thread{}, "acquired = l.acquire(timeout = 0.01)", starlark.StringDict{"l": lock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired := globals["acquired"]; acquired != starlark.False {
+			t.Errorf("acquired contended lock")
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*gotime.Millisecond)
+		defer cancel()
+		thread := &starlark.Thread{}
+		thread.SetParentContext(ctx)
+		_, err := exec(thread, "l.acquire()", starlark.StringDict{"l": lock})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("handoff", func(t *testing.T) {
+		go func() {
+			gotime.Sleep(10 * gotime.Millisecond)
+			lock.Unlock()
+		}()
+		globals, err := exec(&starlark.Thread{}, "acquired = l.acquire(); l.release()", starlark.StringDict{"l": lock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired := globals["acquired"]; acquired != starlark.True {
+			t.Errorf("failed to acquire released lock")
+		}
+	})
+}
+
+func TestLockHostGoroutines(t *testing.T) {
+	lock := sync.NewLock()
+	if err := lock.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err == nil {
+		t.Error("unlocked an unheld lock")
+	}
+
+	if err := lock.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock.Lock(context.Background())
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("second host goroutine did not wait for the lock: %v", err)
+	case <-gotime.After(10 * gotime.Millisecond):
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-acquired; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockAllocs(t *testing.T) {
+	newLock := sync.Module.Members["lock"]
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			lock, err := starlark.Call(thread, newLock, nil, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(lock)
+		}
+	})
+}
+
+func TestQueue(t *testing.T) {
+	const src = `
+q = sync.queue(2)
+elems = [1, 2]
+q.put(elems)
+q.put("x")
+full = q.put(3, timeout = 0)
+first = q.get()
+second = q.get()
+empty = q.get(timeout = 0, default = "empty")
+q.put(None)
+element = q.get(timeout = 0, default = "empty")
+q.close()
+`
+	globals, err := exec(&starlark.Thread{}, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full := globals["full"]; full != starlark.False {
+		t.Errorf("put to full queue: got %v, want False", full)
+	}
+	if first := globals["first"]; first != globals["elems"] {
+		t.Errorf("first element: got %v, want %v", first, globals["elems"])
+	} else if err := first.(*starlark.List).Append(starlark.None); err == nil {
+		t.Errorf("queued value was not frozen")
+	}
+	if second := globals["second"]; second != starlark.String("x") {
+		t.Errorf("second element: got %v, want \"x\"", second)
+	}
+	if empty := globals["empty"]; empty != starlark.String("empty") {
+		t.Errorf("get from empty queue: got %v, want \"empty\"", empty)
+	}
+	if element := globals["element"]; element != starlark.None {
+		t.Errorf("get of None element: got %v, want None", element)
+	}
+}
+
+func TestQueueClosed(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{{
+		name: "put",
+		src:  "q = sync.queue(1); q.close(); q.put(1)",
+		err:  "put: queue closed",
+	}, {
+		name: "get",
+		src:  "q = sync.queue(1); q.put(1); q.close(); q.get(); q.get()",
+		err:  "get: queue closed",
+	}, {
+		name: "get-timeout",
+		src:  "q = sync.queue(1); q.get(timeout = 0)",
+		err:  "get: timeout",
+	}, {
+		name: "size",
+		src:  "sync.queue(0)",
+		err:  "queue: queue size must be positive",
+	}, {
+		name: "huge",
+		src:  "sync.queue(1 << 62)",
+		err:  "queue: queue size too large",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := exec(&starlark.Thread{}, test.src, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error: got %v, want %s", err, test.err)
+			}
+		})
+	}
+}
+
+func TestQueueHostProducer(t *testing.T) {
+	q, err := sync.NewQueue(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for i := 0; i < 10; i++ {
+			if err := q.Put(context.Background(), starlark.MakeInt(i)); err != nil {
+				t.Error(err)
+			}
+		}
+		q.Close()
+	}()
+
+	const src = `
+total = 0
+for _ in range(10):
+    total += q.get()
+`
+	globals, err := exec(&starlark.Thread{}, src, starlark.StringDict{"q": q})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := globals["total"]; total != starlark.MakeInt(45) {
+		t.Errorf("got total %v, want 45", total)
+	}
+	if _, err := q.Get(context.Background()); !errors.Is(err, sync.ErrQueueClosed) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestQueueCancellation(t *testing.T) {
+	q, err := sync.NewQueue(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*gotime.Millisecond)
+	defer cancel()
+	thread := &starlark.Thread{}
+	thread.SetParentContext(ctx)
+	_, err = exec(thread, "q.get()", starlark.StringDict{"q": q})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestQueueAllocs(t *testing.T) {
+	newQueue := sync.Module.Members["queue"]
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			q, err := starlark.Call(thread, newQueue, starlark.Tuple{starlark.MakeInt(16)}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(q)
+		}
+	})
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   sync.Module,
+		Safeties: sync.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   sync.Module,
+		Safeties: sync.Safeties,
+	}
+	suite.Benchmark(b)
+}
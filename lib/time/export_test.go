@@ -3,3 +3,4 @@ package time
 var Safeties = safeties
 var TimeMethods = timeMethods
 var TimeMethodSafeties = timeMethodSafeties
+var LocationSize = locationSize
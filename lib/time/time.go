@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/canonical/starlark/starlark"
@@ -26,6 +27,7 @@ import (
 //	    is_valid_timezone(loc) - Reports whether loc is a valid time zone name.
 //
 //	    now() - Returns the current local time. Applications may replace this function by a deterministic one.
+//	            Threads which require TimeSafe may only call now() if they have their own clock, see SetNow.
 //
 //	    parse_duration(d) - Parses the given duration string. For more details, refer to
 //	                        https://pkg.go.dev/time#ParseDuration.
@@ -111,6 +113,41 @@ func Now(thread *starlark.Thread) func() (time.Time, error) {
 	return nowFunc
 }
 
+// A VirtualClock is a deterministic clock for use with SetNow. Each reading
+// of the clock returns its current time and then advances it by a fixed
+// step, so a clock with a zero step is frozen.
+//
+// A VirtualClock may be shared by several threads.
+type VirtualClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+// NewVirtualClock returns a clock whose first reading is start and whose
+// subsequent readings each advance by step.
+func NewVirtualClock(start time.Time, step time.Duration) *VirtualClock {
+	return &VirtualClock{now: start, step: step}
+}
+
+// Now reads the clock. Its signature is that expected by SetNow.
+func (c *VirtualClock) Now() (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now, nil
+}
+
+// Advance moves the clock forward by d.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
 func parseDuration(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	sdu := SafeDurationUnpacker{}
 	sdu.BindThread(thread)
@@ -118,10 +155,11 @@ func parseDuration(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 		return nil, err
 	}
 	d := sdu.Duration()
-	if err := thread.AddAllocs(starlark.EstimateSize(Duration(0))); err != nil {
+	result := starlark.Value(d)
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
 		return nil, err
 	}
-	return d, nil
+	return result, nil
 }
 
 func isValidTimezone(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -129,8 +167,48 @@ func isValidTimezone(thread *starlark.Thread, _ *starlark.Builtin, args starlark
 	if err := starlark.UnpackPositionalArgs("is_valid_timezone", args, kwargs, 1, &s); err != nil {
 		return nil, err
 	}
-	_, err := time.LoadLocation(s)
-	return starlark.Bool(err == nil), nil
+	if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return starlark.False, nil
+	}
+	// The location is discarded, but it was read and allocated nonetheless.
+	size := locationSize(loc)
+	if err := thread.AddSteps(size); err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(size); err != nil {
+		return nil, err
+	}
+	return starlark.True, nil
+}
+
+// loadLocation returns the location with the given name, declaring the steps
+// required to find and read it from the time zone database.
+func loadLocation(thread *starlark.Thread, name string) (*time.Location, error) {
+	if err := thread.AddSteps(starlark.SafeInt(len(name))); err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	// Reading a zone takes time proportional to the size of its data.
+	if err := thread.AddSteps(locationSize(loc)); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+// locationSize estimates the memory used by loc, which is zero for the
+// shared UTC and Local locations.
+func locationSize(loc *time.Location) starlark.SafeInteger {
+	if loc == time.UTC || loc == time.Local {
+		return starlark.SafeInt(0)
+	}
+	return starlark.EstimateSize(loc)
 }
 
 func parseTime(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -163,7 +241,7 @@ func parseTime(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple
 		return res, nil
 	}
 
-	loc, err := time.LoadLocation(location)
+	loc, err := loadLocation(thread, location)
 	if err != nil {
 		return nil, err
 	}
@@ -186,29 +264,40 @@ func fromTimestamp(thread *starlark.Thread, _ *starlark.Builtin, args starlark.T
 	if err := starlark.UnpackPositionalArgs("from_timestamp", args, kwargs, 1, &sec, &nsec); err != nil {
 		return nil, err
 	}
-	if err := thread.AddAllocs(starlark.EstimateSize(Time{})); err != nil {
+	result := starlark.Value(Time(time.Unix(sec, nsec)))
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
 		return nil, err
 	}
-	return Time(time.Unix(sec, nsec)), nil
+	return result, nil
 }
 
+// wallClockSafety is the safety of reading the process-wide clock, NowFunc.
+const wallClockSafety = starlark.CPUSafe | starlark.MemSafe | starlark.IOSafe
+
 func now(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	nowErrFunc := Now(thread)
-	if nowErrFunc != nil {
-		t, err := nowErrFunc()
-		if err != nil {
+	var t time.Time
+	if nowErrFunc := Now(thread); nowErrFunc != nil {
+		var err error
+		if t, err = nowErrFunc(); err != nil {
 			return nil, err
 		}
-		return Time(t), nil
-	}
-	nowFunc := NowFunc
-	if nowFunc == nil {
-		return nil, errors.New("time.now() is not available")
+	} else {
+		// Without a clock of its own, the thread would observe the wall
+		// clock, whose readings depend on how long execution has taken.
+		if err := starlark.CheckSafety(thread, wallClockSafety); err != nil {
+			return nil, err
+		}
+		nowFunc := NowFunc
+		if nowFunc == nil {
+			return nil, errors.New("time.now() is not available")
+		}
+		t = nowFunc()
 	}
-	if err := thread.AddAllocs(starlark.EstimateSize(Time{})); err != nil {
+	result := starlark.Value(Time(t))
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
 		return nil, err
 	}
-	return Time(nowFunc()), nil
+	return result, nil
 }
 
 // Duration is a Starlark representation of a duration.
@@ -267,6 +356,12 @@ func (d Duration) Hash() (uint32, error) {
 // Truth reports whether the duration is non-zero.
 func (d Duration) Truth() starlark.Bool { return d != 0 }
 
+// EstimateSize returns the estimated size of the duration, implementing the
+// starlark.SizeAware interface.
+func (d Duration) EstimateSize() starlark.SafeInteger {
+	return starlark.EstimateSize(time.Duration(d))
+}
+
 func (d Duration) SafeAttr(thread *starlark.Thread, name string) (starlark.Value, error) {
 	const safety = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
 	if err := starlark.CheckSafety(thread, safety); err != nil {
@@ -328,6 +423,29 @@ func (d Duration) Cmp(v starlark.Value, depth int) (int, error) {
 	return 0, nil
 }
 
+// SafeBinary implements the starlark.HasSafeBinary interface, declaring the
+// allocation of the result.
+func (d Duration) SafeBinary(thread *starlark.Thread, op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	const safety = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+	if err := starlark.CheckSafety(thread, safety); err != nil {
+		return nil, err
+	}
+	result, err := d.Binary(op, y, side)
+	return declareResult(thread, result, err)
+}
+
+// declareResult declares the allocation of the result of a binary
+// operation, if any.
+func declareResult(thread *starlark.Thread, result starlark.Value, err error) (starlark.Value, error) {
+	if result == nil || err != nil {
+		return result, err
+	}
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // Binary implements binary operators, which satisfies the starlark.HasBinary
 // interface. operators:
 //
@@ -468,19 +586,14 @@ func newTime(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple,
 	if len(args) > 0 {
 		return nil, fmt.Errorf("time: unexpected positional arguments")
 	}
-	location, err := time.LoadLocation(loc)
+	location, err := loadLocation(thread, loc)
 	if err != nil {
 		return nil, err
 	}
-	if location != time.UTC && location != time.Local {
-		if err := thread.AddAllocs(starlark.EstimateSize(location)); err != nil {
-			return nil, err
-		}
-	}
-	if err := thread.AddAllocs(starlark.EstimateSize(time.Time{})); err != nil {
+	res := starlark.Value(Time(time.Date(year, time.Month(month), day, hour, min, sec, nsec, location)))
+	if err := thread.AddAllocs(starlark.EstimateSize(res)); err != nil {
 		return nil, err
 	}
-	res := starlark.Value(Time(time.Date(year, time.Month(month), day, hour, min, sec, nsec, location)))
 	return res, nil
 }
 
@@ -517,6 +630,14 @@ func (t Time) Hash() (uint32, error) {
 // interface.
 func (t Time) Truth() starlark.Bool { return !starlark.Bool(time.Time(t).IsZero()) }
 
+// EstimateSize returns the estimated size of the time, implementing the
+// starlark.SizeAware interface. Its location is counted unless it is one of
+// the shared UTC and Local locations.
+func (t Time) EstimateSize() starlark.SafeInteger {
+	size := starlark.EstimateSize(time.Time{})
+	return starlark.SafeAdd(size, locationSize(time.Time(t).Location()))
+}
+
 func (t Time) SafeAttr(thread *starlark.Thread, name string) (starlark.Value, error) {
 	const safety = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
 	if err := starlark.CheckSafety(thread, safety); err != nil {
@@ -594,6 +715,17 @@ func (t Time) Cmp(yV starlark.Value, depth int) (int, error) {
 	return 0, nil
 }
 
+// SafeBinary implements the starlark.HasSafeBinary interface, declaring the
+// allocation of the result.
+func (t Time) SafeBinary(thread *starlark.Thread, op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	const safety = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+	if err := starlark.CheckSafety(thread, safety); err != nil {
+		return nil, err
+	}
+	result, err := t.Binary(op, y, side)
+	return declareResult(thread, result, err)
+}
+
 // Binary implements binary operators, which satisfies the starlark.HasBinary
 // interface
 //
@@ -657,20 +789,16 @@ func timeIn(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 	if err := starlark.UnpackPositionalArgs("in_location", args, kwargs, 1, &x); err != nil {
 		return nil, err
 	}
-	loc, err := time.LoadLocation(x)
+	loc, err := loadLocation(thread, x)
 	if err != nil {
 		return nil, err
 	}
-	if loc != time.UTC && loc != time.Local {
-		if err := thread.AddAllocs(starlark.EstimateSize(loc)); err != nil {
-			return nil, err
-		}
-	}
-	if err := thread.AddAllocs(starlark.EstimateSize(time.Time{})); err != nil {
+	recv := time.Time(b.Receiver().(Time))
+	result := starlark.Value(Time(recv.In(loc)))
+	if err := thread.AddAllocs(starlark.EstimateSize(result)); err != nil {
 		return nil, err
 	}
-	recv := time.Time(b.Receiver().(Time))
-	return Time(recv.In(loc)), nil
+	return result, nil
 }
 
 type builtinMethod func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
@@ -10,12 +10,27 @@ import (
 	"github.com/canonical/starlark/lib/time"
 	"github.com/canonical/starlark/starlark"
 	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/startest/conformance"
+	"github.com/canonical/starlark/syntax"
 )
 
 func isStarlarkCancellation(err error) bool {
 	return strings.Contains(err.Error(), "Starlark computation cancelled:")
 }
 
+// locationSteps returns the steps required to load the named location.
+func locationSteps(t *testing.T, name string) int64 {
+	loc, err := gotime.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, ok := time.LocationSize(loc).Int64()
+	if !ok {
+		t.Fatal("location size overflowed")
+	}
+	return int64(len(name)) + size
+}
+
 func TestPerThreadNowReturnsCorrectTime(t *testing.T) {
 	th := &starlark.Thread{}
 	date := gotime.Date(1, 2, 3, 4, 5, 6, 7, gotime.UTC)
@@ -89,6 +104,39 @@ func TestGlobalNowReturnsErrorWhenNil(t *testing.T) {
 	}
 }
 
+func TestVirtualClock(t *testing.T) {
+	start := gotime.Date(2011, 11, 11, 12, 0, 0, 0, gotime.UTC)
+	clock := time.NewVirtualClock(start, gotime.Second)
+	th := &starlark.Thread{}
+	th.RequireSafety(starlark.TimeSafe)
+	time.SetNow(th, clock.Now)
+
+	expected := []gotime.Time{start, start.Add(gotime.Second), start.Add(gotime.Minute + 2*gotime.Second)}
+	for i, want := range expected {
+		if i == 2 {
+			clock.Advance(gotime.Minute)
+		}
+		res, err := starlark.Call(th, time.Module.Members["now"], nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gotime.Time(res.(time.Time)); !got.Equal(want) {
+			t.Errorf("reading %d: expected %v but got %v", i, want, got)
+		}
+	}
+}
+
+func TestWallClockRejectedWhenTimeSafe(t *testing.T) {
+	th := &starlark.Thread{}
+	th.RequireSafety(starlark.TimeSafe)
+	_, err := starlark.Call(th, time.Module.Members["now"], nil, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	} else if !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestModuleSafeties(t *testing.T) {
 	for name, value := range time.Module.Members {
 		builtin, ok := value.(*starlark.Builtin)
@@ -167,9 +215,11 @@ func TestTimeIsValidTimezoneSteps(t *testing.T) {
 	}
 
 	t.Run("timezone=valid", func(t *testing.T) {
+		steps := locationSteps(t, "Europe/Prague")
 		st := startest.From(t)
 		st.RequireSafety(starlark.CPUSafe)
-		st.SetMaxSteps(0)
+		st.SetMinSteps(steps)
+		st.SetMaxSteps(steps)
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
 				_, err := starlark.Call(thread, is_valid_timezone, starlark.Tuple{starlark.String("Europe/Prague")}, nil)
@@ -183,7 +233,8 @@ func TestTimeIsValidTimezoneSteps(t *testing.T) {
 	t.Run("timezone=invalid", func(t *testing.T) {
 		st := startest.From(t)
 		st.RequireSafety(starlark.CPUSafe)
-		st.SetMaxSteps(0)
+		st.SetMinSteps(int64(len("Middle_Earth/Minas_Tirith")))
+		st.SetMaxSteps(int64(len("Middle_Earth/Minas_Tirith")))
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
 				_, err := starlark.Call(thread, is_valid_timezone, starlark.Tuple{starlark.String("Middle_Earth/Minas_Tirith")}, nil)
@@ -204,7 +255,6 @@ func TestTimeIsValidTimezoneAllocs(t *testing.T) {
 	t.Run("timezone=valid", func(t *testing.T) {
 		st := startest.From(t)
 		st.RequireSafety(starlark.MemSafe)
-		st.SetMaxAllocs(0)
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
 				result, err := starlark.Call(thread, is_valid_timezone, starlark.Tuple{starlark.String("Europe/Prague")}, nil)
@@ -256,16 +306,35 @@ func TestTimeNowAllocs(t *testing.T) {
 		t.Fatal("no such builtin: now")
 	}
 
-	st := startest.From(t)
-	st.RequireSafety(starlark.MemSafe)
-	st.RunThread(func(thread *starlark.Thread) {
-		for i := 0; i < st.N; i++ {
-			result, err := starlark.Call(thread, now, nil, nil)
-			if err != nil {
-				st.Error(err)
+	t.Run("clock=global", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				result, err := starlark.Call(thread, now, nil, nil)
+				if err != nil {
+					st.Error(err)
+				}
+				st.KeepAlive(result)
 			}
-			st.KeepAlive(result)
-		}
+		})
+	})
+
+	t.Run("clock=per-thread", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.RunThread(func(thread *starlark.Thread) {
+			time.SetNow(thread, func() (gotime.Time, error) {
+				return gotime.Date(2011, 11, 11, 12, 0, 0, 0, gotime.UTC), nil
+			})
+			for i := 0; i < st.N; i++ {
+				result, err := starlark.Call(thread, now, nil, nil)
+				if err != nil {
+					st.Error(err)
+				}
+				st.KeepAlive(result)
+			}
+		})
 	})
 }
 
@@ -534,9 +603,14 @@ func TestTimeTimeSteps(t *testing.T) {
 	}}
 	for _, test := range tests {
 		t.Run(test.kwarg, func(t *testing.T) {
+			var steps int64
+			if test.kwarg == "location" {
+				steps = locationSteps(t, string(test.value.(starlark.String)))
+			}
 			st := startest.From(t)
 			st.RequireSafety(starlark.CPUSafe)
-			st.SetMaxSteps(0)
+			st.SetMinSteps(steps)
+			st.SetMaxSteps(steps)
 			st.RunThread(func(thread *starlark.Thread) {
 				kwargs := []starlark.Tuple{
 					{starlark.String(test.kwarg), test.value},
@@ -639,9 +713,11 @@ func TestTimeInLocationSteps(t *testing.T) {
 	}}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			steps := locationSteps(t, test.input)
 			st := startest.From(t)
 			st.RequireSafety(starlark.CPUSafe)
-			st.SetMaxSteps(0)
+			st.SetMinSteps(steps)
+			st.SetMaxSteps(steps)
 			st.RunThread(func(thread *starlark.Thread) {
 				args := starlark.Tuple{starlark.String(test.input)}
 				for i := 0; i < st.N; i++ {
@@ -926,3 +1002,111 @@ func TestSafeAttr(t *testing.T) {
 		runTest(t, time.Time(gotime.Now()))
 	})
 }
+
+var binaryTests = []struct {
+	name string
+	x    starlark.Value
+	op   syntax.Token
+	y    starlark.Value
+}{
+	{"duration+duration", time.Duration(gotime.Second), syntax.PLUS, time.Duration(gotime.Minute)},
+	{"duration+time", time.Duration(gotime.Second), syntax.PLUS, time.Time(gotime.Unix(0, 0))},
+	{"duration-duration", time.Duration(gotime.Second), syntax.MINUS, time.Duration(gotime.Minute)},
+	{"duration/duration", time.Duration(gotime.Second), syntax.SLASH, time.Duration(gotime.Minute)},
+	{"duration/int", time.Duration(gotime.Second), syntax.SLASH, starlark.MakeInt(2)},
+	{"duration/float", time.Duration(gotime.Second), syntax.SLASH, starlark.Float(2)},
+	{"duration//duration", time.Duration(gotime.Minute), syntax.SLASHSLASH, time.Duration(gotime.Second)},
+	{"duration*int", time.Duration(gotime.Second), syntax.STAR, starlark.MakeInt(2)},
+	{"time+duration", time.Time(gotime.Unix(0, 0)), syntax.PLUS, time.Duration(gotime.Second)},
+	{"time-duration", time.Time(gotime.Unix(0, 0)), syntax.MINUS, time.Duration(gotime.Second)},
+	{"time-time", time.Time(gotime.Unix(0, 0)), syntax.MINUS, time.Time(gotime.Unix(1, 0))},
+}
+
+func TestBinarySteps(t *testing.T) {
+	for _, test := range binaryTests {
+		t.Run(test.name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.CPUSafe)
+			st.SetMaxSteps(0)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					_, err := starlark.SafeBinary(thread, test.op, test.x, test.y)
+					if err != nil {
+						st.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestBinaryAllocs(t *testing.T) {
+	for _, test := range binaryTests {
+		t.Run(test.name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.SafeBinary(thread, test.op, test.x, test.y)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+}
+
+func TestTimeEstimateSize(t *testing.T) {
+	utc := time.Time(gotime.Date(2011, 11, 11, 12, 0, 0, 0, gotime.UTC))
+	if size, want := starlark.EstimateSize(utc), starlark.EstimateSize(gotime.Time{}); size != want {
+		t.Errorf("unexpected size of UTC time: got %v, want %v", size, want)
+	}
+
+	riga, err := gotime.LoadLocation("Europe/Riga")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := time.Time(gotime.Date(2011, 11, 11, 12, 0, 0, 0, riga))
+	want := starlark.SafeAdd(starlark.EstimateSize(gotime.Time{}), starlark.EstimateSize(riga))
+	if size := starlark.EstimateSize(local); size != want {
+		t.Errorf("unexpected size of located time: got %v, want %v", size, want)
+	}
+}
+
+func TestModuleMaxAllocs(t *testing.T) {
+	for _, name := range []string{"from_timestamp", "now", "parse_duration"} {
+		var args starlark.Tuple
+		switch name {
+		case "from_timestamp":
+			args = starlark.Tuple{starlark.MakeInt(10000)}
+		case "parse_duration":
+			args = starlark.Tuple{starlark.String("10h")}
+		}
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(1)
+		_, err := starlark.Call(thread, time.Module.Members[name], args, nil)
+		if err == nil {
+			t.Errorf("time.%s: expected error", name)
+		} else if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("time.%s: unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	suite := &conformance.Suite{
+		Module:   time.Module,
+		Safeties: time.Safeties,
+	}
+	suite.Run(t)
+}
+
+func BenchmarkConformance(b *testing.B) {
+	suite := &conformance.Suite{
+		Module:   time.Module,
+		Safeties: time.Safeties,
+	}
+	suite.Benchmark(b)
+}
@@ -99,6 +99,7 @@ const doesnt = "this Starlark dialect does not "
 // features of the BUILD language, so we put them behind flags.
 //
 // Deprecated: use an explicit [syntax.FileOptions] argument instead,
+// or set a thread's options with starlark.Thread.SetFileOptions,
 // as it avoids all the usual problems of global variables.
 var (
 	AllowSet            = false // allow the 'set' built-in
@@ -437,6 +438,9 @@ func (r *resolver) useToplevel(use use) (bind *Binding) {
 		if !r.options.Set && id.Name == "set" {
 			r.errorf(id.NamePos, doesnt+"support sets")
 		}
+		if !r.options.StrBuilder && id.Name == "strbuilder" {
+			r.errorf(id.NamePos, doesnt+"support string builders")
+		}
 		bind = &Binding{Scope: Universal}
 		r.predeclared[id.Name] = bind // save it
 	} else {
@@ -501,6 +505,22 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 	case *syntax.ExprStmt:
 		r.expr(stmt.X)
 
+	case *syntax.DelStmt:
+		if !r.options.SliceAssign {
+			r.errorf(stmt.Del, doesnt+"support del statements")
+		}
+		x := stmt.X
+		for paren, ok := x.(*syntax.ParenExpr); ok; paren, ok = x.(*syntax.ParenExpr) {
+			x = paren.X
+		}
+		if slice, ok := x.(*syntax.SliceExpr); !ok {
+			name := strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", x), "*syntax."))
+			r.errorf(syntax.Start(x), "can't delete %s", name)
+		} else if slice.Step != nil {
+			r.errorf(syntax.Start(x), "can't delete extended slice")
+		}
+		r.expr(stmt.X)
+
 	case *syntax.BranchStmt:
 		if r.loops == 0 && (stmt.Token == syntax.BREAK || stmt.Token == syntax.CONTINUE) {
 			r.errorf(stmt.TokenPos, "%s not in a loop", stmt.Token)
@@ -599,6 +619,12 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 }
 
 func (r *resolver) assign(lhs syntax.Expr, isAugmented bool) {
+	if unop, ok := lhs.(*syntax.UnaryExpr); ok && unop.Op == syntax.STAR {
+		// *x = ...
+		r.errorf(unop.OpPos, "starred assignment target must be in a tuple")
+		return
+	}
+
 	switch lhs := lhs.(type) {
 	case *syntax.Ident:
 		// x = ...
@@ -613,23 +639,32 @@ func (r *resolver) assign(lhs syntax.Expr, isAugmented bool) {
 		// x.f = ...
 		r.expr(lhs.X)
 
+	case *syntax.SliceExpr:
+		// x[i:j] = ...
+		if !r.options.SliceAssign {
+			r.errorf(lhs.Lbrack, doesnt+"support slice assignment")
+		}
+		if isAugmented {
+			r.errorf(syntax.Start(lhs), "can't use slice expression in augmented assignment")
+		}
+		if lhs.Step != nil {
+			r.errorf(syntax.Start(lhs), "can't assign to extended slice")
+		}
+		r.expr(lhs)
+
 	case *syntax.TupleExpr:
 		// (x, y) = ...
 		if isAugmented {
 			r.errorf(syntax.Start(lhs), "can't use tuple expression in augmented assignment")
 		}
-		for _, elem := range lhs.List {
-			r.assign(elem, isAugmented)
-		}
+		r.assignSequence(lhs, lhs.List, isAugmented)
 
 	case *syntax.ListExpr:
 		// [x, y, z] = ...
 		if isAugmented {
 			r.errorf(syntax.Start(lhs), "can't use list expression in augmented assignment")
 		}
-		for _, elem := range lhs.List {
-			r.assign(elem, isAugmented)
-		}
+		r.assignSequence(lhs, lhs.List, isAugmented)
 
 	case *syntax.ParenExpr:
 		r.assign(lhs.X, isAugmented)
@@ -640,6 +675,35 @@ func (r *resolver) assign(lhs syntax.Expr, isAugmented bool) {
 	}
 }
 
+// assignSequence resolves the elements of a tuple or list target, at most
+// one of which may be starred.
+func (r *resolver) assignSequence(lhs syntax.Expr, elems []syntax.Expr, isAugmented bool) {
+	starred := -1
+	for i, elem := range elems {
+		if unop, ok := elem.(*syntax.UnaryExpr); ok && unop.Op == syntax.STAR {
+			// x, *y = ...
+			if !r.options.StarAssign {
+				r.errorf(unop.OpPos, doesnt+"support starred assignment")
+			}
+			if starred >= 0 {
+				r.errorf(unop.OpPos, "multiple starred expressions in assignment")
+			}
+			starred = i
+			r.assign(unop.X, isAugmented)
+			continue
+		}
+		r.assign(elem, isAugmented)
+	}
+
+	// Fail gracefully if compiler-imposed limit is exceeded.
+	if starred >= 256 {
+		r.errorf(syntax.Start(lhs), "%v assignment targets before starred target, limit is 255", starred)
+	}
+	if after := len(elems) - starred - 1; starred >= 0 && after >= 256 {
+		r.errorf(syntax.Start(lhs), "%v assignment targets after starred target, limit is 255", after)
+	}
+}
+
 func (r *resolver) expr(e syntax.Expr) {
 	switch e := e.(type) {
 	case *syntax.Ident:
@@ -717,6 +781,9 @@ func (r *resolver) expr(e syntax.Expr) {
 		}
 
 	case *syntax.UnaryExpr:
+		if e.Op == syntax.STAR {
+			r.errorf(e.OpPos, "starred expression is only allowed as an assignment target")
+		}
 		r.expr(e.X)
 
 	case *syntax.BinaryExpr:
@@ -749,7 +816,7 @@ func (r *resolver) expr(e syntax.Expr) {
 					r.errorf(pos, "multiple *args not allowed")
 				}
 				seenVarargs = true
-				r.expr(arg)
+				r.expr(unop.X)
 			} else if binop, ok := arg.(*syntax.BinaryExpr); ok && binop.Op == syntax.EQ {
 				// k=v
 				n++
@@ -18,10 +18,13 @@ import (
 func getOptions(src string) *syntax.FileOptions {
 	return &syntax.FileOptions{
 		Set:               option(src, "set"),
+		StrBuilder:        option(src, "strbuilder"),
 		While:             option(src, "while"),
 		TopLevelControl:   option(src, "toplevelcontrol"),
 		GlobalReassign:    option(src, "globalreassign"),
 		LoadBindsGlobally: option(src, "loadbindsglobally"),
+		SliceAssign:       option(src, "sliceassign"),
+		StarAssign:        option(src, "starassign"),
 		Recursion:         option(src, "recursion"),
 	}
 }
@@ -89,4 +92,4 @@ func TestLambdaVarargsAndKwargsSet(t *testing.T) {
 
 func isPredeclared(name string) bool { return name == "M" }
 
-func isUniversal(name string) bool { return name == "U" || name == "float" }
+func isUniversal(name string) bool { return name == "U" || name == "float" || name == "strbuilder" }
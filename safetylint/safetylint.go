@@ -0,0 +1,313 @@
+// Package safetylint checks at compile time that Go builtins respect the
+// safety they declare.
+//
+// A builtin created with starlark.NewBuiltinWithSafety promises that its
+// implementation respects each of the given safety flags. Check inspects
+// the implementations of such builtins and reports those which, directly or
+// through functions declared in the same package, refer to a function which
+// a Rule forbids for one of the declared flags; for example, an IOSafe
+// builtin must not call os.Open. Calls into other packages are checked
+// against the rules, but are not followed.
+//
+// The check is necessarily incomplete: it cannot see through interface
+// method calls, function values which are not named at the point of
+// registration, or builtins whose safety is declared separately with
+// DeclareSafety. It complements, rather than replaces, testing with
+// startest.
+//
+// The safetylint command runs Check under go vet:
+//
+//	go install github.com/canonical/starlark/cmd/safetylint
+//	go vet -vettool=$(which safetylint) ./...
+package safetylint // import "github.com/canonical/starlark/safetylint"
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+// A Rule forbids builtins which declare a safety flag from referring to
+// some functions.
+type Rule struct {
+	// Flag is the safety flag which the rule enforces.
+	Flag starlark.SafetyFlags
+
+	// Package is the import path of the package holding the forbidden
+	// functions.
+	Package string
+
+	// Funcs holds the names of the forbidden functions, with methods
+	// written as Type.Method. If empty, all functions and methods of the
+	// package are forbidden.
+	Funcs []string
+
+	// Reason explains why the functions are forbidden.
+	Reason string
+}
+
+// matches reports whether the rule forbids fn.
+func (rule *Rule) matches(fn *types.Func) bool {
+	if fn.Pkg() == nil || fn.Pkg().Path() != rule.Package {
+		return false
+	}
+	if len(rule.Funcs) == 0 {
+		return true
+	}
+	name := funcName(fn)
+	for _, forbidden := range rule.Funcs {
+		if forbidden == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules maps safety flags to the functions which builtins declaring them
+// must not refer to.
+type Rules []Rule
+
+// Forbidden returns the first rule which forbids builtins declaring any of
+// flags from referring to fn, if any.
+func (rules Rules) Forbidden(flags starlark.SafetyFlags, fn *types.Func) (*Rule, bool) {
+	for i := range rules {
+		rule := &rules[i]
+		if flags&rule.Flag != 0 && rule.matches(fn) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// DefaultRules holds the rules used by the safetylint command.
+var DefaultRules = Rules{
+	{Flag: starlark.MemSafe, Package: "io", Funcs: []string{"ReadAll"}, Reason: "allocates without bound"},
+	{Flag: starlark.MemSafe, Package: "io/ioutil", Funcs: []string{"ReadAll"}, Reason: "allocates without bound"},
+	{Flag: starlark.TimeSafe, Package: "time", Funcs: []string{"Sleep"}, Reason: "blocks regardless of cancellation"},
+	{Flag: starlark.IOSafe, Package: "os", Reason: "accesses the file system or process"},
+	{Flag: starlark.IOSafe, Package: "os/exec", Reason: "runs external programs"},
+	{Flag: starlark.IOSafe, Package: "os/signal", Reason: "accesses the process"},
+	{Flag: starlark.IOSafe, Package: "os/user", Reason: "accesses the system's users"},
+	{Flag: starlark.IOSafe, Package: "io/ioutil", Funcs: []string{"ReadFile", "WriteFile", "ReadDir", "TempFile", "TempDir"}, Reason: "accesses the file system"},
+	{Flag: starlark.IOSafe, Package: "net", Reason: "accesses the network"},
+	{Flag: starlark.IOSafe, Package: "net/http", Reason: "accesses the network"},
+	{Flag: starlark.IOSafe, Package: "net/rpc", Reason: "accesses the network"},
+	{Flag: starlark.IOSafe, Package: "net/smtp", Reason: "accesses the network"},
+	{Flag: starlark.IOSafe, Package: "plugin", Reason: "loads code from the file system"},
+	{Flag: starlark.IOSafe, Package: "syscall", Reason: "accesses the operating system"},
+	{Flag: starlark.IOSafe, Package: "time", Funcs: []string{"Now", "Since", "Until"}, Reason: "reads the system clock"},
+}
+
+// A Diagnostic reports a reference to a forbidden function.
+type Diagnostic struct {
+	Pos     token.Pos
+	Message string
+}
+
+const starlarkPath = "github.com/canonical/starlark/starlark"
+
+// Check reports the references to functions forbidden by rules made by the
+// implementations of the builtins registered in files, which make up the
+// type-checked package pkg. info must record at least Uses, Defs and Types.
+// Diagnostics are reported in the order in which builtins are registered.
+func Check(pkg *types.Package, files []*ast.File, info *types.Info, rules Rules) []Diagnostic {
+	c := &checker{
+		pkg:    pkg,
+		info:   info,
+		rules:  rules,
+		bodies: make(map[*types.Func]*ast.BlockStmt),
+		uses:   make(map[ast.Node]*funcUses),
+	}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if decl, ok := decl.(*ast.FuncDecl); ok && decl.Body != nil {
+				if fn, ok := info.Defs[decl.Name].(*types.Func); ok {
+					c.bodies[fn] = decl.Body
+				}
+			}
+		}
+	}
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				c.checkRegistration(call)
+			}
+			return true
+		})
+	}
+	return c.diagnostics
+}
+
+type checker struct {
+	pkg         *types.Package
+	info        *types.Info
+	rules       Rules
+	bodies      map[*types.Func]*ast.BlockStmt
+	uses        map[ast.Node]*funcUses
+	diagnostics []Diagnostic
+}
+
+// funcUses records the functions referred to by a function body.
+type funcUses struct {
+	// local holds the functions declared in the package being checked.
+	local []*types.Func
+
+	// external holds the references to functions of other packages.
+	external []*ast.Ident
+}
+
+// checkRegistration checks the implementation of the builtin created by
+// call, if it is a call to starlark.NewBuiltinWithSafety.
+func (c *checker) checkRegistration(call *ast.CallExpr) {
+	if callee := c.callee(call.Fun); callee == nil || callee.Pkg() == nil ||
+		callee.Pkg().Path() != starlarkPath || callee.Name() != "NewBuiltinWithSafety" {
+		return
+	}
+	if len(call.Args) != 3 {
+		return
+	}
+	name := "?"
+	if tv, ok := c.info.Types[call.Args[0]]; ok && tv.Value != nil && tv.Value.Kind() == constant.String {
+		name = constant.StringVal(tv.Value)
+	}
+	tv, ok := c.info.Types[call.Args[1]]
+	if !ok || tv.Value == nil {
+		// The safety is not known until run time.
+		return
+	}
+	flags64, ok := constant.Uint64Val(constant.ToInt(tv.Value))
+	if !ok {
+		return
+	}
+	flags := starlark.SafetyFlags(flags64)
+
+	var root ast.Node
+	switch impl := unparen(call.Args[2]).(type) {
+	case *ast.FuncLit:
+		root = impl.Body
+	default:
+		fn := c.callee(impl)
+		if fn == nil || c.bodies[fn] == nil {
+			// The implementation is declared elsewhere.
+			return
+		}
+		root = c.bodies[fn]
+	}
+	c.checkImpl(name, flags, root)
+}
+
+// callee returns the function named by expr, if any.
+func (c *checker) callee(expr ast.Expr) *types.Func {
+	var id *ast.Ident
+	switch expr := unparen(expr).(type) {
+	case *ast.Ident:
+		id = expr
+	case *ast.SelectorExpr:
+		id = expr.Sel
+	default:
+		return nil
+	}
+	fn, _ := c.info.Uses[id].(*types.Func)
+	return fn
+}
+
+// checkImpl reports the forbidden functions referred to by root, the body
+// of the implementation of the builtin name, or by the functions of this
+// package which it refers to, directly or indirectly.
+func (c *checker) checkImpl(name string, flags starlark.SafetyFlags, root ast.Node) {
+	type item struct {
+		node ast.Node
+		via  []string
+	}
+	visited := map[ast.Node]bool{root: true}
+	queue := []item{{node: root}}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+
+		uses := c.funcUses(it.node)
+		for _, id := range uses.external {
+			fn := c.info.Uses[id].(*types.Func)
+			rule, ok := c.rules.Forbidden(flags, fn)
+			if !ok {
+				continue
+			}
+			via := ""
+			if len(it.via) > 0 {
+				via = " (via " + strings.Join(it.via, " → ") + ")"
+			}
+			c.diagnostics = append(c.diagnostics, Diagnostic{
+				Pos: id.Pos(),
+				Message: fmt.Sprintf("builtin %q declared %s calls %s.%s%s, which %s",
+					name, rule.Flag, fn.Pkg().Name(), funcName(fn), via, rule.Reason),
+			})
+		}
+		for _, fn := range uses.local {
+			body := c.bodies[fn]
+			if body == nil || visited[body] {
+				continue
+			}
+			visited[body] = true
+			via := append(append([]string(nil), it.via...), funcName(fn))
+			queue = append(queue, item{node: body, via: via})
+		}
+	}
+}
+
+// funcUses returns the functions referred to by node.
+func (c *checker) funcUses(node ast.Node) *funcUses {
+	if uses, ok := c.uses[node]; ok {
+		return uses
+	}
+	uses := &funcUses{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		fn, ok := c.info.Uses[id].(*types.Func)
+		if !ok || fn.Pkg() == nil {
+			return true
+		}
+		if fn.Pkg() == c.pkg {
+			uses.local = append(uses.local, fn)
+		} else {
+			uses.external = append(uses.external, id)
+		}
+		return true
+	})
+	c.uses[node] = uses
+	return uses
+}
+
+// funcName returns the name of fn, qualified by its receiver's type name
+// if it is a method.
+func funcName(fn *types.Func) string {
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return fn.Name()
+	}
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj().Name() + "." + fn.Name()
+	}
+	return fn.Name()
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
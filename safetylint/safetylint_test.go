@@ -0,0 +1,147 @@
+package safetylint_test
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/starlark/safetylint"
+	"github.com/canonical/starlark/starlark"
+)
+
+// starlarkStub declares the parts of the starlark package which the
+// checker recognises, so that tests need not type-check it from source.
+const starlarkStub = `
+package starlark
+
+type SafetyFlags uint
+
+const (
+	NotSafe SafetyFlags = 0
+	CPUSafe SafetyFlags = 1 << (iota - 1)
+	MemSafe
+	TimeSafe
+	IOSafe
+)
+
+type Builtin struct{}
+
+func NewBuiltinWithSafety(name string, safety SafetyFlags, fn func()) *Builtin { return nil }
+`
+
+const testSrc = `
+package builtins
+
+import (
+	"os"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+var direct = starlark.NewBuiltinWithSafety("direct", starlark.IOSafe, func() {
+	os.Getenv("HOME")
+})
+
+var indirect = starlark.NewBuiltinWithSafety("indirect", starlark.MemSafe|starlark.IOSafe, indirectImpl)
+
+func indirectImpl() { load() }
+
+func load() { open() }
+
+func open() {
+	f, _ := os.Open("x")
+	f.Close()
+}
+
+var undeclared = starlark.NewBuiltinWithSafety("undeclared", starlark.MemSafe, indirectImpl)
+
+var clock = starlark.NewBuiltinWithSafety("clock", starlark.IOSafe|starlark.TimeSafe, func() {
+	time.Now()
+	time.Sleep(time.Second)
+	_ = time.Duration(0).String()
+})
+
+var recursive = starlark.NewBuiltinWithSafety("recursive", starlark.IOSafe, recurse)
+
+func recurse() { recurse() }
+`
+
+func TestCheck(t *testing.T) {
+	fset := token.NewFileSet()
+	stub := typeCheck(t, fset, "github.com/canonical/starlark/starlark", starlarkStub, importer.Default())
+	imports := importerFunc(func(path string) (*types.Package, error) {
+		if path == stub.Path() {
+			return stub, nil
+		}
+		return importer.Default().Import(path)
+	})
+
+	file, err := parser.ParseFile(fset, "builtins.go", testSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	pkg, err := (&types.Config{Importer: imports}).Check("builtins", fset, []*ast.File{file}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, diag := range safetylint.Check(pkg, []*ast.File{file}, info, safetylint.DefaultRules) {
+		got = append(got, fset.Position(diag.Pos).String()+": "+diag.Message)
+	}
+	want := []string{
+		`builtins.go:12:5: builtin "direct" declared IOSafe calls os.Getenv, which accesses the file system or process`,
+		`builtins.go:22:13: builtin "indirect" declared IOSafe calls os.Open (via load → open), which accesses the file system or process`,
+		`builtins.go:23:4: builtin "indirect" declared IOSafe calls os.File.Close (via load → open), which accesses the file system or process`,
+		`builtins.go:29:7: builtin "clock" declared IOSafe calls time.Now, which reads the system clock`,
+		`builtins.go:30:7: builtin "clock" declared TimeSafe calls time.Sleep, which blocks regardless of cancellation`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected diagnostics:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestRulesForbidden(t *testing.T) {
+	timePkg, err := importer.Default().Import("time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := timePkg.Scope().Lookup("Now").(*types.Func)
+	if _, ok := safetylint.DefaultRules.Forbidden(starlark.NotSafe, now); ok {
+		t.Error("time.Now forbidden without safety")
+	}
+	rule, ok := safetylint.DefaultRules.Forbidden(starlark.IOSafe, now)
+	if !ok {
+		t.Fatal("time.Now not forbidden")
+	}
+	if rule.Reason != "reads the system clock" {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+}
+
+func typeCheck(t *testing.T, fset *token.FileSet, path, src string, imports types.Importer) *types.Package {
+	t.Helper()
+	file, err := parser.ParseFile(fset, path+".go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := (&types.Config{Importer: imports}).Check(path, fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
@@ -5,6 +5,7 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"unicode/utf8"
 
@@ -305,6 +306,110 @@ func TestConcurrentAddAllocsUsage(t *testing.T) {
 	}
 }
 
+func TestConcurrentAddAllocsCap(t *testing.T) {
+	const maxAllocs = 10_000
+	const goroutines = 16
+
+	thread := &starlark.Thread{}
+	thread.SetMaxAllocs(maxAllocs)
+
+	var accepted int64
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < maxAllocs; j++ {
+				if err := thread.AddAllocs(starlark.SafeInt(1)); err != nil {
+					return
+				}
+				atomic.AddInt64(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != maxAllocs {
+		t.Errorf("concurrent thread.AddAllocs accepted %d allocations, expected exactly %d", accepted, maxAllocs)
+	}
+}
+
+func TestConcurrentSetMaxAllocs(t *testing.T) {
+	thread := &starlark.Thread{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			thread.SetMaxAllocs(int64(1_000_000 - i))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if err := thread.AddAllocs(starlark.SafeInt(1)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestReleaseAllocs(t *testing.T) {
+	t.Run("credit", func(t *testing.T) {
+		pool := starlark.NewResourcePool(0, 0)
+		thread := &starlark.Thread{}
+		thread.SetResourcePool(pool)
+		if err := thread.AddAllocs(starlark.SafeInt(100)); err != nil {
+			t.Fatal(err)
+		}
+		if err := thread.ReleaseAllocs(starlark.SafeInt(60)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allocs, _ := thread.Allocs(); allocs != 40 {
+			t.Errorf("expected 40 allocs, got %d", allocs)
+		}
+		if allocs, _ := pool.Allocs(); allocs != 40 {
+			t.Errorf("expected 40 pool allocs, got %d", allocs)
+		}
+	})
+
+	t.Run("clamp", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		if err := thread.AddAllocs(starlark.SafeInt(10)); err != nil {
+			t.Fatal(err)
+		}
+		if err := thread.ReleaseAllocs(starlark.SafeInt(1000)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allocs, ok := thread.Allocs(); !ok || allocs != 0 {
+			t.Errorf("expected 0 allocs, got %d", allocs)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		if err := thread.ReleaseAllocs(starlark.SafeInt(-1)); err == nil {
+			t.Error("expected error releasing a negative size")
+		}
+		if err := thread.ReleaseAllocs(starlark.InvalidSafeInt); err == nil {
+			t.Error("expected error releasing an invalid size")
+		}
+	})
+
+	t.Run("list-clear", func(t *testing.T) {
+		const src = `
+def f():
+	for i in range(100):
+		l = [None] * 10000
+		l.clear()
+f()
+`
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(1 << 20)
+		if _, err := starlark.ExecFile(thread, "list_clear.star", src, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
 func TestSafeStringBuilder(t *testing.T) {
 	t.Run("over-allocation", func(t *testing.T) {
 		t.Run("Grow", func(t *testing.T) {
@@ -360,6 +465,22 @@ func TestSafeStringBuilder(t *testing.T) {
 		})
 	})
 
+	t.Run("sticky-errors", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(2)
+
+		builder := starlark.NewSafeStringBuilder(thread)
+		if _, err := builder.WriteString("foo"); err == nil {
+			t.Fatal("WriteString shouldn't be able to exceed the step limit")
+		}
+		if err := builder.Err(); err == nil {
+			t.Errorf("WriteString error not retained")
+		}
+		if err := builder.WriteByte('a'); err == nil {
+			t.Errorf("WriteByte shouldn't succeed after an error")
+		}
+	})
+
 	t.Run("nil-thread", func(t *testing.T) {
 		defer func() {
 			if err := recover(); err != nil {
@@ -596,3 +717,105 @@ func TestSafeStringBuilder(t *testing.T) {
 		})
 	})
 }
+
+func TestBuiltinMaxAllocs(t *testing.T) {
+	alloc := starlark.NewBuiltin("alloc", func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var n int64
+		var ignoreErr bool
+		if err := starlark.UnpackArgs("alloc", args, kwargs, "n", &n, "ignore_err?", &ignoreErr); err != nil {
+			return nil, err
+		}
+		if err := thread.AddAllocs(starlark.SafeInt(n)); err != nil && !ignoreErr {
+			return nil, err
+		}
+		return starlark.None, nil
+	})
+	alloc.SetMaxAllocs(100)
+
+	t.Run("within-budget", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		if _, err := starlark.Call(thread, alloc, starlark.Tuple{starlark.MakeInt(100)}, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// The budget applies per call.
+		if _, err := starlark.Call(thread, alloc, starlark.Tuple{starlark.MakeInt(100)}, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("exceeded", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		_, err := starlark.Call(thread, alloc, starlark.Tuple{starlark.MakeInt(101)}, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// Exceeding a builtin's budget does not cancel the thread.
+		if _, err := starlark.ExecFile(thread, "builtin_budget", "", nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("error-ignored", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		_, err := starlark.Call(thread, alloc, starlark.Tuple{starlark.MakeInt(101), starlark.True}, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("bound-method", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		method := alloc.BindReceiver(starlark.None)
+		if max := method.MaxAllocs(); max != 100 {
+			t.Errorf("bound method has wrong limit: got %d, want 100", max)
+		}
+		_, err := starlark.Call(thread, method, starlark.Tuple{starlark.MakeInt(101)}, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		globals := starlark.StringDict{"alloc": alloc}
+		wrap := starlark.NewBuiltin("wrap", func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.Call(thread, args[0], args[1:], nil)
+		})
+		wrap.SetMaxAllocs(1000)
+		globals["wrap"] = wrap
+		_, err := starlark.ExecFile(thread, "nested", "alloc(80); wrap(alloc, 80); wrap(alloc, 80)", globals)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		wrap.SetMaxAllocs(50)
+		_, err = starlark.ExecFile(thread, "nested", "wrap(alloc, 80)", globals)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		explode := starlark.NewBuiltin("explode", func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			panic("boom")
+		})
+		explode.SetMaxAllocs(10)
+
+		thread := &starlark.Thread{}
+		func() {
+			defer func() {
+				if r := recover(); r != "boom" {
+					t.Errorf("unexpected panic: %v", r)
+				}
+			}()
+			starlark.Call(thread, explode, nil, nil)
+		}()
+
+		// The budget of the panicking builtin no longer applies.
+		if err := thread.AddAllocs(starlark.SafeInt(100)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, err := starlark.Call(thread, alloc, starlark.Tuple{starlark.MakeInt(100)}, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package starlark
+
+import (
+	"math"
+	"sort"
+
+	"github.com/canonical/starlark/internal/compile"
+	"github.com/canonical/starlark/syntax"
+)
+
+// An AllocSite identifies the origin of allocations counted by a thread.
+type AllocSite struct {
+	// Name is that of the builtin which declared the allocations or, if
+	// they were declared by the interpreter, that of the bytecode
+	// instruction being executed, for example "makelist".
+	Name string
+
+	// Pos is the position in Starlark code of the call to the builtin or
+	// of the instruction. It is not valid if the allocations were counted
+	// while no Starlark code was executing.
+	Pos syntax.Position
+}
+
+// An AllocProfileEntry records the net allocations counted at a site.
+type AllocProfileEntry struct {
+	Site   AllocSite
+	Allocs int64
+}
+
+// EnableAllocProfile causes the thread to record the site at which each
+// allocation it subsequently counts was declared. The resulting profile is
+// reported by AllocProfile.
+//
+// Profiling adds a map update to each call of AddAllocs so should only be
+// enabled when debugging.
+func (thread *Thread) EnableAllocProfile() {
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
+	if thread.allocProfile == nil {
+		thread.allocProfile = make(map[AllocSite]SafeInteger)
+	}
+}
+
+// AllocProfile returns the net allocations counted at each site since
+// EnableAllocProfile was called, ordered from the largest to the smallest.
+// If profiling is not enabled, AllocProfile returns nil.
+func (thread *Thread) AllocProfile() []AllocProfileEntry {
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
+	if thread.allocProfile == nil {
+		return nil
+	}
+	entries := make([]AllocProfileEntry, 0, len(thread.allocProfile))
+	for site, allocs := range thread.allocProfile {
+		allocs64, ok := allocs.Int64()
+		if !ok {
+			allocs64 = math.MaxInt64
+		}
+		entries = append(entries, AllocProfileEntry{Site: site, Allocs: allocs64})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Allocs != entries[j].Allocs {
+			return entries[i].Allocs > entries[j].Allocs
+		}
+		return entries[i].Site.Pos.String() < entries[j].Site.Pos.String()
+	})
+	return entries
+}
+
+// recordAllocs attributes delta allocations to the current site. The caller
+// must hold allocsLock.
+func (thread *Thread) recordAllocs(delta SafeInteger) {
+	site := thread.allocSite()
+	thread.allocProfile[site] = SafeAdd(thread.allocProfile[site], delta)
+}
+
+// allocSite returns the site at which allocations are currently declared.
+func (thread *Thread) allocSite() AllocSite {
+	if len(thread.stack) == 0 {
+		return AllocSite{}
+	}
+	fr := thread.frameAt(0)
+	if fr.callable == nil {
+		return AllocSite{}
+	}
+	if fn, ok := fr.callable.(*Function); ok {
+		op := compile.Opcode(fn.funcode.Code[fr.pc])
+		return AllocSite{Name: op.String(), Pos: fr.Position()}
+	}
+	site := AllocSite{Name: fr.callable.Name()}
+	if len(thread.stack) > 1 {
+		site.Pos = thread.frameAt(1).Position()
+	}
+	return site
+}
@@ -0,0 +1,83 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestAllocProfile(t *testing.T) {
+	const src = `
+def f():
+	x = [1, 2, 3]
+	y = "a" * 1000
+	return x, y
+f()
+`
+	thread := &starlark.Thread{}
+	if profile := thread.AllocProfile(); profile != nil {
+		t.Errorf("profile reported before profiling was enabled: %v", profile)
+	}
+
+	thread.EnableAllocProfile()
+	if _, err := starlark.ExecFile(thread, "profile.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := thread.AllocProfile()
+	if len(profile) == 0 {
+		t.Fatal("no allocations profiled")
+	}
+
+	var total int64
+	for i, entry := range profile {
+		total += entry.Allocs
+		if i > 0 && entry.Allocs > profile[i-1].Allocs {
+			t.Errorf("profile not sorted: %v", profile)
+		}
+	}
+	if allocs, _ := thread.Allocs(); total != allocs {
+		t.Errorf("profile does not account for all allocations: expected %d but got %d", allocs, total)
+	}
+
+	// The repeated string is the largest allocation.
+	if top := profile[0].Site; top.Name != "star" || top.Pos.Line != 4 {
+		t.Errorf("unexpected largest site: %s at %v", top.Name, top.Pos)
+	}
+
+	found := false
+	for _, entry := range profile {
+		if entry.Site.Name == "maketuple" && entry.Site.Pos.Line == 5 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("tuple construction not profiled: %v", profile)
+	}
+}
+
+func TestAllocProfileBuiltin(t *testing.T) {
+	const src = `
+def f():
+	return list(range(100))
+f()
+`
+	thread := &starlark.Thread{}
+	thread.EnableAllocProfile()
+	if _, err := starlark.ExecFile(thread, "profile.star", src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, entry := range thread.AllocProfile() {
+		if entry.Site.Name == "list" {
+			found = true
+			if entry.Site.Pos.Line != 3 {
+				t.Errorf("builtin call site reported at line %d, expected 3", entry.Site.Pos.Line)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("builtin allocations not profiled: %v", thread.AllocProfile())
+	}
+}
@@ -0,0 +1,90 @@
+package starlark
+
+// This file defines allocation auditing, which compares the allocations
+// declared by builtins with those measured on the Go heap.
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime"
+)
+
+// An AllocationAudit records a sampled builtin call during which more
+// memory was allocated on the Go heap than was declared to the thread.
+type AllocationAudit struct {
+	// Builtin is the builtin which was called.
+	Builtin *Builtin
+
+	// Declared is the number of bytes reported to the thread through
+	// AddAllocs during the call, including by any functions it called.
+	Declared int64
+
+	// Measured is the number of bytes allocated on the Go heap during
+	// the call.
+	Measured int64
+}
+
+func (audit AllocationAudit) String() string {
+	return fmt.Sprintf("%s: measured %d bytes allocated, declared %d", audit.Builtin.Name(), audit.Measured, audit.Declared)
+}
+
+type allocAudit struct {
+	rate   float64
+	report func(AllocationAudit)
+}
+
+// EnableAllocationAudit enables sampled auditing of the allocations declared
+// by the builtins called by this thread, so that drift in MemSafe
+// declarations may be detected in production. The given rate is the
+// fraction of builtin calls which are audited. For each audited call in
+// which the memory measured on the Go heap exceeds the allocations
+// declared, report is called. If report is nil, the discrepancy is logged.
+// If rate is zero or negative, auditing is disabled.
+//
+// Measurement reads the runtime's memory statistics, which briefly stops the
+// world, so rate should be small. As measurements include allocations made
+// concurrently by other goroutines, individual reports may be spurious;
+// consistent reports for the same builtin are not.
+func (thread *Thread) EnableAllocationAudit(rate float64, report func(AllocationAudit)) {
+	if rate <= 0 {
+		thread.allocAudit = nil
+		return
+	}
+	if report == nil {
+		report = func(audit AllocationAudit) {
+			log.Printf("starlark: allocation audit: %v", audit)
+		}
+	}
+	thread.allocAudit = &allocAudit{rate: rate, report: report}
+}
+
+// sample reports whether the next builtin call should be audited.
+func (audit *allocAudit) sample() bool {
+	return audit.rate >= 1 || rand.Float64() < audit.rate
+}
+
+// call calls b, comparing the allocations it declares with those measured.
+func (audit *allocAudit) call(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var before, after runtime.MemStats
+	declaredBefore, declaredOk := thread.Allocs()
+	runtime.ReadMemStats(&before)
+
+	result, err := b.callWithBudget(thread, args, kwargs)
+
+	runtime.ReadMemStats(&after)
+	declaredAfter, declaredOk2 := thread.Allocs()
+	if !declaredOk || !declaredOk2 {
+		return result, err
+	}
+	declared := declaredAfter - declaredBefore
+	measured := int64(after.TotalAlloc - before.TotalAlloc)
+	if measured > declared {
+		audit.report(AllocationAudit{
+			Builtin:  b,
+			Declared: declared,
+			Measured: measured,
+		})
+	}
+	return result, err
+}
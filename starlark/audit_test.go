@@ -0,0 +1,66 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestAllocationAudit(t *testing.T) {
+	const size = 1 << 20
+
+	var sink []byte
+	undeclared := starlark.NewBuiltin("undeclared", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		sink = make([]byte, size)
+		return starlark.None, nil
+	})
+	declared := starlark.NewBuiltin("declared", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := thread.AddAllocs(starlark.SafeInt(2 * size)); err != nil {
+			return nil, err
+		}
+		sink = make([]byte, size)
+		return starlark.None, nil
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		var audits []starlark.AllocationAudit
+		thread := &starlark.Thread{}
+		thread.EnableAllocationAudit(1, func(audit starlark.AllocationAudit) {
+			audits = append(audits, audit)
+		})
+
+		if _, err := starlark.Call(thread, declared, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(audits) != 0 {
+			t.Errorf("unexpected audits: %v", audits)
+		}
+
+		if _, err := starlark.Call(thread, undeclared, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(audits) != 1 {
+			t.Fatalf("expected 1 audit, got %v", audits)
+		}
+		if audit := audits[0]; audit.Builtin != undeclared {
+			t.Errorf("audit of wrong builtin: got %s, want undeclared", audit.Builtin.Name())
+		} else if audit.Declared != 0 {
+			t.Errorf("unexpected declared allocations: got %d, want 0", audit.Declared)
+		} else if audit.Measured < size {
+			t.Errorf("measured allocations too small: got %d, want at least %d", audit.Measured, size)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.EnableAllocationAudit(1, func(audit starlark.AllocationAudit) {
+			t.Errorf("unexpected audit: %v", audit)
+		})
+		thread.EnableAllocationAudit(0, nil)
+		if _, err := starlark.Call(thread, undeclared, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	_ = sink
+}
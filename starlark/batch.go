@@ -0,0 +1,97 @@
+package starlark
+
+import "sync"
+
+// BatchOptions configures a call to EvalBatch. The zero value evaluates each
+// input sequentially on a fresh, unconstrained thread.
+type BatchOptions struct {
+	// Parallelism is the maximum number of inputs which may be evaluated
+	// concurrently. If zero or negative, inputs are evaluated one at a time.
+	Parallelism int
+
+	// NewThread, if non-nil, returns the thread on which the ith input will
+	// be evaluated. Each call must return a distinct thread, which is where
+	// limits such as SetMaxSteps and RequireSafety should be configured.
+	// When Parallelism is greater than one, NewThread may be called
+	// concurrently.
+	NewThread func(i int) *Thread
+
+	// Pool, if non-nil, is the resource pool to which each batch thread is
+	// attached, so that all inputs draw from a single budget. Threads
+	// returned by NewThread which are already attached to a pool are left
+	// unchanged.
+	Pool *ResourcePool
+}
+
+// A BatchResult holds the outcome of evaluating a program against a single
+// input environment.
+type BatchResult struct {
+	// Globals holds the frozen globals of the module. As with ExecFile, a
+	// partial set of globals may be present even if Err is non-nil.
+	Globals StringDict
+
+	// Err holds any error which occurred during evaluation. If evaluation
+	// failed at runtime, this is an *EvalError.
+	Err error
+}
+
+// EvalBatch executes the toplevel code of prog once for each of the given
+// predeclared environments, returning one result per input, in order.
+//
+// Compilation and the creation of the program's constants are performed only
+// once, so EvalBatch is cheaper than repeatedly calling ExecFile when the
+// same script is applied to many inputs. When inputs are evaluated in
+// parallel, any values shared between input environments must be frozen.
+func EvalBatch(prog *Program, inputs []StringDict, opts *BatchOptions) []BatchResult {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+
+	constants := makeConstants(prog.compiled)
+	results := make([]BatchResult, len(inputs))
+
+	eval := func(i int) {
+		var thread *Thread
+		if opts.NewThread != nil {
+			thread = opts.NewThread(i)
+		}
+		if thread == nil {
+			thread = &Thread{}
+		}
+		if opts.Pool != nil && thread.ResourcePool() == nil {
+			thread.SetResourcePool(opts.Pool)
+		}
+
+		toplevel := makeToplevelFunctionWithConstants(prog.compiled, inputs[i], constants)
+		_, err := Call(thread, toplevel, nil, nil)
+		globals := toplevel.Globals()
+		globals.Freeze()
+		results[i] = BatchResult{
+			Globals: globals,
+			Err:     err,
+		}
+	}
+
+	if opts.Parallelism <= 1 {
+		for i := range inputs {
+			eval(i)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.Parallelism)
+	for i := range inputs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			eval(i)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
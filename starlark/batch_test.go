@@ -0,0 +1,147 @@
+package starlark_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestEvalBatch(t *testing.T) {
+	const src = `
+def square(x):
+    return x * x
+
+y = square(x) + offset
+`
+	_, prog, err := starlark.SourceProgram("batch.star", src, func(name string) bool {
+		return name == "x" || name == "offset"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	inputs := make([]starlark.StringDict, n)
+	for i := range inputs {
+		inputs[i] = starlark.StringDict{
+			"x":      starlark.MakeInt(i),
+			"offset": starlark.MakeInt(1),
+		}
+	}
+
+	for _, parallelism := range []int{0, 1, 4, n * 2} {
+		results := starlark.EvalBatch(prog, inputs, &starlark.BatchOptions{Parallelism: parallelism})
+		if len(results) != n {
+			t.Fatalf("parallelism %d: expected %d results, got %d", parallelism, n, len(results))
+		}
+		for i, result := range results {
+			if result.Err != nil {
+				t.Errorf("parallelism %d: input %d: unexpected error: %v", parallelism, i, result.Err)
+				continue
+			}
+			expected := starlark.MakeInt(i*i + 1)
+			if y := result.Globals["y"]; y == nil {
+				t.Errorf("parallelism %d: input %d: y not defined", parallelism, i)
+			} else if eq, err := starlark.Equal(y, expected); err != nil || !eq {
+				t.Errorf("parallelism %d: input %d: expected %v, got %v", parallelism, i, expected, y)
+			}
+		}
+	}
+}
+
+func TestEvalBatchNewThread(t *testing.T) {
+	_, prog, err := starlark.SourceProgram("batch.star", "y = [i for i in range(n)]", func(name string) bool {
+		return name == "n"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []starlark.StringDict{
+		{"n": starlark.MakeInt(1)},
+		{"n": starlark.MakeInt(1_000_000)},
+		{"n": starlark.MakeInt(2)},
+	}
+	var calls int64
+	opts := &starlark.BatchOptions{
+		Parallelism: 2,
+		NewThread: func(i int) *starlark.Thread {
+			atomic.AddInt64(&calls, 1)
+			thread := &starlark.Thread{}
+			thread.SetMaxSteps(1000)
+			return thread
+		},
+	}
+	results := starlark.EvalBatch(prog, inputs, opts)
+	if calls != int64(len(inputs)) {
+		t.Errorf("expected NewThread to be called %d times, got %d", len(inputs), calls)
+	}
+	if err := results[0].Err; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := results[1].Err; err == nil {
+		t.Error("expected error")
+	} else if !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := results[2].Err; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEvalBatchFreezesGlobals(t *testing.T) {
+	_, prog, err := starlark.SourceProgram("batch.star", "l = []", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := starlark.EvalBatch(prog, []starlark.StringDict{nil}, nil)
+	if err := results[0].Err; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l := results[0].Globals["l"].(*starlark.List)
+	if err := l.Append(starlark.None); err == nil {
+		t.Error("expected append to frozen list to fail")
+	}
+}
+
+func TestEvalBatchPool(t *testing.T) {
+	_, prog, err := starlark.SourceProgram("batch.star", "y = [i for i in range(n)]", func(name string) bool {
+		return name == "n"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	inputs := make([]starlark.StringDict, n)
+	for i := range inputs {
+		inputs[i] = starlark.StringDict{"n": starlark.MakeInt(1000)}
+	}
+
+	// Each input fits within the pool alone, but together they do not.
+	const maxSteps = 20000
+	pool := starlark.NewResourcePool(maxSteps, 0)
+	results := starlark.EvalBatch(prog, inputs, &starlark.BatchOptions{
+		Parallelism: 4,
+		Pool:        pool,
+	})
+
+	var failed int
+	for i, result := range results {
+		if err := result.Err; err != nil {
+			if !errors.Is(err, starlark.ErrSafety) {
+				t.Errorf("input %d: unexpected error: %v", i, err)
+			}
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Error("expected the shared budget to be exhausted")
+	}
+	if steps, ok := pool.Steps(); !ok || steps > maxSteps {
+		t.Errorf("pool exceeded its budget: %d > %d", steps, maxSteps)
+	}
+}
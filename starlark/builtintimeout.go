@@ -0,0 +1,133 @@
+package starlark
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetBuiltinTimeout limits the duration of each call by this thread to a
+// builtin which may perform IO, that is, which does not declare IOSafe, so
+// that a slow external dependency cannot hold up the thread indefinitely.
+// If d is zero or negative, such calls are not limited.
+//
+// During a limited call, the context returned by Context expires d after
+// the call began, so builtins which wait for external resources should
+// observe it. A call which is still running when its time expires fails
+// with a BuiltinTimeoutError once it returns, whatever its result; the
+// thread itself is not cancelled. Calls made by such a builtin back into
+// Starlark share its time limit.
+//
+// SetBuiltinTimeout must be called before execution begins.
+func (thread *Thread) SetBuiltinTimeout(d time.Duration) {
+	thread.builtinTimeout = d
+}
+
+// BuiltinTimeout returns the limit set by SetBuiltinTimeout.
+func (thread *Thread) BuiltinTimeout() time.Duration {
+	return thread.builtinTimeout
+}
+
+// A BuiltinTimeoutError reports that a call to a builtin ran for longer than
+// the limit set by SetBuiltinTimeout.
+type BuiltinTimeoutError struct {
+	Name    string
+	Timeout time.Duration
+}
+
+func (e *BuiltinTimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out after %v", e.Name, e.Timeout)
+}
+
+func (e *BuiltinTimeoutError) Is(err error) bool {
+	return err == context.DeadlineExceeded
+}
+
+// SetMaxCallDuration limits the wall-clock time of each call by this thread
+// to a builtin, whatever the builtin's declared safety, so that a single
+// slow builtin, such as one matching a regular expression against
+// adversarial input, cannot stall a scheduler which shares time between
+// threads by their budgets. If d is zero or negative, calls are not limited.
+//
+// As with SetBuiltinTimeout, the context returned by Context expires when a
+// limited call's time is up. Builtins which declare TimeSafe observe it, so
+// on a thread which requires TimeSafe, every builtin returns promptly once
+// its time is up. Unlike SetBuiltinTimeout, a call which overruns its time
+// cancels the thread with a CallDurationSafetyError. Where both limits
+// apply to a call, the shorter takes effect.
+//
+// SetMaxCallDuration must be called before execution begins.
+func (thread *Thread) SetMaxCallDuration(d time.Duration) {
+	thread.maxCallDuration = d
+}
+
+// MaxCallDuration returns the limit set by SetMaxCallDuration.
+func (thread *Thread) MaxCallDuration() time.Duration {
+	return thread.maxCallDuration
+}
+
+// A CallDurationSafetyError reports that a call to a builtin ran for longer
+// than the limit set by SetMaxCallDuration.
+type CallDurationSafetyError struct {
+	Name string
+	Max  time.Duration
+}
+
+func (e *CallDurationSafetyError) Error() string {
+	return fmt.Sprintf("%s: call duration exceeded (%v)", e.Name, e.Max)
+}
+
+func (e *CallDurationSafetyError) Is(err error) bool {
+	return err == ErrSafety || err == context.DeadlineExceeded
+}
+
+// beginBuiltinTimeout starts the time limit, if any, of a call to the
+// builtin c, which declares the given safety. If a limit applies, it
+// returns a function which ends the limit, to be deferred so that it runs
+// even if c panics, and a function to be called once the call has
+// returned, which reports whether the limit was exceeded.
+func (thread *Thread) beginBuiltinTimeout(c Callable, safety SafetyFlags) (end func(), exceeded func() error) {
+	timeout, cancels := thread.maxCallDuration, true
+	if thread.builtinTimeout > 0 && !safety.Contains(IOSafe) {
+		if timeout <= 0 || thread.builtinTimeout < timeout {
+			timeout, cancels = thread.builtinTimeout, false
+		}
+	}
+	if timeout <= 0 {
+		return nil, nil
+	}
+	deadline := time.Now().Add(timeout)
+
+	thread.contextLock.Lock()
+	prev := thread.callContext
+	parent := prev
+	if parent == nil {
+		parent = thread.threadContext()
+	}
+	thread.contextLock.Unlock()
+
+	// The thread's context takes contextLock, so the lock must not be
+	// held while deriving from it.
+	ctx, cancel := context.WithDeadline(parent, deadline)
+
+	thread.contextLock.Lock()
+	thread.callContext = ctx
+	thread.contextLock.Unlock()
+
+	end = func() {
+		thread.contextLock.Lock()
+		thread.callContext = prev
+		thread.contextLock.Unlock()
+		cancel()
+	}
+	exceeded = func() error {
+		if time.Now().Before(deadline) {
+			return nil
+		}
+		if cancels {
+			return thread.cancel(CancelTimeout, &CallDurationSafetyError{Name: c.Name(), Max: timeout})
+		}
+		return &BuiltinTimeoutError{Name: c.Name(), Timeout: timeout}
+	}
+	return end, exceeded
+}
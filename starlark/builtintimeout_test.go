@@ -0,0 +1,174 @@
+package starlark_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestBuiltinTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	wait := func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		select {
+		case <-thread.Context().Done():
+		case <-time.After(10 * timeout):
+		}
+		return starlark.None, nil
+	}
+
+	t.Run("limited", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetBuiltinTimeout(timeout)
+		fn := starlark.NewBuiltinWithSafety("wait", starlark.CPUSafe|starlark.MemSafe, wait)
+
+		start := time.Now()
+		_, err := starlark.Call(thread, fn, nil, nil)
+		if elapsed := time.Since(start); elapsed >= 10*timeout {
+			t.Errorf("builtin did not observe the deadline: took %v", elapsed)
+		}
+		var timeoutErr *starlark.BuiltinTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("expected BuiltinTimeoutError, got %v", err)
+		}
+		if timeoutErr.Name != "wait" || timeoutErr.Timeout != timeout {
+			t.Errorf("unexpected error: %+v", timeoutErr)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("error does not match context.DeadlineExceeded")
+		}
+
+		// The thread itself remains usable.
+		if err := thread.Context().Err(); err != nil {
+			t.Errorf("thread context expired: %v", err)
+		}
+		quick := starlark.NewBuiltin("quick", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			return starlark.None, nil
+		})
+		if _, err := starlark.Call(thread, quick, nil, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetBuiltinTimeout(timeout)
+		fn := starlark.NewBuiltinWithSafety("explode", starlark.CPUSafe|starlark.MemSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			panic("boom")
+		})
+
+		func() {
+			defer func() {
+				if r := recover(); r != "boom" {
+					t.Errorf("unexpected panic: %v", r)
+				}
+			}()
+			starlark.Call(thread, fn, nil, nil)
+		}()
+
+		// The limit of the panicking call no longer applies.
+		if _, ok := thread.Context().Deadline(); ok {
+			t.Error("deadline outlived the call")
+		}
+		time.Sleep(2 * timeout)
+		if err := thread.Context().Err(); err != nil {
+			t.Errorf("thread context expired: %v", err)
+		}
+	})
+
+	t.Run("io-safe", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetBuiltinTimeout(timeout)
+		fn := starlark.NewBuiltinWithSafety("wait", starlark.IOSafe, wait)
+
+		if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		fn := starlark.NewBuiltin("wait", func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if _, ok := thread.Context().Deadline(); ok {
+				t.Error("unexpected deadline")
+			}
+			return starlark.None, nil
+		})
+		if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestMaxCallDuration(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	wait := func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		select {
+		case <-thread.Context().Done():
+		case <-time.After(10 * timeout):
+		}
+		return starlark.None, nil
+	}
+
+	t.Run("limited", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxCallDuration(timeout)
+		fn := starlark.NewBuiltinWithSafety("wait", starlark.CPUSafe|starlark.MemSafe|starlark.TimeSafe|starlark.IOSafe, wait)
+
+		start := time.Now()
+		_, err := starlark.Call(thread, fn, nil, nil)
+		if elapsed := time.Since(start); elapsed >= 10*timeout {
+			t.Errorf("builtin did not observe the deadline: took %v", elapsed)
+		}
+		var durationErr *starlark.CallDurationSafetyError
+		if !errors.As(err, &durationErr) {
+			t.Fatalf("expected CallDurationSafetyError, got %v", err)
+		}
+		if durationErr.Name != "wait" || durationErr.Max != timeout {
+			t.Errorf("unexpected error: %+v", durationErr)
+		}
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Error("error does not match ErrSafety")
+		}
+
+		// Unlike a builtin timeout, the thread is cancelled.
+		var cancelErr *starlark.CancellationError
+		if !errors.As(err, &cancelErr) || cancelErr.Kind != starlark.CancelTimeout {
+			t.Errorf("expected timeout cancellation, got %v", err)
+		}
+		if err := thread.Context().Err(); err == nil {
+			t.Error("thread not cancelled")
+		}
+	})
+
+	t.Run("quick", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxCallDuration(10 * timeout)
+		fn := starlark.NewBuiltinWithSafety("quick", starlark.CPUSafe|starlark.MemSafe|starlark.TimeSafe|starlark.IOSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			return starlark.None, nil
+		})
+		if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("shorter-builtin-timeout", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxCallDuration(100 * timeout)
+		thread.SetBuiltinTimeout(timeout)
+		fn := starlark.NewBuiltinWithSafety("wait", starlark.CPUSafe|starlark.MemSafe, wait)
+
+		_, err := starlark.Call(thread, fn, nil, nil)
+		var timeoutErr *starlark.BuiltinTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("expected BuiltinTimeoutError, got %v", err)
+		}
+		if err := thread.Context().Err(); err != nil {
+			t.Errorf("thread cancelled: %v", err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package starlark
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync/atomic"
+)
+
+// A CancellationEvent describes a thread which was cancelled because it
+// exhausted one of its resources. See SetCancellationHook.
+type CancellationEvent struct {
+	// Thread is the thread which was cancelled. The hook must not execute
+	// Starlark code on it.
+	Thread *Thread
+
+	// Err records why the thread was cancelled. Its Kind is one of
+	// CancelSteps, CancelMemory, CancelTimeout or CancelCPUTime.
+	Err *CancellationError
+
+	// Resources holds the steps and allocations counted by the thread
+	// once its outermost call had returned.
+	Resources ResourceSnapshot
+
+	// Filename and ProgramHash identify the program whose outermost call
+	// was cancelled; ProgramHash is as returned by Program.Hash. Both are
+	// zero if the outermost call was not to a Starlark function.
+	Filename    string
+	ProgramHash [sha256.Size]byte
+}
+
+// cancellationHook holds the func(*CancellationEvent) set by
+// SetCancellationHook, if any.
+var cancellationHook atomic.Value
+
+// SetCancellationHook sets a function to be called whenever any thread is
+// cancelled because it exhausted its steps, memory, time or CPU time, or
+// those of its resource pool, so that an embedder may aggregate which
+// programs, and which limits, are most often responsible without wrapping
+// each call into Starlark. Explicit cancellations and those of a thread's
+// context are not reported. If hook is nil, no function is called.
+//
+// The hook is called once per thread, on the goroutine which made the
+// thread's outermost call into Starlark, once that call has failed. It may
+// therefore be called concurrently for different threads.
+//
+// It is safe to call SetCancellationHook concurrently with execution.
+func SetCancellationHook(hook func(event *CancellationEvent)) {
+	cancellationHook.Store(hook)
+}
+
+// reportCancellation passes the thread's cancellation, if it was for
+// resource reasons, to the hook set by SetCancellationHook. c is the
+// callable of the thread's outermost call, which has just failed.
+func (thread *Thread) reportCancellation(c Callable) {
+	hook, _ := cancellationHook.Load().(func(*CancellationEvent))
+	if hook == nil || thread.cancellationReported {
+		return
+	}
+
+	var cancelErr *CancellationError
+	if !errors.As(thread.cancelled(), &cancelErr) {
+		return
+	}
+	switch cancelErr.Kind {
+	case CancelSteps, CancelMemory, CancelTimeout, CancelCPUTime:
+	default:
+		return
+	}
+	thread.cancellationReported = true
+
+	event := &CancellationEvent{
+		Thread:    thread,
+		Err:       cancelErr,
+		Resources: thread.Snapshot(),
+	}
+	if fn, ok := c.(*Function); ok {
+		prog := &Program{compiled: fn.module.program}
+		event.Filename = prog.Filename()
+		event.ProgramHash = prog.Hash()
+	}
+	hook(event)
+}
@@ -0,0 +1,79 @@
+package starlark_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestCancellationHook(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[*starlark.Thread][]*starlark.CancellationEvent)
+	starlark.SetCancellationHook(func(event *starlark.CancellationEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[event.Thread] = append(events[event.Thread], event)
+	})
+	defer starlark.SetCancellationHook(nil)
+
+	const src = `
+def f():
+	for i in range(1000):
+		pass
+f()
+`
+	prog := func(t *testing.T) *starlark.Program {
+		_, prog, err := starlark.SourceProgram("hook.star", src, func(string) bool { return false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prog
+	}
+
+	t.Run("steps", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(100)
+		prog := prog(t)
+		if _, err := prog.Init(thread, nil); err == nil {
+			t.Fatal("expected cancellation")
+		}
+		// Later calls fail too, but the cancellation is only reported once.
+		if _, err := prog.Init(thread, nil); err == nil {
+			t.Fatal("expected cancellation")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if n := len(events[thread]); n != 1 {
+			t.Fatalf("expected one event, got %d", n)
+		}
+		event := events[thread][0]
+		if event.Err.Kind != starlark.CancelSteps {
+			t.Errorf("unexpected kind: %v", event.Err.Kind)
+		}
+		if steps, _ := event.Resources.Steps(); steps <= 100 {
+			t.Errorf("unexpected steps: %d", steps)
+		}
+		if event.Filename != "hook.star" {
+			t.Errorf("unexpected filename: %q", event.Filename)
+		}
+		if event.ProgramHash != prog.Hash() {
+			t.Errorf("unexpected program hash: %x", event.ProgramHash)
+		}
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Cancel("done")
+		if _, err := prog(t).Init(thread, nil); err == nil {
+			t.Fatal("expected cancellation")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if n := len(events[thread]); n != 0 {
+			t.Errorf("explicit cancellation reported %d times", n)
+		}
+	})
+}
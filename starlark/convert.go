@@ -0,0 +1,569 @@
+package starlark
+
+// This file defines conversions between Go and Starlark values.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"github.com/canonical/starlark/syntax"
+)
+
+// ToValue converts a Go value into a new Starlark value, declaring the steps
+// and allocations required to the thread, which may be nil.
+//
+// The following conversions are made:
+//   - nil and nil pointers become None;
+//   - Starlark values are returned as is;
+//   - booleans, integers, floating-point numbers and strings become bool, int,
+//     float and string respectively;
+//   - *big.Int becomes int;
+//   - []byte becomes bytes;
+//   - other slices and arrays become lists;
+//   - maps become dicts, with entries in the sorted order of their keys;
+//   - structs become dicts keyed by field name (see below);
+//   - pointers and interfaces are converted according to the value they
+//     reference.
+//
+// The key used for an exported struct field is given by its `starlark` tag if
+// present and otherwise by its name. Fields tagged with `starlark:"-"` and
+// unexported fields are skipped.
+func ToValue(thread *Thread, x interface{}) (Value, error) {
+	if x == nil {
+		return None, nil
+	}
+	if v, ok := x.(Value); ok {
+		return v, nil
+	}
+	if i, ok := x.(*big.Int); ok {
+		if i == nil {
+			return None, nil
+		}
+		return toValueChecked(thread, MakeBigInt(i))
+	}
+	return toValue(thread, reflect.ValueOf(x), 0)
+}
+
+// maxConversionDepth bounds the depth of values converted by ToValue
+// and FromValue, preventing unbounded recursion on cyclic Go values.
+const maxConversionDepth = 1000
+
+var errConversionDepth = errors.New("conversion depth exceeded")
+
+func toValueChecked(thread *Thread, v Value) (Value, error) {
+	if thread != nil {
+		if err := thread.AddSteps(SafeInt(1)); err != nil {
+			return nil, err
+		}
+		if err := thread.AddAllocs(EstimateSize(v)); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// sortMapKeys sorts the converted keys of a map, together with the
+// corresponding reflected keys, declaring a step for each comparison.
+// Keys of different types are ordered by type name, and keys of the
+// same type which cannot be compared are ordered by their string form.
+func sortMapKeys(thread *Thread, keys []Value, rkeys []reflect.Value) error {
+	comparisons := 0
+	sort.Sort(mapKeySlice{keys, rkeys, &comparisons})
+	if thread != nil {
+		return thread.AddSteps(SafeInt(comparisons))
+	}
+	return nil
+}
+
+type mapKeySlice struct {
+	keys        []Value
+	rkeys       []reflect.Value
+	comparisons *int
+}
+
+func (s mapKeySlice) Len() int { return len(s.keys) }
+func (s mapKeySlice) Less(i, j int) bool {
+	*s.comparisons++
+	x, y := s.keys[i], s.keys[j]
+	if xt, yt := x.Type(), y.Type(); xt != yt {
+		return xt < yt
+	}
+	if lt, err := Compare(syntax.LT, x, y); err == nil {
+		return lt
+	}
+	return x.String() < y.String()
+}
+func (s mapKeySlice) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.rkeys[i], s.rkeys[j] = s.rkeys[j], s.rkeys[i]
+}
+
+func toValue(thread *Thread, rv reflect.Value, depth int) (Value, error) {
+	if depth > maxConversionDepth {
+		return nil, errConversionDepth
+	}
+
+	if rv.IsValid() && rv.CanInterface() {
+		switch x := rv.Interface().(type) {
+		case Value:
+			if x != nil {
+				return x, nil
+			}
+		case *big.Int:
+			if x == nil {
+				return None, nil
+			}
+			return toValueChecked(thread, MakeBigInt(x))
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return None, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return None, nil
+		}
+		return toValue(thread, rv.Elem(), depth+1)
+	case reflect.Bool:
+		return Bool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return toValueChecked(thread, MakeInt64(rv.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return toValueChecked(thread, MakeUint64(rv.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return toValueChecked(thread, Float(rv.Float()))
+	case reflect.String:
+		return toValueChecked(thread, String(rv.String()))
+	case reflect.Slice:
+		if rv.IsNil() {
+			return None, nil
+		}
+		if rv.Type().ConvertibleTo(bytesType) && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return toValueChecked(thread, Bytes(rv.Convert(bytesType).Bytes()))
+		}
+		fallthrough
+	case reflect.Array:
+		n := rv.Len()
+		if thread != nil {
+			if err := thread.AddSteps(SafeInt(n)); err != nil {
+				return nil, err
+			}
+			size := SafeAdd(EstimateMakeSize([]Value{}, SafeInt(n)), EstimateSize(&List{}))
+			if err := thread.AddAllocs(size); err != nil {
+				return nil, err
+			}
+		}
+		elems := make([]Value, n)
+		for i := 0; i < n; i++ {
+			elem, err := toValue(thread, rv.Index(i), depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+			elems[i] = elem
+		}
+		return NewList(elems), nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return None, nil
+		}
+		// Go's map iteration order is random, so the entries are inserted
+		// in the order of their keys to make the result deterministic.
+		rkeys := rv.MapKeys()
+		keys := make([]Value, len(rkeys))
+		for i, rkey := range rkeys {
+			k, err := toValue(thread, rkey, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = k
+		}
+		if err := sortMapKeys(thread, keys, rkeys); err != nil {
+			return nil, err
+		}
+		dict, err := SafeNewDict(thread, len(keys))
+		if err != nil {
+			return nil, err
+		}
+		for i, k := range keys {
+			v, err := toValue(thread, rv.MapIndex(rkeys[i]), depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("in key %s: %w", k, err)
+			}
+			if err := dict.SafeSetKey(thread, k, v); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case reflect.Struct:
+		fields := structFields(rv.Type())
+		dict, err := SafeNewDict(thread, len(fields))
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			v, err := toValue(thread, rv.FieldByIndex(field.index), depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("in field %s: %w", field.name, err)
+			}
+			if err := dict.SafeSetKey(thread, String(field.name), v); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("cannot convert Go value of type %s to a Starlark value", rv.Type())
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields returns the fields of a struct type which are visible to
+// Starlark, sorted by name.
+func structFields(t reflect.Type) []structField {
+	fields := make([]structField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("starlark"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: f.Index})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
+
+// FromValue converts a Starlark value into the Go value pointed to by ptr,
+// validating that its shape matches that of the Go type.
+//
+// The conversions performed mirror those of ToValue. Additionally:
+//   - an int may be stored in a floating-point target;
+//   - any iterable may be stored in a slice or array;
+//   - any iterable mapping may be stored in a map;
+//   - a mapping with string keys may be stored in a struct, in which case it
+//     is an error for the mapping to contain a key which does not correspond
+//     to a field;
+//   - a target of type interface{} receives nil, bool, int64 or *big.Int,
+//     float64, string, []byte, []interface{} or map[string]interface{}
+//     according to the type of the value;
+//   - a target of type Value receives the value as is.
+//
+// FromValue returns an error describing the location of the first mismatch
+// between the value and the target type.
+func FromValue(v Value, ptr interface{}) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("FromValue: expected non-nil pointer, got %T", ptr)
+	}
+	return fromValue(v, rv.Elem(), 0)
+}
+
+var (
+	valueType     = reflect.TypeOf((*Value)(nil)).Elem()
+	bigIntPtrType = reflect.TypeOf((*big.Int)(nil))
+)
+
+func fromValue(v Value, rv reflect.Value, depth int) error {
+	if depth > maxConversionDepth {
+		return errConversionDepth
+	}
+
+	t := rv.Type()
+	if t == valueType {
+		rv.Set(reflect.ValueOf(&v).Elem())
+		return nil
+	}
+	if t == bigIntPtrType {
+		i, ok := v.(Int)
+		if !ok {
+			return fmt.Errorf("got %s, want int", v.Type())
+		}
+		rv.Set(reflect.ValueOf(i.BigInt()))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			break
+		}
+		x, err := toGo(v, depth)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			rv.Set(reflect.Zero(t))
+		} else {
+			rv.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Ptr:
+		if v == None {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		elem := reflect.New(t.Elem())
+		if err := fromValue(v, elem.Elem(), depth+1); err != nil {
+			return err
+		}
+		rv.Set(elem)
+		return nil
+	case reflect.Bool:
+		b, ok := v.(Bool)
+		if !ok {
+			return fmt.Errorf("got %s, want bool", v.Type())
+		}
+		rv.SetBool(bool(b))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := v.(Int)
+		if !ok {
+			return fmt.Errorf("got %s, want int", v.Type())
+		}
+		i64, ok := i.Int64()
+		if !ok || rv.OverflowInt(i64) {
+			return fmt.Errorf("int %s out of range for %s", i, t)
+		}
+		rv.SetInt(i64)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := v.(Int)
+		if !ok {
+			return fmt.Errorf("got %s, want int", v.Type())
+		}
+		u64, ok := i.Uint64()
+		if !ok || rv.OverflowUint(u64) {
+			return fmt.Errorf("int %s out of range for %s", i, t)
+		}
+		rv.SetUint(u64)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch v := v.(type) {
+		case Float:
+			f = float64(v)
+		case Int:
+			f = float64(v.Float())
+		default:
+			return fmt.Errorf("got %s, want float", v.Type())
+		}
+		if rv.OverflowFloat(f) && !math.IsInf(f, 0) {
+			return fmt.Errorf("float %v out of range for %s", f, t)
+		}
+		rv.SetFloat(f)
+		return nil
+	case reflect.String:
+		s, ok := v.(String)
+		if !ok {
+			return fmt.Errorf("got %s, want string", v.Type())
+		}
+		rv.SetString(string(s))
+		return nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if b, ok := v.(Bytes); ok {
+				rv.SetBytes([]byte(b))
+				return nil
+			}
+		}
+		if v == None {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		iterable, ok := v.(Iterable)
+		if !ok {
+			return fmt.Errorf("got %s, want iterable", v.Type())
+		}
+		n := Len(v)
+		if n < 0 {
+			n = 0
+		}
+		slice := reflect.MakeSlice(t, 0, n)
+		iter := iterable.Iterate()
+		defer iter.Done()
+		var x Value
+		for i := 0; iter.Next(&x); i++ {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := fromValue(x, elem, depth+1); err != nil {
+				return fmt.Errorf("at %s index %d: %w", v.Type(), i, err)
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		rv.Set(slice)
+		return nil
+	case reflect.Array:
+		indexable, ok := v.(Indexable)
+		if !ok {
+			return fmt.Errorf("got %s, want indexable", v.Type())
+		}
+		if indexable.Len() != t.Len() {
+			return fmt.Errorf("got %s of length %d, want length %d", v.Type(), indexable.Len(), t.Len())
+		}
+		for i := 0; i < t.Len(); i++ {
+			if err := fromValue(indexable.Index(i), rv.Index(i), depth+1); err != nil {
+				return fmt.Errorf("at %s index %d: %w", v.Type(), i, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v == None {
+			rv.Set(reflect.Zero(t))
+			return nil
+		}
+		mapping, ok := v.(IterableMapping)
+		if !ok {
+			return fmt.Errorf("got %s, want mapping", v.Type())
+		}
+		items := mapping.Items()
+		m := reflect.MakeMapWithSize(t, len(items))
+		for _, item := range items {
+			key := reflect.New(t.Key()).Elem()
+			if err := fromValue(item[0], key, depth+1); err != nil {
+				return fmt.Errorf("in %s key: %w", v.Type(), err)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := fromValue(item[1], elem, depth+1); err != nil {
+				return fmt.Errorf("in %s key %s: %w", v.Type(), item[0], err)
+			}
+			m.SetMapIndex(key, elem)
+		}
+		rv.Set(m)
+		return nil
+	case reflect.Struct:
+		return structFromValue(v, rv, depth)
+	}
+	return fmt.Errorf("cannot convert Starlark value to Go type %s", t)
+}
+
+func structFromValue(v Value, rv reflect.Value, depth int) error {
+	fields := structFields(rv.Type())
+	byName := make(map[string]structField, len(fields))
+	for _, field := range fields {
+		byName[field.name] = field
+	}
+
+	set := func(name string, x Value) error {
+		field, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unexpected field %s", name)
+		}
+		if err := fromValue(x, rv.FieldByIndex(field.index), depth+1); err != nil {
+			return fmt.Errorf("in field %s: %w", name, err)
+		}
+		return nil
+	}
+
+	switch v := v.(type) {
+	case IterableMapping:
+		for _, item := range v.Items() {
+			name, ok := item[0].(String)
+			if !ok {
+				return fmt.Errorf("%s has %s key, want string", v.Type(), item[0].Type())
+			}
+			if err := set(string(name), item[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case String, Bytes, Iterable:
+		// Only the methods of these types are accessible as attributes.
+	case HasAttrs:
+		for _, name := range v.AttrNames() {
+			x, err := v.Attr(name)
+			if err != nil {
+				return err
+			}
+			if x == nil {
+				continue
+			}
+			if _, ok := x.(Callable); ok {
+				continue // methods are not data
+			}
+			if err := set(name, x); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("got %s, want mapping or value with attributes", v.Type())
+}
+
+// toGo converts a Starlark value into its natural Go representation.
+func toGo(v Value, depth int) (interface{}, error) {
+	if depth > maxConversionDepth {
+		return nil, errConversionDepth
+	}
+
+	switch v := v.(type) {
+	case NoneType:
+		return nil, nil
+	case Bool:
+		return bool(v), nil
+	case Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case Float:
+		return float64(v), nil
+	case String:
+		return string(v), nil
+	case Bytes:
+		return []byte(v), nil
+	case IterableMapping:
+		items := v.Items()
+		m := make(map[string]interface{}, len(items))
+		for _, item := range items {
+			k, ok := item[0].(String)
+			if !ok {
+				return nil, fmt.Errorf("%s has %s key, want string", v.Type(), item[0].Type())
+			}
+			x, err := toGo(item[1], depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("in %s key %s: %w", v.Type(), item[0], err)
+			}
+			m[string(k)] = x
+		}
+		return m, nil
+	case Iterable:
+		var elems []interface{}
+		iter := v.Iterate()
+		defer iter.Done()
+		var x Value
+		for i := 0; iter.Next(&x); i++ {
+			elem, err := toGo(x, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("at %s index %d: %w", v.Type(), i, err)
+			}
+			elems = append(elems, elem)
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		if elems == nil {
+			elems = []interface{}{}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Go value", v.Type())
+}
@@ -0,0 +1,78 @@
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A StepCostModel weights the steps counted by a thread, so that they may
+// be made roughly proportional to the time taken on a given host. Models
+// are typically produced by the calibrate command.
+type StepCostModel struct {
+	// Opcodes maps the name of each bytecode instruction to the steps
+	// counted when it is executed, as for Thread.SetOpcodeCosts.
+	Opcodes map[string]int64 `json:"opcodes,omitempty"`
+
+	// Builtins maps the name of each builtin to the steps counted when it
+	// is called, in addition to any it counts itself. Methods are named
+	// by the type of their receiver and their own name, for example
+	// "list.append".
+	Builtins map[string]int64 `json:"builtins,omitempty"`
+}
+
+// ReadStepCostModel reads a model in the JSON form written by
+// StepCostModel.Write.
+func ReadStepCostModel(r io.Reader) (*StepCostModel, error) {
+	model := &StepCostModel{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(model); err != nil {
+		return nil, fmt.Errorf("reading step cost model: %w", err)
+	}
+	return model, nil
+}
+
+// Write writes the model to w as JSON.
+func (model *StepCostModel) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(model)
+}
+
+// SetStepCostModel sets the steps counted by the thread for executing each
+// instruction and calling each builtin named by model.
+//
+// SetStepCostModel returns an error, leaving the costs unchanged, if an
+// instruction is unknown or a cost is negative. It must be called before
+// execution begins.
+func (thread *Thread) SetStepCostModel(model *StepCostModel) error {
+	for name, cost := range model.Builtins {
+		if cost < 0 {
+			return fmt.Errorf("SetStepCostModel: negative cost for %s: %d", name, cost)
+		}
+	}
+	if err := thread.SetOpcodeCosts(model.Opcodes); err != nil {
+		return err
+	}
+
+	thread.builtinCosts = make(map[string]int64, len(model.Builtins))
+	for name, cost := range model.Builtins {
+		if cost != 0 {
+			thread.builtinCosts[name] = cost
+		}
+	}
+	return nil
+}
+
+// addBuiltinCost counts the steps set by SetStepCostModel for a call to b.
+func (thread *Thread) addBuiltinCost(b *Builtin) error {
+	name := b.Name()
+	if b.recv != nil {
+		name = b.recv.Type() + "." + name
+	}
+	if cost, ok := thread.builtinCosts[name]; ok {
+		return thread.AddSteps(SafeInt(cost))
+	}
+	return nil
+}
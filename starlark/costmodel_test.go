@@ -0,0 +1,75 @@
+package starlark_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestStepCostModelRoundTrip(t *testing.T) {
+	model := &starlark.StepCostModel{
+		Opcodes:  map[string]int64{"call": 3},
+		Builtins: map[string]int64{"len": 2, "list.append": 1},
+	}
+	var buf bytes.Buffer
+	if err := model.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := starlark.ReadStepCostModel(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, model) {
+		t.Errorf("model did not round-trip: got %+v, want %+v", read, model)
+	}
+
+	if _, err := starlark.ReadStepCostModel(strings.NewReader(`{"nonesuch": {}}`)); err == nil {
+		t.Error("expected error reading unknown field")
+	}
+}
+
+func TestSetStepCostModel(t *testing.T) {
+	const src = `
+def f():
+	l = []
+	for i in range(10):
+		l.append(len(l))
+`
+	steps := func(model *starlark.StepCostModel) int64 {
+		thread := &starlark.Thread{}
+		globals, err := starlark.ExecFile(thread, "model.star", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread = &starlark.Thread{}
+		if model != nil {
+			if err := thread.SetStepCostModel(model); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := starlark.Call(thread, globals["f"], nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		steps, _ := thread.Steps()
+		return steps
+	}
+
+	base := steps(nil)
+	weighted := steps(&starlark.StepCostModel{
+		Builtins: map[string]int64{"len": 2, "list.append": 5},
+	})
+	if expected := base + 10*2 + 10*5; weighted != expected {
+		t.Errorf("incorrect weighted steps: expected %d but got %d", expected, weighted)
+	}
+
+	thread := &starlark.Thread{}
+	if err := thread.SetStepCostModel(&starlark.StepCostModel{Builtins: map[string]int64{"len": -1}}); err == nil {
+		t.Error("expected error for negative builtin cost")
+	}
+	if err := thread.SetStepCostModel(&starlark.StepCostModel{Opcodes: map[string]int64{"nonesuch": 1}}); err == nil {
+		t.Error("expected error for unknown opcode")
+	}
+}
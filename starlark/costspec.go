@@ -0,0 +1,58 @@
+package starlark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A CostSpec declares how the resources used by a call to a builtin grow with
+// the size of its input. It documents the builtin's cost for script authors
+// and may be checked against measured behaviour by tests, see
+// startest.ST.AssertCost.
+//
+// A zero rate means that the corresponding resource is not declared to grow
+// with the input.
+type CostSpec struct {
+	// StepsPerElement is the number of steps taken per element of input.
+	StepsPerElement float64 `json:"steps_per_element,omitempty"`
+
+	// AllocsPerByte is the number of bytes allocated per byte of input.
+	AllocsPerByte float64 `json:"allocs_per_byte,omitempty"`
+}
+
+// String describes the spec, for example
+// "1 step per element, 2 bytes per input byte".
+func (spec CostSpec) String() string {
+	var rates []string
+	if spec.StepsPerElement != 0 {
+		rates = append(rates, formatRate(spec.StepsPerElement, "step")+" per element")
+	}
+	if spec.AllocsPerByte != 0 {
+		rates = append(rates, formatRate(spec.AllocsPerByte, "byte")+" per input byte")
+	}
+	if len(rates) == 0 {
+		return "constant"
+	}
+	return strings.Join(rates, ", ")
+}
+
+func formatRate(rate float64, unit string) string {
+	if rate != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%s %s", strconv.FormatFloat(rate, 'g', -1, 64), unit)
+}
+
+// DeclareCost attaches a cost specification to the builtin. Methods obtained
+// from the builtin by BindReceiver share its specification.
+func (b *Builtin) DeclareCost(spec CostSpec) { b.cost = &spec }
+
+// Cost returns the specification set by DeclareCost and reports whether one
+// was set.
+func (b *Builtin) Cost() (CostSpec, bool) {
+	if b.cost == nil {
+		return CostSpec{}, false
+	}
+	return *b.cost, true
+}
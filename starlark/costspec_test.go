@@ -0,0 +1,43 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestCostSpecString(t *testing.T) {
+	tests := []struct {
+		spec     starlark.CostSpec
+		expected string
+	}{{
+		spec:     starlark.CostSpec{},
+		expected: "constant",
+	}, {
+		spec:     starlark.CostSpec{StepsPerElement: 1},
+		expected: "1 step per element",
+	}, {
+		spec:     starlark.CostSpec{StepsPerElement: 2, AllocsPerByte: 1.5},
+		expected: "2 steps per element, 1.5 bytes per input byte",
+	}}
+	for _, test := range tests {
+		if s := test.spec.String(); s != test.expected {
+			t.Errorf("incorrect description: expected %q but got %q", test.expected, s)
+		}
+	}
+}
+
+func TestBuiltinCost(t *testing.T) {
+	b := starlark.NewBuiltin("b", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	if _, ok := b.Cost(); ok {
+		t.Error("unexpected cost on new builtin")
+	}
+
+	spec := starlark.CostSpec{StepsPerElement: 1}
+	b.DeclareCost(spec)
+	if cost, ok := b.BindReceiver(starlark.None).Cost(); !ok || cost != spec {
+		t.Errorf("bound method has incorrect cost: expected %v but got %v", spec, cost)
+	}
+}
@@ -0,0 +1,120 @@
+package starlark
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// cpuCheckInterval is the number of instructions executed between readings
+// of the CPU clock of a thread with a CPU time budget.
+const cpuCheckInterval = 1024
+
+// A cpuMeter measures the CPU time consumed by a thread. See SetMaxCPUTime.
+type cpuMeter struct {
+	max time.Duration
+
+	// used is the CPU time consumed up to the last reading, in
+	// nanoseconds. It is accessed atomically.
+	used int64
+
+	// clock is the value of the OS thread's CPU clock at the last reading.
+	clock time.Duration
+
+	// countdown is the number of instructions to execute before the
+	// clock is next read.
+	countdown int
+}
+
+// SetMaxCPUTime sets a limit on the CPU time which may be consumed by this
+// thread. Unlike the abstract steps counted by SetMaxSteps, CPU time is
+// measured by the operating system, so the limit means the same thing
+// whatever the speed of the hardware. If the limit is exceeded, the thread
+// is cancelled with a CPUTimeSafetyError. If max is zero or negative, the
+// thread will not be cancelled.
+//
+// Time spent in builtins called by the thread is included, but time spent
+// blocked, for example sleeping or waiting for IO, is not. While Starlark
+// code is being executed, the calling goroutine is locked to its OS thread
+// so that the OS thread's CPU clock may be used. On platforms without
+// per-thread CPU clocks, elapsed wall-clock time is counted instead, which
+// never underestimates CPU time.
+//
+// The clock is read periodically rather than at every instruction, so a
+// thread may overrun its limit briefly. SetMaxCPUTime must be called
+// before execution begins.
+func (thread *Thread) SetMaxCPUTime(max time.Duration) {
+	if max <= 0 {
+		thread.cpuMeter = nil
+		return
+	}
+	thread.cpuMeter = &cpuMeter{max: max}
+}
+
+// CPUTime returns the CPU time consumed by this thread, if its CPU time
+// is being measured (see SetMaxCPUTime). While the thread is executing,
+// the result may lag slightly behind.
+//
+// It is safe to call CPUTime from any goroutine, even if the thread is
+// actively executing.
+func (thread *Thread) CPUTime() time.Duration {
+	if thread.cpuMeter == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&thread.cpuMeter.used))
+}
+
+// startCPUMeter begins measuring the CPU time of a thread as it is entered
+// from the host. The returned function must be called when the thread
+// returns to the host.
+func (thread *Thread) startCPUMeter() (stop func()) {
+	runtime.LockOSThread()
+	meter := thread.cpuMeter
+	meter.clock = threadCPUClock()
+	meter.countdown = cpuCheckInterval
+	return func() {
+		meter.read()
+		runtime.UnlockOSThread()
+	}
+}
+
+// read records the CPU time consumed since the last reading.
+func (meter *cpuMeter) read() time.Duration {
+	clock := threadCPUClock()
+	delta := clock - meter.clock
+	meter.clock = clock
+	return time.Duration(atomic.AddInt64(&meter.used, int64(delta)))
+}
+
+// checkCPUTime cancels the thread if it has exceeded its CPU time budget.
+// It is called by the interpreter before each instruction, and reads the
+// clock once every cpuCheckInterval calls.
+func (thread *Thread) checkCPUTime() error {
+	meter := thread.cpuMeter
+	meter.countdown--
+	if meter.countdown > 0 {
+		return nil
+	}
+	meter.countdown = cpuCheckInterval
+
+	if used := meter.read(); used > meter.max {
+		return thread.cancel(CancelCPUTime, &CPUTimeSafetyError{Used: used, Max: meter.max})
+	}
+	return nil
+}
+
+// A CPUTimeSafetyError reports that a thread consumed more CPU time than
+// allowed by SetMaxCPUTime.
+type CPUTimeSafetyError struct {
+	Used time.Duration
+	Max  time.Duration
+}
+
+func (e *CPUTimeSafetyError) Error() string {
+	return fmt.Sprintf("CPU time exceeded (%v > %v)", e.Used, e.Max)
+}
+
+func (e *CPUTimeSafetyError) Is(err error) bool {
+	return err == ErrSafety
+}
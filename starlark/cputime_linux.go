@@ -0,0 +1,14 @@
+package starlark
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// threadCPUClock returns the CPU time consumed by the calling OS thread.
+func threadCPUClock() time.Duration {
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_THREAD_CPUTIME_ID, &ts) // can't fail
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package starlark
+
+import "time"
+
+// threadCPUClock returns the CPU time consumed by the calling OS thread.
+// This platform has no per-thread CPU clock, so the elapsed wall-clock time
+// is used instead.
+func threadCPUClock() time.Duration {
+	return time.Duration(nanotime())
+}
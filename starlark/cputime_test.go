@@ -0,0 +1,71 @@
+package starlark_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestCPUTimeCancellation(t *testing.T) {
+	const src = `
+def loop():
+	for i in range(1 << 30):
+		pass
+loop()
+`
+	thread := &starlark.Thread{}
+	thread.SetMaxCPUTime(10 * time.Millisecond)
+	_, err := starlark.ExecFile(thread, "loop.star", src, nil)
+	if err == nil {
+		t.Fatal("expected cancellation")
+	}
+	if !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var cpuErr *starlark.CPUTimeSafetyError
+	if !errors.As(err, &cpuErr) {
+		t.Fatalf("expected CPU time error, got %v", err)
+	}
+	if cpuErr.Max != 10*time.Millisecond {
+		t.Errorf("incorrect limit: expected %v but got %v", 10*time.Millisecond, cpuErr.Max)
+	}
+	if used := thread.CPUTime(); used <= cpuErr.Max {
+		t.Errorf("CPU time %v does not exceed limit %v", used, cpuErr.Max)
+	}
+}
+
+func TestCPUTimeExcludesBlocking(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("per-thread CPU clock not available")
+	}
+
+	sleep := starlark.NewBuiltin("sleep", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		time.Sleep(50 * time.Millisecond)
+		return starlark.None, nil
+	})
+	predeclared := starlark.StringDict{"sleep": sleep}
+
+	thread := &starlark.Thread{}
+	thread.SetMaxCPUTime(20 * time.Millisecond)
+	_, err := starlark.ExecFile(thread, "sleep.star", "sleep()", predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := thread.CPUTime(); used >= 20*time.Millisecond {
+		t.Errorf("blocking counted as CPU time: %v", used)
+	}
+}
+
+func TestCPUTimeUnlimited(t *testing.T) {
+	thread := &starlark.Thread{}
+	thread.SetMaxCPUTime(0)
+	if _, err := starlark.ExecFile(thread, "empty.star", "x = 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if used := thread.CPUTime(); used != 0 {
+		t.Errorf("unexpected CPU time for unmetered thread: %v", used)
+	}
+}
@@ -0,0 +1,99 @@
+package starlark
+
+import (
+	"fmt"
+	"sort"
+)
+
+// An Entrypoint is a Starlark function which serves as the interface between
+// a Go program and a Starlark module. Its arguments and result are exchanged
+// as Go values, which are converted using ToValue and FromValue.
+type Entrypoint struct {
+	name string
+	fn   Callable
+}
+
+// LookupEntrypoint returns the entrypoint with the given name in the globals
+// of a module. It is an error if no such global exists or if it is not
+// callable.
+func LookupEntrypoint(globals StringDict, name string) (*Entrypoint, error) {
+	v, ok := globals[name]
+	if !ok {
+		return nil, fmt.Errorf("entrypoint %s not defined", name)
+	}
+	fn, ok := v.(Callable)
+	if !ok {
+		return nil, fmt.Errorf("entrypoint %s: got %s, want callable", name, v.Type())
+	}
+	return &Entrypoint{name: name, fn: fn}, nil
+}
+
+// Name returns the name under which the entrypoint was found.
+func (ep *Entrypoint) Name() string { return ep.name }
+
+// Callable returns the underlying Starlark callable.
+func (ep *Entrypoint) Callable() Callable { return ep.fn }
+
+// Call converts args and kwargs to Starlark values, calls the entrypoint
+// on the given thread, and stores the result in the Go value pointed to by
+// out, which may be nil if the result is not required. Keyword arguments
+// are passed in the sorted order of their names.
+//
+// The conversion of arguments is accounted for by the thread, so is subject
+// to the same step and allocation limits as the call itself. The result is
+// validated against the type of out: if its shape does not match, Call
+// returns an error describing the mismatch.
+func (ep *Entrypoint) Call(thread *Thread, out interface{}, args []interface{}, kwargs map[string]interface{}) error {
+	var sargs Tuple
+	if len(args) > 0 {
+		if err := thread.AddAllocs(EstimateMakeSize(Tuple{}, SafeInt(len(args)))); err != nil {
+			return err
+		}
+		sargs = make(Tuple, len(args))
+		for i, arg := range args {
+			v, err := ToValue(thread, arg)
+			if err != nil {
+				return fmt.Errorf("%s: argument %d: %w", ep.name, i+1, err)
+			}
+			sargs[i] = v
+		}
+	}
+
+	var skwargs []Tuple
+	if len(kwargs) > 0 {
+		kwargsSize := SafeAdd(
+			EstimateMakeSize([]Tuple{}, SafeInt(len(kwargs))),
+			EstimateMakeSize([]string{}, SafeInt(len(kwargs))),
+		)
+		if err := thread.AddAllocs(kwargsSize); err != nil {
+			return err
+		}
+		// Pass keyword arguments in a deterministic order.
+		names := make([]string, 0, len(kwargs))
+		for name := range kwargs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		skwargs = make([]Tuple, 0, len(kwargs))
+		for _, name := range names {
+			arg := kwargs[name]
+			v, err := ToValue(thread, arg)
+			if err != nil {
+				return fmt.Errorf("%s: argument %s: %w", ep.name, name, err)
+			}
+			skwargs = append(skwargs, Tuple{String(name), v})
+		}
+	}
+
+	result, err := Call(thread, ep.fn, sargs, skwargs)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := FromValue(result, out); err != nil {
+		return fmt.Errorf("%s: invalid result: %w", ep.name, err)
+	}
+	return nil
+}
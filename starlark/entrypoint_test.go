@@ -0,0 +1,241 @@
+package starlark_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	type inner struct {
+		Tags []string
+	}
+	type record struct {
+		Name    string `starlark:"name"`
+		Count   int    `starlark:"count"`
+		Ratio   float64
+		Data    []byte
+		Inner   *inner
+		Skipped string `starlark:"-"`
+		private int
+	}
+
+	in := record{
+		Name:    "x",
+		Count:   3,
+		Ratio:   0.5,
+		Data:    []byte("abc"),
+		Inner:   &inner{Tags: []string{"a", "b"}},
+		Skipped: "ignored",
+	}
+	v, err := starlark.ToValue(&starlark.Thread{}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"Data": b"abc", "Inner": {"Tags": ["a", "b"]}, "Ratio": 0.5, "count": 3, "name": "x"}`
+	if got := v.String(); got != want {
+		t.Errorf("ToValue: got %s, want %s", got, want)
+	}
+
+	var out record
+	if err := starlark.FromValue(v, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("FromValue: got %#v, want %#v", out, in)
+	}
+}
+
+func TestToValueMapOrder(t *testing.T) {
+	m := map[string]int{}
+	for _, k := range strings.Fields("m z a q c x b y") {
+		m[k] = len(m)
+	}
+	const want = `{"a": 2, "b": 6, "c": 4, "m": 0, "q": 3, "x": 5, "y": 7, "z": 1}`
+	for i := 0; i < 10; i++ {
+		v, err := starlark.ToValue(&starlark.Thread{}, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.String(); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+
+	mixed := map[interface{}]bool{"b": true, 2: true, "a": true, 1: true}
+	v, err := starlark.ToValue(&starlark.Thread{}, mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.String(), `{1: True, 2: True, "a": True, "b": True}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestFromValueInterface(t *testing.T) {
+	v := starlark.NewDict(2)
+	v.SetKey(starlark.String("a"), starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.None}))
+	v.SetKey(starlark.String("b"), starlark.True)
+
+	var out interface{}
+	if err := starlark.FromValue(v, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"a": []interface{}{int64(1), nil},
+		"b": true,
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %#v, want %#v", out, want)
+	}
+}
+
+func TestFromValueMismatch(t *testing.T) {
+	type point struct{ X, Y int8 }
+	tests := []struct {
+		name  string
+		value starlark.Value
+		out   interface{}
+		err   string
+	}{{
+		name:  "wrong-type",
+		value: starlark.String("1"),
+		out:   new(int),
+		err:   "got string, want int",
+	}, {
+		name:  "overflow",
+		value: starlark.MakeInt(1000),
+		out:   new(int8),
+		err:   "int 1000 out of range for int8",
+	}, {
+		name:  "nested",
+		value: starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.True}),
+		out:   new([]int),
+		err:   "at list index 1: got bool, want int",
+	}, {
+		name: "unknown-field",
+		value: func() starlark.Value {
+			d := starlark.NewDict(1)
+			d.SetKey(starlark.String("Z"), starlark.MakeInt(1))
+			return d
+		}(),
+		out: new(point),
+		err: "unexpected field Z",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := starlark.FromValue(test.value, test.out)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if err.Error() != test.err {
+				t.Errorf("unexpected error: got %q, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEntrypoint(t *testing.T) {
+	const src = `
+def summarise(values, scale = 1):
+    return {"total": sum(values) * scale, "count": len(values)}
+
+def broken():
+    return "not a summary"
+
+def sum(values):
+    total = 0
+    for v in values:
+        total += v
+    return total
+
+not_callable = 1
+`
+	thread := &starlark.Thread{}
+	globals, err := starlark.ExecFile(thread, "entrypoint.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type summary struct {
+		Total int `starlark:"total"`
+		Count int `starlark:"count"`
+	}
+
+	t.Run("call", func(t *testing.T) {
+		ep, err := starlark.LookupEntrypoint(globals, "summarise")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out summary
+		if err := ep.Call(thread, &out, []interface{}{[]int{1, 2, 3}}, map[string]interface{}{"scale": 2}); err != nil {
+			t.Fatal(err)
+		}
+		if want := (summary{Total: 12, Count: 3}); out != want {
+			t.Errorf("got %+v, want %+v", out, want)
+		}
+	})
+
+	t.Run("kwargs-order", func(t *testing.T) {
+		globals, err := starlark.ExecFile(thread, "kwargs.star", "def names(**kwargs): return list(kwargs)", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep, err := starlark.LookupEntrypoint(globals, "names")
+		if err != nil {
+			t.Fatal(err)
+		}
+		kwargs := map[string]interface{}{"m": 1, "z": 2, "a": 3, "q": 4, "c": 5}
+		for i := 0; i < 10; i++ {
+			var out []string
+			if err := ep.Call(thread, &out, nil, kwargs); err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a", "c", "m", "q", "z"}; !reflect.DeepEqual(out, want) {
+				t.Fatalf("got %v, want %v", out, want)
+			}
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := starlark.LookupEntrypoint(globals, "missing"); err == nil {
+			t.Error("expected error")
+		}
+		if _, err := starlark.LookupEntrypoint(globals, "not_callable"); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("invalid-result", func(t *testing.T) {
+		ep, err := starlark.LookupEntrypoint(globals, "broken")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out summary
+		err = ep.Call(thread, &out, nil, nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "invalid result") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		ep, err := starlark.LookupEntrypoint(globals, "summarise")
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(10)
+		values := make([]int, 100)
+		err = ep.Call(thread, nil, []interface{}{values}, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
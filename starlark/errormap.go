@@ -0,0 +1,92 @@
+package starlark
+
+import (
+	"errors"
+	"reflect"
+)
+
+// An ErrorMap rewrites the errors returned by host-defined callables, such
+// as builtins, before they are reported to Starlark code. It allows an
+// embedder to stop internal details such as file paths, addresses or
+// credentials from leaking to untrusted script authors.
+//
+// A rewritten error reports only its new message to Starlark code, but
+// still wraps the original error, so the host may recover it with
+// errors.Is or errors.As, for example to log it.
+//
+// Errors which cancel the thread, which violate its safety requirements
+// or which were already reported by Starlark code are never rewritten.
+type ErrorMap struct {
+	rules []errorRule
+
+	// Redact, if non-nil, rewrites the message of each error which matches
+	// no rule. fn is the callable which returned the error.
+	Redact func(fn Callable, msg string) string
+}
+
+type errorRule struct {
+	match func(err error) bool
+	msg   string
+}
+
+// MapIs causes errors which match target, as by errors.Is, to be reported
+// with the message msg.
+func (m *ErrorMap) MapIs(target error, msg string) {
+	m.rules = append(m.rules, errorRule{
+		match: func(err error) bool { return errors.Is(err, target) },
+		msg:   msg,
+	})
+}
+
+// MapType causes errors whose chain contains an error of the same dynamic
+// type as example to be reported with the message msg.
+func (m *ErrorMap) MapType(example error, msg string) {
+	if example == nil {
+		panic("ErrorMap.MapType: nil example")
+	}
+	typ := reflect.TypeOf(example)
+	m.rules = append(m.rules, errorRule{
+		match: func(err error) bool {
+			for ; err != nil; err = errors.Unwrap(err) {
+				if reflect.TypeOf(err) == typ {
+					return true
+				}
+			}
+			return false
+		},
+		msg: msg,
+	})
+}
+
+// rewrite returns the error reported in place of err, returned by fn.
+// Rules are tried in the order in which they were added.
+func (m *ErrorMap) rewrite(fn Callable, err error) error {
+	if _, ok := err.(*EvalError); ok {
+		return err
+	}
+	var cancelErr *CancellationError
+	if errors.Is(err, ErrSafety) || errors.As(err, &cancelErr) {
+		return err
+	}
+	for _, rule := range m.rules {
+		if rule.match(err) {
+			return wrappedError{msg: rule.msg, cause: err}
+		}
+	}
+	if m.Redact != nil {
+		msg := err.Error()
+		if redacted := m.Redact(fn, msg); redacted != msg {
+			return wrappedError{msg: redacted, cause: err}
+		}
+	}
+	return err
+}
+
+// SetErrorMap causes the errors returned by host-defined callables called
+// by the thread to be rewritten by m. If m is nil, errors are reported
+// unchanged.
+//
+// The ErrorMap must not be modified while the thread is executing.
+func (thread *Thread) SetErrorMap(m *ErrorMap) {
+	thread.errorMap = m
+}
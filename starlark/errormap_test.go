@@ -0,0 +1,111 @@
+package starlark_test
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestErrorMap(t *testing.T) {
+	errSecret := errors.New("connect 10.0.0.1:5432: connection refused")
+	pathErr := &fs.PathError{Op: "open", Path: "/srv/secrets/key", Err: fs.ErrNotExist}
+	fail := func(err error) *starlark.Builtin {
+		return starlark.NewBuiltin("fail", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return nil, err
+		})
+	}
+
+	errorMap := &starlark.ErrorMap{
+		Redact: func(fn starlark.Callable, msg string) string {
+			return strings.ReplaceAll(msg, "/srv", "<redacted>")
+		},
+	}
+	errorMap.MapIs(errSecret, "database unavailable")
+	errorMap.MapType(&fs.PathError{}, "file not accessible")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{{
+		name: "is",
+		err:  errSecret,
+		want: "database unavailable",
+	}, {
+		name: "is-wrapped",
+		err:  wrapError{errSecret},
+		want: "database unavailable",
+	}, {
+		name: "type",
+		err:  pathErr,
+		want: "file not accessible",
+	}, {
+		name: "redact",
+		err:  errors.New("cannot read /srv/data"),
+		want: "cannot read <redacted>/data",
+	}, {
+		name: "unmatched",
+		err:  errors.New("bad argument"),
+		want: "bad argument",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{}
+			thread.SetErrorMap(errorMap)
+			_, err := starlark.Call(thread, fail(test.err), nil, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			evalErr, ok := err.(*starlark.EvalError)
+			if !ok {
+				t.Fatalf("expected EvalError, got %T", err)
+			}
+			if evalErr.Msg != test.want {
+				t.Errorf("unexpected message: got %q, want %q", evalErr.Msg, test.want)
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("original error not retained: %v", err)
+			}
+		})
+	}
+
+	t.Run("safety", func(t *testing.T) {
+		errorMap := &starlark.ErrorMap{
+			Redact: func(fn starlark.Callable, msg string) string { return "redacted" },
+		}
+		thread := &starlark.Thread{}
+		thread.SetErrorMap(errorMap)
+		thread.SetMaxSteps(1)
+		_, err := starlark.ExecFile(thread, "safety.star", "[x for x in range(100)]", nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !errors.Is(err, starlark.ErrSafety) || strings.Contains(err.Error(), "redacted") {
+			t.Errorf("safety error was rewritten: %v", err)
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		errorMap := &starlark.ErrorMap{
+			Redact: func(fn starlark.Callable, msg string) string { return "[" + msg + "]" },
+		}
+		thread := &starlark.Thread{}
+		thread.SetErrorMap(errorMap)
+		predeclared := starlark.StringDict{"fail": fail(errors.New("oops"))}
+		_, err := starlark.ExecFile(thread, "nested.star", "def f(): fail()\nf()", predeclared)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if msg := err.(*starlark.EvalError).Msg; msg != "[oops]" {
+			t.Errorf("unexpected message: got %q, want %q", msg, "[oops]")
+		}
+	})
+}
+
+type wrapError struct{ err error }
+
+func (e wrapError) Error() string { return "wrapped: " + e.err.Error() }
+func (e wrapError) Unwrap() error { return e.err }
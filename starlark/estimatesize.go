@@ -43,6 +43,17 @@ var SliceTypeOverhead = EstimateSize([]struct{}{})
 // size of values not controlled by Starlark, objects should implement the
 // optional SizeAware interface to override the default exploration.
 func EstimateSize(obj interface{}) SafeInteger {
+	return EstimateSizeDepth(obj, -1)
+}
+
+// EstimateSizeDepth is like EstimateSize, but follows at most maxDepth
+// references (pointers, interfaces, slices, maps and channels) away from
+// the value pointed to by obj; memory further away is not counted. This
+// bounds the cost of estimating the size of a large host value whose
+// distant parts are accounted for elsewhere. If maxDepth is negative, all
+// references are followed. As with EstimateSize, values reached more than
+// once, including through cycles, are counted once.
+func EstimateSizeDepth(obj interface{}, maxDepth int) SafeInteger {
 	if obj == nil {
 		return SafeInt(0)
 	}
@@ -52,12 +63,49 @@ func EstimateSize(obj interface{}) SafeInteger {
 	}
 
 	v := reflect.ValueOf(obj)
+	if size, ok := estimateRegisteredSize(v); ok {
+		return size
+	}
 
+	walk := newSizeWalk(maxDepth)
 	if v.Kind() == reflect.Ptr {
-		return estimateSizeIndirect(v, make(map[uintptr]struct{}))
+		// The pointer itself is not counted as a reference.
+		walk.depth--
+		return estimateSizeIndirect(v, walk)
+	}
+
+	return estimateSizeAll(v, walk)
+}
+
+// A sizeWalk records the state of an exploration of an object tree.
+type sizeWalk struct {
+	// seen holds the addresses of the memory already counted.
+	seen map[uintptr]struct{}
+
+	// depth is the number of references followed to reach the current
+	// value, which may not exceed maxDepth unless it is negative.
+	depth, maxDepth int
+}
+
+func newSizeWalk(maxDepth int) *sizeWalk {
+	return &sizeWalk{
+		seen:     make(map[uintptr]struct{}),
+		maxDepth: maxDepth,
+	}
+}
+
+// follow reports whether another reference may be followed, and if so,
+// records that it is being followed until the matching call to unfollow.
+func (walk *sizeWalk) follow() bool {
+	if walk.maxDepth >= 0 && walk.depth >= walk.maxDepth {
+		return false
 	}
+	walk.depth++
+	return true
+}
 
-	return estimateSizeAll(v, make(map[uintptr]struct{}))
+func (walk *sizeWalk) unfollow() {
+	walk.depth--
 }
 
 // EstimateMakeSize estimates the cost of calling make to build a slice, map or
@@ -90,7 +138,7 @@ func estimateMakeSliceSize(template reflect.Value, n int64) SafeInteger {
 
 	size := roundAllocSize(SafeMul(n, template.Type().Elem().Size()))
 	if len > 0 {
-		elemSize := estimateSizeIndirect(template.Index(0), make(map[uintptr]struct{}))
+		elemSize := estimateSizeIndirect(template.Index(0), newSizeWalk(-1))
 		size = SafeAdd(size, SafeMul(n, elemSize))
 	}
 	return size
@@ -107,9 +155,9 @@ func estimateMakeMapSize(template reflect.Value, n int64) SafeInteger {
 		iter := template.MapRange()
 		iter.Next()
 
-		seen := map[uintptr]struct{}{}
-		keysSize := SafeMul(n, estimateSizeIndirect(iter.Key(), seen))
-		valuesSize := SafeMul(n, estimateSizeIndirect(iter.Value(), seen))
+		walk := newSizeWalk(-1)
+		keysSize := SafeMul(n, estimateSizeIndirect(iter.Key(), walk))
+		valuesSize := SafeMul(n, estimateSizeIndirect(iter.Value(), walk))
 		size = SafeAdd(size, SafeAdd(keysSize, valuesSize))
 	}
 	return size
@@ -122,31 +170,36 @@ func estimateMakeChanSize(template reflect.Value, n int64) SafeInteger {
 	return estimateChanDirectWithCap(template.Type(), n)
 }
 
-func estimateSizeAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateSizeAll(v reflect.Value, walk *sizeWalk) SafeInteger {
 	switch v.Kind() {
 	case reflect.String:
-		return estimateStringAll(v, seen)
+		return estimateStringAll(v, walk)
 	case reflect.Chan:
-		return estimateChanAll(v, seen)
+		return estimateChanAll(v, walk)
 	case reflect.Map:
-		return estimateMapAll(v, seen)
+		return estimateMapAll(v, walk)
 	default:
-		return SafeAdd(estimateSizeDirect(v), estimateSizeIndirect(v, seen))
+		return SafeAdd(estimateSizeDirect(v), estimateSizeIndirect(v, walk))
 	}
 }
 
+// interfaceData returns the data word of the addressable interface v.
+func interfaceData(v reflect.Value) uintptr {
+	return (*[2]uintptr)(unsafe.Pointer(v.UnsafeAddr()))[1]
+}
+
 func estimateSizeDirect(v reflect.Value) SafeInteger {
 	return roundAllocSize(SafeInt(v.Type().Size()))
 }
 
-func estimateSizeIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateSizeIndirect(v reflect.Value, walk *sizeWalk) SafeInteger {
 	// This adds the address of the value or the field to the `seen`
 	// list. It is important to still consider the memory **pointed
 	// by** this memory, so that we don't miss anything (e.g. pointers
 	// to the members of an array or members of a struct)
 	if v.CanAddr() {
 		ptr := v.Addr().Pointer()
-		seen[ptr] = struct{}{}
+		walk.seen[ptr] = struct{}{}
 	}
 
 	if v.CanInterface() {
@@ -155,6 +208,14 @@ func estimateSizeIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeIntege
 		}
 	}
 
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan:
+		if !walk.follow() {
+			return SafeInt(0)
+		}
+		defer walk.unfollow()
+	}
+
 	switch v.Kind() {
 	// The following kinds are pointer-like, so their memory lives outside
 	// of this already-counted structure. We must therefore estimate the
@@ -166,38 +227,58 @@ func estimateSizeIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeIntege
 
 		elem := v.Elem()
 		if elem.Kind() == reflect.Ptr {
-			return estimateSizeIndirect(elem, seen)
+			return estimateSizeIndirect(elem, walk)
 		}
 
-		return estimateSizeAll(elem, seen)
+		// Other values held by an interface are boxed.
+		if !walk.follow() {
+			return SafeInt(0)
+		}
+		defer walk.unfollow()
+		if _, ok := registeredSizer(elem.Type()); ok && v.CanAddr() {
+			// Interfaces which share a box share the value it holds.
+			box := interfaceData(v)
+			if _, ok := walk.seen[box]; ok {
+				return SafeInt(0)
+			}
+			walk.seen[box] = struct{}{}
+		}
+		if size, ok := estimateRegisteredSize(elem); ok {
+			return size
+		}
+		return estimateSizeAll(elem, walk)
 	case reflect.Ptr:
 		if !v.IsNil() {
-			if _, ok := seen[v.Pointer()]; !ok {
-				return estimateSizeAll(v.Elem(), seen)
+			if _, ok := walk.seen[v.Pointer()]; !ok {
+				if size, ok := estimateRegisteredSize(v); ok {
+					walk.seen[v.Pointer()] = struct{}{}
+					return size
+				}
+				return estimateSizeAll(v.Elem(), walk)
 			}
 		}
 		return SafeInt(0)
 	case reflect.Map:
-		return estimateMapAll(v, seen)
+		return estimateMapAll(v, walk)
 	case reflect.Slice:
-		return estimateSliceAll(v, seen)
+		return estimateSliceAll(v, walk)
 	case reflect.Chan:
-		return estimateChanAll(v, seen)
+		return estimateChanAll(v, walk)
 
 	// The following kinds are embedded, so backing storage
 	// has already been accounted for.
 	case reflect.Struct:
-		return estimateStructIndirect(v, seen)
+		return estimateStructIndirect(v, walk)
 	case reflect.Array:
-		return estimateArrayIndirect(v, seen)
+		return estimateArrayIndirect(v, walk)
 	case reflect.String:
-		return estimateStringIndirect(v, seen)
+		return estimateStringIndirect(v, walk)
 	default:
 		return SafeInt(0)
 	}
 }
 
-func estimateStringAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateStringAll(v reflect.Value, walk *sizeWalk) SafeInteger {
 	// There are misuses of strings.Builder that may lead
 	// to some assumptions in this functions being false, for example:
 	//
@@ -208,18 +289,18 @@ func estimateStringAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
 	// it is not possible to get the capacity of the buffer
 	// holding the string.
 
-	return SafeAdd(estimateSizeDirect(v), estimateStringIndirect(v, seen))
+	return SafeAdd(estimateSizeDirect(v), estimateStringIndirect(v, walk))
 }
 
-func estimateStringIndirect(v reflect.Value, _ map[uintptr]struct{}) SafeInteger {
+func estimateStringIndirect(v reflect.Value, _ *sizeWalk) SafeInteger {
 	return roundAllocSize(SafeInt(v.Len()))
 }
 
-func estimateChanAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateChanAll(v reflect.Value, walk *sizeWalk) SafeInteger {
 	if !v.IsNil() {
 		ptr := v.Pointer()
-		if _, ok := seen[ptr]; !ok {
-			seen[ptr] = struct{}{}
+		if _, ok := walk.seen[ptr]; !ok {
+			walk.seen[ptr] = struct{}{}
 			// There is no chan indirect call as the contents of a
 			// channel cannot be safely read without flushing them.
 			return estimateChanDirect(v)
@@ -234,7 +315,7 @@ func estimateChanDirect(v reflect.Value) SafeInteger {
 }
 
 func estimateChanDirectWithCap(t reflect.Type, cap int64) SafeInteger {
-	const chanHeaderSize = 10 * unsafe.Sizeof(int(0))
+	const chanHeaderSize = 13 * unsafe.Sizeof(int(0))
 
 	// This is a very rough approximation of the size of
 	// the chan header.
@@ -248,18 +329,18 @@ func estimateChanDirectWithCap(t reflect.Type, cap int64) SafeInteger {
 	return SafeAdd(headerSize, roundAllocSize(SafeMul(cap, elemSize)))
 }
 
-func estimateMapAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateMapAll(v reflect.Value, walk *sizeWalk) SafeInteger {
 	if v.IsNil() {
 		return SafeInt(0)
 	}
 
 	ptr := v.Pointer()
-	if _, ok := seen[ptr]; ok {
+	if _, ok := walk.seen[ptr]; ok {
 		return SafeInt(0)
 	}
 
-	seen[ptr] = struct{}{}
-	return SafeAdd(estimateMapDirect(v), estimateMapIndirect(v, seen))
+	walk.seen[ptr] = struct{}{}
+	return SafeAdd(estimateMapDirect(v), estimateMapIndirect(v, walk))
 }
 
 func estimateMapDirect(v reflect.Value) SafeInteger {
@@ -321,20 +402,20 @@ func getMapKVPairSize(k, v int64) SafeInteger {
 	}
 }
 
-func estimateMapIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateMapIndirect(v reflect.Value, walk *sizeWalk) SafeInteger {
 	result := SafeInt(0)
 
 	iter := v.MapRange()
 	for iter.Next() {
-		keySize := estimateSizeIndirect(iter.Key(), seen)
-		valueSize := estimateSizeIndirect(iter.Value(), seen)
+		keySize := estimateSizeIndirect(iter.Key(), walk)
+		valueSize := estimateSizeIndirect(iter.Value(), walk)
 		result = SafeAdd(result, SafeAdd(keySize, valueSize))
 	}
 
 	return result
 }
 
-func estimateSliceAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateSliceAll(v reflect.Value, walk *sizeWalk) SafeInteger {
 	if v.IsNil() {
 		return SafeInt(0)
 	}
@@ -349,38 +430,38 @@ func estimateSliceAll(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
 	// as "seen" while visiting b[0] will make the function miss all the
 	// memory pointed by b. It is better in this case to just be pessimistic
 	// and estimate more memory than it actually is allocated.
-	return SafeAdd(estimateSliceDirect(v), estimateSliceIndirect(v, seen))
+	return SafeAdd(estimateSliceDirect(v), estimateSliceIndirect(v, walk))
 }
 
 func estimateSliceDirect(v reflect.Value) SafeInteger {
 	return roundAllocSize(SafeMul(v.Type().Elem().Size(), v.Cap()))
 }
 
-func estimateSliceIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateSliceIndirect(v reflect.Value, walk *sizeWalk) SafeInteger {
 	result := SafeInt(0)
 
 	for i := 0; i < v.Len(); i++ {
-		result = SafeAdd(result, estimateSizeIndirect(v.Index(i), seen))
+		result = SafeAdd(result, estimateSizeIndirect(v.Index(i), walk))
 	}
 
 	return result
 }
 
-func estimateArrayIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateArrayIndirect(v reflect.Value, walk *sizeWalk) SafeInteger {
 	result := SafeInt(0)
 
 	for i := 0; i < v.Len(); i++ {
-		result = SafeAdd(result, estimateSizeIndirect(v.Index(i), seen))
+		result = SafeAdd(result, estimateSizeIndirect(v.Index(i), walk))
 	}
 
 	return result
 }
 
-func estimateStructIndirect(v reflect.Value, seen map[uintptr]struct{}) SafeInteger {
+func estimateStructIndirect(v reflect.Value, walk *sizeWalk) SafeInteger {
 	result := SafeInt(0)
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
-		result = SafeAdd(result, estimateSizeIndirect(field, seen))
+		result = SafeAdd(result, estimateSizeIndirect(field, walk))
 	}
 	return result
 }
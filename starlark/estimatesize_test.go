@@ -671,3 +671,57 @@ func TestSizeAware(t *testing.T) {
 		})
 	}
 }
+
+type sizeTestNode struct {
+	next *sizeTestNode
+	data [48]byte
+}
+
+func TestEstimateSizeDepth(t *testing.T) {
+	const n = 5
+	nodes := make([]*sizeTestNode, n)
+	for i := range nodes {
+		nodes[i] = &sizeTestNode{}
+		if i > 0 {
+			nodes[i-1].next = nodes[i]
+		}
+	}
+	nodeSize := starlark.EstimateSize(sizeTestNode{})
+
+	for depth := 0; depth < n; depth++ {
+		want := starlark.SafeMul(nodeSize, depth+1)
+		if got := starlark.EstimateSizeDepth(nodes[0], depth); got != want {
+			t.Errorf("incorrect size at depth %d: expected %v but got %v", depth, want, got)
+		}
+	}
+
+	want := starlark.SafeMul(nodeSize, n)
+	if got := starlark.EstimateSizeDepth(nodes[0], -1); got != want {
+		t.Errorf("incorrect size at unlimited depth: expected %v but got %v", want, got)
+	}
+	if got := starlark.EstimateSize(nodes[0]); got != want {
+		t.Errorf("incorrect size from EstimateSize: expected %v but got %v", want, got)
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		nodes[n-1].next = nodes[0]
+		defer func() { nodes[n-1].next = nil }()
+		if got := starlark.EstimateSize(nodes[0]); got != want {
+			t.Errorf("incorrect size of cycle: expected %v but got %v", want, got)
+		}
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		// The backing array of the slice is one reference away and an
+		// interface holding a pointer is a single further reference.
+		values := []interface{}{nodes[0]}
+		sliceSize := starlark.EstimateSize([]interface{}{nil})
+		if size := starlark.EstimateSizeDepth(values, 1); size != sliceSize {
+			t.Errorf("incorrect size through interface at depth 1: expected %v but got %v", sliceSize, size)
+		}
+		want := starlark.SafeAdd(sliceSize, nodeSize)
+		if size := starlark.EstimateSizeDepth(values, 2); size != want {
+			t.Errorf("incorrect size through interface at depth 2: expected %v but got %v", want, size)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package starlark
+
+import (
+	"math"
+
+	"github.com/canonical/starlark/internal/compile"
+	"github.com/canonical/starlark/syntax"
+)
+
+// A StepEstimate describes the number of steps a compiled function may take,
+// counting only the steps charged by the interpreter for executing the
+// function's own bytecode at the default cost of each instruction. Steps
+// taken by callees, including builtins, are not included.
+type StepEstimate struct {
+	Name string          // name of the function, or "<toplevel>"
+	Pos  syntax.Position // position of the def or lambda token
+
+	// Min and Max bound the number of steps taken on any path through the
+	// function which returns normally. If the function contains a loop, Max
+	// is not meaningful and Unbounded is set.
+	Min, Max  int64
+	Unbounded bool
+
+	// Expected is the number of steps taken if each branch of every
+	// conditional is equally likely and each loop body executes once.
+	Expected float64
+}
+
+// EstimateSteps statically estimates the steps taken by the program's
+// module initialization code and by each function defined within it. The
+// estimate for the top level comes first, followed by the functions in the
+// order in which they appear in the source.
+func EstimateSteps(prog *Program) []StepEstimate {
+	estimates := make([]StepEstimate, 0, 1+len(prog.compiled.Functions))
+	estimates = append(estimates, estimateFuncodeSteps(prog.compiled.Toplevel))
+	for _, fn := range prog.compiled.Functions {
+		estimates = append(estimates, estimateFuncodeSteps(fn))
+	}
+	return estimates
+}
+
+// stepNode is a single instruction in a function's control-flow graph.
+type stepNode struct {
+	cost  int64
+	succs []int
+}
+
+func estimateFuncodeSteps(fn *compile.Funcode) StepEstimate {
+	nodes := stepGraph(fn)
+	min := minPathSteps(nodes)
+	max, bounded := maxPathSteps(nodes)
+	expected := expectedPathSteps(nodes)
+
+	estimate := StepEstimate{
+		Name:     fn.Name,
+		Pos:      fn.Pos,
+		Min:      min,
+		Expected: expected,
+	}
+	if bounded {
+		estimate.Max = max
+	} else {
+		estimate.Max = math.MaxInt64
+		estimate.Unbounded = true
+	}
+	return estimate
+}
+
+// stepGraph returns the control-flow graph of fn, with one node per
+// instruction. Node 0 is the entry.
+func stepGraph(fn *compile.Funcode) []stepNode {
+	var pcs []uint32
+	var ops []compile.Opcode
+	var args []uint32
+	forEachInsn(fn, func(pc uint32, op compile.Opcode, arg uint32) error {
+		pcs = append(pcs, pc)
+		ops = append(ops, op)
+		args = append(args, arg)
+		return nil
+	})
+
+	index := make(map[uint32]int, len(pcs))
+	for i, pc := range pcs {
+		index[pc] = i
+	}
+
+	nodes := make([]stepNode, len(pcs))
+	for i, op := range ops {
+		node := &nodes[i]
+		node.cost = defaultOpcodeCosts[op]
+		switch op {
+		case compile.RETURN:
+			// No successors.
+		case compile.JMP:
+			node.succs = []int{index[args[i]]}
+		case compile.CJMP, compile.ITERJMP:
+			// The fall-through successor is listed first, so that for
+			// ITERJMP it denotes the loop body.
+			node.succs = []int{i + 1, index[args[i]]}
+		default:
+			if i+1 < len(nodes) {
+				node.succs = []int{i + 1}
+			}
+		}
+	}
+	return nodes
+}
+
+// minPathSteps returns the cost of the cheapest path from the entry to a
+// RETURN. As costs are non-negative, no such path repeats a node.
+func minPathSteps(nodes []stepNode) int64 {
+	if len(nodes) == 0 {
+		return 0
+	}
+	const inf = math.MaxInt64
+	dist := make([]int64, len(nodes))
+	for i, node := range nodes {
+		if len(node.succs) == 0 {
+			dist[i] = node.cost
+		} else {
+			dist[i] = inf
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, node := range nodes {
+			for _, succ := range node.succs {
+				if dist[succ] == inf {
+					continue
+				}
+				if d := node.cost + dist[succ]; d < dist[i] {
+					dist[i] = d
+					changed = true
+				}
+			}
+		}
+	}
+	if dist[0] == inf {
+		return 0
+	}
+	return dist[0]
+}
+
+// maxPathSteps returns the cost of the most expensive path from the entry to
+// a RETURN. If a cycle is reachable from the entry, the cost is unbounded and
+// maxPathSteps reports false.
+func maxPathSteps(nodes []stepNode) (int64, bool) {
+	if len(nodes) == 0 {
+		return 0, true
+	}
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make([]int, len(nodes))
+	max := make([]int64, len(nodes))
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case inProgress:
+			return false
+		case done:
+			return true
+		}
+		state[i] = inProgress
+		var best int64
+		for _, succ := range nodes[i].succs {
+			if !visit(succ) {
+				return false
+			}
+			if max[succ] > best {
+				best = max[succ]
+			}
+		}
+		max[i] = nodes[i].cost + best
+		state[i] = done
+		return true
+	}
+	if !visit(0) {
+		return 0, false
+	}
+	return max[0], true
+}
+
+// expectedPathSteps returns the expected cost of a path from the entry to a
+// RETURN, where each successor of a conditional jump is equally likely and
+// each loop body executes once. Returning to a loop header which is being
+// visited ends the iteration, and the header is charged again for the check
+// which terminates the loop.
+func expectedPathSteps(nodes []stepNode) float64 {
+	if len(nodes) == 0 {
+		return 0
+	}
+	onStack := make([]bool, len(nodes))
+	memo := make([]float64, len(nodes))
+	visited := make([]bool, len(nodes))
+	var visit func(i int) float64
+	visit = func(i int) float64 {
+		if onStack[i] {
+			return 0
+		}
+		if visited[i] {
+			return memo[i]
+		}
+		onStack[i] = true
+		node := nodes[i]
+		cost := float64(node.cost)
+		switch len(node.succs) {
+		case 0:
+		case 1:
+			cost += visit(node.succs[0])
+		default:
+			body, exit := visit(node.succs[0]), visit(node.succs[1])
+			if isLoopHeader(nodes, i) {
+				cost += body + float64(node.cost) + exit
+			} else {
+				cost += (body + exit) / 2
+			}
+		}
+		onStack[i] = false
+		visited[i] = true
+		memo[i] = cost
+		return cost
+	}
+	return visit(0)
+}
+
+// isLoopHeader reports whether some later instruction jumps back to node i.
+func isLoopHeader(nodes []stepNode, i int) bool {
+	for j := i + 1; j < len(nodes); j++ {
+		for _, succ := range nodes[j].succs {
+			if succ == i {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestEstimateSteps(t *testing.T) {
+	const src = `
+def straight(x):
+	y = x + 1
+	return y * 2
+
+def branch(x):
+	if x:
+		a = 1
+		b = a + 2
+		return b * 3
+	return 0
+
+def loop(n):
+	for i in range(n):
+		pass
+`
+	_, prog, err := starlark.SourceProgram("estimate.star", src, func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimates := starlark.EstimateSteps(prog)
+	if len(estimates) != 4 {
+		t.Fatalf("expected 4 estimates, got %d", len(estimates))
+	}
+	if name := estimates[0].Name; name != "<toplevel>" {
+		t.Errorf("expected toplevel estimate first, got %s", name)
+	}
+
+	thread := &starlark.Thread{}
+	globals, err := prog.Init(thread, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps, _ := thread.Steps(); steps != estimates[0].Min || steps != estimates[0].Max {
+		t.Errorf("toplevel: took %d steps, estimated [%d, %d]", steps, estimates[0].Min, estimates[0].Max)
+	}
+
+	byName := make(map[string]starlark.StepEstimate)
+	for _, estimate := range estimates[1:] {
+		byName[estimate.Name] = estimate
+	}
+
+	steps := func(name string, arg starlark.Value) int64 {
+		thread := &starlark.Thread{}
+		if _, err := starlark.Call(thread, globals[name], starlark.Tuple{arg}, nil); err != nil {
+			t.Fatal(err)
+		}
+		steps, _ := thread.Steps()
+		return steps
+	}
+
+	t.Run("straight", func(t *testing.T) {
+		estimate := byName["straight"]
+		if estimate.Unbounded {
+			t.Error("straight-line code estimated as unbounded")
+		}
+		if estimate.Min != estimate.Max || float64(estimate.Min) != estimate.Expected {
+			t.Errorf("inconsistent straight-line estimate: %+v", estimate)
+		}
+		if actual := steps("straight", starlark.MakeInt(1)); actual != estimate.Min {
+			t.Errorf("took %d steps, estimated %d", actual, estimate.Min)
+		}
+	})
+
+	t.Run("branch", func(t *testing.T) {
+		estimate := byName["branch"]
+		if estimate.Unbounded {
+			t.Error("branching code estimated as unbounded")
+		}
+		short, long := steps("branch", starlark.False), steps("branch", starlark.True)
+		if short != estimate.Min {
+			t.Errorf("short path took %d steps, estimated %d", short, estimate.Min)
+		}
+		if long != estimate.Max {
+			t.Errorf("long path took %d steps, estimated %d", long, estimate.Max)
+		}
+		if expected := float64(short+long) / 2; estimate.Expected != expected {
+			t.Errorf("expected %g steps, estimated %g", expected, estimate.Expected)
+		}
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		estimate := byName["loop"]
+		if !estimate.Unbounded {
+			t.Error("loop estimated as bounded")
+		}
+		if actual := steps("loop", starlark.MakeInt(0)); actual != estimate.Min {
+			t.Errorf("empty loop took %d steps, estimated %d", actual, estimate.Min)
+		}
+		if actual := steps("loop", starlark.MakeInt(1)); float64(actual) != estimate.Expected {
+			t.Errorf("single iteration took %d steps, expected %g", actual, estimate.Expected)
+		}
+	})
+}
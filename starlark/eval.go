@@ -6,6 +6,7 @@ package starlark
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"math/big"
 	"math/bits"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -39,6 +41,12 @@ type Thread struct {
 	cancelCleanup func()
 	cancelReason  error
 	done          chan struct{}
+	timeoutTimer  *time.Timer     // see SetPolicy
+	callContext   context.Context // see SetBuiltinTimeout
+
+	// cancellationReported records whether the thread's cancellation has
+	// been passed to the hook set by SetCancellationHook.
+	cancellationReported bool
 
 	// stack is the stack of (internal) call frames.
 	stack []*frame
@@ -57,6 +65,12 @@ type Thread struct {
 	// See example_test.go for some example implementations of Load.
 	Load func(thread *Thread, module string) (StringDict, error)
 
+	// LoadSafety, if non-nil, reports the safety declared by a module
+	// before it is loaded. If the thread requires safety (see
+	// RequireSafety), a load statement fails without calling Load unless
+	// the module declares at least the required safety.
+	LoadSafety func(thread *Thread, module string) (SafetyFlags, error)
+
 	// Steps a count of abstract computation steps executed
 	// by this thread. It is incremented by the interpreter. It may be used
 	// as a measure of the approximate cost of Starlark execution, by
@@ -72,10 +86,106 @@ type Thread struct {
 	maxAllocs  int64
 	allocsLock sync.Mutex
 
+	// pauseLock guards resume, which is non-nil while the thread has been
+	// asked to pause. pauseRequested and paused are accessed atomically.
+	// See Pause.
+	pauseLock      sync.Mutex
+	resume         chan struct{}
+	pauseRequested uint32
+	paused         uint32
+
+	// liveMemory, if non-nil, holds the state of live memory accounting.
+	// See SetAccountingMode.
+	liveMemory *liveMemory
+
+	// cpuMeter, if non-nil, measures the CPU time consumed by the thread.
+	// See SetMaxCPUTime.
+	cpuMeter *cpuMeter
+
+	// softLimits, if non-nil, holds the thresholds at which the client is
+	// notified of the thread's usage. See SetSoftLimits.
+	softLimits *softLimits
+
+	// allocProfile, if non-nil, records the allocations counted at each
+	// site. See EnableAllocProfile.
+	allocProfile map[AllocSite]SafeInteger
+
+	// stringPool, if non-nil, deduplicates strings interned by the
+	// thread. See SetStringPool.
+	stringPool *StringPool
+
+	// opcodeCounts, if non-nil, counts the instructions executed by
+	// opcode. See EnableOpcodeStats.
+	opcodeCounts *opcodeCounts
+
+	// policy is the policy set by SetPolicy.
+	policy Policy
+
+	// builtinTimeout, if positive, limits the duration of calls to
+	// builtins which may perform IO. See SetBuiltinTimeout.
+	builtinTimeout time.Duration
+
+	// maxCallDuration, if positive, limits the duration of calls to any
+	// builtin. See SetMaxCallDuration.
+	maxCallDuration time.Duration
+
+	// fileOptions, if non-nil, holds the options with which code passed
+	// to ExecFile, Eval and EvalExpr is parsed. See SetFileOptions.
+	fileOptions *syntax.FileOptions
+
+	// checkpoint names the functions whose partial results are recovered
+	// by salvage when the budget is exhausted. See SetCheckpoint.
+	checkpoint string
+	salvage    SalvageFunc
+
+	// maxCollectionLen, if positive, limits the number of elements in
+	// lists, dicts and sets. See SetMaxCollectionLen.
+	maxCollectionLen int
+
+	// opcodeCosts, if non-nil, overrides the steps counted for each
+	// instruction. See SetOpcodeCosts.
+	opcodeCosts *opcodeCostTable
+
+	// builtinCosts, if non-nil, maps the names of builtins to the extra
+	// steps counted when they are called. See SetStepCostModel.
+	builtinCosts map[string]int64
+
+	// pool, if non-nil, is the shared budget from which this thread's steps
+	// and allocations are also drawn. See SetResourcePool.
+	pool *ResourcePool
+
+	// parent, if non-nil, is the thread which spawned this one, and which
+	// also counts this thread's steps and allocations. See Spawn.
+	parent *Thread
+
+	// allocBudgets holds the allocation budgets of the builtins currently
+	// being called by this thread, outermost first.
+	allocBudgets []*allocBudget
+
+	// allocAudit, if non-nil, samples builtin calls to compare their declared
+	// allocations with those measured. See EnableAllocationAudit.
+	allocAudit *allocAudit
+
+	// checkIterators, if non-nil, receives reports of misused iterators.
+	// See CheckIterators.
+	checkIterators func(*IteratorMisuse)
+
+	// pureCache holds the results of calls to pure functions made by this
+	// thread. See Pure.
+	pureCache *Dict
+
+	// errorMap, if non-nil, rewrites the errors returned by host-defined
+	// callables. See SetErrorMap.
+	errorMap *ErrorMap
+
 	// locals holds arbitrary "thread-local" Go values belonging to the client.
 	// They are accessible to the client but not to any Starlark program.
 	locals map[string]interface{}
 
+	// stepProfile, if non-nil, samples the call stack as steps are
+	// counted. See StartStepProfile.
+	stepProfile *stepProfile
+
 	// proftime holds the accumulated execution time since the last profile event.
 	proftime time.Duration
 
@@ -170,7 +280,7 @@ func (thread *Thread) SetParentContext(ctx context.Context) {
 	thread.parentContext = ctx
 
 	stop := afterFunc(ctx, func() {
-		thread.cancel(cause(ctx))
+		thread.cancel(CancelContext, cause(ctx))
 	})
 
 	thread.cancelCleanup = func() { stop() }
@@ -178,17 +288,27 @@ func (thread *Thread) SetParentContext(ctx context.Context) {
 
 // Context returns a context which gets cancelled when this thread is
 // cancelled. Calling Value on the returned context with a string key is
-// equivalent to calling thread.Local with that key.
+// equivalent to calling thread.Local with that key. When called by a builtin
+// whose calls are limited by SetBuiltinTimeout, the context also expires at
+// the end of the call's time limit.
 //
 // If Context is called, Cancel must also be called.
 func (thread *Thread) Context() context.Context {
 	thread.contextLock.Lock()
 	defer thread.contextLock.Unlock()
 
+	if thread.callContext != nil {
+		return thread.callContext
+	}
+	return thread.threadContext()
+}
+
+// threadContext returns the thread as a context. The thread's contextLock
+// must be held.
+func (thread *Thread) threadContext() context.Context {
 	if thread.parentContext == nil {
 		thread.parentContext = context.Background()
 	}
-
 	return (*threadContext)(thread)
 }
 
@@ -200,11 +320,33 @@ func (thread *Thread) Steps() (int64, bool) {
 	return thread.steps.Int64()
 }
 
+// RemainingSteps returns the number of steps which may still be counted by
+// this thread before it is cancelled, taking into account the limit set by
+// SetMaxSteps, those of any ResourcePool to which the thread is attached
+// and, for a spawned thread, that of its parent. If no limit applies,
+// limited is false.
+//
+// It is safe to call RemainingSteps from any goroutine, even if the thread
+// is actively executing.
+func (thread *Thread) RemainingSteps() (remaining int64, limited bool) {
+	thread.stepsLock.Lock()
+	remaining, limited = remainingBelow(thread.steps, thread.maxSteps)
+	thread.stepsLock.Unlock()
+
+	return thread.remainingInPool(poolSteps, remaining, limited)
+}
+
 // SetMaxSteps sets a limit on the number of Starlark computation steps that
 // may be executed by this thread. If the thread's step counter exceeds this
 // limit, the thread is cancelled. If max is zero, negative or MaxInt64, the
 // thread will not be cancelled.
+//
+// It is safe to call SetMaxSteps from any goroutine, even if the thread
+// is actively executing. A lowered limit applies from the next count.
 func (thread *Thread) SetMaxSteps(max int64) {
+	thread.stepsLock.Lock()
+	defer thread.stepsLock.Unlock()
+
 	thread.maxSteps = max
 }
 
@@ -218,26 +360,65 @@ func (thread *Thread) CheckSteps(delta SafeInteger) error {
 	defer thread.stepsLock.Unlock()
 
 	_, err := thread.simulateSteps(delta)
+	if err == nil && thread.pool != nil {
+		err = thread.pool.draw(poolSteps, delta, false)
+	}
+	if err == nil && thread.parent != nil {
+		err = thread.parent.CheckSteps(delta)
+	}
 	return err
 }
 
 // AddSteps reports an increase in the number of steps taken
 // by this thread. If the new total steps exceeds the limit defined by
 // SetMaxSteps, the thread is cancelled and an error is returned.
+// If the thread has already been cancelled, its cancellation error
+// is returned.
+//
+// Built-in functions which declare CPUSafe should call AddSteps in
+// proportion to the work they do, just as MemSafe functions report
+// their allocations through AddAllocs.
 //
 // It is safe to call AddSteps from any goroutine, even if the thread
 // is actively executing.
 func (thread *Thread) AddSteps(delta SafeInteger) error {
+	return thread.addSteps(delta, true)
+}
+
+// addSteps implements AddSteps. If profile is false, the steps are not
+// recorded by the thread's step profile. This is the case for steps
+// counted by a spawned thread, which may run on a different goroutine so
+// must not inspect the stack of its parent.
+func (thread *Thread) addSteps(delta SafeInteger, profile bool) error {
+	// The soft limit callback is deferred first so that it runs after the
+	// lock is released.
+	crossed := false
+	defer func() {
+		if crossed {
+			thread.reportSoftLimit()
+		}
+	}()
+
 	thread.stepsLock.Lock()
 	defer thread.stepsLock.Unlock()
 
 	nextSteps, err := thread.simulateSteps(delta)
+	if err == nil && thread.pool != nil {
+		err = thread.pool.draw(poolSteps, delta, true)
+	}
+	if err == nil && thread.parent != nil {
+		err = thread.parent.addSteps(delta, false)
+	}
 	thread.steps = nextSteps
+	crossed = thread.crossSoftSteps()
+	if profile && thread.stepProfile != nil {
+		thread.recordSteps(delta)
+	}
 	if err != nil {
-		thread.cancel(err)
+		return thread.cancel(CancelSteps, err)
 	}
 
-	return err
+	return nil
 }
 
 var errStepCountInvalidated = errors.New("step count invalidated")
@@ -280,19 +461,120 @@ func (thread *Thread) Allocs() (int64, bool) {
 	return thread.allocs.Int64()
 }
 
+// RemainingAllocs returns the allocations which may still be reported to
+// this thread before it is cancelled, taking into account the limit set by
+// SetMaxAllocs, those of any ResourcePool to which the thread is attached
+// and, for a spawned thread, that of its parent. If no limit applies,
+// limited is false.
+//
+// It is safe to call RemainingAllocs from any goroutine, even if the thread
+// is actively executing.
+func (thread *Thread) RemainingAllocs() (remaining int64, limited bool) {
+	thread.allocsLock.Lock()
+	remaining, limited = remainingBelow(thread.allocs, thread.maxAllocs)
+	thread.allocsLock.Unlock()
+
+	return thread.remainingInPool(poolAllocs, remaining, limited)
+}
+
+// remainingBelow returns the amount by which used falls short of max, and
+// whether max is a limit as understood by SetMaxSteps and SetMaxAllocs.
+func remainingBelow(used SafeInteger, max int64) (remaining int64, limited bool) {
+	if max <= 0 || max == math.MaxInt64 {
+		return math.MaxInt64, false
+	}
+	if used64, ok := used.Int64(); ok && used64 < max {
+		return max - used64, true
+	}
+	return 0, true
+}
+
+// remainingInPool lowers remaining to the amount of the given resource
+// left in the thread's pool and to its parent, if any. Nothing remains to
+// a cancelled thread.
+func (thread *Thread) remainingInPool(resource poolResource, remaining int64, limited bool) (int64, bool) {
+	if thread.pool != nil {
+		poolRemaining, poolLimited := thread.pool.remaining(resource)
+		if poolLimited {
+			limited = true
+			if poolRemaining < remaining {
+				remaining = poolRemaining
+			}
+		}
+	}
+	if thread.parent != nil {
+		var parentRemaining int64
+		var parentLimited bool
+		if resource == poolSteps {
+			parentRemaining, parentLimited = thread.parent.RemainingSteps()
+		} else {
+			parentRemaining, parentLimited = thread.parent.RemainingAllocs()
+		}
+		if parentLimited {
+			limited = true
+			if parentRemaining < remaining {
+				remaining = parentRemaining
+			}
+		}
+	}
+	if thread.cancelled() != nil {
+		return 0, true
+	}
+	return remaining, limited
+}
+
 // SetMaxAllocs sets the maximum allocations that may be reported to this
 // thread via AddAllocs before Cancel is internally called. If max is zero,
 // negative or MaxInt64, the thread will not be cancelled.
+//
+// It is safe to call SetMaxAllocs from any goroutine, even if the thread
+// is actively executing. A lowered limit applies from the next count.
 func (thread *Thread) SetMaxAllocs(max int64) {
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
 	thread.maxAllocs = max
 }
 
+// SetMaxCollectionLen sets a limit on the number of elements in any list,
+// dict or set built or grown by this thread. Unlike the limit set by
+// SetMaxAllocs, it bounds the cardinality of collections however little
+// memory their elements occupy. An insertion which would exceed the limit
+// fails with a CollectionLenSafetyError, but the thread is not cancelled. If
+// max is zero or negative, the number of elements is not limited.
+func (thread *Thread) SetMaxCollectionLen(max int) {
+	thread.maxCollectionLen = max
+}
+
+// CheckCollectionLen returns an error if a list, dict or set of n elements
+// would exceed the limit set by SetMaxCollectionLen. Builtins which create
+// or grow such collections should call it before doing so.
+func (thread *Thread) CheckCollectionLen(n int) error {
+	if thread == nil || thread.maxCollectionLen <= 0 || n <= thread.maxCollectionLen {
+		return nil
+	}
+	return &CollectionLenSafetyError{
+		Len: n,
+		Max: thread.maxCollectionLen,
+	}
+}
+
 // RequireSafety makes the thread only accept functions that declare at least
 // the provided safety.
+//
+// When the thread is used to call a Starlark function from Go, the call is
+// rejected before execution begins if the function references by name any
+// callable with insufficient safety; see Function.InferredSafety.
 func (thread *Thread) RequireSafety(safety SafetyFlags) {
 	thread.requiredSafety |= safety
 }
 
+// RequiredSafety returns the safety required by the thread, as accumulated
+// by RequireSafety.
+func (thread *Thread) RequiredSafety() SafetyFlags {
+	return thread.requiredSafety
+}
+
 // Permits checks whether this thread would allow execution of the provided
 // safety-aware value.
 func (thread *Thread) Permits(value SafetyAware) bool {
@@ -315,6 +597,7 @@ func (thread *Thread) CheckPermits(value SafetyAware) error {
 
 // Cancel causes execution of Starlark code in the specified thread to
 // promptly fail with an EvalError that includes the specified reason.
+// The EvalError wraps a CancellationError of kind CancelExplicit.
 // There may be a delay before the interpreter observes the cancellation
 // if the thread is currently in a call to a built-in function.
 //
@@ -327,17 +610,22 @@ func (thread *Thread) Cancel(reason string, args ...interface{}) {
 	} else {
 		err = fmt.Errorf(reason, args...)
 	}
-	thread.cancel(err)
+	thread.cancel(CancelExplicit, err)
 }
 
-func (thread *Thread) cancel(err error) {
+// cancel cancels the thread for the given reason, unless it has already been
+// cancelled. It returns the error which records the thread's cancellation.
+func (thread *Thread) cancel(kind CancellationKind, err error) error {
 	thread.contextLock.Lock()
 	defer thread.contextLock.Unlock()
 
 	if thread.cancelReason != nil {
-		return
+		return thread.cancelReason
 	}
-	thread.cancelReason = fmt.Errorf("Starlark computation cancelled: %w", err)
+	if thread.parent != nil {
+		kind, err = inheritedCancellation(kind, err)
+	}
+	thread.cancelReason = &CancellationError{Kind: kind, Err: err}
 
 	if thread.done != nil {
 		close(thread.done)
@@ -347,6 +635,11 @@ func (thread *Thread) cancel(err error) {
 		thread.cancelCleanup()
 		thread.cancelCleanup = nil
 	}
+	if thread.timeoutTimer != nil {
+		thread.timeoutTimer.Stop()
+		thread.timeoutTimer = nil
+	}
+	return thread.cancelReason
 }
 
 func (thread *Thread) cancelled() error {
@@ -463,13 +756,25 @@ func (tb *SafeStringBuilder) declareAllocs() error {
 	return nil
 }
 
+// checkGrow checks that the buffer may grow to accommodate n more bytes.
+// Like append, the underlying strings.Builder may overshoot by up to twice
+// its current capacity, so that is the transient allocation checked.
+func (tb *SafeStringBuilder) checkGrow(n int) error {
+	if tb.builder.Cap()-tb.builder.Len() >= n {
+		return nil
+	}
+	return tb.thread.CheckAllocs(roundAllocSize(SafeAdd(SafeMul(tb.builder.Cap(), 2), n)))
+}
+
+// Grow grows the buffer's capacity, if necessary, to guarantee space for
+// another n bytes, declaring any change in capacity.
 func (tb *SafeStringBuilder) Grow(n int) {
 	if tb.err != nil {
 		return
 	}
 
-	if tb.thread != nil && tb.Cap()-tb.Len() < n {
-		if err := tb.thread.CheckAllocs(roundAllocSize(SafeAdd(SafeMul(tb.Cap(), 2), n))); err != nil {
+	if tb.thread != nil {
+		if err := tb.checkGrow(n); err != nil {
 			tb.err = err
 			return
 		}
@@ -483,17 +788,17 @@ func (tb *SafeStringBuilder) Grow(n int) {
 	}
 }
 
+// Write appends the contents of b to the buffer, counting a step per byte
+// and declaring any change in capacity.
 func (tb *SafeStringBuilder) Write(b []byte) (int, error) {
 	if tb.err != nil {
 		return 0, tb.err
 	}
 
 	if tb.thread != nil {
-		if tb.builder.Cap()-tb.builder.Len() < len(b) {
-			if err := tb.thread.CheckAllocs(roundAllocSize(SafeAdd(tb.builder.Len(), len(b)))); err != nil {
-				tb.err = err
-				return 0, err
-			}
+		if err := tb.checkGrow(len(b)); err != nil {
+			tb.err = err
+			return 0, err
 		}
 		if err := tb.thread.AddSteps(SafeInt(len(b))); err != nil {
 			tb.err = err
@@ -520,19 +825,19 @@ func (tb *SafeStringBuilder) WriteString(s string) (int, error) {
 	}
 
 	if tb.thread != nil {
-		if tb.builder.Cap()-tb.builder.Len() < len(s) {
-			if err := tb.thread.CheckAllocs(roundAllocSize(SafeAdd(tb.builder.Len(), len(s)))); err != nil {
-				tb.err = err
-				return 0, err
-			}
+		if err := tb.checkGrow(len(s)); err != nil {
+			tb.err = err
+			return 0, err
 		}
 		if err := tb.thread.AddSteps(SafeInt(len(s))); err != nil {
+			tb.err = err
 			return 0, err
 		}
 	}
 
 	n, err := tb.builder.WriteString(s)
 	if err != nil {
+		tb.err = err
 		return 0, err
 	}
 	if tb.thread != nil {
@@ -549,18 +854,18 @@ func (tb *SafeStringBuilder) WriteByte(b byte) error {
 	}
 
 	if tb.thread != nil {
-		if tb.builder.Cap()-tb.builder.Len() < 1 {
-			if err := tb.thread.CheckAllocs(roundAllocSize(SafeAdd(tb.builder.Len(), 1))); err != nil {
-				tb.err = err
-				return err
-			}
+		if err := tb.checkGrow(1); err != nil {
+			tb.err = err
+			return err
 		}
 		if err := tb.thread.AddSteps(SafeInt(1)); err != nil {
+			tb.err = err
 			return err
 		}
 	}
 
 	if err := tb.builder.WriteByte(b); err != nil {
+		tb.err = err
 		return err
 	}
 	if tb.thread != nil {
@@ -583,23 +888,24 @@ func (tb *SafeStringBuilder) WriteRune(r rune) (int, error) {
 		} else {
 			growAmount = utf8.UTFMax
 		}
-		if tb.builder.Cap()-tb.builder.Len() < growAmount {
-			if err := tb.thread.CheckAllocs(roundAllocSize(SafeAdd(tb.builder.Len(), growAmount))); err != nil {
-				tb.err = err
-				return 0, err
-			}
+		if err := tb.checkGrow(growAmount); err != nil {
+			tb.err = err
+			return 0, err
 		}
 		if err := tb.thread.CheckSteps(SafeInt(growAmount)); err != nil {
+			tb.err = err
 			return 0, err
 		}
 	}
 
 	n, err := tb.builder.WriteRune(r)
 	if err != nil {
+		tb.err = err
 		return 0, err
 	}
 	if tb.thread != nil {
 		if err := tb.thread.AddSteps(SafeInt(n)); err != nil {
+			tb.err = err
 			return 0, err
 		}
 		if err := tb.declareAllocs(); err != nil {
@@ -679,10 +985,16 @@ func (d StringDict) Has(key string) bool { _, ok := d[key]; return ok }
 // A frame records a call to a Starlark function (including module toplevel)
 // or a built-in function or method.
 type frame struct {
-	callable  Callable // current function (or toplevel) or built-in
-	pc        uint32   // program counter (Starlark frames only)
-	locals    []Value  // local variables (Starlark frames only)
-	spanStart int64    // start time of current profiler span
+	callable  Callable    // current function (or toplevel) or built-in
+	pc        uint32      // program counter (Starlark frames only)
+	locals    []Value     // local variables (Starlark frames only)
+	operands  []Value     // operand stack (Starlark frames only)
+	iterstack *[]Iterator // active iterators (Starlark frames only)
+	spanStart int64       // start time of current profiler span
+
+	// startSteps and startAllocs are the thread's totals when the
+	// frame was entered.
+	startSteps, startAllocs SafeInteger
 }
 
 // Position returns the source position of the current point of execution in this frame.
@@ -736,7 +1048,22 @@ func (stack CallStack) String() string {
 type EvalError struct {
 	Msg       string
 	CallStack CallStack
-	cause     error
+
+	// Usage, if non-nil, records the resources used by each frame of
+	// CallStack, including those used by its callees. It is set only if
+	// the thread was cancelled for exceeding its step or allocation
+	// budget.
+	Usage []FrameUsage
+
+	cause error
+}
+
+// A FrameUsage records the steps and allocations attributed to a call
+// frame, from its entry to the moment of an error.
+type FrameUsage struct {
+	Name   string
+	Steps  int64
+	Allocs int64
 }
 
 // A CallFrame represents the function name and current
@@ -754,11 +1081,42 @@ func (fr *frame) asCallFrame() CallFrame {
 }
 
 func (thread *Thread) evalError(err error) *EvalError {
-	return &EvalError{
+	evalErr := &EvalError{
 		Msg:       err.Error(),
 		CallStack: thread.CallStack(),
 		cause:     err,
 	}
+	var cancelErr *CancellationError
+	if errors.As(err, &cancelErr) && (cancelErr.Kind == CancelSteps || cancelErr.Kind == CancelMemory) {
+		evalErr.Usage = thread.frameUsage()
+	}
+	return evalErr
+}
+
+// frameUsage returns the resources used by each frame of the thread's
+// stack, outermost first.
+func (thread *Thread) frameUsage() []FrameUsage {
+	steps, allocs := thread.totals()
+	usage := make([]FrameUsage, len(thread.stack))
+	for i, fr := range thread.stack {
+		usage[i].Name = fr.Callable().Name()
+		usage[i].Steps, _ = SafeSub(steps, fr.startSteps).Int64()
+		usage[i].Allocs, _ = SafeSub(allocs, fr.startAllocs).Int64()
+	}
+	return usage
+}
+
+// totals returns the steps and allocations counted by the thread so far.
+func (thread *Thread) totals() (steps, allocs SafeInteger) {
+	thread.stepsLock.Lock()
+	steps = thread.steps
+	thread.stepsLock.Unlock()
+
+	thread.allocsLock.Lock()
+	allocs = thread.allocs
+	thread.allocsLock.Unlock()
+
+	return steps, allocs
 }
 
 func (e *EvalError) Error() string { return e.Msg }
@@ -774,7 +1132,14 @@ func (e *EvalError) Backtrace() string {
 		suffix = " in " + stack[last].Name
 		stack = stack[:last]
 	}
-	return fmt.Sprintf("%sError%s: %s", stack, suffix, e.Msg)
+	usage := new(strings.Builder)
+	if len(e.Usage) > 0 {
+		fmt.Fprintf(usage, "Resources used (most recent call last, including callees):\n")
+		for _, u := range e.Usage {
+			fmt.Fprintf(usage, "  %s: %d steps, %d allocs\n", u.Name, u.Steps, u.Allocs)
+		}
+	}
+	return fmt.Sprintf("%s%sError%s: %s", stack, usage, suffix, e.Msg)
 }
 
 func (e *EvalError) Unwrap() error { return e.cause }
@@ -786,6 +1151,7 @@ func (e *EvalError) Unwrap() error { return e.cause }
 // or by loading a previously saved compiled program (see CompiledProgram).
 type Program struct {
 	compiled *compile.Program
+	options  *syntax.FileOptions // nil if unknown
 }
 
 // CompilerVersion is the version number of the protocol for compiled
@@ -810,6 +1176,53 @@ func (prog *Program) Load(i int) (string, syntax.Position) {
 	return id.Name, id.Pos
 }
 
+// CheckPredeclaredSafety returns an error if any predeclared or universal
+// value referenced by the program does not report at least the given safety.
+// The predeclared environment should be the one with which the program will
+// be executed.
+//
+// This allows a host to reject a program which could not run to completion
+// under a thread requiring the given safety before the program is executed.
+func (prog *Program) CheckPredeclaredSafety(predeclared StringDict, require SafetyFlags) error {
+	check := func(fn *compile.Funcode) error {
+		return forEachInsn(fn, func(pc uint32, op compile.Opcode, arg uint32) error {
+			var v Value
+			switch op {
+			case compile.PREDECLARED:
+				v = predeclared[fn.Prog.Names[arg]]
+			case compile.UNIVERSAL:
+				v = Universe[fn.Prog.Names[arg]]
+			default:
+				return nil
+			}
+			if _, ok := v.(Callable); !ok {
+				return nil
+			}
+			if err := callableSafety(v).CheckContains(require); err != nil {
+				return fmt.Errorf("%s: %s: %w", fn.Position(pc), fn.Prog.Names[arg], err)
+			}
+			return nil
+		})
+	}
+
+	if err := check(prog.compiled.Toplevel); err != nil {
+		return err
+	}
+	for _, fn := range prog.compiled.Functions {
+		if err := check(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hash returns the SHA-256 digest of the compiled module, as written by
+// Write. It identifies the program in reports of cancellation; see
+// SetCancellationHook.
+func (prog *Program) Hash() [sha256.Size]byte {
+	return sha256.Sum256(prog.compiled.Encode())
+}
+
 // WriteTo writes the compiled module to the specified output stream.
 func (prog *Program) Write(out io.Writer) error {
 	data := prog.compiled.Encode()
@@ -817,12 +1230,14 @@ func (prog *Program) Write(out io.Writer) error {
 	return err
 }
 
-// ExecFile calls [ExecFileOptions] using [syntax.LegacyFileOptions].
+// ExecFile calls [ExecFileOptions] using the thread's options, as set by
+// [Thread.SetFileOptions], or else [syntax.LegacyFileOptions].
 //
 // Deprecated: use [ExecFileOptions] with [syntax.FileOptions] instead,
-// because this function relies on legacy global variables.
+// or set the thread's options, because this function may rely on legacy
+// global variables.
 func ExecFile(thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, error) {
-	return ExecFileOptions(syntax.LegacyFileOptions(), thread, filename, src, predeclared)
+	return ExecFileOptions(thread.FileOptions(), thread, filename, src, predeclared)
 }
 
 // ExecFileOptions parses, resolves, and executes a Starlark file in the
@@ -906,11 +1321,18 @@ func FileProgram(f *syntax.File, isPredeclared func(string) bool) (*Program, err
 	module := f.Module.(*resolve.Module)
 	compiled := compile.File(f.Options, f.Stmts, pos, "<toplevel>", module.Locals, module.Globals)
 
-	return &Program{compiled}, nil
+	return &Program{compiled: compiled, options: f.Options}, nil
 }
 
 // CompiledProgram produces a new program from the representation
 // of a compiled program previously saved by Program.Write.
+//
+// The program's bytecode is verified before it is returned, so that a
+// corrupt or crafted representation, for example from a shared cache, is
+// rejected with an error rather than crashing the interpreter when run.
+// Verification does not establish that the program is the one which was
+// saved: callers which need that must check the integrity of the data
+// themselves, for example by comparing it with a Program.Hash.
 func CompiledProgram(in io.Reader) (*Program, error) {
 	data, err := io.ReadAll(in)
 	if err != nil {
@@ -920,7 +1342,7 @@ func CompiledProgram(in io.Reader) (*Program, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Program{compiled}, nil
+	return &Program{compiled: compiled}, nil
 }
 
 // Init creates a set of global variables for the program,
@@ -962,7 +1384,7 @@ func ExecREPLChunk(f *syntax.File, thread *Thread, globals StringDict) error {
 
 	module := f.Module.(*resolve.Module)
 	compiled := compile.File(f.Options, f.Stmts, pos, "<toplevel>", module.Locals, module.Globals)
-	prog := &Program{compiled}
+	prog := &Program{compiled: compiled}
 
 	// -- variant of Program.Init --
 
@@ -988,7 +1410,13 @@ func ExecREPLChunk(f *syntax.File, thread *Thread, globals StringDict) error {
 }
 
 func makeToplevelFunction(prog *compile.Program, predeclared StringDict) *Function {
-	// Create the Starlark value denoted by each program constant c.
+	return makeToplevelFunctionWithConstants(prog, predeclared, makeConstants(prog))
+}
+
+// makeConstants creates the Starlark value denoted by each program constant.
+// As these values are immutable, the result may be shared between modules
+// created from the same program.
+func makeConstants(prog *compile.Program) []Value {
 	constants := make([]Value, len(prog.Constants))
 	for i, c := range prog.Constants {
 		var v Value
@@ -1008,7 +1436,10 @@ func makeToplevelFunction(prog *compile.Program, predeclared StringDict) *Functi
 		}
 		constants[i] = v
 	}
+	return constants
+}
 
+func makeToplevelFunctionWithConstants(prog *compile.Program, predeclared StringDict, constants []Value) *Function {
 	return &Function{
 		funcode: prog.Toplevel,
 		module: &module{
@@ -1020,12 +1451,14 @@ func makeToplevelFunction(prog *compile.Program, predeclared StringDict) *Functi
 	}
 }
 
-// Eval calls [EvalOptions] using [syntax.LegacyFileOptions].
+// Eval calls [EvalOptions] using the thread's options, as set by
+// [Thread.SetFileOptions], or else [syntax.LegacyFileOptions].
 //
 // Deprecated: use [EvalOptions] with [syntax.FileOptions] instead,
-// because this function relies on legacy global variables.
+// or set the thread's options, because this function may rely on legacy
+// global variables.
 func Eval(thread *Thread, filename string, src interface{}, env StringDict) (Value, error) {
-	return EvalOptions(syntax.LegacyFileOptions(), thread, filename, src, env)
+	return EvalOptions(thread.FileOptions(), thread, filename, src, env)
 }
 
 // EvalOptions parses, resolves, and evaluates an expression within the
@@ -1050,12 +1483,14 @@ func EvalOptions(opts *syntax.FileOptions, thread *Thread, filename string, src
 	return Call(thread, f, nil, nil)
 }
 
-// EvalExpr calls [EvalExprOptions] using [syntax.LegacyFileOptions].
+// EvalExpr calls [EvalExprOptions] using the thread's options, as set by
+// [Thread.SetFileOptions], or else [syntax.LegacyFileOptions].
 //
 // Deprecated: use [EvalExprOptions] with [syntax.FileOptions] instead,
-// because this function relies on legacy global variables.
+// or set the thread's options, because this function may rely on legacy
+// global variables.
 func EvalExpr(thread *Thread, expr syntax.Expr, env StringDict) (Value, error) {
-	return EvalExprOptions(syntax.LegacyFileOptions(), thread, expr, env)
+	return EvalExprOptions(thread.FileOptions(), thread, expr, env)
 }
 
 // EvalExprOptions resolves and evaluates an expression within the
@@ -1119,6 +1554,9 @@ func safeListExtend(thread *Thread, x *List, y Iterable) error {
 		if err := thread.AddSteps(SafeInt(len(ylist.elems))); err != nil {
 			return err
 		}
+		if err := thread.CheckCollectionLen(len(x.elems) + len(ylist.elems)); err != nil {
+			return err
+		}
 		if err := elemsAppender.AppendSlice(ylist.elems); err != nil {
 			return err
 		}
@@ -1130,6 +1568,9 @@ func safeListExtend(thread *Thread, x *List, y Iterable) error {
 		defer iter.Done()
 		var z Value
 		for iter.Next(&z) {
+			if err := thread.CheckCollectionLen(len(x.elems) + 1); err != nil {
+				return err
+			}
 			if err := elemsAppender.Append(z); err != nil {
 				return err
 			}
@@ -1311,6 +1752,75 @@ func setIndex(thread *Thread, x, y, z Value) error {
 	}
 }
 
+// setSlice implements x[lo:hi] = y.
+func setSlice(thread *Thread, x, lo, hi, y Value) error {
+	list, ok := x.(*List)
+	if !ok {
+		return fmt.Errorf("%s value does not support slice assignment", x.Type())
+	}
+	if err := list.checkMutable("assign to slice of"); err != nil {
+		return err
+	}
+	if err := list.unshare(thread); err != nil {
+		return err
+	}
+	iterable, ok := y.(Iterable)
+	if !ok {
+		return fmt.Errorf("can only assign an iterable to a slice, not %s", y.Type())
+	}
+
+	// Collect the new elements before modifying the list, as y may be
+	// the list itself.
+	var values []Value
+	iter, err := SafeIterate(thread, iterable)
+	if err != nil {
+		return err
+	}
+	valuesAppender := NewSafeAppender(thread, &values)
+	var value Value
+	for iter.Next(&value) {
+		if err := valuesAppender.Append(value); err != nil {
+			iter.Done()
+			return err
+		}
+	}
+	iter.Done()
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	start, end, err := indices(lo, hi, list.Len())
+	if err != nil {
+		return err
+	}
+	if end < start {
+		end = start
+	}
+	return list.replaceSlice(thread, start, end, values)
+}
+
+// deleteSlice implements del x[lo:hi].
+func deleteSlice(thread *Thread, x, lo, hi Value) error {
+	list, ok := x.(*List)
+	if !ok {
+		return fmt.Errorf("%s value does not support slice deletion", x.Type())
+	}
+	if err := list.checkMutable("delete from"); err != nil {
+		return err
+	}
+	if err := list.unshare(thread); err != nil {
+		return err
+	}
+	start, end, err := indices(lo, hi, list.Len())
+	if err != nil {
+		return err
+	}
+	if end < start {
+		end = start
+	}
+	return list.replaceSlice(thread, start, end, nil)
+}
+
 // Unary applies a unary operator (+, -, ~, not) to its operand.
 func Unary(op syntax.Token, x Value) (Value, error) {
 	return SafeUnary(nil, op, x)
@@ -1438,6 +1948,21 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			}
 		case *List:
 			if y, ok := y.(*List); ok {
+				// Concatenating a frozen list with an empty one shares
+				// the frozen list's elements.
+				shared, ok := x, y.Len() == 0
+				if x.Len() == 0 {
+					shared, ok = y, true
+				}
+				if ok && shared.frozen {
+					if thread != nil {
+						if err := thread.AddAllocs(EstimateSize(&List{})); err != nil {
+							return nil, err
+						}
+					}
+					return newSharedList(shared.elems), nil
+				}
+
 				resultLen := SafeAdd(x.Len(), y.Len())
 				resultLen64, ok := resultLen.Int64()
 				if !ok {
@@ -1445,6 +1970,9 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 				}
 
 				if thread != nil {
+					if err := thread.CheckCollectionLen(int(resultLen64)); err != nil {
+						return nil, err
+					}
 					if err := thread.AddSteps(resultLen); err != nil {
 						return nil, err
 					}
@@ -1613,6 +2141,9 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if thread != nil {
+					if err := thread.CheckCollectionLen(len(elems)); err != nil {
+						return nil, err
+					}
 					if err := thread.AddAllocs(EstimateSize(&List{})); err != nil {
 						return nil, err
 					}
@@ -1672,6 +2203,9 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if thread != nil {
+					if err := thread.CheckCollectionLen(len(elems)); err != nil {
+						return nil, err
+					}
 					if err := thread.AddAllocs(EstimateSize(&List{})); err != nil {
 						return nil, err
 					}
@@ -1917,7 +2451,7 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 						return nil, err
 					}
 				}
-				if eq, err := Equal(elem, x); err != nil {
+				if eq, err := safeEqual(thread, elem, x); err != nil {
 					return nil, err
 				} else if eq {
 					return True, nil
@@ -1931,7 +2465,7 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 						return nil, err
 					}
 				}
-				if eq, err := Equal(elem, x); err != nil {
+				if eq, err := safeEqual(thread, elem, x); err != nil {
 					return nil, err
 				} else if eq {
 					return True, nil
@@ -2166,17 +2700,34 @@ func safeBinary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 
 	// user-defined types
 	// (nil, nil) => unhandled
-	// TODO: use SafeIterate (SafeBinary?)
-	if err := CheckSafety(thread, NotSafe); err != nil {
-		return nil, err
+	if !isSafeBinary(x) && !isSafeBinary(y) {
+		if err := CheckSafety(thread, NotSafe); err != nil {
+			return nil, err
+		}
 	}
-	if x, ok := x.(HasBinary); ok {
+	if xs, ok := x.(HasSafeBinary); ok {
+		z, err := xs.SafeBinary(thread, op, y, Left)
+		if z != nil || err != nil {
+			return z, err
+		}
+	} else if x, ok := x.(HasBinary); ok {
+		if err := CheckSafety(thread, NotSafe); err != nil {
+			return nil, err
+		}
 		z, err := x.Binary(op, y, Left)
 		if z != nil || err != nil {
 			return z, err
 		}
 	}
-	if y, ok := y.(HasBinary); ok {
+	if ys, ok := y.(HasSafeBinary); ok {
+		z, err := ys.SafeBinary(thread, op, x, Right)
+		if z != nil || err != nil {
+			return z, err
+		}
+	} else if y, ok := y.(HasBinary); ok {
+		if err := CheckSafety(thread, NotSafe); err != nil {
+			return nil, err
+		}
 		z, err := y.Binary(op, x, Right)
 		if z != nil || err != nil {
 			return z, err
@@ -2188,6 +2739,11 @@ unknown:
 	return nil, fmt.Errorf("unknown binary op: %s %s %s", x.Type(), op, y.Type())
 }
 
+func isSafeBinary(x Value) bool {
+	_, ok := x.(HasSafeBinary)
+	return ok
+}
+
 // It's always possible to overeat in small bites but we'll
 // try to stop someone swallowing the world in one gulp.
 const maxAlloc = 1 << 30
@@ -2286,6 +2842,10 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		return nil, fmt.Errorf("cannot call value of type '%s': %w", c.Type(), err)
 	}
 
+	if err := thread.checkHostEntry(c); err != nil {
+		return nil, err
+	}
+
 	if len(thread.stack)+1 >= maxStackDepth {
 		return nil, fmt.Errorf("stack overflow")
 	}
@@ -2304,11 +2864,6 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		fr = new(frame)
 	}
 
-	// one-time initialization of thread
-	if thread.maxSteps == 0 {
-		thread.maxSteps-- // (MaxUint64)
-	}
-
 	// Count only for stack memory as other resources are already
 	// accounted for.
 	prevStackCap := cap(thread.stack)
@@ -2322,7 +2877,14 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		}
 	}
 
+	// Measure CPU time from the outermost call.
+	if len(thread.stack) == 1 && thread.cpuMeter != nil {
+		stop := thread.startCPUMeter()
+		defer stop()
+	}
+
 	fr.callable = c
+	fr.startSteps, fr.startAllocs = thread.totals()
 
 	thread.beginProfSpan()
 
@@ -2340,7 +2902,29 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		thread.stack = thread.stack[:len(thread.stack)-1] // pop
 	}()
 
-	result, err := c.CallInternal(thread, args, kwargs)
+	var result Value
+	var err error
+	if c, ok := c.(SafeCallable); ok {
+		err = c.CheckCall(thread)
+	}
+	if err == nil {
+		var timeoutExceeded func() error
+		if _, ok := c.(*Function); !ok {
+			var endTimeout func()
+			endTimeout, timeoutExceeded = thread.beginBuiltinTimeout(c, callableSafety)
+			if endTimeout != nil {
+				defer endTimeout()
+			}
+		}
+
+		result, err = c.CallInternal(thread, args, kwargs)
+
+		if timeoutExceeded != nil {
+			if err2 := timeoutExceeded(); err2 != nil {
+				result, err = nil, err2
+			}
+		}
+	}
 
 	// Sanity check: nil is not a valid Starlark value.
 	if result == nil && err == nil {
@@ -2349,11 +2933,18 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 
 	// Always return an EvalError with an accurate frame.
 	if err != nil {
+		if _, ok := c.(*Function); !ok && thread.errorMap != nil {
+			err = thread.errorMap.rewrite(c, err)
+		}
 		if _, ok := err.(*EvalError); !ok {
 			err = thread.evalError(err)
 		}
 	}
 
+	if err != nil && len(thread.stack) == 1 {
+		thread.reportCancellation(c)
+	}
+
 	return result, err
 }
 
@@ -2645,6 +3236,7 @@ func interpolate(thread *Thread, format string, x Value) (Value, error) {
 		}
 
 		var arg Value
+		keyed := false
 		if format != "" && format[0] == '(' {
 			// keyword argument: %(name)s.
 			format = format[1:]
@@ -2674,29 +3266,50 @@ func interpolate(thread *Thread, format string, x Value) (Value, error) {
 				return nil, fmt.Errorf("key not found: %s", key)
 			}
 			arg = v
+			keyed = true
 			format = format[j+1:]
-		} else {
-			// positional argument: %s.
+		}
+
+		// positional argument: %s.
+		nextArg := func() (Value, error) {
 			if index >= nargs {
 				return nil, fmt.Errorf("not enough arguments for format string")
 			}
+			var arg Value
 			if tuple, ok := x.(Tuple); ok {
 				arg = tuple[index]
 			} else {
 				arg = x
 			}
+			index++
+			return arg, nil
 		}
 
-		// NOTE: Starlark does not support any of these optional Python features:
-		// - optional conversion flags: [#0- +], etc.
-		// - optional minimum field width (number or *).
-		// - optional precision (.123 or *)
-		// - optional length modifier
+		// optional conversion flags, minimum field width, precision and
+		// length modifier.
+		var spec formatSpec
+		var err error
+		if format, err = spec.parse(format, keyed, nextArg); err != nil {
+			return nil, err
+		}
+
+		if !keyed {
+			if arg, err = nextArg(); err != nil {
+				return nil, err
+			}
+		}
 
 		// conversion type
 		if format == "" {
 			return nil, fmt.Errorf("incomplete format")
 		}
+		if spec != (formatSpec{prec: -1}) {
+			if err := interpolateSpec(thread, buf, format[0], arg, spec); err != nil {
+				return nil, err
+			}
+			format = format[1:]
+			continue
+		}
 		switch c := format[0]; c {
 		case 's', 'r':
 			if str, ok := AsString(arg); ok && c == 's' {
@@ -2769,7 +3382,6 @@ func interpolate(thread *Thread, format string, x Value) (Value, error) {
 			return nil, fmt.Errorf("unknown conversion %%%c", c)
 		}
 		format = format[1:]
-		index++
 	}
 	if err := buf.Err(); err != nil {
 		return nil, err
@@ -2789,6 +3401,299 @@ func interpolate(thread *Thread, format string, x Value) (Value, error) {
 	return String(buf.String()), nil
 }
 
+// A formatSpec holds the optional conversion flags, minimum field width
+// and precision of a conversion performed by the % operator.
+type formatSpec struct {
+	alt, zero, left, space, plus bool
+
+	width int // zero if absent
+	prec  int // negative if absent
+}
+
+// maxFormatWidth bounds the field width and precision of a conversion.
+const maxFormatWidth = math.MaxInt32
+
+// parse parses the flags, width, precision and length modifier at the
+// start of format, returning the remainder. A width or precision of * is
+// taken from the next positional argument, which is unavailable to keyed
+// conversions.
+func (spec *formatSpec) parse(format string, keyed bool, nextArg func() (Value, error)) (string, error) {
+	*spec = formatSpec{prec: -1}
+flags:
+	for format != "" {
+		switch format[0] {
+		case '#':
+			spec.alt = true
+		case '0':
+			spec.zero = true
+		case '-':
+			spec.left = true
+		case ' ':
+			spec.space = true
+		case '+':
+			spec.plus = true
+		default:
+			break flags
+		}
+		format = format[1:]
+	}
+
+	number := func(what string) (int, error) {
+		if format != "" && format[0] == '*' {
+			format = format[1:]
+			if keyed {
+				return 0, fmt.Errorf("* %s requires positional arguments", what)
+			}
+			arg, err := nextArg()
+			if err != nil {
+				return 0, err
+			}
+			n, err := AsInt32(arg)
+			if err != nil {
+				return 0, fmt.Errorf("* %s requires int: %v", what, err)
+			}
+			return n, nil
+		}
+		n := 0
+		for format != "" && '0' <= format[0] && format[0] <= '9' {
+			n = n*10 + int(format[0]-'0')
+			if n > maxFormatWidth {
+				return 0, fmt.Errorf("%s too big", what)
+			}
+			format = format[1:]
+		}
+		return n, nil
+	}
+
+	width, err := number("width")
+	if err != nil {
+		return "", err
+	}
+	if width < 0 {
+		spec.left = true
+		width = -width
+	}
+	spec.width = width
+	if format != "" && format[0] == '.' {
+		format = format[1:]
+		prec, err := number("precision")
+		if err != nil {
+			return "", err
+		}
+		if prec < 0 {
+			prec = 0
+		}
+		spec.prec = prec
+	}
+
+	// As in Python, length modifiers are accepted but ignored.
+	if format != "" && (format[0] == 'h' || format[0] == 'l' || format[0] == 'L') {
+		format = format[1:]
+	}
+	return format, nil
+}
+
+// interpolateSpec writes to buf the conversion c of arg, formatted
+// according to spec. The memory for the field's width and precision is
+// declared before the field is formatted.
+func interpolateSpec(thread *Thread, buf *SafeStringBuilder, c byte, arg Value, spec formatSpec) error {
+	if thread != nil && spec.prec > 0 {
+		// Formatting a number to a given precision builds a temporary
+		// string of at least that length.
+		if err := thread.CheckAllocs(EstimateMakeSize([]byte{}, SafeInt(spec.prec))); err != nil {
+			return err
+		}
+	}
+
+	var sign, prefix, body string
+	zeros := 0 // leading zeros required by the precision of an integer
+	numeric := false
+	switch c {
+	case 's', 'r':
+		if str, ok := AsString(arg); ok && c == 's' {
+			body = str
+		} else {
+			field := NewSafeStringBuilder(thread)
+			if err := writeValue(thread, field, arg, nil); err != nil {
+				return err
+			}
+			body = field.String()
+		}
+		if spec.prec >= 0 {
+			body = truncateRunes(body, spec.prec)
+		}
+	case 'd', 'i', 'o', 'x', 'X':
+		i, err := NumberToInt(arg)
+		if err != nil {
+			return fmt.Errorf("%%%c format requires integer: %v", c, err)
+		}
+		numeric = true
+		if i.Sign() < 0 {
+			sign = "-"
+			i = zero.Sub(i)
+		}
+		switch c {
+		case 'd', 'i':
+			body = fmt.Sprintf("%d", i)
+		case 'o':
+			body = fmt.Sprintf("%o", i)
+			if spec.alt {
+				prefix = "0o"
+			}
+		case 'x':
+			body = fmt.Sprintf("%x", i)
+			if spec.alt {
+				prefix = "0x"
+			}
+		case 'X':
+			body = fmt.Sprintf("%X", i)
+			if spec.alt {
+				prefix = "0X"
+			}
+		}
+		if spec.prec > len(body) {
+			zeros = spec.prec - len(body)
+		}
+	case 'e', 'f', 'g', 'E', 'F', 'G':
+		f, ok := AsFloat(arg)
+		if !ok {
+			return fmt.Errorf("%%%c format requires float, not %s", c, arg.Type())
+		}
+		if !isFinite(f) {
+			field := NewSafeStringBuilder(thread)
+			if err := Float(f).format(field, c); err != nil {
+				return err
+			}
+			body = field.String()
+			if body[0] == '+' || body[0] == '-' {
+				sign, body = body[:1], body[1:]
+			}
+			break
+		}
+		numeric = true
+		if math.Signbit(f) {
+			sign = "-"
+			f = -f
+		}
+		conv := c
+		if conv == 'F' {
+			conv = 'f'
+		}
+		switch {
+		case spec.alt:
+			prec := spec.prec
+			if prec < 0 {
+				prec = 6
+			}
+			body = fmt.Sprintf("%#.*"+string(conv), prec, f)
+		case spec.prec >= 0:
+			body = strconv.FormatFloat(f, conv, spec.prec, 64)
+		default:
+			field := NewSafeStringBuilder(thread)
+			if err := Float(f).format(field, conv); err != nil {
+				return err
+			}
+			body = field.String()
+		}
+	case 'c':
+		switch arg := arg.(type) {
+		case Int:
+			// chr(int)
+			r, err := AsInt32(arg)
+			if err != nil || r < 0 || r > unicode.MaxRune {
+				return fmt.Errorf("%%c format requires a valid Unicode code point, got %s", arg)
+			}
+			body = string(rune(r))
+		case String:
+			r, size := utf8.DecodeRuneInString(string(arg))
+			if size != len(arg) || len(arg) == 0 {
+				return fmt.Errorf("%%c format requires a single-character string")
+			}
+			body = string(r)
+		default:
+			return fmt.Errorf("%%c format requires int or single-character string, not %s", arg.Type())
+		}
+	case '%':
+		body = "%"
+	default:
+		return fmt.Errorf("unknown conversion %%%c", c)
+	}
+	if numeric && sign == "" {
+		if spec.plus {
+			sign = "+"
+		} else if spec.space {
+			sign = " "
+		}
+	}
+
+	n := len(sign) + len(prefix) + zeros + utf8.RuneCountInString(body)
+	pad := 0
+	if spec.width > n {
+		pad = spec.width - n
+	}
+	buf.Grow(len(sign) + len(prefix) + zeros + len(body) + pad)
+	if err := buf.Err(); err != nil {
+		return err
+	}
+
+	if !spec.left && !(numeric && spec.zero) {
+		if err := writeRepeated(buf, ' ', pad); err != nil {
+			return err
+		}
+	}
+	if _, err := buf.WriteString(sign); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(prefix); err != nil {
+		return err
+	}
+	if !spec.left && numeric && spec.zero {
+		zeros += pad
+	}
+	if err := writeRepeated(buf, '0', zeros); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(body); err != nil {
+		return err
+	}
+	if spec.left {
+		if err := writeRepeated(buf, ' ', pad); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateRunes returns the prefix of s of at most n runes.
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// writeRepeated writes n copies of the byte b to buf.
+func writeRepeated(buf *SafeStringBuilder, b byte, n int) error {
+	var chunk [64]byte
+	for i := range chunk {
+		chunk[i] = b
+	}
+	for n > 0 {
+		m := n
+		if m > len(chunk) {
+			m = len(chunk)
+		}
+		if _, err := buf.Write(chunk[:m]); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
+
 type AllocsSafetyError struct {
 	Current SafeInteger
 	Max     int64
@@ -2802,6 +3707,79 @@ func (e *AllocsSafetyError) Is(err error) bool {
 	return err == ErrSafety
 }
 
+// A CancellationKind describes why a thread was cancelled.
+type CancellationKind int
+
+const (
+	// CancelExplicit indicates cancellation by a call to Thread.Cancel.
+	CancelExplicit CancellationKind = iota
+
+	// CancelSteps indicates that the thread's step budget, or that of its
+	// resource pool, was exhausted.
+	CancelSteps
+
+	// CancelMemory indicates that the thread's allocation budget, or that of
+	// its resource pool, was exhausted.
+	CancelMemory
+
+	// CancelContext indicates that the thread's parent context was
+	// cancelled or its deadline passed.
+	CancelContext
+
+	// CancelTimeout indicates that the timeout of the thread's policy
+	// passed, or that a builtin ran for longer than the limit set by
+	// SetMaxCallDuration.
+	CancelTimeout
+
+	// CancelCPUTime indicates that the thread's CPU time budget was
+	// exhausted.
+	CancelCPUTime
+)
+
+var cancellationKindNames = [...]string{
+	CancelExplicit: "explicit",
+	CancelSteps:    "steps",
+	CancelMemory:   "memory",
+	CancelContext:  "context",
+	CancelTimeout:  "timeout",
+	CancelCPUTime:  "cpu time",
+}
+
+func (kind CancellationKind) String() string {
+	if kind >= 0 && int(kind) < len(cancellationKindNames) {
+		return cancellationKindNames[kind]
+	}
+	return fmt.Sprintf("CancellationKind(%d)", int(kind))
+}
+
+// A CancellationError records why a thread was cancelled. Once a thread is
+// cancelled, execution fails with an error which wraps its CancellationError.
+type CancellationError struct {
+	Kind CancellationKind
+	Err  error
+}
+
+func (e *CancellationError) Error() string {
+	return "Starlark computation cancelled: " + e.Err.Error()
+}
+
+func (e *CancellationError) Unwrap() error {
+	return e.Err
+}
+
+type CollectionLenSafetyError struct {
+	Len int
+	Max int
+}
+
+func (e *CollectionLenSafetyError) Error() string {
+	return fmt.Sprintf("too many elements in collection (%d > %d)", e.Len, e.Max)
+}
+
+func (e *CollectionLenSafetyError) Is(err error) bool {
+	return err == ErrSafety
+}
+
 type StepsSafetyError struct {
 	Current SafeInteger
 	Max     int64
@@ -2824,8 +3802,17 @@ func (thread *Thread) CheckAllocs(delta SafeInteger) error {
 	thread.allocsLock.Lock()
 	defer thread.allocsLock.Unlock()
 
-	_, err := thread.simulateAllocs(delta)
-	return err
+	next, err := thread.simulateAllocs(delta)
+	if err == nil && thread.pool != nil {
+		err = thread.pool.draw(poolAllocs, delta, false)
+	}
+	if err == nil && thread.parent != nil {
+		err = thread.parent.CheckAllocs(delta)
+	}
+	if err != nil {
+		return err
+	}
+	return thread.checkAllocBudgets(next)
 }
 
 // AddAllocs reports a change in allocations associated with this thread. If
@@ -2835,16 +3822,165 @@ func (thread *Thread) CheckAllocs(delta SafeInteger) error {
 // It is safe to call AddAllocs from any goroutine, even if the thread is
 // actively executing.
 func (thread *Thread) AddAllocs(delta SafeInteger) error {
+	return thread.addAllocs(delta, true)
+}
+
+// addAllocs implements AddAllocs. As for addSteps, if profile is false,
+// the allocations are not recorded by the thread's allocation profile.
+func (thread *Thread) addAllocs(delta SafeInteger, profile bool) error {
+	// As in AddSteps, the soft limit callback runs after the lock is
+	// released.
+	crossed := false
+	defer func() {
+		if crossed {
+			thread.reportSoftLimit()
+		}
+	}()
+
 	thread.allocsLock.Lock()
 	defer thread.allocsLock.Unlock()
 
 	next, err := thread.simulateAllocs(delta)
+	if err == nil && thread.pool != nil {
+		err = thread.pool.draw(poolAllocs, delta, true)
+	}
+	if err == nil && thread.parent != nil {
+		err = thread.parent.addAllocs(delta, false)
+	}
 	thread.allocs = next
+	crossed = thread.crossSoftAllocs()
+	if thread.liveMemory != nil {
+		thread.liveMemory.noteAllocs(next)
+	}
+	if profile && thread.allocProfile != nil {
+		thread.recordAllocs(delta)
+	}
 	if err != nil {
-		thread.cancel(err)
+		return thread.cancel(CancelMemory, err)
 	}
 
-	return err
+	// Exceeding a builtin's budget fails only the builtin's call, so the
+	// thread is not cancelled.
+	return thread.checkAllocBudgets(next)
+}
+
+// ReleaseAllocs reports that memory previously counted by AddAllocs has
+// been released, crediting it back to the thread's allocation budget, to
+// any ResourcePool to which the thread is attached and, for a spawned
+// thread, to its parent. It is the counterpart of AddAllocs for builtins
+// which provably release large temporaries or backing storage, so that
+// long-running programs which build and discard large intermediate values
+// are not cancelled for memory they no longer hold.
+//
+// Only memory which is unreachable once released should be credited. The
+// thread's count never falls below zero, and releasing memory never
+// cancels the thread, though an error is returned if the thread has
+// already been cancelled.
+//
+// It is safe to call ReleaseAllocs from any goroutine, even if the thread
+// is actively executing.
+func (thread *Thread) ReleaseAllocs(size SafeInteger) error {
+	return thread.releaseAllocs(size, true)
+}
+
+// releaseAllocs implements ReleaseAllocs. As for addSteps, if profile is
+// false, the release is not recorded by the thread's allocation profile.
+func (thread *Thread) releaseAllocs(size SafeInteger, profile bool) error {
+	size64, ok := size.Int64()
+	if !ok || size64 < 0 {
+		return errAllocCountInvalidated
+	}
+	if err := thread.cancelled(); err != nil {
+		return err
+	}
+
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
+	allocs, ok := thread.allocs.Int64()
+	if !ok {
+		return errAllocCountInvalidated
+	}
+	if size64 > allocs {
+		size64 = allocs
+	}
+	delta := SafeNeg(SafeInt(size64))
+	if thread.pool != nil {
+		if err := thread.pool.draw(poolAllocs, delta, true); err != nil {
+			return err
+		}
+	}
+	if thread.parent != nil {
+		if err := thread.parent.releaseAllocs(SafeInt(size64), false); err != nil {
+			return err
+		}
+	}
+	thread.allocs = SafeAdd(thread.allocs, delta)
+	if profile && thread.allocProfile != nil {
+		thread.recordAllocs(delta)
+	}
+	return nil
+}
+
+// An allocBudget limits the allocations which may be made during a call to
+// a builtin. See Builtin.SetMaxAllocs.
+type allocBudget struct {
+	start SafeInteger
+	max   int64
+}
+
+// checkAllocBudgets returns an error if the given total allocations would
+// exceed the budget of any builtin currently being called.
+func (thread *Thread) checkAllocBudgets(next SafeInteger) error {
+	for _, budget := range thread.allocBudgets {
+		used, ok := SafeSub(next, budget.start).Int64()
+		if !ok {
+			return errAllocCountInvalidated
+		}
+		if used > budget.max {
+			return &AllocsSafetyError{
+				Current: SafeSub(thread.allocs, budget.start),
+				Max:     budget.max,
+			}
+		}
+	}
+	return nil
+}
+
+// pushAllocBudget starts a new allocation budget of the given size.
+func (thread *Thread) pushAllocBudget(max int64) *allocBudget {
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
+	budget := &allocBudget{start: thread.allocs, max: max}
+	thread.allocBudgets = append(thread.allocBudgets, budget)
+	return budget
+}
+
+// popAllocBudget ends the given allocation budget, which must be the most
+// recently pushed, returning an error if it was exceeded.
+func (thread *Thread) popAllocBudget(budget *allocBudget) error {
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
+	n := len(thread.allocBudgets) - 1
+	if n < 0 || thread.allocBudgets[n] != budget {
+		panic("alloc budgets popped out of order")
+	}
+	thread.allocBudgets[n] = nil
+	thread.allocBudgets = thread.allocBudgets[:n]
+
+	used, ok := SafeSub(thread.allocs, budget.start).Int64()
+	if !ok {
+		return errAllocCountInvalidated
+	}
+	if used > budget.max {
+		return &AllocsSafetyError{
+			Current: SafeInt(used),
+			Max:     budget.max,
+		}
+	}
+	return nil
 }
 
 var errAllocCountInvalidated = errors.New("alloc count invalidated")
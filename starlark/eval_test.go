@@ -16,6 +16,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	gotime "time"
 
@@ -38,10 +39,13 @@ import (
 func getOptions(src string) *syntax.FileOptions {
 	return &syntax.FileOptions{
 		Set:               option(src, "set"),
+		StrBuilder:        option(src, "strbuilder"),
 		While:             option(src, "while"),
 		TopLevelControl:   option(src, "toplevelcontrol"),
 		GlobalReassign:    option(src, "globalreassign"),
 		LoadBindsGlobally: option(src, "loadbindsglobally"),
+		SliceAssign:       option(src, "sliceassign"),
+		StarAssign:        option(src, "starassign"),
 		Recursion:         option(src, "recursion"),
 	}
 }
@@ -577,6 +581,81 @@ Error in join: join: in list, want string, got int`,
 	}
 }
 
+func TestEvalErrorUsage(t *testing.T) {
+	const src = `
+def spin():
+	for i in range(1000): pass
+def grow():
+	x = []
+	for i in range(1000): x.append([i])
+def f(fn):
+	return fn()
+f(%s)
+`
+	tests := []struct {
+		name  string
+		fn    string
+		setup func(thread *starlark.Thread)
+	}{{
+		name:  "steps",
+		fn:    "spin",
+		setup: func(thread *starlark.Thread) { thread.SetMaxSteps(1500) },
+	}, {
+		name:  "allocs",
+		fn:    "grow",
+		setup: func(thread *starlark.Thread) { thread.SetMaxAllocs(10000) },
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{}
+			test.setup(thread)
+			_, err := starlark.ExecFile(thread, "usage.star", fmt.Sprintf(src, test.fn), nil)
+			evalErr, ok := err.(*starlark.EvalError)
+			if !ok {
+				t.Fatalf("expected EvalError, got %v", err)
+			}
+
+			usage := evalErr.Usage
+			if len(usage) != len(evalErr.CallStack) {
+				t.Fatalf("got %d usage entries for %d frames", len(usage), len(evalErr.CallStack))
+			}
+			for i, u := range usage {
+				if name := evalErr.CallStack[i].Name; u.Name != name {
+					t.Errorf("usage entry %d is for %s, expected %s", i, u.Name, name)
+				}
+				if i > 0 && (u.Steps > usage[i-1].Steps || u.Allocs > usage[i-1].Allocs) {
+					t.Errorf("%s used more than its caller %s", u.Name, usage[i-1].Name)
+				}
+			}
+			if top := usage[len(usage)-1].Name; top != test.fn {
+				t.Errorf("topmost frame is %s, expected %s", top, test.fn)
+			}
+			if steps, _ := thread.Steps(); usage[0].Steps != steps {
+				t.Errorf("toplevel used %d steps, expected %d", usage[0].Steps, steps)
+			}
+			if allocs, _ := thread.Allocs(); usage[0].Allocs > allocs {
+				t.Errorf("toplevel used %d allocs, more than the thread's %d", usage[0].Allocs, allocs)
+			}
+			if backtrace := evalErr.Backtrace(); !strings.Contains(backtrace, "Resources used") {
+				t.Errorf("backtrace does not report resources used:\n%s", backtrace)
+			}
+		})
+	}
+
+	t.Run("explicit", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Cancel("done")
+		_, err := starlark.ExecFile(thread, "usage.star", fmt.Sprintf(src, "spin"), nil)
+		evalErr, ok := err.(*starlark.EvalError)
+		if !ok {
+			t.Fatalf("expected EvalError, got %v", err)
+		}
+		if evalErr.Usage != nil {
+			t.Errorf("unexpected usage for explicit cancellation: %v", evalErr.Usage)
+		}
+	})
+}
+
 func TestLoadBacktrace(t *testing.T) {
 	// This test ensures that load() does NOT preserve stack traces,
 	// but that API callers can get them with Unwrap().
@@ -1442,6 +1521,72 @@ func TestCancelConsistency(t *testing.T) {
 	}
 }
 
+func TestCancellationKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   starlark.CancellationKind
+		wraps  error
+		cancel func(thread *starlark.Thread) error
+	}{{
+		name: "explicit",
+		kind: starlark.CancelExplicit,
+		cancel: func(thread *starlark.Thread) error {
+			thread.Cancel("stop")
+			return nil
+		},
+	}, {
+		name:  "steps",
+		kind:  starlark.CancelSteps,
+		wraps: starlark.ErrSafety,
+		cancel: func(thread *starlark.Thread) error {
+			thread.SetMaxSteps(10)
+			return thread.AddSteps(starlark.SafeInt(11))
+		},
+	}, {
+		name:  "memory",
+		kind:  starlark.CancelMemory,
+		wraps: starlark.ErrSafety,
+		cancel: func(thread *starlark.Thread) error {
+			thread.SetMaxAllocs(10)
+			return thread.AddAllocs(starlark.SafeInt(11))
+		},
+	}, {
+		name:  "context",
+		kind:  starlark.CancelContext,
+		wraps: context.Canceled,
+		cancel: func(thread *starlark.Thread) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			thread.SetParentContext(ctx)
+			cancel()
+			<-thread.Context().Done()
+			return nil
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{}
+			if err := test.cancel(thread); err != nil {
+				var cancellation *starlark.CancellationError
+				if !errors.As(err, &cancellation) {
+					t.Errorf("budget exhaustion returned %T, want cancellation", err)
+				}
+			}
+
+			_, err := starlark.ExecFile(thread, "cancelled.star", "x = 1", nil)
+			var cancellation *starlark.CancellationError
+			if !errors.As(err, &cancellation) {
+				t.Fatalf("execution returned error %v, want cancellation", err)
+			}
+			if cancellation.Kind != test.kind {
+				t.Errorf("incorrect kind: expected %v but got %v", test.kind, cancellation.Kind)
+			}
+			if test.wraps != nil && !errors.Is(err, test.wraps) {
+				t.Errorf("cancellation does not wrap %v: %v", test.wraps, err)
+			}
+		})
+	}
+}
+
 func TestOverflowingPositiveDeltaStep(t *testing.T) {
 	thread := &starlark.Thread{}
 	thread.SetMaxSteps(math.MaxInt64)
@@ -1614,7 +1759,7 @@ func TestAddStepsFail(t *testing.T) {
 	expectedSteps += 2 * maxValidSteps
 	if err := thread.AddSteps(starlark.SafeMul(2, maxValidSteps)); err == nil {
 		t.Errorf("expected error")
-	} else if err.Error() != "too many steps" {
+	} else if err.Error() != "Starlark computation cancelled: too many steps" {
 		t.Errorf("unexpected error: %v", err)
 	} else if steps, ok := thread.Steps(); !ok {
 		t.Fatal("step count invalidated")
@@ -1670,6 +1815,51 @@ func TestConcurrentAddStepsUsage(t *testing.T) {
 	}
 }
 
+func TestConcurrentAddStepsCap(t *testing.T) {
+	const maxSteps = 10_000
+	const goroutines = 16
+
+	thread := &starlark.Thread{}
+	thread.SetMaxSteps(maxSteps)
+
+	var accepted int64
+	wg := sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < maxSteps; j++ {
+				if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+					return
+				}
+				atomic.AddInt64(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != maxSteps {
+		t.Errorf("concurrent thread.AddSteps accepted %d steps, expected exactly %d", accepted, maxSteps)
+	}
+}
+
+func TestConcurrentSetMaxSteps(t *testing.T) {
+	thread := &starlark.Thread{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			thread.SetMaxSteps(int64(1_000_000 - i))
+		}
+	}()
+	_, err := starlark.ExecFile(thread, "loop.star", "[i for i in range(10000)]", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	<-done
+}
+
 func TestThreadPermits(t *testing.T) {
 	const threadSafety = starlark.CPUSafe | starlark.MemSafe
 	t.Run("Safety=Allowed", func(t *testing.T) {
@@ -2433,6 +2623,20 @@ func TestSafeBinary(t *testing.T) {
 			},
 			minSteps: 2 * int64(len(`x`)),
 			maxSteps: int64(len(`["x", "x"]`)),
+		}, {
+			name: "string % width",
+			op:   syntax.PERCENT,
+			left: constant(starlark.String("%*s")),
+			right: func(thread *starlark.Thread, n int) (starlark.Value, error) {
+				if thread != nil {
+					if err := thread.AddAllocs(starlark.EstimateMakeSize(starlark.Tuple{}, starlark.SafeInt(2))); err != nil {
+						return nil, err
+					}
+				}
+				return starlark.Tuple{starlark.MakeInt(n), starlark.String("x")}, nil
+			},
+			minSteps: 1,
+			maxSteps: 1,
 		}}
 		for _, test := range tests {
 			test.Run(t)
@@ -2778,3 +2982,160 @@ func TestSafeStringBuilderNilThread(t *testing.T) {
 		}
 	})
 }
+
+func TestMaxCollectionLen(t *testing.T) {
+	const max = 10
+	tests := []struct {
+		name, src string
+		ok        bool
+	}{
+		{"list-literal", "[0, 1, 2]", true},
+		{"list-literal-long", "[0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0]", false},
+		{"list-comprehension", "[i for i in range(10)]", true},
+		{"list-comprehension-long", "[i for i in range(11)]", false},
+		{"list-builtin", "list(range(11))", false},
+		{"list-append", "l = [0] * 10\nl.append(1)", false},
+		{"list-insert", "l = [0] * 10\nl.insert(0, 1)", false},
+		{"list-extend", "l = [0] * 5\nl.extend(range(6))", false},
+		{"list-augmented", "def f():\n\tl = [0] * 5\n\tl += [1] * 6\nf()", false},
+		{"list-concat", "[0] * 5 + [1] * 6", false},
+		{"list-repeat", "[0] * 11", false},
+		{"sorted", "sorted(range(11))", false},
+		{"dict-comprehension", "{i: i for i in range(10)}", true},
+		{"dict-comprehension-long", "{i: i for i in range(11)}", false},
+		{"dict-update-existing", "d = {i: i for i in range(10)}\nd[0] = 1", true},
+		{"dict-setitem", "d = {i: i for i in range(10)}\nd[10] = 1", false},
+		{"set", "set(range(11))", false},
+		{"dict-builtin", "dict([(i, i) for i in range(10)], a = 1)", false},
+		{"dict-builtin-mapping", "dict(big)", false},
+		{"split", "('a,' * 10).split(',')", false},
+		{"split-short", "('a,' * 9).split(',')", true},
+		{"rsplit", "('a,' * 10).rsplit(',')", false},
+		{"split-whitespace", "('a ' * 11).split()", false},
+		{"splitlines", "('a\\n' * 11).splitlines()", false},
+		{"enumerate", "enumerate(range(11))", false},
+		{"enumerate-short", "enumerate(range(10))", true},
+		{"enumerate-iterable", "enumerate(iter(11))", false},
+		{"zip", "zip(range(11))", false},
+		{"zip-short", "zip(range(10), range(11))", true},
+		{"zip-iterable", "zip(iter(11))", false},
+		{"zip-iterable-short", "zip(iter(10))", true},
+		{"sorted-iterable", "sorted(iter(11))", false},
+		{"list-iterable", "list(iter(11))", false},
+		{"dict-items", "big.items()", false},
+		{"dict-keys", "big.keys()", false},
+		{"dict-values", "big.values()", false},
+	}
+	big := starlark.NewDict(max + 1)
+	for i := 0; i <= max; i++ {
+		big.SetKey(starlark.MakeInt(i), starlark.None)
+	}
+	iter := starlark.NewBuiltin("iter", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var n int
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &n); err != nil {
+			return nil, err
+		}
+		return &testIterable{
+			maxN: n,
+			nth: func(_ *starlark.Thread, n int) (starlark.Value, error) {
+				return starlark.MakeInt(n), nil
+			},
+		}, nil
+	})
+	predeclared := starlark.StringDict{
+		"big":  big,
+		"iter": iter,
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{}
+			thread.SetMaxCollectionLen(max)
+			_, err := starlark.ExecFileOptions(&syntax.FileOptions{Set: true}, thread, "collection.star", test.src, predeclared)
+			if test.ok {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var lenErr *starlark.CollectionLenSafetyError
+			if err == nil {
+				t.Error("expected error")
+			} else if !errors.As(err, &lenErr) {
+				t.Errorf("unexpected error: %v", err)
+			} else if lenErr.Max != max {
+				t.Errorf("incorrect limit reported: expected %d but got %d", max, lenErr.Max)
+			}
+		})
+	}
+}
+
+func TestThreadFileOptions(t *testing.T) {
+	const src = `
+s = set([1, 2])
+def fib(n):
+	return n if n < 2 else fib(n - 1) + fib(n - 2)
+x = fib(10)
+`
+	opts := &syntax.FileOptions{Set: true, Recursion: true}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			thread := &starlark.Thread{}
+			if i%2 == 0 {
+				thread.SetFileOptions(opts)
+			}
+			_, errs[i] = starlark.ExecFile(thread, "options.star", src, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if i%2 == 0 {
+			if err != nil {
+				t.Errorf("thread %d: unexpected error: %v", i, err)
+			}
+		} else if err == nil {
+			t.Errorf("thread %d: expected error", i)
+		}
+	}
+
+	parent := &starlark.Thread{}
+	parent.SetFileOptions(opts)
+	child := parent.Spawn("child")
+	if child.FileOptions() != opts {
+		t.Error("spawned thread did not inherit file options")
+	}
+	if _, err := starlark.Eval(child, "child.star", "set([1])", nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if (&starlark.Thread{}).FileOptions() == nil {
+		t.Error("default file options are nil")
+	}
+}
+
+func TestProgramOptions(t *testing.T) {
+	opts := &syntax.FileOptions{Set: true}
+	_, prog, err := starlark.SourceProgramOptions(opts, "prog.star", "x = set()", func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prog.Options() != opts {
+		t.Errorf("program options not recorded: got %v", prog.Options())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := prog.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := starlark.CompiledProgram(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Options() != nil {
+		t.Errorf("compiled program has options: %v", decoded.Options())
+	}
+}
@@ -5,6 +5,7 @@
 package starlark_test
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
@@ -163,6 +164,49 @@ func ExampleThread_Load_parallel() {
 	// c = 2
 }
 
+// ExampleThread_AddSteps demonstrates a built-in function which
+// charges the calling thread for the work it does, so that it can be
+// called safely by threads with a step budget.
+func ExampleThread_AddSteps() {
+	const data = `
+print(checksum("abc"))
+print(checksum("abc" * 1000))
+`
+
+	// checksum(s) sums the bytes of s, taking one step per byte.
+	checksum := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var s string
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+			return nil, err
+		}
+		if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+			return nil, err
+		}
+		sum := 0
+		for i := 0; i < len(s); i++ {
+			sum += int(s[i])
+		}
+		return starlark.MakeInt(sum), nil
+	}
+
+	thread := &starlark.Thread{
+		Name:  "example",
+		Print: func(_ *starlark.Thread, msg string) { fmt.Println(msg) },
+	}
+	thread.RequireSafety(starlark.CPUSafe)
+	thread.SetMaxSteps(1000)
+
+	predeclared := starlark.StringDict{
+		"checksum": starlark.NewBuiltinWithSafety("checksum", starlark.CPUSafe, checksum),
+	}
+	_, err := starlark.ExecFile(thread, "checksum.star", data, predeclared)
+	fmt.Println(errors.Is(err, starlark.ErrSafety))
+
+	// Output:
+	// 294
+	// true
+}
+
 // TestThread_Load_parallelCycle demonstrates detection
 // of cycles during parallel loading.
 func TestThreadLoad_ParallelCycle(t *testing.T) {
@@ -6,6 +6,11 @@ import (
 
 var AfterFunc = afterFunc
 
+func InferredSafetyCached(fn *Function) bool {
+	_, ok := fn.module.inferredSafety.Load(fn.funcode)
+	return ok
+}
+
 func ThreadSafety(thread *Thread) SafetyFlags {
 	return thread.requiredSafety
 }
@@ -22,9 +27,15 @@ var BytesMethodSafeties = bytesMethodSafeties
 var DictMethods = dictMethods
 var DictMethodSafeties = dictMethodSafeties
 
+var IntMethods = intMethods
+var IntMethodSafeties = intMethodSafeties
+
 var ListMethods = listMethods
 var ListMethodSafeties = listMethodSafeties
 
+var StrbuilderMethods = strbuilderMethods
+var StrbuilderMethodSafeties = strbuilderMethodSafeties
+
 var StringMethods = stringMethods
 var StringMethodSafeties = stringMethodSafeties
 
@@ -83,3 +94,14 @@ func (s String) Codepoints(ords bool) Value {
 func (s String) Elems(ords bool) Value {
 	return stringElems{s, ords}
 }
+
+// BucketListSteps returns the steps charged for inspecting the first n
+// buckets of a hash table's bucket list.
+func BucketListSteps(n int) int64 {
+	total := SafeInt(0)
+	for depth := 0; depth < n; depth++ {
+		total = SafeAdd(total, bucketSteps(depth))
+	}
+	steps, _ := total.Int64()
+	return steps
+}
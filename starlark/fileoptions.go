@@ -0,0 +1,30 @@
+package starlark
+
+import "github.com/canonical/starlark/syntax"
+
+// SetFileOptions sets the options with which ExecFile, Eval and EvalExpr
+// parse and resolve code run on this thread, so that embedders which need
+// different dialects may run them concurrently without modifying the legacy
+// global variables of the resolve package. Threads created by Spawn inherit
+// these options. If opts is nil, syntax.LegacyFileOptions is used.
+//
+// SetFileOptions must be called before execution begins.
+func (thread *Thread) SetFileOptions(opts *syntax.FileOptions) {
+	thread.fileOptions = opts
+}
+
+// FileOptions returns the options set by SetFileOptions or, if none were
+// set, a new syntax.LegacyFileOptions.
+func (thread *Thread) FileOptions() *syntax.FileOptions {
+	if thread.fileOptions == nil {
+		return syntax.LegacyFileOptions()
+	}
+	return thread.fileOptions
+}
+
+// Options returns the options with which the program was parsed and
+// resolved. A program read by CompiledProgram does not record them, so for
+// such a program Options returns nil.
+func (prog *Program) Options() *syntax.FileOptions {
+	return prog.options
+}
@@ -0,0 +1,62 @@
+package starlark
+
+// This file defines the recording of where values were frozen.
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// RecordFreezeSites, if true, causes lists, dicts and sets to record the Go
+// call stack at which they were frozen. Errors caused by attempts to mutate
+// such a value then report where it was frozen, which helps to identify, for
+// example, the module whose execution froze a shared list.
+//
+// Recording is expensive and is intended for debugging. RecordFreezeSites
+// must not be changed while Starlark values are being frozen.
+var RecordFreezeSites = false
+
+// A freezeSite describes where a value was frozen.
+type freezeSite struct {
+	goStack string
+}
+
+// newFreezeSite returns the current freeze site, or nil if freeze sites are
+// not being recorded.
+func newFreezeSite() *freezeSite {
+	if !RecordFreezeSites {
+		return nil
+	}
+
+	var pcs [32]uintptr
+	n := runtime.Callers(2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	var buf strings.Builder
+	skipping := true
+	for {
+		frame, more := frames.Next()
+		// Omit the recursive calls which froze the value's container.
+		if skipping && (strings.HasSuffix(frame.Function, ".Freeze") || strings.HasSuffix(frame.Function, ".freeze")) {
+			if !more {
+				break
+			}
+			continue
+		}
+		skipping = false
+		fmt.Fprintf(&buf, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return &freezeSite{goStack: buf.String()}
+}
+
+// frozenError returns the error reported by an attempt to mutate a value
+// which was frozen at site, which may be nil.
+func frozenError(verb, typ string, site *freezeSite) error {
+	if site == nil {
+		return fmt.Errorf("cannot %s frozen %s", verb, typ)
+	}
+	return fmt.Errorf("cannot %s frozen %s\nfrozen at:\n%s", verb, typ, site.goStack)
+}
@@ -0,0 +1,62 @@
+package starlark_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestFreezeSites(t *testing.T) {
+	defer func(record bool) { starlark.RecordFreezeSites = record }(starlark.RecordFreezeSites)
+
+	const src = `
+l = [[]]
+d = {"k": {}}
+`
+	exec := func(t *testing.T) starlark.StringDict {
+		globals, err := starlark.ExecFile(&starlark.Thread{}, "freeze.star", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return globals
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		starlark.RecordFreezeSites = false
+		globals := exec(t)
+		err := globals["l"].(*starlark.List).Append(starlark.None)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if expected := "cannot append to frozen list"; err.Error() != expected {
+			t.Errorf("unexpected error: expected %q but got %q", expected, err.Error())
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		starlark.RecordFreezeSites = true
+		globals := exec(t)
+		mutations := map[string]error{
+			"list":        globals["l"].(*starlark.List).Append(starlark.None),
+			"nested list": globals["l"].(*starlark.List).Index(0).(*starlark.List).Append(starlark.None),
+			"dict":        globals["d"].(*starlark.Dict).SetKey(starlark.String("k"), starlark.None),
+		}
+		for name, err := range mutations {
+			if err == nil {
+				t.Errorf("%s: expected error", name)
+				continue
+			}
+			msg := err.Error()
+			if !strings.Contains(msg, "frozen at:\n") {
+				t.Errorf("%s: error does not report freeze site: %s", name, msg)
+			}
+			if !strings.Contains(msg, "starlark.ExecFileOptions") || !strings.Contains(msg, "TestFreezeSites") {
+				t.Errorf("%s: freeze site does not include caller: %s", name, msg)
+			}
+			if strings.Contains(msg, ".Freeze\n") {
+				t.Errorf("%s: freeze site includes calls to Freeze: %s", name, msg)
+			}
+		}
+	})
+}
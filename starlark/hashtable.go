@@ -5,9 +5,14 @@
 package starlark
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"os"
+	"strconv"
 )
 
 // hashtable is used to represent Starlark dict and set values.
@@ -23,6 +28,11 @@ type hashtable struct {
 	head      *entry  // insertion order doubly-linked list; may be nil
 	tailLink  **entry // address of nil link at end of list (perhaps &head)
 	frozen    bool
+	frozenAt  *freezeSite // where the table was frozen, if recorded
+
+	// shared records that the table and entries belong to a frozen
+	// hashtable, so must be copied by unshare before they are modified.
+	shared bool
 
 	_ noCopy // triggers vet copylock check on this type.
 }
@@ -36,6 +46,22 @@ func (*noCopy) Unlock() {}
 
 const bucketSize = 8
 
+// maxBucketDepth is the number of buckets in a bucket list beyond which the
+// list is considered degenerate. With well-distributed hashes, bucket lists
+// this long are vanishingly rare.
+const maxBucketDepth = 4
+
+// bucketSteps returns the number of steps charged for inspecting the bucket
+// at the given depth (counting from zero) in a bucket list. Buckets beyond
+// maxBucketDepth are charged extra steps, increasing with depth, so that a
+// script which provokes collisions pays for the work they cause.
+func bucketSteps(depth int) SafeInteger {
+	if depth < maxBucketDepth {
+		return SafeInt(1)
+	}
+	return SafeAdd(1, SafeMul(depth-maxBucketDepth+1, bucketSize))
+}
+
 type bucket struct {
 	entries [bucketSize]entry
 	next    *bucket // linked list of buckets
@@ -52,10 +78,31 @@ func (ht *hashtable) init(thread *Thread, size int) error {
 	if size < 0 {
 		panic("size < 0")
 	}
+	nb, err := tableBuckets(size)
+	if err != nil {
+		return err
+	}
+	if nb < 2 {
+		ht.table = ht.bucket0[:1]
+	} else {
+		if thread != nil {
+			if err := thread.AddAllocs(EstimateMakeSize([]bucket{}, SafeInt(nb))); err != nil {
+				return err
+			}
+		}
+		ht.table = make([]bucket, nb)
+	}
+	ht.tailLink = &ht.head
+	return nil
+}
+
+// tableBuckets returns the number of buckets in the table of a hashtable
+// initialized to hold size entries.
+func tableBuckets(size int) (int, error) {
 	nb := SafeInt(1)
 	for {
 		if over, err := overloaded(size, nb); err != nil {
-			return err
+			return 0, err
 		} else if !over {
 			break
 		}
@@ -63,25 +110,50 @@ func (ht *hashtable) init(thread *Thread, size int) error {
 	}
 	nbInt, ok := nb.Int()
 	if !ok {
-		return errors.New("hashtable size overflow")
+		return 0, errors.New("hashtable size overflow")
 	}
-	if nbInt < 2 {
-		ht.table = ht.bucket0[:1]
-	} else {
-		if thread != nil {
-			if err := thread.AddAllocs(EstimateMakeSize([]bucket{}, nb)); err != nil {
-				return err
-			}
-		}
-		ht.table = make([]bucket, nbInt)
+	return nbInt, nil
+}
+
+// tableSize returns the memory allocated for the table of a hashtable
+// initialized to hold size entries. The first bucket is stored inline.
+func tableSize(size int) (SafeInteger, error) {
+	nb, err := tableBuckets(size)
+	if err != nil {
+		return SafeInt(0), err
 	}
-	ht.tailLink = &ht.head
-	return nil
+	if nb < 2 {
+		return SafeInt(0), nil
+	}
+	return EstimateMakeSize([]bucket{}, SafeInt(nb)), nil
+}
+
+// capacity returns the number of entries the hashtable may hold before
+// inserting another causes its table to grow.
+func (ht *hashtable) capacity() int {
+	nb := len(ht.table)
+	if nb == 0 {
+		nb = 1
+	}
+	capacity := int(math.Ceil(maxLoadFactor * float64(nb)))
+	if capacity < bucketSize {
+		capacity = bucketSize
+	}
+	return capacity
+}
+
+// loadFactor returns the mean number of entries per bucket of the table.
+func (ht *hashtable) loadFactor() float64 {
+	if len(ht.table) == 0 {
+		return 0
+	}
+	return float64(ht.len) / float64(len(ht.table))
 }
 
 func (ht *hashtable) freeze() {
 	if !ht.frozen {
 		ht.frozen = true
+		ht.frozenAt = newFreezeSite()
 		for e := ht.head; e != nil; e = e.next {
 			e.key.Freeze()
 			e.value.Freeze()
@@ -89,6 +161,67 @@ func (ht *hashtable) freeze() {
 	}
 }
 
+// share makes the newly created, empty hashtable ht share the entries of
+// the frozen hashtable src until ht is first modified, so that copying a
+// frozen structure costs only the hashtable itself.
+func (ht *hashtable) share(src *hashtable) {
+	if src.len == 0 {
+		return
+	}
+	ht.table = src.table
+	ht.head = src.head
+	ht.tailLink = src.tailLink
+	ht.len = src.len
+	ht.shared = true
+}
+
+// unshare gives ht its own copy of any entries shared by share, reporting
+// the copy to thread. If the thread's limits do not allow the copy, ht is
+// left unchanged.
+func (ht *hashtable) unshare(thread *Thread) error {
+	if !ht.shared {
+		return nil
+	}
+	if thread != nil {
+		if err := thread.AddSteps(SafeInt(ht.len)); err != nil {
+			return err
+		}
+	}
+	table, head, tailLink, n := ht.table, ht.head, ht.tailLink, ht.len
+	ht.table, ht.head, ht.len, ht.shared = nil, nil, 0, false
+	if err := ht.init(nil, int(n)); err != nil {
+		return err
+	}
+	for e := head; e != nil; e = e.next {
+		// The keys are known to be hashable and distinct.
+		ht.insert(nil, e.key, e.value)
+	}
+	if thread != nil {
+		if err := thread.AddAllocs(ht.entriesSize()); err != nil {
+			ht.table, ht.head, ht.tailLink, ht.len, ht.shared = table, head, tailLink, n, true
+			ht.bucket0[0] = bucket{}
+			return err
+		}
+	}
+	return nil
+}
+
+// entriesSize estimates the memory held by the hashtable's table and its
+// overflow buckets, excluding the keys and values the entries refer to.
+func (ht *hashtable) entriesSize() SafeInteger {
+	size := SafeInt(0)
+	if len(ht.table) > 1 {
+		size = EstimateMakeSize([]bucket{}, SafeInt(len(ht.table)))
+	}
+	overflowSize := EstimateSize(&bucket{})
+	for i := range ht.table {
+		for b := ht.table[i].next; b != nil; b = b.next {
+			size = SafeAdd(size, overflowSize)
+		}
+	}
+	return size
+}
+
 func (ht *hashtable) insert(thread *Thread, k, v Value) error {
 	if err := CheckSafety(thread, CPUSafe|MemSafe|TimeSafe|IOSafe); err != nil {
 		return err
@@ -96,10 +229,13 @@ func (ht *hashtable) insert(thread *Thread, k, v Value) error {
 	if err := ht.checkMutable("insert into"); err != nil {
 		return err
 	}
+	if err := ht.unshare(thread); err != nil {
+		return err
+	}
 	if ht.table == nil {
 		ht.init(thread, 1)
 	}
-	h, err := k.Hash()
+	h, err := safeHash(thread, k)
 	if err != nil {
 		return err
 	}
@@ -112,9 +248,9 @@ retry:
 
 	// Inspect each bucket in the bucket list.
 	p := &ht.table[h&(uint32(len(ht.table)-1))]
-	for {
+	for depth := 0; ; depth++ {
 		if thread != nil {
-			if err := thread.AddSteps(SafeInt(1)); err != nil {
+			if err := thread.AddSteps(bucketSteps(depth)); err != nil {
 				return err
 			}
 		}
@@ -127,7 +263,7 @@ retry:
 				}
 				continue
 			}
-			if eq, err := Equal(k, e.key); err != nil {
+			if eq, err := safeEqual(thread, k, e.key); err != nil {
 				return err // e.g. excessively recursive tuple
 			} else if !eq {
 				continue
@@ -144,6 +280,10 @@ retry:
 
 	// Key not found.  p points to the last bucket.
 
+	if err := thread.CheckCollectionLen(int(ht.len) + 1); err != nil {
+		return err
+	}
+
 	// Does the number of elements exceed the buckets' load factor?
 	if overloaded, err := overloaded(int(ht.len), SafeInt(len(ht.table))); err != nil {
 		return err
@@ -181,13 +321,16 @@ retry:
 	return nil
 }
 
+// maxLoadFactor is the mean number of entries per bucket beyond which a
+// hashtable's table grows.
+const maxLoadFactor = 6.5 // just a guess
+
 func overloaded(elems int, buckets SafeInteger) (bool, error) {
-	const loadFactor = 6.5 // just a guess
 	bucketsInt, ok := buckets.Int()
 	if !ok {
 		return false, errors.New("hashtable bucket count invalidated")
 	}
-	return elems >= bucketSize && float64(elems) >= loadFactor*float64(bucketsInt), nil
+	return elems >= bucketSize && float64(elems) >= maxLoadFactor*float64(bucketsInt), nil
 }
 
 func (ht *hashtable) grow(thread *Thread) error {
@@ -226,7 +369,7 @@ func (ht *hashtable) lookup(thread *Thread, k Value) (v Value, found bool, err e
 	if err := CheckSafety(thread, CPUSafe|MemSafe|TimeSafe|IOSafe); err != nil {
 		return nil, false, err
 	}
-	h, err := k.Hash()
+	h, err := safeHash(thread, k)
 	if err != nil {
 		return nil, false, err // unhashable
 	}
@@ -238,16 +381,18 @@ func (ht *hashtable) lookup(thread *Thread, k Value) (v Value, found bool, err e
 	}
 
 	// Inspect each bucket in the bucket list.
+	depth := 0
 	for p := &ht.table[h&(uint32(len(ht.table)-1))]; p != nil; p = p.next {
 		if thread != nil {
-			if err := thread.AddSteps(SafeInt(1)); err != nil {
+			if err := thread.AddSteps(bucketSteps(depth)); err != nil {
 				return nil, false, err
 			}
 		}
+		depth++
 		for i := range p.entries {
 			e := &p.entries[i]
 			if e.hash == h {
-				if eq, err := Equal(k, e.key); err != nil {
+				if eq, err := safeEqual(thread, k, e.key); err != nil {
 					return nil, false, err // e.g. excessively recursive tuple
 				} else if eq {
 					return e.value, true, nil // found
@@ -288,7 +433,7 @@ func (ht *hashtable) count(thread *Thread, iter Iterator) (int, error) {
 		bitsets[i].SetBits(storage[i : i+1 : i+1])
 	}
 	for iter.Next(&k) && count != int(ht.len) {
-		h, err := k.Hash()
+		h, err := safeHash(thread, k)
 		if err != nil {
 			return 0, err // unhashable
 		}
@@ -301,14 +446,14 @@ func (ht *hashtable) count(thread *Thread, iter Iterator) (int, error) {
 		i := 0
 		for p := &ht.table[bucketId]; p != nil; p = p.next {
 			if thread != nil {
-				if err := thread.AddSteps(SafeInt(1)); err != nil {
+				if err := thread.AddSteps(bucketSteps(i)); err != nil {
 					return 0, err
 				}
 			}
 			for j := range p.entries {
 				e := &p.entries[j]
 				if e.hash == h {
-					if eq, err := Equal(k, e.key); err != nil {
+					if eq, err := safeEqual(thread, k, e.key); err != nil {
 						return 0, err
 					} else if eq {
 						bitIndex := i<<3 + j
@@ -370,10 +515,13 @@ func (ht *hashtable) delete(thread *Thread, k Value) (v Value, found bool, err e
 	if err := ht.checkMutable("delete from"); err != nil {
 		return nil, false, err
 	}
+	if err := ht.unshare(thread); err != nil {
+		return nil, false, err
+	}
 	if ht.table == nil {
 		return None, false, nil // empty
 	}
-	h, err := k.Hash()
+	h, err := safeHash(thread, k)
 	if err != nil {
 		return nil, false, err // unhashable
 	}
@@ -382,16 +530,18 @@ func (ht *hashtable) delete(thread *Thread, k Value) (v Value, found bool, err e
 	}
 
 	// Inspect each bucket in the bucket list.
+	depth := 0
 	for p := &ht.table[h&(uint32(len(ht.table)-1))]; p != nil; p = p.next {
 		if thread != nil {
-			if err := thread.AddSteps(SafeInt(1)); err != nil {
+			if err := thread.AddSteps(bucketSteps(depth)); err != nil {
 				return nil, false, err
 			}
 		}
+		depth++
 		for i := range p.entries {
 			e := &p.entries[i]
 			if e.hash == h {
-				if eq, err := Equal(k, e.key); err != nil {
+				if eq, err := safeEqual(thread, k, e.key); err != nil {
 					return nil, false, err
 				} else if eq {
 					// Remove e from doubly-linked list.
@@ -420,7 +570,7 @@ func (ht *hashtable) delete(thread *Thread, k Value) (v Value, found bool, err e
 // verb+" dict" should describe the operation.
 func (ht *hashtable) checkMutable(verb string) error {
 	if ht.frozen {
-		return fmt.Errorf("cannot %s frozen hash table", verb)
+		return frozenError(verb, "hash table", ht.frozenAt)
 	}
 	if ht.itercount > 0 {
 		return fmt.Errorf("cannot %s hash table during iteration", verb)
@@ -438,6 +588,11 @@ func (ht *hashtable) clear(thread *Thread) error {
 	if ht.len == 0 {
 		return nil
 	}
+	if ht.shared {
+		ht.table, ht.head, ht.len, ht.shared = nil, nil, 0, false
+		ht.tailLink = &ht.head
+		return nil
+	}
 	if ht.table != nil {
 		if thread != nil {
 			if err := thread.AddSteps(SafeInt(len(ht.table))); err != nil {
@@ -539,22 +694,50 @@ func (ht *hashtable) entries(yield func(k, v Value) bool) {
 	}
 }
 
+// String hashes are keyed randomly for each process so that colliding keys,
+// which degrade dict and set performance, cannot be chosen in advance.
+// If the STARLARK_HASH_SEED environment variable is set, hashSeed instead
+// perturbs a fixed FNV-1a hash, so that string hashes (and therefore the
+// steps counted by dict and set operations) are deterministic. This mode
+// offers no protection against chosen collisions.
+var hashSeed, hashSeedFixed = initHashSeed()
+
+func initHashSeed() (seed uint32, fixed bool) {
+	if env, ok := os.LookupEnv("STARLARK_HASH_SEED"); ok {
+		if seed, err := strconv.ParseUint(env, 10, 32); err == nil {
+			return uint32(seed), true
+		}
+	}
+	var buf [4]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic(fmt.Sprintf("cannot seed string hash: %v", err))
+	}
+	return binary.LittleEndian.Uint32(buf[:]), false
+}
+
 // hashString computes the hash of s.
 func hashString(s string) uint32 {
-	if len(s) >= 12 {
-		// Call the Go runtime's optimized hash implementation,
-		// which uses the AES instructions on amd64 and arm64 machines.
-		return maphash_string(s)
+	if hashSeedFixed {
+		return seededHashString(s, hashSeed)
 	}
-	return softHashString(s)
+	// Call the Go runtime's optimized hash implementation,
+	// which uses the AES instructions on amd64 and arm64 machines.
+	// It is seeded randomly for each process.
+	return maphash_string(s)
 }
 
-// softHashString computes the 32-bit FNV-1a hash of s in software.
-func softHashString(s string) uint32 {
-	var h uint32 = 2166136261
+// seededHashString computes the 32-bit FNV-1a hash of s in software, starting
+// from an offset perturbed by seed.
+func seededHashString(s string, seed uint32) uint32 {
+	h := 2166136261 ^ seed
 	for i := 0; i < len(s); i++ {
 		h ^= uint32(s[i])
 		h *= 16777619
 	}
 	return h
 }
+
+// softHashString computes the 32-bit FNV-1a hash of s in software.
+func softHashString(s string) uint32 {
+	return seededHashString(s, 0)
+}
@@ -5,6 +5,7 @@
 package starlark
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -137,3 +138,150 @@ func TestHashtableCount(t *testing.T) {
 		t.Errorf("count doesn't match: expected %d got %d", count, c)
 	}
 }
+
+func TestNewDictWithBudget(t *testing.T) {
+	const size = 100
+
+	thread := &Thread{}
+	dict, err := NewDictWithBudget(thread, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict.Cap() < size {
+		t.Errorf("insufficient capacity: got %d, want at least %d", dict.Cap(), size)
+	}
+	declared, _ := thread.Allocs()
+	if minimum, _ := SafeAdd(EstimateSize(&Dict{}), EstimateMakeSize([]bucket{}, SafeInt(len(dict.ht.table)))).Int64(); declared < minimum {
+		t.Errorf("table not declared: got %d bytes, want at least %d", declared, minimum)
+	}
+
+	table := dict.ht.table
+	for i := 0; i < dict.Cap(); i++ {
+		if err := dict.SetKey(MakeInt(i), None); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if &dict.ht.table[0] != &table[0] {
+		t.Errorf("table grew before reaching capacity %d", dict.Cap())
+	}
+	if expected := float64(dict.Len()) / float64(len(table)); dict.LoadFactor() != expected {
+		t.Errorf("incorrect load factor: got %v, want %v", dict.LoadFactor(), expected)
+	}
+	if err := dict.SetKey(MakeInt(-1), None); err != nil {
+		t.Fatal(err)
+	}
+	if len(dict.ht.table) == len(table) {
+		t.Errorf("table did not grow beyond capacity %d", len(table))
+	}
+}
+
+func TestNewSetWithBudget(t *testing.T) {
+	thread := &Thread{}
+	set, err := NewSetWithBudget(thread, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Cap() != bucketSize {
+		t.Errorf("incorrect capacity of empty set: got %d, want %d", set.Cap(), bucketSize)
+	}
+	if set.LoadFactor() != 0 {
+		t.Errorf("incorrect load factor of empty set: got %v", set.LoadFactor())
+	}
+}
+
+func TestNewHashtableWithBudgetLimits(t *testing.T) {
+	t.Run("allocs", func(t *testing.T) {
+		thread := &Thread{}
+		thread.SetMaxAllocs(1000)
+		if _, err := NewDictWithBudget(thread, 1_000_000); !errors.Is(err, ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if _, err := NewSetWithBudget(thread, 1_000_000); !errors.Is(err, ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+	})
+
+	t.Run("collection-len", func(t *testing.T) {
+		thread := &Thread{}
+		thread.SetMaxCollectionLen(10)
+		if _, err := NewDictWithBudget(thread, 11); !errors.Is(err, ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if _, err := NewDictWithBudget(thread, 10); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		if _, err := NewSetWithBudget(&Thread{}, -1); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+// collidingKey is a hashable value whose hash is always the same.
+type collidingKey int
+
+func (k collidingKey) String() string        { return fmt.Sprintf("collidingKey(%d)", int(k)) }
+func (k collidingKey) Type() string          { return "collidingKey" }
+func (k collidingKey) Freeze()               {}
+func (k collidingKey) Truth() Bool           { return True }
+func (k collidingKey) Hash() (uint32, error) { return 42, nil }
+
+func TestHashtableCollisionSteps(t *testing.T) {
+	const count = 200
+	insertSteps := func(key func(i int) Value) int64 {
+		thread := &Thread{}
+		var ht hashtable
+		for i := 0; i < count; i++ {
+			if err := ht.insert(thread, key(i), None); err != nil {
+				t.Fatal(err)
+			}
+		}
+		steps, _ := thread.Steps()
+		return steps
+	}
+
+	distinct := insertSteps(func(i int) Value { return MakeInt(i) })
+	colliding := insertSteps(func(i int) Value { return collidingKey(i) })
+
+	// Without extra charges, inserting colliding keys costs about
+	// count*count/(2*bucketSize) steps.
+	if uncharged := int64(count * count / (2 * bucketSize)); colliding < 4*uncharged {
+		t.Errorf("degenerate buckets were not charged extra steps: got %d steps, want at least %d", colliding, 4*uncharged)
+	}
+	if distinct > 4*count {
+		t.Errorf("well-distributed keys were charged extra steps: got %d steps for %d insertions", distinct, count)
+	}
+}
+
+func TestStringHashSeed(t *testing.T) {
+	const s = "key"
+	if seededHashString(s, 1) == seededHashString(s, 2) {
+		t.Errorf("seed does not affect string hash")
+	}
+	if seededHashString(s, 0) != softHashString(s) {
+		t.Errorf("zero seed does not yield FNV-1a hash")
+	}
+}
+
+func TestShortStringHashLowBits(t *testing.T) {
+	if hashSeedFixed {
+		t.Skip("string hashes are deterministic")
+	}
+
+	// Short strings whose bytes share their low 7 bits must not all fall
+	// into the same bucket of a table with 128 buckets.
+	const mask = 127
+	buckets := make(map[uint32]bool)
+	for i := 0; i < 256; i++ {
+		b := make([]byte, 8)
+		for j := range b {
+			b[j] = 'A' | byte(i>>j&1)<<7
+		}
+		buckets[hashString(string(b))&mask] = true
+	}
+	if len(buckets) < 32 {
+		t.Errorf("short strings occupied only %d of %d buckets", len(buckets), mask+1)
+	}
+}
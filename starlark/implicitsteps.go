@@ -0,0 +1,173 @@
+package starlark
+
+import "github.com/canonical/starlark/syntax"
+
+// Hashing and comparing values is implicit in many operations, such as
+// indexing a dict, testing for membership of a list or evaluating ==. The
+// work done is proportional to the size of the values involved, so it is
+// counted in steps: one per bytesPerImplicitStep bytes of a string, and one
+// per element of a container visited.
+
+// bytesPerImplicitStep is the number of bytes of a string which may be
+// hashed or compared in a single step. Short strings are therefore covered
+// by the step of the operation which hashes or compares them.
+const bytesPerImplicitStep = 64
+
+// byteSteps returns the number of steps taken to hash or compare n bytes.
+func byteSteps(n int) SafeInteger {
+	return SafeInt(n / bytesPerImplicitStep)
+}
+
+// hashSteps returns the number of steps taken to hash x.
+func hashSteps(x Value) SafeInteger {
+	switch x := x.(type) {
+	case String:
+		return byteSteps(len(x))
+	case Bytes:
+		return byteSteps(len(x))
+	case Tuple:
+		steps := SafeInt(len(x))
+		for _, elem := range x {
+			steps = SafeAdd(steps, hashSteps(elem))
+		}
+		return steps
+	}
+	return SafeInt(0)
+}
+
+// safeHash returns the hash of x, counting the steps taken to compute it.
+// It allows a nil thread.
+func safeHash(thread *Thread, x Value) (uint32, error) {
+	if thread != nil {
+		if err := thread.AddSteps(hashSteps(x)); err != nil {
+			return 0, err
+		}
+	}
+	return x.Hash()
+}
+
+// safeEqual is like Equal, but counts the steps taken to compare x and y.
+// It allows a nil thread.
+func safeEqual(thread *Thread, x, y Value) (bool, error) {
+	return safeCompareDepth(thread, syntax.EQL, x, y, CompareLimit)
+}
+
+// safeCompare is like Compare, but counts the steps taken to compare x and
+// y. It allows a nil thread.
+func safeCompare(thread *Thread, op syntax.Token, x, y Value) (bool, error) {
+	return safeCompareDepth(thread, op, x, y, CompareLimit)
+}
+
+// safeCompareDepth is like CompareDepth, but counts the steps taken to
+// compare strings and the elements of built-in containers. Other values
+// are compared by CompareDepth.
+func safeCompareDepth(thread *Thread, op syntax.Token, x, y Value, depth int) (bool, error) {
+	if thread == nil || depth < 1 || !sameType(x, y) {
+		return CompareDepth(op, x, y, depth)
+	}
+
+	switch x := x.(type) {
+	case String:
+		if err := thread.AddSteps(stringCompareSteps(op, len(x), len(y.(String)))); err != nil {
+			return false, err
+		}
+	case Bytes:
+		if err := thread.AddSteps(stringCompareSteps(op, len(x), len(y.(Bytes)))); err != nil {
+			return false, err
+		}
+	case *List:
+		return safeSliceCompare(thread, op, x.elems, y.(*List).elems, depth)
+	case Tuple:
+		return safeSliceCompare(thread, op, x, y.(Tuple), depth)
+	case *Dict:
+		if op == syntax.EQL || op == syntax.NEQ {
+			eq, err := safeDictsEqual(thread, x, y.(*Dict), depth)
+			return eq != (op == syntax.NEQ), err
+		}
+	case *Set:
+		if op == syntax.EQL || op == syntax.NEQ {
+			eq, err := safeSetsEqual(thread, x, y.(*Set))
+			return eq != (op == syntax.NEQ), err
+		}
+	}
+	return CompareDepth(op, x, y, depth)
+}
+
+// stringCompareSteps returns the number of steps taken to compare strings
+// of the given lengths. Strings of different lengths are never equal, so
+// their bytes are only compared by ordered comparisons.
+func stringCompareSteps(op syntax.Token, xlen, ylen int) SafeInteger {
+	if xlen != ylen && (op == syntax.EQL || op == syntax.NEQ) {
+		return SafeInt(0)
+	}
+	if ylen < xlen {
+		xlen = ylen
+	}
+	return byteSteps(xlen)
+}
+
+// safeSliceCompare is like sliceCompare, but counts a step for each pair
+// of elements compared, as well as the steps taken to compare them.
+func safeSliceCompare(thread *Thread, op syntax.Token, x, y []Value, depth int) (bool, error) {
+	// Fast path: check length.
+	if len(x) != len(y) && (op == syntax.EQL || op == syntax.NEQ) {
+		return op == syntax.NEQ, nil
+	}
+
+	// Find first element that is not equal in both lists.
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if err := thread.AddSteps(SafeInt(1)); err != nil {
+			return false, err
+		}
+		if eq, err := safeCompareDepth(thread, syntax.EQL, x[i], y[i], depth-1); err != nil {
+			return false, err
+		} else if !eq {
+			switch op {
+			case syntax.EQL:
+				return false, nil
+			case syntax.NEQ:
+				return true, nil
+			default:
+				return safeCompareDepth(thread, op, x[i], y[i], depth-1)
+			}
+		}
+	}
+
+	return threeway(op, len(x)-len(y)), nil
+}
+
+// safeDictsEqual is like dictsEqual, but counts the steps taken to look up
+// each key of x in y and to compare the corresponding values.
+func safeDictsEqual(thread *Thread, x, y *Dict, depth int) (bool, error) {
+	if x.Len() != y.Len() {
+		return false, nil
+	}
+	for e := x.ht.head; e != nil; e = e.next {
+		if yval, found, err := y.ht.lookup(thread, e.key); err != nil {
+			return false, err
+		} else if !found {
+			return false, nil
+		} else if eq, err := safeCompareDepth(thread, syntax.EQL, e.value, yval, depth-1); err != nil {
+			return false, err
+		} else if !eq {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// safeSetsEqual is like setsEqual, but counts the steps taken to look up
+// each element of x in y.
+func safeSetsEqual(thread *Thread, x, y *Set) (bool, error) {
+	if x.Len() != y.Len() {
+		return false, nil
+	}
+	for e := x.ht.head; e != nil; e = e.next {
+		if _, found, err := y.ht.lookup(thread, e.key); err != nil {
+			return false, err
+		} else if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,90 @@
+package starlark_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/syntax"
+)
+
+func TestImplicitSteps(t *testing.T) {
+	// Long values cost n more steps than their short counterparts to hash
+	// or compare.
+	const n = 1000
+	const bytesPerStep = 64
+
+	values := func(size int) starlark.StringDict {
+		text := strings.Repeat("a", size)
+		elems := make([]starlark.Value, size/bytesPerStep)
+		for i := range elems {
+			elems[i] = starlark.MakeInt(i)
+		}
+		return starlark.StringDict{
+			"x":  starlark.String(text),
+			"y":  starlark.String(string([]byte(text))),
+			"bx": starlark.Bytes(text),
+			"by": starlark.Bytes(string([]byte(text))),
+			"l1": starlark.NewList(elems),
+			"l2": starlark.NewList(append([]starlark.Value(nil), elems...)),
+			"t1": starlark.Tuple(elems),
+		}
+	}
+	short, long := values(bytesPerStep), values(n*bytesPerStep)
+
+	steps := func(t *testing.T, src string, predeclared starlark.StringDict) int64 {
+		thread := &starlark.Thread{}
+		opts := &syntax.FileOptions{Set: true}
+		if _, err := starlark.ExecFileOptions(opts, thread, "implicit.star", src, predeclared); err != nil {
+			t.Fatal(err)
+		}
+		steps, _ := thread.Steps()
+		return steps
+	}
+
+	tests := []struct {
+		name, src string
+		cost      int64 // in multiples of n
+	}{
+		{"string-eq", "x == y", 1},
+		{"string-lt", "x < y", 1},
+		{"bytes-eq", "bx == by", 1},
+		{"string-hash", "{x: 1}", 1},
+		{"string-lookup", "d = {x: 1}; d[y]", 3},
+		{"string-in-list", "x in [y]", 1},
+		{"string-in-set", "y in set([x])", 3},
+		{"list-index", "[x].index(y)", 1},
+		{"list-eq", "l1 == l2", 1},
+		{"list-ne", "l1 != l2", 1},
+		{"nested-eq", "[l1] == [l2]", 1},
+		{"dict-eq", "{1: l1} == {1: l2}", 1},
+		{"set-eq", "set([x]) == set([y])", 4},
+		{"tuple-hash", "{t1: 1}", 1},
+		{"sorted", "sorted([x, y])", 1},
+		{"max", "max(x, y)", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := steps(t, test.src, short)
+			extra := steps(t, test.src, long) - base
+			if want := test.cost * n; extra < want-test.cost || extra > want {
+				t.Errorf("long values counted %d extra steps, want %d", extra, want)
+			}
+		})
+	}
+}
+
+func TestImplicitStepsCancellation(t *testing.T) {
+	text := strings.Repeat("a", 1<<20)
+	predeclared := starlark.StringDict{
+		"x": starlark.String(text),
+		"y": starlark.String(string([]byte(text))),
+	}
+	thread := &starlark.Thread{}
+	thread.SetMaxSteps(100)
+	_, err := starlark.ExecFile(thread, "implicit.star", "x == y", predeclared)
+	if !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("expected safety error, got %v", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/bits"
 	"reflect"
 	"strconv"
 
@@ -160,6 +161,27 @@ func (i Int) bigInt() *big.Int {
 	return big.NewInt(iSmall)
 }
 
+// bitLen returns the length of the absolute value of i in bits.
+func (i Int) bitLen() int {
+	iSmall, iBig := i.get()
+	if iBig != nil {
+		return iBig.BitLen()
+	}
+	if iSmall < 0 {
+		return bits.Len64(uint64(-iSmall))
+	}
+	return bits.Len64(uint64(iSmall))
+}
+
+// text returns the digits of i in the given base.
+func (i Int) text(base int) string {
+	iSmall, iBig := i.get()
+	if iBig != nil {
+		return iBig.Text(base)
+	}
+	return strconv.FormatInt(iSmall, base)
+}
+
 // Uint64 returns the value as a uint64.
 // If it is not exactly representable the result is undefined and ok is false.
 func (i Int) Uint64() (_ uint64, ok bool) {
@@ -251,6 +273,14 @@ func (i Int) String() string {
 	}
 	return strconv.FormatInt(iSmall, 10)
 }
+
+func (i Int) Attr(name string) (Value, error) { return builtinAttr(i, name, intMethods) }
+func (i Int) AttrNames() []string             { return builtinAttrNames(intMethods) }
+
+func (i Int) SafeAttr(thread *Thread, name string) (Value, error) {
+	return safeBuiltinAttr(thread, i, name, intMethods)
+}
+
 func (i Int) Type() string { return "int" }
 func (i Int) Freeze()      {} // immutable
 func (i Int) Truth() Bool  { return i.Sign() != 0 }
@@ -0,0 +1,84 @@
+package starlark
+
+import "sync"
+
+// A StringPool deduplicates the strings interned by the threads attached to
+// it, so that each distinct string is stored and charged for only once.
+// Strings remain in the pool for as long as it is reachable, so a pool
+// should live no longer than the threads which use it.
+//
+// A StringPool is safe for use by multiple goroutines.
+type StringPool struct {
+	mu      sync.Mutex
+	strings map[string]string
+}
+
+// NewStringPool returns a new empty pool.
+func NewStringPool() *StringPool {
+	return &StringPool{strings: make(map[string]string)}
+}
+
+// Len returns the number of distinct strings in the pool.
+func (pool *StringPool) Len() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return len(pool.strings)
+}
+
+// SetStringPool attaches the thread to pool, so that the strings it interns
+// are shared with all other threads attached to the same pool.
+//
+// SetStringPool must be called before execution begins.
+func (thread *Thread) SetStringPool(pool *StringPool) {
+	thread.stringPool = pool
+}
+
+// StringPool returns the pool to which the thread is attached, if any.
+func (thread *Thread) StringPool() *StringPool {
+	return thread.stringPool
+}
+
+// InternString returns a string equal to s, counting the allocation of a
+// new copy of s unless an equal string is already held by the thread's
+// string pool, in which case only the string header is counted and the
+// pooled string is returned. If the thread is not attached to a pool, s is
+// returned and counted as a new copy.
+//
+// The pool retains s itself, so s should not share memory with a larger
+// string.
+//
+// Builtins which produce many strings that are likely to repeat, such as
+// the keys of decoded objects, may use InternString in place of counting
+// each new string with AddAllocs.
+func (thread *Thread) InternString(s string) (string, error) {
+	pool := thread.stringPool
+	if pool == nil {
+		if err := thread.AddAllocs(EstimateSize(s)); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if interned, ok := pool.strings[s]; ok {
+		if err := thread.AddAllocs(StringTypeOverhead); err != nil {
+			return "", err
+		}
+		return interned, nil
+	}
+	// The pool's table grows as it is filled, so each new string is charged
+	// for its share of that growth.
+	n := len(pool.strings)
+	growth := SafeSub(
+		EstimateMakeSize(map[string]string{}, SafeInt(n+1)),
+		EstimateMakeSize(map[string]string{}, SafeInt(n)),
+	)
+	if err := thread.AddAllocs(SafeAdd(EstimateSize(s), growth)); err != nil {
+		return "", err
+	}
+	pool.strings[s] = s
+	return s, nil
+}
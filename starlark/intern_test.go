@@ -0,0 +1,61 @@
+package starlark_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestInternString(t *testing.T) {
+	s := strings.Repeat("x", 1000)
+
+	t.Run("no-pool", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		result, err := thread.InternString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != s {
+			t.Errorf("incorrect result: %q", result)
+		}
+		expected, _ := starlark.EstimateSize(s).Int64()
+		if allocs, _ := thread.Allocs(); allocs != expected {
+			t.Errorf("incorrect allocs: expected %d but got %d", expected, allocs)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		pool := starlark.NewStringPool()
+		thread1 := &starlark.Thread{}
+		thread1.SetStringPool(pool)
+		thread2 := &starlark.Thread{}
+		thread2.SetStringPool(pool)
+
+		first, err := thread1.InternString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := thread2.InternString(strings.Repeat("x", 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (*reflect.StringHeader)(unsafe.Pointer(&first)).Data != (*reflect.StringHeader)(unsafe.Pointer(&second)).Data {
+			t.Error("interned strings do not share memory")
+		}
+		if n := pool.Len(); n != 1 {
+			t.Errorf("incorrect pool length: expected 1 but got %d", n)
+		}
+
+		stringSize, _ := starlark.EstimateSize(s).Int64()
+		if allocs, _ := thread1.Allocs(); allocs < stringSize {
+			t.Errorf("first intern was undercharged: %d < %d", allocs, stringSize)
+		}
+		overhead, _ := starlark.StringTypeOverhead.Int64()
+		if allocs, _ := thread2.Allocs(); allocs != overhead {
+			t.Errorf("repeated intern was charged incorrectly: expected %d but got %d", overhead, allocs)
+		}
+	})
+}
@@ -17,7 +17,7 @@ const vmdebug = false // TODO(adonovan): use a bitfield of specific kinds of err
 // - optimize position table.
 // - opt: record MaxIterStack during compilation and preallocate the stack.
 
-func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (_ Value, err error) {
+func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (retval Value, err error) {
 	// Postcondition: args is not mutated. This is stricter than required by Callable,
 	// but allows CALL to avoid a copy.
 
@@ -69,6 +69,7 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (_
 	args, kwargs = nil, nil
 
 	fr.locals = locals
+	fr.operands = stack
 
 	if vmdebug {
 		fmt.Printf("Entering %s @ %s\n", f.Name, f.Position(0))
@@ -90,6 +91,7 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (_
 	// - there is no redefinition of 'err'.
 
 	var iterstack []Iterator // stack of active iterators
+	fr.iterstack = &iterstack
 
 	// Use defer so that application panics can pass through
 	// interpreter without leaving thread in a bad state.
@@ -102,13 +104,21 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (_
 			}
 		}
 
+		if err != nil && thread.isCheckpoint(fn, err) {
+			retval, err = thread.salvageFrame(fr, err)
+		}
+
 		fr.locals = nil
+		fr.operands = nil
+		fr.iterstack = nil
 	}()
 
 	sp := 0
 	var pc uint32
 	var result Value
 	code := f.Code
+	opcodeCosts := thread.opcodeCostTable()
+	opcodeCounts := thread.opcodeCounts
 loop:
 	for {
 		fr.pc = pc
@@ -133,8 +143,24 @@ loop:
 			compile.PrintOp(f, fr.pc, op, arg)
 		}
 
-		if addStep(op) {
-			if err = thread.AddSteps(SafeInt(1)); err != nil {
+		if opcodeCounts != nil {
+			opcodeCounts[op]++
+		}
+		if thread.pausing() {
+			if err = thread.waitResume(); err != nil {
+				break loop
+			}
+		}
+		if thread.cpuMeter != nil {
+			if err = thread.checkCPUTime(); err != nil {
+				break loop
+			}
+		}
+		if thread.liveMemory != nil {
+			thread.checkLiveMemory()
+		}
+		if cost := opcodeCosts[op]; cost != 0 {
+			if err = thread.AddSteps(SafeInt(cost)); err != nil {
 				break loop
 			}
 		}
@@ -162,7 +188,7 @@ loop:
 			y := stack[sp-1]
 			x := stack[sp-2]
 			sp -= 2
-			ok, err2 := Compare(op, x, y)
+			ok, err2 := safeCompare(thread, op, x, y)
 			if err2 != nil {
 				err = err2
 				break loop
@@ -227,6 +253,9 @@ loop:
 					if err = xlist.checkMutable("apply += to"); err != nil {
 						break loop
 					}
+					if err = xlist.unshare(thread); err != nil {
+						break loop
+					}
 					if err = safeListExtend(thread, xlist, yiter); err != nil {
 						break loop
 					}
@@ -525,6 +554,15 @@ loop:
 			stack[sp] = new(Dict)
 			sp++
 
+		case compile.RESERVE:
+			dict := stack[sp-2].(*Dict)
+			if n := Len(stack[sp-1]); n > 0 && thread.CheckCollectionLen(n) == nil {
+				if err2 := dict.reserve(thread, n); err2 != nil {
+					err = err2
+					break loop
+				}
+			}
+
 		case compile.SETDICT, compile.SETDICTUNIQ:
 			dict := stack[sp-3].(*Dict)
 			k := stack[sp-2]
@@ -544,6 +582,10 @@ loop:
 			elem := stack[sp-1]
 			list := stack[sp-2].(*List)
 			sp -= 2
+			if err2 := thread.CheckCollectionLen(len(list.elems) + 1); err2 != nil {
+				err = err2
+				break loop
+			}
 			listAppender := NewSafeAppender(thread, &list.elems)
 			if err2 := listAppender.Append(elem); err2 != nil {
 				err = err2
@@ -564,6 +606,27 @@ loop:
 			stack[sp] = res
 			sp++
 
+		case compile.SETSLICE:
+			x := stack[sp-4]
+			lo := stack[sp-3]
+			hi := stack[sp-2]
+			y := stack[sp-1]
+			sp -= 4
+			err = setSlice(thread, x, lo, hi, y)
+			if err != nil {
+				break loop
+			}
+
+		case compile.DELSLICE:
+			x := stack[sp-3]
+			lo := stack[sp-2]
+			hi := stack[sp-1]
+			sp -= 3
+			err = deleteSlice(thread, x, lo, hi)
+			if err != nil {
+				break loop
+			}
+
 		case compile.UNPACK:
 			n := int(arg)
 			iterable := stack[sp-1]
@@ -598,6 +661,60 @@ loop:
 				break loop
 			}
 
+		case compile.UNPACKSTAR:
+			before, after := int(arg>>8), int(arg&0xff)
+			iterable := stack[sp-1]
+			sp--
+			iter, err2 := SafeIterate(thread, iterable)
+			if err2 != nil {
+				if err2 == ErrUnsupported {
+					err = fmt.Errorf("got %s in sequence assignment", iterable.Type())
+				} else {
+					err = err2
+				}
+				break loop
+			}
+			var values []Value
+			valuesAppender := NewSafeAppender(thread, &values)
+			var value Value
+			for iter.Next(&value) {
+				if err2 := valuesAppender.Append(value); err2 != nil {
+					iter.Done()
+					err = err2
+					break loop
+				}
+			}
+			iter.Done()
+			if err2 := iter.Err(); err2 != nil {
+				err = err2
+				break loop
+			}
+			if len(values) < before+after {
+				err = fmt.Errorf("too few values to unpack (got %d, want at least %d)", len(values), before+after)
+				break loop
+			}
+			end := len(values) - after
+			if err2 := thread.CheckCollectionLen(end - before); err2 != nil {
+				err = err2
+				break loop
+			}
+			if err2 := thread.AddAllocs(EstimateSize(&List{})); err2 != nil {
+				err = err2
+				break loop
+			}
+			// The rest list shares the array of values, whose allocation
+			// has already been counted.
+			rest := NewList(values[before:end:end])
+			n := before + 1 + after
+			sp += n
+			for i := 0; i < before; i++ {
+				stack[sp-1-i] = values[i]
+			}
+			stack[sp-1-before] = rest
+			for i := 0; i < after; i++ {
+				stack[sp-2-before-i] = values[end+i]
+			}
+
 		case compile.CJMP:
 			if stack[sp-1].Truth() {
 				pc = arg
@@ -623,6 +740,10 @@ loop:
 
 		case compile.MAKELIST:
 			n := int(arg)
+			if err2 := thread.CheckCollectionLen(n); err2 != nil {
+				err = err2
+				break loop
+			}
 			elemsSize := EstimateMakeSize([]Value{}, SafeInt(n))
 			listSize := EstimateSize(&List{})
 			if err2 := thread.AddAllocs(SafeAdd(elemsSize, listSize)); err2 != nil {
@@ -663,6 +784,14 @@ loop:
 				break loop
 			}
 
+			if err2 := thread.checkLoadSafety(module); err2 != nil {
+				err = wrappedError{
+					msg:   fmt.Sprintf("cannot load %s: %v", module, err2),
+					cause: err2,
+				}
+				break loop
+			}
+
 			thread.endProfSpan()
 			dict, err2 := thread.Load(thread, module)
 			thread.beginProfSpan()
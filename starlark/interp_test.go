@@ -1,11 +1,13 @@
 package starlark_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/canonical/starlark/starlark"
 	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/syntax"
 )
 
 func TestUnary(t *testing.T) {
@@ -270,6 +272,53 @@ func TestDictComprehension(t *testing.T) {
 	})
 }
 
+func TestDictPresizing(t *testing.T) {
+	allocs := func(t *testing.T, src string) int64 {
+		thread := &starlark.Thread{}
+		if _, err := starlark.ExecFile(thread, "presize.star", src, nil); err != nil {
+			t.Fatal(err)
+		}
+		allocs, _ := thread.Allocs()
+		return allocs
+	}
+
+	t.Run("comprehension", func(t *testing.T) {
+		// The condition prevents the dict from being presized.
+		presized := allocs(t, "x = {i: None for i in range(1000)}")
+		grown := allocs(t, "x = {i: None for i in range(1000) if True}")
+		if presized >= grown {
+			t.Errorf("presized comprehension used %d allocs, grown used %d", presized, grown)
+		}
+	})
+
+	t.Run("dict", func(t *testing.T) {
+		const kwargs = "a=1, b=2, c=3, d=4, e=5, f=6, g=7, h=8, i=9, j=10, k=11, l=12, m=13, n=14, o=15, p=16, q=17"
+		presized := allocs(t, "x = dict("+kwargs+")")
+		grown := allocs(t, "x = dict()\nx.update("+kwargs+")")
+		if presized >= grown {
+			t.Errorf("presized dict used %d allocs, grown used %d", presized, grown)
+		}
+	})
+
+	t.Run("repeated-keys", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(4 << 20)
+		const src = "x = {i % 2: i for i in list(range(100000))}"
+		if _, err := starlark.ExecFile(thread, "presize.star", src, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("huge-source", func(t *testing.T) {
+		// The condition prevents the dict from being presized.
+		presized := allocs(t, "x = {0: None for i in range(1 << 16)}")
+		grown := allocs(t, "x = {0: None for i in range(1 << 16) if True}")
+		if presized-grown > 1<<16 {
+			t.Errorf("presized a single-entry dict with %d extra allocs", presized-grown)
+		}
+	})
+}
+
 func TestIterate(t *testing.T) {
 	t.Run("small", func(t *testing.T) {
 		st := startest.From(t)
@@ -329,6 +378,133 @@ func TestSequenceAssignment(t *testing.T) {
 	})
 }
 
+func TestSliceAssignment(t *testing.T) {
+	const src = `
+def assign(x, lo, hi, y):
+    x[lo:hi] = y
+
+def delete(x, lo, hi):
+    del x[lo:hi]
+`
+	opts := &syntax.FileOptions{SliceAssign: true}
+	globals, err := starlark.ExecFileOptions(opts, &starlark.Thread{}, "slice.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assign, delete := globals["assign"], globals["delete"]
+
+	makeList := func(n int) *starlark.List {
+		elems := make([]starlark.Value, n)
+		for i := range elems {
+			elems[i] = starlark.None
+		}
+		return starlark.NewList(elems)
+	}
+
+	t.Run("grow", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMinSteps(2)
+		st.RunThread(func(thread *starlark.Thread) {
+			x := starlark.NewList(nil)
+			args := starlark.Tuple{x, starlark.None, starlark.None, makeList(st.N)}
+			if _, err := starlark.Call(thread, assign, args, nil); err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(x)
+		})
+	})
+
+	t.Run("insert", func(t *testing.T) {
+		// Inserting at the front moves every existing element.
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMinSteps(1)
+		st.RunThread(func(thread *starlark.Thread) {
+			x := makeList(st.N)
+			zero := starlark.MakeInt(0)
+			args := starlark.Tuple{x, zero, zero, starlark.Tuple{starlark.None}}
+			if _, err := starlark.Call(thread, assign, args, nil); err != nil {
+				st.Error(err)
+			}
+		})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMinSteps(1)
+		st.SetMaxAllocs(0)
+		st.RunThread(func(thread *starlark.Thread) {
+			x := makeList(st.N)
+			args := starlark.Tuple{x, starlark.None, starlark.None}
+			if _, err := starlark.Call(thread, delete, args, nil); err != nil {
+				st.Error(err)
+			}
+			if x.Len() != 0 {
+				st.Errorf("list not emptied: got %v", x)
+			}
+		})
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(100)
+		x := starlark.NewList(nil)
+		args := starlark.Tuple{x, starlark.None, starlark.None, makeList(1000)}
+		_, err := starlark.Call(thread, assign, args, nil)
+		if err == nil {
+			t.Fatal("expected cancellation")
+		}
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if x.Len() != 0 {
+			t.Errorf("list modified despite cancellation: length %d", x.Len())
+		}
+	})
+}
+
+func TestStarAssignment(t *testing.T) {
+	const src = `
+def unpack(x):
+    first, *rest = x
+    return rest
+`
+	opts := &syntax.FileOptions{StarAssign: true}
+	globals, err := starlark.ExecFileOptions(opts, &starlark.Thread{}, "star.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpack := globals["unpack"]
+
+	t.Run("resources", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMinSteps(1)
+		st.RunThread(func(thread *starlark.Thread) {
+			elems := make([]starlark.Value, st.N+1)
+			for i := range elems {
+				elems[i] = starlark.None
+			}
+			result, err := starlark.Call(thread, unpack, starlark.Tuple{starlark.Tuple(elems)}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		})
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(1000)
+		_, err := starlark.Call(thread, unpack, starlark.Tuple{starlark.Tuple(make([]starlark.Value, 1000))}, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+	})
+}
+
 func TestAttrAccessAllocs(t *testing.T) {
 	tests := []struct {
 		name  string
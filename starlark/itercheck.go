@@ -0,0 +1,152 @@
+package starlark
+
+// This file defines checks which detect misuse of iterators.
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// An IteratorMisuse describes a violation of the Iterator contract detected
+// by the checks enabled with Thread.CheckIterators.
+type IteratorMisuse struct {
+	// Problem describes the violation.
+	Problem string
+
+	// Type is the type of the value being iterated.
+	Type string
+
+	// Stack is the Starlark call stack when the iterator was created.
+	Stack CallStack
+
+	// GoStack describes the Go call site at which the iterator was created.
+	GoStack string
+}
+
+func (m *IteratorMisuse) Error() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "iterator misuse: %s (iterating %s)\n", m.Problem, m.Type)
+	if len(m.Stack) > 0 {
+		buf.WriteString(m.Stack.String())
+	}
+	buf.WriteString("created at:\n")
+	buf.WriteString(m.GoStack)
+	return buf.String()
+}
+
+// CheckIterators enables checks on the iterators created by SafeIterate for
+// this thread, including those used by the interpreter. The checks detect
+// iterators which are garbage collected without a call to Done, calls to
+// Next after Done, repeated calls to Done, and changes in the length of the
+// iterated value during iteration. Each misuse is passed to report, along
+// with where the iterator was created. If report is nil, checks are
+// disabled.
+//
+// Misuse which can be detected while iterating is also reported by the
+// iterator's Err method. A missing call to Done is only detected by the
+// garbage collector, so report may be called from another goroutine.
+//
+// The checks are expensive and are intended for debugging, for example in
+// the tests of custom Iterable implementations.
+func (thread *Thread) CheckIterators(report func(*IteratorMisuse)) {
+	thread.checkIterators = report
+}
+
+// checkedIterator wraps an Iterator to detect misuse.
+type checkedIterator struct {
+	iter     Iterator
+	iterable Value
+	len      int
+	done     bool
+	err      error
+
+	misuse func(problem string) *IteratorMisuse
+	report func(*IteratorMisuse)
+}
+
+var _ Iterator = &checkedIterator{}
+
+// checkIterator returns an Iterator which reports misuse of iter, which
+// iterates over x.
+func (thread *Thread) checkIterator(x Value, iter Iterator) Iterator {
+	report := thread.checkIterators
+	stack := thread.CallStack()
+	goStack := goCallSite(3)
+	ci := &checkedIterator{
+		iter:     iter,
+		iterable: x,
+		len:      Len(x),
+		misuse: func(problem string) *IteratorMisuse {
+			return &IteratorMisuse{
+				Problem: problem,
+				Type:    x.Type(),
+				Stack:   stack,
+				GoStack: goStack,
+			}
+		},
+		report: report,
+	}
+	runtime.SetFinalizer(ci, func(ci *checkedIterator) {
+		if !ci.done {
+			ci.report(ci.misuse("iterator not finished with Done"))
+		}
+	})
+	return ci
+}
+
+func (ci *checkedIterator) fail(problem string) {
+	misuse := ci.misuse(problem)
+	if ci.err == nil {
+		ci.err = misuse
+	}
+	ci.report(misuse)
+}
+
+func (ci *checkedIterator) Next(p *Value) bool {
+	if ci.done {
+		ci.fail("Next called after Done")
+		return false
+	}
+	if ci.err != nil {
+		return false
+	}
+	if ci.len >= 0 && Len(ci.iterable) != ci.len {
+		ci.fail("iterable mutated during iteration")
+		return false
+	}
+	return ci.iter.Next(p)
+}
+
+func (ci *checkedIterator) Done() {
+	if ci.done {
+		ci.fail("Done called more than once")
+		return
+	}
+	ci.done = true
+	ci.iter.Done()
+}
+
+func (ci *checkedIterator) Err() error {
+	if ci.err != nil {
+		return ci.err
+	}
+	return ci.iter.Err()
+}
+
+// goCallSite returns a description of the Go call stack, skipping the given
+// number of frames.
+func goCallSite(skip int) string {
+	var pcs [16]uintptr
+	n := runtime.Callers(skip+1, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	var buf strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&buf, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
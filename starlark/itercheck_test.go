@@ -0,0 +1,169 @@
+package starlark_test
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+// mutableSequence is a Sequence whose iterator does not detect mutation.
+type mutableSequence struct {
+	elems []starlark.Value
+}
+
+var _ starlark.Sequence = &mutableSequence{}
+
+func (ms *mutableSequence) Freeze() {}
+func (ms *mutableSequence) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", ms.Type())
+}
+func (ms *mutableSequence) String() string       { return "mutableSequence" }
+func (ms *mutableSequence) Truth() starlark.Bool { return len(ms.elems) > 0 }
+func (ms *mutableSequence) Type() string         { return "mutableSequence" }
+func (ms *mutableSequence) Len() int             { return len(ms.elems) }
+func (ms *mutableSequence) Iterate() starlark.Iterator {
+	return &mutableSequenceIterator{seq: ms}
+}
+
+type mutableSequenceIterator struct {
+	seq *mutableSequence
+	i   int
+}
+
+func (it *mutableSequenceIterator) Next(p *starlark.Value) bool {
+	if it.i >= len(it.seq.elems) {
+		return false
+	}
+	*p = it.seq.elems[it.i]
+	it.i++
+	return true
+}
+func (it *mutableSequenceIterator) Done()      {}
+func (it *mutableSequenceIterator) Err() error { return nil }
+
+func TestCheckIterators(t *testing.T) {
+	newThread := func(misuses *[]*starlark.IteratorMisuse) *starlark.Thread {
+		thread := &starlark.Thread{}
+		thread.CheckIterators(func(misuse *starlark.IteratorMisuse) {
+			*misuses = append(*misuses, misuse)
+		})
+		return thread
+	}
+	list := starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.MakeInt(2)})
+
+	t.Run("next-after-done", func(t *testing.T) {
+		var misuses []*starlark.IteratorMisuse
+		iter, err := starlark.SafeIterate(newThread(&misuses), list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iter.Done()
+		var v starlark.Value
+		if iter.Next(&v) {
+			t.Error("Next succeeded after Done")
+		}
+		var misuse *starlark.IteratorMisuse
+		if err := iter.Err(); !errors.As(err, &misuse) {
+			t.Errorf("unexpected error: %v", err)
+		} else if misuse.Problem != "Next called after Done" {
+			t.Errorf("unexpected problem: %s", misuse.Problem)
+		} else if !strings.Contains(misuse.GoStack, "TestCheckIterators") {
+			t.Errorf("creation site not recorded:\n%s", misuse.GoStack)
+		}
+		if len(misuses) != 1 {
+			t.Errorf("expected 1 report, got %d", len(misuses))
+		}
+	})
+
+	t.Run("done-twice", func(t *testing.T) {
+		var misuses []*starlark.IteratorMisuse
+		iter, err := starlark.SafeIterate(newThread(&misuses), list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iter.Done()
+		iter.Done()
+		if len(misuses) != 1 || misuses[0].Problem != "Done called more than once" {
+			t.Errorf("unexpected reports: %v", misuses)
+		}
+	})
+
+	t.Run("mutation", func(t *testing.T) {
+		var misuses []*starlark.IteratorMisuse
+		seq := &mutableSequence{elems: []starlark.Value{starlark.None, starlark.None}}
+		iter, err := starlark.SafeIterate(newThread(&misuses), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer iter.Done()
+		var v starlark.Value
+		if !iter.Next(&v) {
+			t.Fatal("iteration ended early")
+		}
+		seq.elems = append(seq.elems, starlark.None)
+		if iter.Next(&v) {
+			t.Error("iteration continued after mutation")
+		}
+		if len(misuses) != 1 || misuses[0].Problem != "iterable mutated during iteration" {
+			t.Errorf("unexpected reports: %v", misuses)
+		}
+	})
+
+	t.Run("missing-done", func(t *testing.T) {
+		reports := make(chan *starlark.IteratorMisuse, 1)
+		thread := &starlark.Thread{}
+		thread.CheckIterators(func(misuse *starlark.IteratorMisuse) {
+			reports <- misuse
+		})
+		func() {
+			iter, err := starlark.SafeIterate(thread, list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v starlark.Value
+			iter.Next(&v)
+		}()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			runtime.GC()
+			select {
+			case misuse := <-reports:
+				if misuse.Problem != "iterator not finished with Done" {
+					t.Errorf("unexpected problem: %s", misuse.Problem)
+				}
+				return
+			case <-timeout:
+				t.Fatal("missing Done not reported")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+
+	t.Run("interpreter", func(t *testing.T) {
+		var misuses []*starlark.IteratorMisuse
+		thread := newThread(&misuses)
+		const src = `
+def f():
+    l = [x for x in range(10) if x % 2]
+    for x in l:
+        for y in {"a": 1}:
+            pass
+
+f()
+`
+		if _, err := starlark.ExecFile(thread, "itercheck.star", src, nil); err != nil {
+			t.Fatal(err)
+		}
+		runtime.GC()
+		runtime.GC()
+		if len(misuses) != 0 {
+			t.Errorf("unexpected reports: %v", misuses)
+		}
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/bits"
 	"os"
 	"sort"
 	"strconv"
@@ -40,72 +41,74 @@ var ErrNoAttr = errors.New("no such attribute")
 func init() {
 	// https://github.com/google/starlark-go/blob/master/doc/spec.md#built-in-constants-and-functions
 	Universe = StringDict{
-		"None":      None,
-		"True":      True,
-		"False":     False,
-		"abs":       NewBuiltin("abs", abs),
-		"any":       NewBuiltin("any", any_),
-		"all":       NewBuiltin("all", all),
-		"bool":      NewBuiltin("bool", bool_),
-		"bytes":     NewBuiltin("bytes", bytes_),
-		"chr":       NewBuiltin("chr", chr),
-		"dict":      NewBuiltin("dict", dict),
-		"dir":       NewBuiltin("dir", dir),
-		"enumerate": NewBuiltin("enumerate", enumerate),
-		"fail":      NewBuiltin("fail", fail),
-		"float":     NewBuiltin("float", float),
-		"getattr":   NewBuiltin("getattr", getattr),
-		"hasattr":   NewBuiltin("hasattr", hasattr),
-		"hash":      NewBuiltin("hash", hash),
-		"int":       NewBuiltin("int", int_),
-		"len":       NewBuiltin("len", len_),
-		"list":      NewBuiltin("list", list),
-		"max":       NewBuiltin("max", minmax),
-		"min":       NewBuiltin("min", minmax),
-		"ord":       NewBuiltin("ord", ord),
-		"print":     NewBuiltin("print", print),
-		"range":     NewBuiltin("range", range_),
-		"repr":      NewBuiltin("repr", repr),
-		"reversed":  NewBuiltin("reversed", reversed),
-		"set":       NewBuiltin("set", set), // requires resolve.AllowSet
-		"sorted":    NewBuiltin("sorted", sorted),
-		"str":       NewBuiltin("str", str),
-		"tuple":     NewBuiltin("tuple", tuple),
-		"type":      NewBuiltin("type", type_),
-		"zip":       NewBuiltin("zip", zip),
+		"None":       None,
+		"True":       True,
+		"False":      False,
+		"abs":        NewBuiltin("abs", abs),
+		"any":        NewBuiltin("any", any_),
+		"all":        NewBuiltin("all", all),
+		"bool":       NewBuiltin("bool", bool_),
+		"bytes":      NewBuiltin("bytes", bytes_),
+		"chr":        NewBuiltin("chr", chr),
+		"dict":       NewBuiltin("dict", dict),
+		"dir":        NewBuiltin("dir", dir),
+		"enumerate":  NewBuiltin("enumerate", enumerate),
+		"fail":       NewBuiltin("fail", fail),
+		"float":      NewBuiltin("float", float),
+		"getattr":    NewBuiltin("getattr", getattr),
+		"hasattr":    NewBuiltin("hasattr", hasattr),
+		"hash":       NewBuiltin("hash", hash),
+		"int":        NewBuiltin("int", int_),
+		"len":        NewBuiltin("len", len_),
+		"list":       NewBuiltin("list", list),
+		"max":        NewBuiltin("max", minmax),
+		"min":        NewBuiltin("min", minmax),
+		"ord":        NewBuiltin("ord", ord),
+		"print":      NewBuiltin("print", print),
+		"range":      NewBuiltin("range", range_),
+		"repr":       NewBuiltin("repr", repr),
+		"reversed":   NewBuiltin("reversed", reversed),
+		"set":        NewBuiltin("set", set), // requires resolve.AllowSet
+		"sorted":     NewBuiltin("sorted", sorted),
+		"str":        NewBuiltin("str", str),
+		"strbuilder": NewBuiltin("strbuilder", strbuilder), // requires syntax.FileOptions.StrBuilder
+		"tuple":      NewBuiltin("tuple", tuple),
+		"type":       NewBuiltin("type", type_),
+		"zip":        NewBuiltin("zip", zip),
 	}
 
 	universeSafeties = map[string]SafetyFlags{
-		"abs":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"any":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"all":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"bool":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"bytes":     CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"chr":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"dict":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"dir":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"enumerate": CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"fail":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"float":     CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"getattr":   CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"hasattr":   CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"hash":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"int":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"len":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"list":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"max":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"min":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"ord":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"print":     CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"range":     CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"repr":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"reversed":  CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"set":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"sorted":    CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"str":       CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"tuple":     CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"type":      CPUSafe | MemSafe | TimeSafe | IOSafe,
-		"zip":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"abs":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"any":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"all":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"bool":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"bytes":      CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"chr":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"dict":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"dir":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"enumerate":  CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"fail":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"float":      CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"getattr":    CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"hasattr":    CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"hash":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"int":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"len":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"list":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"max":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"min":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"ord":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"print":      CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"range":      CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"repr":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"reversed":   CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"set":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"sorted":     CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"str":        CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"strbuilder": CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"tuple":      CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"type":       CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"zip":        CPUSafe | MemSafe | TimeSafe | IOSafe,
 	}
 
 	for name, flags := range universeSafeties {
@@ -148,6 +151,13 @@ var (
 		"values":     CPUSafe | MemSafe | TimeSafe | IOSafe,
 	}
 
+	intMethods = map[string]*Builtin{
+		"to_str": NewBuiltin("to_str", int_to_str),
+	}
+	intMethodSafeties = map[string]SafetyFlags{
+		"to_str": CPUSafe | MemSafe | TimeSafe | IOSafe,
+	}
+
 	listMethods = map[string]*Builtin{
 		"append": NewBuiltin("append", list_append),
 		"clear":  NewBuiltin("clear", list_clear),
@@ -156,6 +166,7 @@ var (
 		"insert": NewBuiltin("insert", list_insert),
 		"pop":    NewBuiltin("pop", list_pop),
 		"remove": NewBuiltin("remove", list_remove),
+		"sort":   NewBuiltin("sort", list_sort),
 	}
 	listMethodSafeties = map[string]SafetyFlags{
 		"append": CPUSafe | MemSafe | TimeSafe | IOSafe,
@@ -165,6 +176,16 @@ var (
 		"insert": CPUSafe | MemSafe | TimeSafe | IOSafe,
 		"pop":    CPUSafe | MemSafe | TimeSafe | IOSafe,
 		"remove": CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"sort":   CPUSafe | MemSafe | TimeSafe | IOSafe,
+	}
+
+	strbuilderMethods = map[string]*Builtin{
+		"append": NewBuiltin("append", strbuilder_append),
+		"build":  NewBuiltin("build", strbuilder_build),
+	}
+	strbuilderMethodSafeties = map[string]SafetyFlags{
+		"append": CPUSafe | MemSafe | TimeSafe | IOSafe,
+		"build":  CPUSafe | MemSafe | TimeSafe | IOSafe,
 	}
 
 	stringMethods = map[string]*Builtin{
@@ -283,12 +304,24 @@ func init() {
 		}
 	}
 
+	for name, safety := range intMethodSafeties {
+		if builtin, ok := intMethods[name]; ok {
+			builtin.DeclareSafety(safety)
+		}
+	}
+
 	for name, safety := range listMethodSafeties {
 		if builtin, ok := listMethods[name]; ok {
 			builtin.DeclareSafety(safety)
 		}
 	}
 
+	for name, safety := range strbuilderMethodSafeties {
+		if builtin, ok := strbuilderMethods[name]; ok {
+			builtin.DeclareSafety(safety)
+		}
+	}
+
 	for name, safety := range stringMethodSafeties {
 		if builtin, ok := stringMethods[name]; ok {
 			builtin.DeclareSafety(safety)
@@ -521,8 +554,40 @@ func dict(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if len(args) > 1 {
 		return nil, fmt.Errorf("dict: got %d arguments, want at most 1", len(args))
 	}
-	dict := new(Dict)
-	if err := thread.AddAllocs(EstimateSize(dict)); err != nil {
+	if len(args) == 1 && len(kwargs) == 0 {
+		if x, ok := args[0].(*Dict); ok && x.ht.frozen {
+			// Share the entries until the new dict is modified.
+			if err := thread.AddAllocs(EstimateSize(&Dict{})); err != nil {
+				return nil, err
+			}
+			dict := new(Dict)
+			dict.ht.share(&x.ht)
+			return dict, nil
+		}
+	}
+	// Presize the dict when the number of entries is known, to avoid
+	// rehashing as it grows.
+	size := len(kwargs)
+	if len(args) == 1 {
+		switch x := args[0].(type) {
+		case IterableMapping:
+			if n := Len(x); n > 0 {
+				size += n
+			}
+		case Tuple, *List:
+			// The pairs may repeat keys, so reserve only a bounded amount.
+			if n := Len(x); n > maxDictReserve {
+				size += maxDictReserve
+			} else if n > 0 {
+				size += n
+			}
+		}
+	}
+	if err := thread.CheckCollectionLen(size); err != nil {
+		size = 0
+	}
+	dict, err := SafeNewDict(thread, size)
+	if err != nil {
 		return nil, err
 	}
 	if err := updateDict(thread, dict, args, kwargs); err != nil {
@@ -544,6 +609,9 @@ func dir(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if x, ok := args[0].(HasAttrs); ok {
 		names = x.AttrNames()
 	}
+	if err := thread.CheckCollectionLen(len(names)); err != nil {
+		return nil, err
+	}
 	if err := thread.AddSteps(SafeInt(len(names))); err != nil {
 		return nil, err
 	}
@@ -559,6 +627,19 @@ func dir(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	return res, nil
 }
 
+// cancellationCheckInterval is the number of elements a builtin may process
+// between checks for cancellation when its steps have been charged up front.
+const cancellationCheckInterval = 64
+
+// checkCancelledEvery returns the thread's cancellation error, if any, on
+// every cancellationCheckInterval-th iteration i of a builtin's loop.
+func checkCancelledEvery(thread *Thread, i int) error {
+	if i%cancellationCheckInterval != 0 {
+		return nil
+	}
+	return thread.cancelled()
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#enumerate
 func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
@@ -578,6 +659,9 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 
 	if n := Len(iterable); n >= 0 {
 		// common case: known length
+		if err := thread.CheckCollectionLen(n); err != nil {
+			return nil, err
+		}
 		if err := thread.AddSteps(SafeInt(n)); err != nil {
 			return nil, err
 		}
@@ -592,6 +676,9 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 		pairs = make([]Value, 0, n)
 		array := make(Tuple, 2*n) // allocate a single backing array
 		for i := 0; iter.Next(&x); i++ {
+			if err := checkCancelledEvery(thread, i); err != nil {
+				return nil, err
+			}
 			pair := array[:2:2]
 			array = array[2:]
 			pair[0] = MakeInt(start + i)
@@ -603,6 +690,12 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 		pairCost := EstimateSize(Tuple{MakeInt(0), nil})
 		pairsAppender := NewSafeAppender(thread, &pairs)
 		for i := 0; iter.Next(&x); i++ {
+			if err := checkCancelledEvery(thread, i); err != nil {
+				return nil, err
+			}
+			if err := thread.CheckCollectionLen(len(pairs) + 1); err != nil {
+				return nil, err
+			}
 			if err := thread.AddAllocs(pairCost); err != nil {
 				return nil, err
 			}
@@ -743,10 +836,18 @@ func getattr(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 	if err := UnpackPositionalArgs("getattr", args, kwargs, 2, &object, &name, &dflt); err != nil {
 		return nil, err
 	}
+	if err := thread.AddSteps(SafeInt(attrQuerySteps)); err != nil {
+		return nil, err
+	}
 
 	v, err := getAttr(thread, object, name, false)
+	if err == nil {
+		err = checkAttrPermitted(thread, object, name, v)
+	}
 	if err != nil {
-		if dflt != nil {
+		// The default replaces a missing attribute, but never hides
+		// a safety violation or the cancellation of the thread.
+		if dflt != nil && !errors.Is(err, ErrSafety) && thread.cancelled() == nil {
 			return dflt, nil
 		}
 		return nil, nameErr(b, err)
@@ -754,6 +855,20 @@ func getattr(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 	return v, nil
 }
 
+// attrQuerySteps is the number of steps charged by getattr and hasattr for
+// each query, in addition to any charged by the queried value.
+const attrQuerySteps = 1
+
+// checkAttrPermitted returns an error if v, the value of object's attribute
+// name, is not permitted by the thread's safety requirements. Such
+// attributes are hidden from getattr and hasattr.
+func checkAttrPermitted(thread *Thread, object Value, name string, v Value) error {
+	if v, ok := v.(SafetyAware); ok && thread != nil && !thread.Permits(v) {
+		return fmt.Errorf("%s has no .%s field or method permitted by this thread", object.Type(), name)
+	}
+	return nil
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#hasattr
 func hasattr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var object Value
@@ -761,22 +876,27 @@ func hasattr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, err
 	if err := UnpackPositionalArgs("hasattr", args, kwargs, 2, &object, &name); err != nil {
 		return nil, err
 	}
+	if err := thread.AddSteps(SafeInt(attrQuerySteps)); err != nil {
+		return nil, err
+	}
 
 	if object, ok := object.(HasAttrs); ok {
 		if object2, ok := object.(HasSafeAttrs); ok {
-			if _, err := object2.SafeAttr(thread, name); err == ErrNoAttr {
+			if v, err := object2.SafeAttr(thread, name); err == ErrNoAttr {
 				return False, nil
 			} else if _, ok := err.(NoSuchAttrError); ok {
 				return False, nil
 			} else if errors.Is(err, ErrSafety) {
 				return nil, err
+			} else if err == nil {
+				return Bool(checkAttrPermitted(thread, object, name, v) == nil), nil
 			}
 		} else {
 			if err := CheckSafety(thread, NotSafe); err != nil {
 				return nil, err
 			}
 			if v, err := object.Attr(name); err == nil {
-				return Bool(v != nil), nil
+				return Bool(v != nil && checkAttrPermitted(thread, object, name, v) == nil), nil
 			}
 		}
 
@@ -784,7 +904,11 @@ func hasattr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, err
 		// absence of a field: it could occur while computing
 		// the value of a present attribute, or it could be a
 		// "no such attribute" error with details.
-		for _, x := range object.AttrNames() {
+		names := object.AttrNames()
+		if err := thread.AddSteps(SafeInt(len(names))); err != nil {
+			return nil, err
+		}
+		for _, x := range names {
 			if x == name {
 				return True, nil
 			}
@@ -863,15 +987,9 @@ func int_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (res Value, er
 	}()
 
 	if s, ok := AsString(x); ok {
-		// Max result size is going to be base36, where each char is going to have 36 values
-		// To make things easy we will just consider each character to be max 6 bits.
-		// It's pessimistic, but easy.
 		if err := thread.AddSteps(SafeInt(len(s))); err != nil {
 			return nil, err
 		}
-		if err := thread.CheckAllocs(SafeDiv(SafeAdd(SafeMul(len(s), 6), 7), 8)); err != nil {
-			return nil, err
-		}
 
 		b := 10
 		if base != nil {
@@ -884,6 +1002,15 @@ func int_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (res Value, er
 				return nil, fmt.Errorf("int: base must be an integer >= 2 && <= 36")
 			}
 		}
+		// Each digit contributes at most ceil(log2(b)) bits to the result.
+		// With automatic base detection, the widest base is 16.
+		digitBits := 4
+		if b != 0 {
+			digitBits = bits.Len(uint(b - 1))
+		}
+		if err := thread.CheckAllocs(SafeDiv(SafeAdd(SafeMul(len(s), digitBits), 7), 8)); err != nil {
+			return nil, err
+		}
 		res := parseInt(s, b)
 		if res == nil {
 			return nil, fmt.Errorf("int: invalid literal with base %d: %s", b, s)
@@ -994,11 +1121,7 @@ func len_(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if len < 0 {
 		return nil, fmt.Errorf("len: value of type %s has no len", x.Type())
 	}
-	result := Value(MakeInt(len))
-	if err := thread.AddAllocs(EstimateSize(result)); err != nil {
-		return nil, err
-	}
-	return result, nil
+	return safeIntValue(thread, len)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list
@@ -1007,6 +1130,13 @@ func list(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	if err := UnpackPositionalArgs("list", args, kwargs, 0, &iterable); err != nil {
 		return nil, err
 	}
+	if elems, ok := immutableElems(iterable); ok {
+		// Share the elements until the new list is modified.
+		if err := thread.AddAllocs(EstimateSize(&List{})); err != nil {
+			return nil, err
+		}
+		return newSharedList(elems), nil
+	}
 	var elems []Value
 	if iterable != nil {
 		iter, err := SafeIterate(thread, iterable)
@@ -1023,6 +1153,9 @@ func list(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		elemsAppender := NewSafeAppender(thread, &elems)
 		var x Value
 		for iter.Next(&x) {
+			if err := thread.CheckCollectionLen(len(elems) + 1); err != nil {
+				return nil, err
+			}
 			if err := elemsAppender.Append(x); err != nil {
 				return nil, err
 			}
@@ -1101,7 +1234,7 @@ func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 			key = res
 		}
 
-		if ok, err := Compare(op, key, extremeKey); err != nil {
+		if ok, err := safeCompare(thread, op, key, extremeKey); err != nil {
 			return nil, nameErr(b, err)
 		} else if ok {
 			extremum = x
@@ -1134,22 +1267,14 @@ func ord(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 			n := utf8.RuneCountInString(s)
 			return nil, fmt.Errorf("ord: string encodes %d Unicode code points, want 1", n)
 		}
-		res := Value(MakeInt(int(r)))
-		if err := thread.AddAllocs(EstimateSize(res)); err != nil {
-			return nil, err
-		}
-		return res, nil
+		return safeIntValue(thread, int(r))
 
 	case Bytes:
 		// ord(bytes) returns int value of sole byte.
 		if len(x) != 1 {
 			return nil, fmt.Errorf("ord: bytes has length %d, want 1", len(x))
 		}
-		res := Value(MakeInt(int(x[0])))
-		if err := thread.AddAllocs(EstimateSize(res)); err != nil {
-			return nil, err
-		}
-		return res, nil
+		return byteInt(x[0]), nil
 	default:
 		return nil, fmt.Errorf("ord: got %s, want string or bytes", x.Type())
 	}
@@ -1231,19 +1356,13 @@ var (
 )
 
 func (r rangeValue) Len() int          { return r.len }
-func (r rangeValue) Index(i int) Value { return MakeInt(r.start + i*r.step) }
+func (r rangeValue) Index(i int) Value { return intValue(r.start + i*r.step) }
 func (r rangeValue) SafeIndex(thread *Thread, i int) (Value, error) {
 	const safety = CPUSafe | MemSafe | TimeSafe | IOSafe
 	if err := CheckSafety(thread, safety); err != nil {
 		return nil, err
 	}
-	result := Value(MakeInt(r.start + i*r.step))
-	if thread != nil {
-		if err := thread.AddAllocs(EstimateSize(result)); err != nil {
-			return nil, err
-		}
-	}
-	return result, nil
+	return safeIntValue(thread, r.start+i*r.step)
 }
 
 func (r rangeValue) Iterate() Iterator { return &rangeIterator{r: r} }
@@ -1470,7 +1589,7 @@ func set(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#sorted
-func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (l Value, err error) {
+func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	// Oddly, Python's sorted permits all arguments to be positional, thus so do we.
 	var iterable Iterable
 	var key Callable
@@ -1498,6 +1617,9 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (l Value, er
 	valuesAppender := NewSafeAppender(thread, &values)
 	var x Value
 	for iter.Next(&x) {
+		if err := thread.CheckCollectionLen(len(values) + 1); err != nil {
+			return nil, err
+		}
 		if err := valuesAppender.Append(x); err != nil {
 			return nil, err
 		}
@@ -1506,14 +1628,33 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (l Value, er
 		return nil, err
 	}
 
+	if err := sortValues(thread, values, key, reverse); err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(EstimateSize(List{})); err != nil {
+		return nil, err
+	}
+	return NewList(values), nil
+}
+
+// sortValues performs a stable sort of values in place, ordering them by
+// the result of applying key to each, if non-nil. Each call to key and
+// each comparison counts as one step.
+func sortValues(thread *Thread, values []Value, key Callable, reverse bool) (err error) {
 	// Derive keys from values by applying key function.
 	var keys []Value
 	if key != nil {
+		if err := thread.AddAllocs(EstimateMakeSize([]Value{}, SafeInt(len(values)))); err != nil {
+			return err
+		}
 		keys = make([]Value, len(values))
 		for i, v := range values {
+			if err := thread.AddSteps(SafeInt(1)); err != nil {
+				return err
+			}
 			k, err := Call(thread, key, Tuple{v}, nil)
 			if err != nil {
-				return nil, err // to preserve backtrace, don't modify error
+				return err // to preserve backtrace, don't modify error
 			}
 			keys[i] = k
 		}
@@ -1534,10 +1675,7 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (l Value, er
 	} else {
 		sort.Stable(slice)
 	}
-	if err := thread.AddAllocs(EstimateSize(List{})); err != nil {
-		return nil, err
-	}
-	return NewList(slice.values), nil
+	return nil
 }
 
 type sortError struct {
@@ -1559,7 +1697,7 @@ func (s *sortSlice) Less(i, j int) bool {
 	if s.keys == nil {
 		keys = s.values
 	}
-	ok, err := Compare(syntax.LT, keys[i], keys[j])
+	ok, err := safeCompare(s.thread, syntax.LT, keys[i], keys[j])
 	if err != nil {
 		panic(sortError{err})
 	}
@@ -1646,6 +1784,13 @@ func tuple(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error
 	if len(args) == 0 {
 		return Tuple(nil), nil
 	}
+	if elems, ok := immutableElems(iterable); ok {
+		// The elements can never change, so may be shared.
+		if err := thread.AddAllocs(EstimateSize(Tuple{})); err != nil {
+			return nil, err
+		}
+		return Tuple(elems[:len(elems):len(elems)]), nil
+	}
 	iter, err := SafeIterate(thread, iterable)
 	if err != nil {
 		return nil, err
@@ -1721,6 +1866,9 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	var result []Value
 	if rows >= 0 {
 		// length known
+		if err := thread.CheckCollectionLen(rows); err != nil {
+			return nil, err
+		}
 
 		// Equalise step cost for fast and slow path.
 		if err := thread.AddSteps(SafeInt(rows)); err != nil {
@@ -1738,6 +1886,9 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		result = make([]Value, rows)
 		array := make(Tuple, arraySize64) // allocate a single backing array
 		for i := 0; i < rows; i++ {
+			if err := checkCancelledEvery(thread, i); err != nil {
+				return nil, err
+			}
 			tuple := array[:cols:cols]
 			array = array[cols:]
 			for j, iter := range iters {
@@ -1755,7 +1906,10 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		tupleSize := SafeAdd(EstimateMakeSize(Tuple{}, SafeInt(cols)), SliceTypeOverhead)
 		appender := NewSafeAppender(thread, &result)
 	outer:
-		for {
+		for i := 0; ; i++ {
+			if err := checkCancelledEvery(thread, i); err != nil {
+				return nil, err
+			}
 			if err := thread.AddAllocs(tupleSize); err != nil {
 				return nil, err
 			}
@@ -1768,6 +1922,9 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 					break outer
 				}
 			}
+			if err := thread.CheckCollectionLen(len(result) + 1); err != nil {
+				return nil, err
+			}
 			if err := appender.Append(tuple); err != nil {
 				return nil, err
 			}
@@ -1822,6 +1979,9 @@ func dict_items(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	}
 	receiver := b.Receiver().(*Dict)
 	len := receiver.Len()
+	if err := thread.CheckCollectionLen(len); err != nil {
+		return nil, err
+	}
 	if err := thread.AddSteps(SafeInt(len)); err != nil {
 		return nil, err
 	}
@@ -1851,6 +2011,9 @@ func dict_keys(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 	}
 	recv := b.Receiver().(*Dict)
 	len := recv.Len()
+	if err := thread.CheckCollectionLen(len); err != nil {
+		return nil, err
+	}
 	if err := thread.AddSteps(SafeInt(len)); err != nil {
 		return nil, err
 	}
@@ -1938,6 +2101,9 @@ func dict_values(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	}
 	recv := b.Receiver().(*Dict)
 	len := recv.Len()
+	if err := thread.CheckCollectionLen(len); err != nil {
+		return nil, err
+	}
 	if err := thread.AddSteps(SafeInt(len)); err != nil {
 		return nil, err
 	}
@@ -1949,6 +2115,31 @@ func dict_values(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	return NewList(recv.Values()), nil
 }
 
+// int_to_str returns the digits of an int in the given base, which must be
+// between 2 and 36 inclusive. Digits above 9 are written as lowercase letters.
+func int_to_str(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	base := 10
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &base); err != nil {
+		return nil, err
+	}
+	if base < 2 || base > 36 {
+		return nil, nameErr(b, "base must be an integer >= 2 && <= 36")
+	}
+
+	recv := b.Receiver().(Int)
+	// Each digit represents at least floor(log2(base)) bits, so the number
+	// of digits is at most bitlen/floor(log2(base)) + 1, plus one for the sign.
+	maxDigits := SafeAdd(SafeDiv(recv.bitLen(), bits.Len(uint(base))-1), 2)
+	if err := thread.AddSteps(maxDigits); err != nil {
+		return nil, err
+	}
+	resultSize := SafeAdd(EstimateMakeSize([]byte{}, maxDigits), StringTypeOverhead)
+	if err := thread.AddAllocs(resultSize); err != nil {
+		return nil, err
+	}
+	return String(recv.text(base)), nil
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·append
 func list_append(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var object Value
@@ -1959,6 +2150,12 @@ func list_append(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("append to"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := recv.unshare(thread); err != nil {
+		return nil, err
+	}
+	if err := thread.CheckCollectionLen(len(recv.elems) + 1); err != nil {
+		return nil, nameErr(b, err)
+	}
 	elemsAppender := NewSafeAppender(thread, &recv.elems)
 	if err := elemsAppender.Append(object); err != nil {
 		return nil, err
@@ -1975,7 +2172,7 @@ func list_clear(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	if err := thread.AddSteps(SafeInt(recv.Len())); err != nil {
 		return nil, err
 	}
-	if err := recv.Clear(); err != nil {
+	if err := recv.clear(thread); err != nil {
 		return nil, nameErr(b, err)
 	}
 	return None, nil
@@ -1991,6 +2188,9 @@ func list_extend(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("extend"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := recv.unshare(thread); err != nil {
+		return nil, err
+	}
 
 	if err := safeListExtend(thread, recv, iterable); err != nil {
 		return nil, err
@@ -2016,7 +2216,7 @@ func list_index(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	}
 
 	for i := start; i < end; i++ {
-		if eq, err := Equal(recv.elems[i], value); err != nil {
+		if eq, err := safeEqual(thread, recv.elems[i], value); err != nil {
 			return nil, nameErr(b, err)
 		} else if eq {
 			res := Value(MakeInt(i))
@@ -2043,6 +2243,12 @@ func list_insert(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("insert into"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := recv.unshare(thread); err != nil {
+		return nil, err
+	}
+	if err := thread.CheckCollectionLen(len(recv.elems) + 1); err != nil {
+		return nil, nameErr(b, err)
+	}
 
 	if index < 0 {
 		index += recv.Len()
@@ -2082,11 +2288,14 @@ func list_remove(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	if err := recv.checkMutable("remove from"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := recv.unshare(thread); err != nil {
+		return nil, err
+	}
 	if err := thread.AddSteps(SafeInt(recv.Len())); err != nil {
 		return nil, err
 	}
 	for i, elem := range recv.elems {
-		if eq, err := Equal(elem, value); err != nil {
+		if eq, err := safeEqual(thread, elem, value); err != nil {
 			return nil, fmt.Errorf("remove: %v", err)
 		} else if eq {
 			recv.elems = append(recv.elems[:i], recv.elems[i+1:]...)
@@ -2096,6 +2305,33 @@ func list_remove(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 	return nil, fmt.Errorf("remove: element not found")
 }
 
+// https://github.com/google/starlark-go/blob/master/doc/spec.md#list·sort
+func list_sort(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var key Callable
+	var reverse bool
+	if err := UnpackArgs(b.Name(), args, kwargs,
+		"key?", &key,
+		"reverse?", &reverse,
+	); err != nil {
+		return nil, err
+	}
+	recv := b.Receiver().(*List)
+	if err := recv.checkMutable("sort"); err != nil {
+		return nil, nameErr(b, err)
+	}
+	if err := recv.unshare(thread); err != nil {
+		return nil, err
+	}
+
+	// Prevent the key function from modifying the list during the sort.
+	recv.itercount++
+	defer func() { recv.itercount-- }()
+	if err := sortValues(thread, recv.elems, key, reverse); err != nil {
+		return nil, err
+	}
+	return None, nil
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·pop
 func list_pop(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	recv := b.Receiver()
@@ -2115,6 +2351,9 @@ func list_pop(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 	if err := list.checkMutable("pop from"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := list.unshare(thread); err != nil {
+		return nil, err
+	}
 	if err := thread.AddSteps(SafeSub(n, i)); err != nil {
 		return nil, err
 	}
@@ -2233,14 +2472,7 @@ func (it *bytesIterator) Next(p *Value) bool {
 	if it.bytes == "" {
 		return false
 	}
-	value := Value(MakeInt(int(it.bytes[0])))
-	if it.thread != nil {
-		if err := it.thread.AddAllocs(EstimateSize(value)); err != nil {
-			it.err = err
-			return false
-		}
-	}
-	*p = value
+	*p = byteInt(it.bytes[0])
 
 	it.bytes = it.bytes[1:]
 	return true
@@ -3022,6 +3254,9 @@ func string_split(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 		return nil, fmt.Errorf("split: got %s for separator, want string", sep_.Type())
 	}
 
+	if err := thread.CheckCollectionLen(len(res)); err != nil {
+		return nil, err
+	}
 	listSize := EstimateMakeSize([]Value{String("")}, SafeInt(len(res)))
 	resultSize := EstimateSize(&List{})
 	if err := thread.AddAllocs(SafeAdd(listSize, resultSize)); err != nil {
@@ -3094,32 +3329,41 @@ func string_splitlines(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &keepends); err != nil {
 		return nil, err
 	}
-	var lines []string
-	if s := string(b.Receiver().(String)); s != "" {
-		if err := thread.AddSteps(SafeInt(len(s))); err != nil {
-			return nil, err
-		}
-		// TODO(adonovan): handle CRLF correctly.
-		if keepends {
-			lines = strings.SplitAfter(s, "\n")
-		} else {
-			lines = strings.Split(s, "\n")
-		}
-		if strings.HasSuffix(s, "\n") {
-			lines = lines[:len(lines)-1]
-		}
+	s := string(b.Receiver().(String))
+	if err := thread.AddSteps(SafeInt(len(s))); err != nil {
+		return nil, err
+	}
+	// The lines are counted before splitting so that no intermediate slice
+	// of substrings is allocated.
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	if err := thread.CheckCollectionLen(n); err != nil {
+		return nil, err
 	}
 	var itemTemplate String
 	resultSize := SafeAdd(
-		EstimateMakeSize([]Value{itemTemplate}, SafeInt(len(lines))),
+		EstimateMakeSize([]Value{itemTemplate}, SafeInt(n)),
 		EstimateSize(&List{}),
 	)
 	if err := thread.AddAllocs(resultSize); err != nil {
 		return nil, err
 	}
-	list := make([]Value, len(lines))
-	for i, x := range lines {
-		list[i] = String(x)
+	list := make([]Value, 0, n)
+	// TODO(adonovan): handle CRLF correctly.
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			list = append(list, String(s))
+			break
+		}
+		if keepends {
+			list = append(list, String(s[:i+1]))
+		} else {
+			list = append(list, String(s[:i]))
+		}
+		s = s[i+1:]
 	}
 	return NewList(list), nil
 }
@@ -3364,6 +3608,10 @@ func string_find_impl(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple, al
 	}
 	var result Value
 	if i < 0 {
+		// The whole slice was searched.
+		if err := thread.AddSteps(SafeInt(len(slice))); err != nil {
+			return nil, err
+		}
 		if !allowError {
 			return nil, nameErr(b, "substring not found")
 		}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"regexp"
 	"strings"
 	"testing"
@@ -51,10 +52,18 @@ func TestDictMethodSafeties(t *testing.T) {
 	testBuiltinSafeties(t, "dict", starlark.DictMethods, starlark.DictMethodSafeties)
 }
 
+func TestIntMethodSafeties(t *testing.T) {
+	testBuiltinSafeties(t, "int", starlark.IntMethods, starlark.IntMethodSafeties)
+}
+
 func TestListMethodSafeties(t *testing.T) {
 	testBuiltinSafeties(t, "list", starlark.ListMethods, starlark.ListMethodSafeties)
 }
 
+func TestStrbuilderMethodSafeties(t *testing.T) {
+	testBuiltinSafeties(t, "strbuilder", starlark.StrbuilderMethods, starlark.StrbuilderMethodSafeties)
+}
+
 func TestStringMethodSafeties(t *testing.T) {
 	testBuiltinSafeties(t, "string", starlark.StringMethods, starlark.StringMethodSafeties)
 }
@@ -1731,6 +1740,38 @@ func TestEnumerateCancellation(t *testing.T) {
 			}
 		})
 	})
+	t.Run("mid-iteration", func(t *testing.T) {
+		const cancelAt = 100
+		const maxN = 100000
+		for _, seqType := range []string{"sequence", "iterable"} {
+			t.Run(seqType, func(t *testing.T) {
+				thread := &starlark.Thread{}
+				calls := 0
+				nth := func(thread *starlark.Thread, n int) (starlark.Value, error) {
+					calls++
+					if n == cancelAt {
+						thread.Cancel("done")
+					}
+					return starlark.None, nil
+				}
+				var iter starlark.Iterable
+				if seqType == "sequence" {
+					iter = &testSequence{nth: nth, maxN: maxN}
+				} else {
+					iter = &testIterable{nth: nth, maxN: maxN}
+				}
+				_, err := starlark.Call(thread, enumerate, starlark.Tuple{iter}, nil)
+				if err == nil {
+					t.Error("expected cancellation")
+				} else if !isStarlarkCancellation(err) {
+					t.Errorf("expected cancellation, got: %v", err)
+				}
+				if calls >= maxN/10 {
+					t.Errorf("enumerate did not stop promptly: %d elements consumed", calls)
+				}
+			})
+		}
+	})
 }
 
 type unsafeTestStringer struct {
@@ -2376,8 +2417,8 @@ func TestGetattrSteps(t *testing.T) {
 		}
 		st := startest.From(t)
 		st.RequireSafety(starlark.CPUSafe)
-		st.SetMinSteps(int64(len(attrName)))
-		st.SetMaxSteps(int64(len(attrName)))
+		st.SetMinSteps(int64(len(attrName)) + 1)
+		st.SetMaxSteps(int64(len(attrName)) + 1)
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
 				_, err := starlark.Call(thread, getattr, starlark.Tuple{value, starlark.String(attrName)}, nil)
@@ -2516,7 +2557,8 @@ func TestHasattrSteps(t *testing.T) {
 			t.Run(test.name, func(t *testing.T) {
 				st := startest.From(t)
 				st.RequireSafety(starlark.CPUSafe)
-				st.SetMaxSteps(0)
+				st.SetMinSteps(1)
+				st.SetMaxSteps(1)
 				st.RunThread(func(thread *starlark.Thread) {
 					for i := 0; i < st.N; i++ {
 						args := starlark.Tuple{test.input, missing}
@@ -2564,7 +2606,8 @@ func TestHasattrSteps(t *testing.T) {
 			t.Run(test.name, func(t *testing.T) {
 				st := startest.From(t)
 				st.RequireSafety(starlark.CPUSafe)
-				st.SetMaxSteps(0)
+				st.SetMinSteps(1)
+				st.SetMaxSteps(1)
 				st.RunThread(func(thread *starlark.Thread) {
 					for i := 0; i < st.N; i++ {
 						args := starlark.Tuple{test.input, starlark.String(test.attr)}
@@ -2681,6 +2724,81 @@ func TestHasattrAllocs(t *testing.T) {
 	})
 }
 
+func TestAttrSafetyGating(t *testing.T) {
+	getattr, hasattr := starlark.Universe["getattr"], starlark.Universe["hasattr"]
+
+	unsafeMethod := starlark.NewBuiltinWithSafety("unsafe", starlark.NotSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	value := &testSafeAttr{
+		safety: starlark.Safe,
+		attr: func(thread *starlark.Thread, name string) (starlark.Value, error) {
+			if name == "unsafe" {
+				return unsafeMethod, nil
+			}
+			return nil, starlark.ErrNoAttr
+		},
+	}
+	name := starlark.String("unsafe")
+
+	t.Run("permitted", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		if result, err := starlark.Call(thread, hasattr, starlark.Tuple{value, name}, nil); err != nil {
+			t.Fatal(err)
+		} else if result != starlark.True {
+			t.Error("permitted attribute is not present")
+		}
+		if result, err := starlark.Call(thread, getattr, starlark.Tuple{value, name}, nil); err != nil {
+			t.Fatal(err)
+		} else if result != unsafeMethod {
+			t.Errorf("unexpected result: %v", result)
+		}
+	})
+
+	t.Run("hidden", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.CPUSafe)
+		if result, err := starlark.Call(thread, hasattr, starlark.Tuple{value, name}, nil); err != nil {
+			t.Fatal(err)
+		} else if result != starlark.False {
+			t.Error("unpermitted attribute is present")
+		}
+		dflt := starlark.String("default")
+		if result, err := starlark.Call(thread, getattr, starlark.Tuple{value, name, dflt}, nil); err != nil {
+			t.Fatal(err)
+		} else if result != dflt {
+			t.Errorf("expected default, got %v", result)
+		}
+		if _, err := starlark.Call(thread, getattr, starlark.Tuple{value, name}, nil); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("default-preserves-safety-errors", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.CPUSafe)
+		unsafeValue := &testSafeAttr{
+			safety: starlark.NotSafe,
+			attr: func(thread *starlark.Thread, name string) (starlark.Value, error) {
+				return starlark.None, nil
+			},
+		}
+		_, err := starlark.Call(thread, getattr, starlark.Tuple{unsafeValue, name, starlark.None}, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+	})
+
+	t.Run("default-preserves-cancellation", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Cancel("done")
+		_, err := starlark.Call(thread, getattr, starlark.Tuple{value, starlark.String("missing"), starlark.None}, nil)
+		if err == nil {
+			t.Error("expected cancellation")
+		}
+	})
+}
+
 func TestHashSteps(t *testing.T) {
 	hash, ok := starlark.Universe["hash"]
 	if !ok {
@@ -2859,6 +2977,45 @@ func TestIntAllocs(t *testing.T) {
 	})
 }
 
+func TestIntToStrSteps(t *testing.T) {
+	st := startest.From(t)
+	st.RequireSafety(starlark.CPUSafe)
+	st.SetMinSteps(16)
+	st.SetMaxSteps(17)
+	st.RunThread(func(thread *starlark.Thread) {
+		x := starlark.MakeBigInt(new(big.Int).Lsh(big.NewInt(1), uint(st.N*64)))
+		int_to_str, _ := x.Attr("to_str")
+		if int_to_str == nil {
+			st.Fatal("no such method: int.to_str")
+		}
+		_, err := starlark.Call(thread, int_to_str, starlark.Tuple{starlark.MakeInt(16)}, nil)
+		if err != nil {
+			st.Error(err)
+		}
+	})
+}
+
+func TestIntToStrAllocs(t *testing.T) {
+	for _, base := range []int{2, 10, 16, 36} {
+		t.Run(fmt.Sprintf("base=%d", base), func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				x := starlark.MakeBigInt(new(big.Int).Lsh(big.NewInt(-1), uint(st.N*64)))
+				int_to_str, _ := x.Attr("to_str")
+				if int_to_str == nil {
+					st.Fatal("no such method: int.to_str")
+				}
+				result, err := starlark.Call(thread, int_to_str, starlark.Tuple{starlark.MakeInt(base)}, nil)
+				if err != nil {
+					st.Error(err)
+				}
+				st.KeepAlive(result)
+			})
+		})
+	}
+}
+
 func TestLenSteps(t *testing.T) {
 	len_, ok := starlark.Universe["len"]
 	if !ok {
@@ -3119,6 +3276,76 @@ func TestListAllocs(t *testing.T) {
 	})
 }
 
+func TestFrozenCopyAllocs(t *testing.T) {
+	elems := make([]starlark.Value, 1000)
+	for i := range elems {
+		elems[i] = starlark.MakeInt(i)
+	}
+	frozenList := starlark.NewList(elems)
+	frozenList.Freeze()
+	frozenDict := starlark.NewDict(len(elems))
+	for _, elem := range elems {
+		frozenDict.SetKey(elem, elem)
+	}
+	frozenDict.Freeze()
+
+	tests := []struct {
+		name, src string
+	}{{
+		name: "list",
+		src:  "list(frozen_list)",
+	}, {
+		name: "concatenation",
+		src:  "frozen_list + []",
+	}, {
+		name: "tuple",
+		src:  "tuple(frozen_list)",
+	}, {
+		name: "list-of-tuple",
+		src:  "list(frozen_tuple)",
+	}, {
+		name: "dict",
+		src:  "dict(frozen_dict)",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe | starlark.CPUSafe)
+			st.SetMaxSteps(10)
+			st.AddValue("frozen_list", frozenList)
+			st.AddValue("frozen_tuple", starlark.Tuple(elems))
+			st.AddValue("frozen_dict", frozenDict)
+			st.RunString(fmt.Sprintf(`
+for _ in st.ntimes():
+	st.keep_alive(%s)
+`, test.src))
+		})
+	}
+
+	modifiedTests := []struct {
+		name, src string
+	}{{
+		name: "modified-list",
+		src:  "x = list(frozen_list); x[0] = None",
+	}, {
+		name: "modified-dict",
+		src:  "x = dict(frozen_dict); x[0] = None",
+	}}
+	for _, test := range modifiedTests {
+		t.Run(test.name, func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.AddValue("frozen_list", frozenList)
+			st.AddValue("frozen_dict", frozenDict)
+			st.RunString(fmt.Sprintf(`
+for _ in st.ntimes():
+	%s
+	st.keep_alive(x)
+`, test.src))
+		})
+	}
+}
+
 func TestListCancellation(t *testing.T) {
 	list, ok := starlark.Universe["list"]
 	if !ok {
@@ -3613,6 +3840,76 @@ func TestRangeAllocs(t *testing.T) {
 	})
 }
 
+func TestSmallValueAllocs(t *testing.T) {
+	const text = `("The quick brown fox jumps over the lazy dog. " * 8)`
+	tests := []struct {
+		name  string
+		input string
+	}{{
+		name:  "range",
+		input: "range(-128, 256)",
+	}, {
+		name:  "string",
+		input: text,
+	}, {
+		name:  "elems",
+		input: text + ".elems()",
+	}, {
+		name:  "elem_ords",
+		input: text + ".elem_ords()",
+	}, {
+		name:  "codepoints",
+		input: text + ".codepoints()",
+	}, {
+		name:  "codepoint_ords",
+		input: text + ".codepoint_ords()",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := starlark.Eval(&starlark.Thread{}, "small_values", test.input, starlark.Universe)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.SetMaxAllocs(0)
+			st.RunThread(func(thread *starlark.Thread) {
+				if indexable, ok := input.(starlark.SafeIndexable); ok {
+					for i := 0; i < st.N; i++ {
+						value, err := indexable.SafeIndex(thread, i%indexable.Len())
+						if err != nil {
+							st.Fatal(err)
+						}
+						st.KeepAlive(value)
+					}
+					return
+				}
+
+				// Iterators are long enough for their own allocation
+				// to round away.
+				var iter starlark.Iterator
+				for i := 0; i < st.N; i++ {
+					var value starlark.Value
+					for iter == nil || !iter.Next(&value) {
+						if iter != nil {
+							if err := iter.Err(); err != nil {
+								st.Fatal(err)
+							}
+							iter.Done()
+						}
+						if iter, err = starlark.SafeIterate(thread, input); err != nil {
+							st.Fatal(err)
+						}
+					}
+					st.KeepAlive(value)
+				}
+				iter.Done()
+			})
+		})
+	}
+}
+
 func TestReprSteps(t *testing.T) {
 	testWriteValueSteps(t, "repr", 0, false, []writeValueStepTest{{
 		name:  "String",
@@ -4148,6 +4445,37 @@ func TestSortedSteps(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("key", func(t *testing.T) {
+		const iterSize = 100
+		iter := &testIterable{
+			nth: func(_ *starlark.Thread, n int) (starlark.Value, error) {
+				return starlark.MakeInt(-n), nil
+			},
+			maxN: iterSize,
+		}
+		identity := starlark.NewBuiltinWithSafety(
+			"identity",
+			starlark.CPUSafe|starlark.MemSafe|starlark.TimeSafe|starlark.IOSafe,
+			func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+				return args[0], nil
+			})
+
+		const listConstructionSteps = 2 * iterSize
+		const keySteps = iterSize
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMinSteps(listConstructionSteps + keySteps + iterSize)
+		st.SetMaxSteps(listConstructionSteps + keySteps + iterSize*iterSize)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				_, err := starlark.Call(thread, sorted, starlark.Tuple{iter, identity}, nil)
+				if err != nil {
+					st.Error(err)
+				}
+			}
+		})
+	})
 }
 
 func TestSortedAllocs(t *testing.T) {
@@ -4426,6 +4754,102 @@ func TestStrCancellation(t *testing.T) {
 	testWriteValueCancellation(t, "str")
 }
 
+func TestStrbuilderAllocs(t *testing.T) {
+	strbuilder, ok := starlark.Universe["strbuilder"]
+	if !ok {
+		t.Fatal("no such builtin: strbuilder")
+	}
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		for i := 0; i < st.N; i++ {
+			sb, err := starlark.Call(thread, strbuilder, nil, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(sb)
+		}
+	})
+}
+
+func TestStrbuilderAppendSteps(t *testing.T) {
+	const piece = "hello, world"
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.CPUSafe)
+	st.SetMinSteps(int64(len(piece)))
+	st.SetMaxSteps(int64(len(piece)))
+	st.RunThread(func(thread *starlark.Thread) {
+		sb, err := starlark.Call(thread, starlark.Universe["strbuilder"], nil, nil)
+		if err != nil {
+			st.Fatal(err)
+		}
+		sb_append, _ := sb.(starlark.HasAttrs).Attr("append")
+		if sb_append == nil {
+			st.Fatal("no such method: strbuilder.append")
+		}
+		for i := 0; i < st.N; i++ {
+			if _, err := starlark.Call(thread, sb_append, starlark.Tuple{starlark.String(piece)}, nil); err != nil {
+				st.Error(err)
+			}
+		}
+	})
+}
+
+func TestStrbuilderAppendAllocs(t *testing.T) {
+	const piece = "hello, world"
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	// Growth is amortized, so the mean cost of an append is bounded by
+	// a small multiple of its length.
+	st.SetMaxAllocs(4 * int64(len(piece)))
+	st.RunThread(func(thread *starlark.Thread) {
+		sb, err := starlark.Call(thread, starlark.Universe["strbuilder"], nil, nil)
+		if err != nil {
+			st.Fatal(err)
+		}
+		sb_append, _ := sb.(starlark.HasAttrs).Attr("append")
+		if sb_append == nil {
+			st.Fatal("no such method: strbuilder.append")
+		}
+		for i := 0; i < st.N; i++ {
+			if _, err := starlark.Call(thread, sb_append, starlark.Tuple{starlark.String(piece)}, nil); err != nil {
+				st.Error(err)
+			}
+		}
+		st.KeepAlive(sb)
+	})
+}
+
+func TestStrbuilderBuildAllocs(t *testing.T) {
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.SetMaxAllocs(mustInt64(starlark.StringTypeOverhead))
+	st.RunThread(func(thread *starlark.Thread) {
+		sb, err := starlark.Call(thread, starlark.Universe["strbuilder"], nil, nil)
+		if err != nil {
+			st.Fatal(err)
+		}
+		sb_append, _ := sb.(starlark.HasAttrs).Attr("append")
+		sb_build, _ := sb.(starlark.HasAttrs).Attr("build")
+		if sb_append == nil || sb_build == nil {
+			st.Fatal("no such method: strbuilder.append or strbuilder.build")
+		}
+		if _, err := starlark.Call(thread, sb_append, starlark.Tuple{starlark.String("hello, world")}, nil); err != nil {
+			st.Fatal(err)
+		}
+		for i := 0; i < st.N; i++ {
+			result, err := starlark.Call(thread, sb_build, nil, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		}
+	})
+}
+
 func TestTupleSteps(t *testing.T) {
 	tuple, ok := starlark.Universe["tuple"]
 	if !ok {
@@ -5089,6 +5513,38 @@ func TestZipCancellation(t *testing.T) {
 			})
 		})
 	})
+	t.Run("mid-iteration", func(t *testing.T) {
+		const cancelAt = 100
+		const maxN = 100000
+		for _, seqType := range []string{"sequence", "iterable"} {
+			t.Run(seqType, func(t *testing.T) {
+				thread := &starlark.Thread{}
+				calls := 0
+				nth := func(thread *starlark.Thread, n int) (starlark.Value, error) {
+					calls++
+					if n == cancelAt {
+						thread.Cancel("done")
+					}
+					return starlark.None, nil
+				}
+				var iter starlark.Iterable
+				if seqType == "sequence" {
+					iter = &testSequence{nth: nth, maxN: maxN}
+				} else {
+					iter = &testIterable{nth: nth, maxN: maxN}
+				}
+				_, err := starlark.Call(thread, zip, starlark.Tuple{iter, iter}, nil)
+				if err == nil {
+					t.Error("expected cancellation")
+				} else if !isStarlarkCancellation(err) {
+					t.Errorf("expected cancellation, got: %v", err)
+				}
+				if calls >= maxN/10 {
+					t.Errorf("zip did not stop promptly: %d elements consumed", calls)
+				}
+			})
+		}
+	})
 }
 
 func TestBytesElemsSteps(t *testing.T) {
@@ -5372,7 +5828,7 @@ func TestDictGetSteps(t *testing.T) {
 		t.Run("present", func(t *testing.T) {
 			st := startest.From(t)
 			st.SetMinSteps(1)
-			st.SetMaxSteps(dictSize / 8)
+			st.SetMaxSteps(starlark.BucketListSteps(dictSize / 8))
 			st.RequireSafety(starlark.CPUSafe)
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
@@ -5388,8 +5844,8 @@ func TestDictGetSteps(t *testing.T) {
 		t.Run("missing", func(t *testing.T) {
 			st := startest.From(t)
 			// Each bucket can contain 8 elements tops
-			st.SetMinSteps((dictSize / 8))
-			st.SetMaxSteps((dictSize / 8) + 1)
+			st.SetMinSteps(starlark.BucketListSteps(dictSize / 8))
+			st.SetMaxSteps(starlark.BucketListSteps((dictSize / 8) + 1))
 			st.RequireSafety(starlark.CPUSafe)
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
@@ -5784,7 +6240,7 @@ func TestDictPopSteps(t *testing.T) {
 			st := startest.From(t)
 			st.RequireSafety(starlark.CPUSafe)
 			st.SetMinSteps(1)
-			st.SetMaxSteps((dictSize / 8) + 1)
+			st.SetMaxSteps(starlark.BucketListSteps((dictSize / 8) + 1))
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
 					input := starlark.Value(starlark.MakeInt64(int64(i%dictSize) << 32))
@@ -5801,8 +6257,8 @@ func TestDictPopSteps(t *testing.T) {
 			st := startest.From(t)
 			// Each bucket can contain 8 elements tops
 			st.RequireSafety(starlark.CPUSafe)
-			st.SetMinSteps(dictSize / 8)
-			st.SetMaxSteps((dictSize / 8) + 1)
+			st.SetMinSteps(starlark.BucketListSteps(dictSize / 8))
+			st.SetMaxSteps(starlark.BucketListSteps((dictSize / 8) + 1))
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
 					input := starlark.Value(starlark.MakeInt64(dictSize << 32))
@@ -5954,7 +6410,7 @@ func TestDictPopitemSteps(t *testing.T) {
 
 		st := startest.From(t)
 		st.SetMinSteps(1)
-		st.SetMaxSteps((dictSize / 8) + 1)
+		st.SetMaxSteps(starlark.BucketListSteps((dictSize / 8) + 1))
 		st.RequireSafety(starlark.CPUSafe)
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
@@ -6090,8 +6546,8 @@ func TestDictSetdefaultSteps(t *testing.T) {
 
 		st := startest.From(t)
 		st.RequireSafety(starlark.CPUSafe)
-		st.SetMinSteps((dictSize / 8) * 2)
-		st.SetMaxSteps((dictSize / 8) * 2)
+		st.SetMinSteps(2 * starlark.BucketListSteps(dictSize/8-1))
+		st.SetMaxSteps(2 * starlark.BucketListSteps(dictSize/8))
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
 				key := starlark.MakeInt64(int64(-i) << 32)
@@ -7224,6 +7680,141 @@ func TestListRemoveCancellation(t *testing.T) {
 	})
 }
 
+func TestListSortSteps(t *testing.T) {
+	const listSize = 100
+	reversed := func() *starlark.List {
+		elems := make([]starlark.Value, listSize)
+		for i := range elems {
+			elems[i] = starlark.MakeInt(listSize - i)
+		}
+		return starlark.NewList(elems)
+	}
+	identity := starlark.NewBuiltinWithSafety(
+		"identity",
+		starlark.CPUSafe|starlark.MemSafe|starlark.TimeSafe|starlark.IOSafe,
+		func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			return args[0], nil
+		})
+
+	t.Run("no-key", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMinSteps(listSize - 1)
+		st.SetMaxSteps(listSize * listSize) // Should be at least better than quadratic.
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				list_sort, _ := reversed().Attr("sort")
+				if list_sort == nil {
+					t.Fatal("no such method: list.sort")
+				}
+				_, err := starlark.Call(thread, list_sort, nil, nil)
+				if err != nil {
+					st.Error(err)
+				}
+			}
+		})
+	})
+
+	t.Run("key", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMinSteps(listSize + listSize - 1)
+		st.SetMaxSteps(listSize + listSize*listSize)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				list_sort, _ := reversed().Attr("sort")
+				if list_sort == nil {
+					t.Fatal("no such method: list.sort")
+				}
+				kwargs := []starlark.Tuple{{starlark.String("key"), identity}}
+				_, err := starlark.Call(thread, list_sort, nil, kwargs)
+				if err != nil {
+					st.Error(err)
+				}
+			}
+		})
+	})
+}
+
+func TestListSortAllocs(t *testing.T) {
+	const listSize = 100
+	identity := starlark.NewBuiltinWithSafety(
+		"identity",
+		starlark.CPUSafe|starlark.MemSafe|starlark.TimeSafe|starlark.IOSafe,
+		func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+			return args[0], nil
+		})
+
+	t.Run("no-key", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.SetMaxAllocs(0)
+		st.RunThread(func(thread *starlark.Thread) {
+			list := starlark.NewList(make([]starlark.Value, 0, listSize))
+			for i := 0; i < listSize; i++ {
+				list.Append(starlark.MakeInt(listSize - i))
+			}
+			list_sort, _ := list.Attr("sort")
+			if list_sort == nil {
+				t.Fatal("no such method: list.sort")
+			}
+			for i := 0; i < st.N; i++ {
+				_, err := starlark.Call(thread, list_sort, nil, nil)
+				if err != nil {
+					st.Error(err)
+				}
+			}
+			st.KeepAlive(list)
+		})
+	})
+
+	t.Run("key", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.RunThread(func(thread *starlark.Thread) {
+			list := starlark.NewList(make([]starlark.Value, 0, st.N))
+			for i := 0; i < st.N; i++ {
+				list.Append(starlark.MakeInt(st.N - i))
+			}
+			list_sort, _ := list.Attr("sort")
+			if list_sort == nil {
+				t.Fatal("no such method: list.sort")
+			}
+			kwargs := []starlark.Tuple{{starlark.String("key"), identity}}
+			_, err := starlark.Call(thread, list_sort, nil, kwargs)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(list)
+		})
+	})
+}
+
+func TestListSortCancellation(t *testing.T) {
+	st := startest.From(t)
+	st.RequireSafety(starlark.TimeSafe)
+	st.SetMaxSteps(0)
+	st.RunThread(func(thread *starlark.Thread) {
+		thread.Cancel("done")
+		list := starlark.NewList(make([]starlark.Value, 0, st.N))
+		for i := 0; i < st.N; i++ {
+			list.Append(starlark.MakeInt(st.N - i))
+		}
+		list_sort, _ := list.Attr("sort")
+		if list_sort == nil {
+			t.Fatal("no such method: list.sort")
+		}
+		_, err := starlark.Call(thread, list_sort, nil, nil)
+		if err == nil {
+			if st.N > 1 {
+				st.Error("expected cancellation")
+			}
+		} else if !isStarlarkCancellation(err) {
+			st.Errorf("expected cancellation, got: %v", err)
+		}
+	})
+}
+
 func TestStringCapitalizeSteps(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -7656,6 +8247,30 @@ func testStringFindMethodSteps(t *testing.T, name string) {
 			}
 		})
 	})
+
+	t.Run("absent", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMinSteps(int64(len("a🍖")))
+		st.SetMaxSteps(int64(len("a🍖")))
+		st.RunThread(func(thread *starlark.Thread) {
+			haystack := starlark.String(strings.Repeat("a🍖", st.N))
+			method, _ := haystack.Attr(name)
+			if method == nil {
+				t.Fatalf("no such method: string.%s", name)
+			}
+
+			needle := starlark.String("b")
+			_, err := starlark.Call(thread, method, starlark.Tuple{needle}, nil)
+			if strings.HasSuffix(name, "index") {
+				if err == nil {
+					st.Error("expected error")
+				}
+			} else if err != nil {
+				st.Error(err)
+			}
+		})
+	})
 }
 
 func testStringFindMethodAllocs(t *testing.T, name string) {
@@ -9238,15 +9853,44 @@ Curabitur nec velit fringilla arcu lacinia commodo.`)
 		t.Fatal("no such method: string.splitlines")
 	}
 
-	st := startest.From(t)
-	st.RequireSafety(starlark.MemSafe)
-	st.RunThread(func(thread *starlark.Thread) {
-		for i := 0; i < st.N; i++ {
-			result, err := starlark.Call(thread, string_splitlines, nil, nil)
-			if err != nil {
-				st.Error(err)
-			}
-			st.KeepAlive(result)
+	for _, keepends := range []starlark.Bool{starlark.False, starlark.True} {
+		t.Run(fmt.Sprintf("keepends=%s", keepends), func(t *testing.T) {
+			st := startest.From(t)
+			st.RequireSafety(starlark.MemSafe)
+			st.RunThread(func(thread *starlark.Thread) {
+				for i := 0; i < st.N; i++ {
+					result, err := starlark.Call(thread, string_splitlines, starlark.Tuple{keepends}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				}
+			})
+		})
+	}
+
+	t.Run("large", func(t *testing.T) {
+		for _, keepends := range []starlark.Bool{starlark.False, starlark.True} {
+			t.Run(fmt.Sprintf("keepends=%s", keepends), func(t *testing.T) {
+				st := startest.From(t)
+				st.RequireSafety(starlark.MemSafe)
+				// Each line is a 16-byte list element referring to a
+				// 16-byte string header, with some slack for the list's
+				// growth.
+				st.SetMaxAllocs(40)
+				st.RunThread(func(thread *starlark.Thread) {
+					str := starlark.String(strings.Repeat("a\n", st.N))
+					string_splitlines, _ := str.Attr("splitlines")
+					if string_splitlines == nil {
+						st.Fatal("no such method: string.splitlines")
+					}
+					result, err := starlark.Call(thread, string_splitlines, starlark.Tuple{keepends}, nil)
+					if err != nil {
+						st.Error(err)
+					}
+					st.KeepAlive(result)
+				})
+			})
 		}
 	})
 }
@@ -9676,8 +10320,8 @@ func TestSetAddSteps(t *testing.T) {
 
 		st := startest.From(t)
 		st.RequireSafety(starlark.CPUSafe)
-		st.SetMinSteps(setSize / 8)
-		st.SetMaxSteps((setSize / 8) * 2)
+		st.SetMinSteps(starlark.BucketListSteps(setSize / 8))
+		st.SetMaxSteps(2 * starlark.BucketListSteps(setSize/8))
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
 				key := starlark.MakeInt64(int64(i) << 32)
@@ -10109,7 +10753,7 @@ func TestSetDiscardSteps(t *testing.T) {
 			st := startest.From(t)
 			st.RequireSafety(starlark.CPUSafe)
 			st.SetMinSteps(1)
-			st.SetMaxSteps((setSize / 8) + 1)
+			st.SetMaxSteps(starlark.BucketListSteps((setSize / 8) + 1))
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
 					input := starlark.MakeInt64(int64(i%setSize) << 32)
@@ -10126,8 +10770,8 @@ func TestSetDiscardSteps(t *testing.T) {
 			st := startest.From(t)
 			st.RequireSafety(starlark.CPUSafe)
 			// Each bucket can contain 8 elements tops
-			st.SetMinSteps(setSize / 8)
-			st.SetMaxSteps((setSize / 8) + 1)
+			st.SetMinSteps(starlark.BucketListSteps(setSize / 8))
+			st.SetMaxSteps(starlark.BucketListSteps((setSize / 8) + 1))
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
 					input := starlark.MakeInt64(setSize << 32)
@@ -10755,7 +11399,7 @@ func TestSetPopSteps(t *testing.T) {
 		st := startest.From(t)
 		st.SetMinSteps(1)
 		// Each bucket can contain at most 8 elements.
-		st.SetMaxSteps(setSize / 8)
+		st.SetMaxSteps(starlark.BucketListSteps(setSize / 8))
 		st.RequireSafety(starlark.CPUSafe)
 		st.RunThread(func(thread *starlark.Thread) {
 			for i := 0; i < st.N; i++ {
@@ -10909,7 +11553,7 @@ func TestSetRemoveSteps(t *testing.T) {
 			st := startest.From(t)
 			st.SetMinSteps(1)
 			// Each bucket can contain at most 8 elements.
-			st.SetMaxSteps((setSize + 7) / 8)
+			st.SetMaxSteps(starlark.BucketListSteps((setSize + 7) / 8))
 			st.RequireSafety(starlark.CPUSafe)
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
@@ -10925,8 +11569,8 @@ func TestSetRemoveSteps(t *testing.T) {
 
 		t.Run("missing", func(t *testing.T) {
 			st := startest.From(t)
-			st.SetMinSteps((setSize + 7) / 8)
-			st.SetMaxSteps((setSize + 7) / 8)
+			st.SetMinSteps(starlark.BucketListSteps((setSize + 7) / 8))
+			st.SetMaxSteps(starlark.BucketListSteps((setSize + 7) / 8))
 			st.RequireSafety(starlark.CPUSafe)
 			st.RunThread(func(thread *starlark.Thread) {
 				for i := 0; i < st.N; i++ {
@@ -11451,6 +12095,107 @@ func TestSafeIterateCancellation(t *testing.T) {
 	})
 }
 
+// legacyIterable is an Iterable whose iterator is not safety-aware, but
+// which declares its own safety.
+type legacyIterable struct {
+	n int
+}
+
+var _ starlark.Iterable = &legacyIterable{}
+var _ starlark.SafetyAware = &legacyIterable{}
+
+func (li *legacyIterable) Freeze() {}
+func (li *legacyIterable) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: %s", li.Type())
+}
+func (li *legacyIterable) String() string               { return "legacyIterable" }
+func (li *legacyIterable) Truth() starlark.Bool         { return li.n != 0 }
+func (li *legacyIterable) Type() string                 { return "legacyIterable" }
+func (li *legacyIterable) Safety() starlark.SafetyFlags { return starlark.Safe }
+func (li *legacyIterable) Iterate() starlark.Iterator   { return &legacyIterator{n: li.n} }
+
+type legacyIterator struct {
+	i, n int
+}
+
+func (it *legacyIterator) Next(p *starlark.Value) bool {
+	if it.i >= it.n {
+		return false
+	}
+	*p = starlark.String(strings.Repeat("x", 64))
+	it.i++
+	return true
+}
+func (it *legacyIterator) Done()      {}
+func (it *legacyIterator) Err() error { return nil }
+
+func TestSafeIterateLegacy(t *testing.T) {
+	t.Run("undeclared", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.CPUSafe)
+		undeclared := &struct{ starlark.Iterable }{&legacyIterable{}}
+		if _, err := starlark.SafeIterate(thread, undeclared); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+	})
+
+	t.Run("steps", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMinSteps(1)
+		st.SetMaxSteps(1)
+		st.RunThread(func(thread *starlark.Thread) {
+			iter, err := starlark.SafeIterate(thread, &legacyIterable{n: st.N})
+			if err != nil {
+				st.Fatal(err)
+			}
+			defer iter.Done()
+			var v starlark.Value
+			for iter.Next(&v) {
+				// Do nothing.
+			}
+			if err := iter.Err(); err != nil {
+				st.Error(err)
+			}
+		})
+	})
+
+	t.Run("allocs", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.MemSafe)
+		st.RunThread(func(thread *starlark.Thread) {
+			iter, err := starlark.SafeIterate(thread, &legacyIterable{n: st.N})
+			if err != nil {
+				st.Fatal(err)
+			}
+			defer iter.Done()
+			var v starlark.Value
+			for iter.Next(&v) {
+				st.KeepAlive(v)
+			}
+			if err := iter.Err(); err != nil {
+				st.Error(err)
+			}
+		})
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.TimeSafe)
+		thread.Cancel("done")
+		iter := starlark.NewSafeIterator((&legacyIterable{n: 10}).Iterate(), starlark.Safe)
+		iter.BindThread(thread)
+		defer iter.Done()
+		var v starlark.Value
+		if iter.Next(&v) {
+			t.Error("iteration continued after cancellation")
+		}
+		if err := iter.Err(); !isStarlarkCancellation(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
 func TestTupleIterationSteps(t *testing.T) {
 	st := startest.From(t)
 	st.RequireSafety(starlark.CPUSafe)
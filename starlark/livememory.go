@@ -0,0 +1,180 @@
+package starlark
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// An AccountingMode determines what the allocations counted by a thread,
+// and limited by SetMaxAllocs, represent.
+type AccountingMode int
+
+const (
+	// CumulativeAllocs counts every allocation declared by the thread,
+	// whether or not the memory is still in use. This is the default.
+	CumulativeAllocs AccountingMode = iota
+
+	// LiveMemory counts an estimate of the memory still reachable from the
+	// program running on the thread. Allocations are declared as usual, but
+	// whenever enough have been declared since the last measurement, the
+	// memory reachable from the thread's call stack, operand stacks,
+	// iterators and module globals is measured, and the thread's count is
+	// lowered to the result. This allows long-running loops which keep a
+	// constant residency to run indefinitely under a memory limit.
+	//
+	// Measurement happens only between instructions while no builtin is
+	// being called, when all memory in use by the program is reachable
+	// from those roots. Values retained by the host, for example in
+	// thread-local storage or from earlier executions, are not counted
+	// once the thread has been measured. The time spent measuring is
+	// amortized against the allocations which prompted it and is not
+	// counted as steps.
+	LiveMemory
+)
+
+var accountingModeNames = [...]string{
+	CumulativeAllocs: "cumulative allocations",
+	LiveMemory:       "live memory",
+}
+
+func (mode AccountingMode) String() string {
+	if 0 <= int(mode) && int(mode) < len(accountingModeNames) {
+		return accountingModeNames[mode]
+	}
+	return "unknown accounting mode"
+}
+
+// SetAccountingMode sets what the allocations counted by the thread
+// represent. SetAccountingMode must be called before execution begins.
+func (thread *Thread) SetAccountingMode(mode AccountingMode) {
+	switch mode {
+	case CumulativeAllocs:
+		thread.liveMemory = nil
+	case LiveMemory:
+		// The thread is first measured at its first allocation, when
+		// the interval to the next measurement is established.
+		thread.liveMemory = &liveMemory{}
+	default:
+		panic("SetAccountingMode: unknown accounting mode")
+	}
+}
+
+// AccountingMode returns the mode set by SetAccountingMode.
+func (thread *Thread) AccountingMode() AccountingMode {
+	if thread.liveMemory != nil {
+		return LiveMemory
+	}
+	return CumulativeAllocs
+}
+
+// liveMemory records the state of a thread's live memory accounting.
+type liveMemory struct {
+	// next is the count of allocations at which the thread is next
+	// measured. It is guarded by the thread's allocsLock.
+	next int64
+
+	// due is set atomically once the thread's allocations reach next.
+	due uint32
+}
+
+// liveMemoryMinimum is the least count of allocations at which a thread
+// without an allocation limit is measured.
+const liveMemoryMinimum = 1 << 20
+
+// liveMemoryMinFraction is the reciprocal of the least fraction of its
+// allocation limit which a thread must allocate between measurements.
+const liveMemoryMinFraction = 16
+
+// noteAllocs records that the thread's allocations have reached allocs.
+// The thread's allocsLock must be held.
+func (lm *liveMemory) noteAllocs(allocs SafeInteger) {
+	if allocs64, ok := allocs.Int64(); ok && allocs64 >= lm.next {
+		atomic.StoreUint32(&lm.due, 1)
+	}
+}
+
+// checkLiveMemory measures the thread's live memory if enough allocations
+// have been declared since it was last measured. It is called by the
+// interpreter between instructions.
+func (thread *Thread) checkLiveMemory() {
+	lm := thread.liveMemory
+	if atomic.LoadUint32(&lm.due) == 0 {
+		return
+	}
+	for _, fr := range thread.stack {
+		if _, ok := fr.callable.(*Function); !ok {
+			// A builtin may hold memory unreachable from the roots.
+			return
+		}
+	}
+	atomic.StoreUint32(&lm.due, 0)
+
+	live, ok := EstimateSize(thread.liveRoots()).Int64()
+	if !ok {
+		return
+	}
+
+	thread.allocsLock.Lock()
+	defer thread.allocsLock.Unlock()
+
+	current, ok := thread.allocs.Int64()
+	if !ok {
+		return
+	}
+	if live < current {
+		// The estimate may include memory, such as the program itself,
+		// which was never counted, so the count is only ever lowered.
+		delta := SafeInt(live - current)
+		if thread.pool == nil || thread.pool.draw(poolAllocs, delta, true) == nil {
+			thread.allocs = SafeInt(live)
+			current = live
+		}
+	}
+
+	// Measure again once half of the remaining headroom has been used,
+	// so that the cost of measurement is amortized against allocation.
+	// Near the limit, measurements are spaced by a fixed fraction of it,
+	// so that a program whose residency approaches the limit is not
+	// measured after every allocation.
+	if max := thread.maxAllocs; max <= 0 || max == math.MaxInt64 {
+		lm.next = 2 * current
+		if lm.next < liveMemoryMinimum {
+			lm.next = liveMemoryMinimum
+		}
+	} else {
+		interval := (max - current) / 2
+		if interval < max/liveMemoryMinFraction {
+			interval = max / liveMemoryMinFraction
+		}
+		lm.next = current + interval
+	}
+	lm.noteAllocs(thread.allocs)
+}
+
+// liveRoots holds the values from which the memory in use by a thread's
+// program is reachable.
+type liveRoots struct {
+	locals    [][]Value
+	operands  [][]Value
+	iterators [][]Iterator
+	globals   [][]Value
+}
+
+// liveRoots returns the roots of the memory in use by the thread, which
+// must be executing only Starlark functions.
+func (thread *Thread) liveRoots() *liveRoots {
+	roots := &liveRoots{}
+	modules := make(map[*module]bool)
+	for _, fr := range thread.stack {
+		roots.locals = append(roots.locals, fr.locals)
+		roots.operands = append(roots.operands, fr.operands)
+		if fr.iterstack != nil {
+			roots.iterators = append(roots.iterators, *fr.iterstack)
+		}
+		if m := fr.callable.(*Function).module; !modules[m] {
+			modules[m] = true
+			roots.globals = append(roots.globals, m.globals)
+		}
+	}
+	return roots
+}
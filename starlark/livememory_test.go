@@ -0,0 +1,88 @@
+package starlark_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestLiveMemory(t *testing.T) {
+	const maxAllocs = 4 << 20
+
+	run := func(mode starlark.AccountingMode, src string) error {
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(maxAllocs)
+		thread.SetAccountingMode(mode)
+		_, err := starlark.ExecFile(thread, "live_memory.star", src, nil)
+		return err
+	}
+
+	t.Run("constant-residency", func(t *testing.T) {
+		const src = `
+def f():
+	for i in range(1000):
+		l = [None] * 10000
+f()
+`
+		if err := run(starlark.CumulativeAllocs, src); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected cumulative accounting to fail: got %v", err)
+		}
+		if err := run(starlark.LiveMemory, src); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("iterators", func(t *testing.T) {
+		const src = `
+def f():
+	for x in range(1000):
+		for y in [None] * 10000:
+			break
+f()
+`
+		if err := run(starlark.LiveMemory, src); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("growing-residency", func(t *testing.T) {
+		const src = `
+def f():
+	kept = []
+	for i in range(1000):
+		kept.append([None] * 10000)
+f()
+`
+		if err := run(starlark.LiveMemory, src); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected growing residency to fail: got %v", err)
+		}
+	})
+
+	t.Run("globals", func(t *testing.T) {
+		const src = `
+kept = []
+def f():
+	for i in range(1000):
+		kept.append([None] * 10000)
+f()
+`
+		if err := run(starlark.LiveMemory, src); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected growing residency to fail: got %v", err)
+		}
+	})
+}
+
+func TestAccountingMode(t *testing.T) {
+	thread := &starlark.Thread{}
+	if mode := thread.AccountingMode(); mode != starlark.CumulativeAllocs {
+		t.Errorf("unexpected default mode: %v", mode)
+	}
+	thread.SetAccountingMode(starlark.LiveMemory)
+	if mode := thread.AccountingMode(); mode != starlark.LiveMemory {
+		t.Errorf("unexpected mode: %v", mode)
+	}
+	if s := starlark.LiveMemory.String(); s != "live memory" {
+		t.Errorf("unexpected name: %q", s)
+	}
+}
@@ -9,5 +9,5 @@ var seed = maphash.MakeSeed()
 
 func maphash_string(s string) uint32 {
 	h := maphash.String(seed, s)
-	return uint32(h>>32) | uint32(h)
+	return uint32(h>>32) ^ uint32(h)
 }
@@ -9,5 +9,5 @@ import _ "unsafe"
 func runtime_stringhash(s string, seed uintptr) uintptr
 
 func maphash_string(s string) uint32 {
-	return uint32(runtime_stringhash(s, 0))
+	return uint32(runtime_stringhash(s, uintptr(hashSeed)))
 }
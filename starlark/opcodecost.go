@@ -0,0 +1,65 @@
+package starlark
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/starlark/internal/compile"
+)
+
+// opcodeCostTable holds the steps counted for executing each instruction.
+type opcodeCostTable [compile.OpcodeMax + 1]int64
+
+// defaultOpcodeCosts counts one step for each instruction, except those
+// which only manage the operand stack.
+var defaultOpcodeCosts = func() *opcodeCostTable {
+	var costs opcodeCostTable
+	for op := range costs {
+		if addStep(compile.Opcode(op)) {
+			costs[op] = 1
+		}
+	}
+	return &costs
+}()
+
+// SetOpcodeCosts overrides the number of steps counted when the thread
+// executes each kind of bytecode instruction. Costs are keyed by the name of
+// the instruction as shown in disassembly, for example "call", "iterpush" or
+// "constant". Instructions which are not mentioned keep their default cost:
+// zero for those which only manage the operand stack ("nop", "dup", "dup2",
+// "pop" and "exch") and one for all others.
+//
+// SetOpcodeCosts returns an error, leaving the costs unchanged, if a name is
+// not that of an instruction or a cost is negative. It must be called before
+// execution begins.
+func (thread *Thread) SetOpcodeCosts(costs map[string]int64) error {
+	table := *defaultOpcodeCosts
+	for name, cost := range costs {
+		op, ok := opcodeByName(name)
+		if !ok {
+			return fmt.Errorf("SetOpcodeCosts: unknown opcode %q", name)
+		}
+		if cost < 0 {
+			return fmt.Errorf("SetOpcodeCosts: negative cost for %s: %d", name, cost)
+		}
+		table[op] = cost
+	}
+	thread.opcodeCosts = &table
+	return nil
+}
+
+func (thread *Thread) opcodeCostTable() *opcodeCostTable {
+	if thread.opcodeCosts == nil {
+		return defaultOpcodeCosts
+	}
+	return thread.opcodeCosts
+}
+
+func opcodeByName(name string) (compile.Opcode, bool) {
+	for op := compile.Opcode(0); op <= compile.OpcodeMax; op++ {
+		if strings.TrimSpace(op.String()) == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
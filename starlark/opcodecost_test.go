@@ -0,0 +1,75 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestSetOpcodeCosts(t *testing.T) {
+	const src = `
+def f():
+	pass
+
+def g():
+	for i in range(10):
+		f()
+`
+	steps := func(costs map[string]int64) int64 {
+		thread := &starlark.Thread{}
+		if costs != nil {
+			if err := thread.SetOpcodeCosts(costs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		globals, err := starlark.ExecFile(thread, "costs.star", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread = &starlark.Thread{}
+		if costs != nil {
+			if err := thread.SetOpcodeCosts(costs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := starlark.Call(thread, globals["g"], nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		steps, _ := thread.Steps()
+		return steps
+	}
+
+	base := steps(nil)
+	if unchanged := steps(map[string]int64{}); unchanged != base {
+		t.Errorf("empty cost table changed steps: expected %d but got %d", base, unchanged)
+	}
+
+	// g calls range once and f ten times.
+	if expensive := steps(map[string]int64{"call": 11}); expensive != base+10*11 {
+		t.Errorf("incorrect steps with expensive calls: expected %d but got %d", base+10*11, expensive)
+	}
+
+	// ITERJMP executes once per iteration and once more to exit the loop.
+	if expensive := steps(map[string]int64{"iterjmp": 3}); expensive != base+2*11 {
+		t.Errorf("incorrect steps with expensive iteration: expected %d but got %d", base+2*11, expensive)
+	}
+
+	if free := steps(map[string]int64{"call": 0}); free != base-11 {
+		t.Errorf("incorrect steps with free calls: expected %d but got %d", base-11, free)
+	}
+}
+
+func TestSetOpcodeCostsInvalid(t *testing.T) {
+	thread := &starlark.Thread{}
+	if err := thread.SetOpcodeCosts(map[string]int64{"nonesuch": 1}); err == nil {
+		t.Error("expected error for unknown opcode")
+	} else if expected := `SetOpcodeCosts: unknown opcode "nonesuch"`; err.Error() != expected {
+		t.Errorf("unexpected error: expected %q but got %q", expected, err.Error())
+	}
+	if err := thread.SetOpcodeCosts(map[string]int64{"call": -1}); err == nil {
+		t.Error("expected error for negative cost")
+	}
+	if err := thread.SetOpcodeCosts(map[string]int64{"call_var_kw": 2}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
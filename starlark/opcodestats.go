@@ -0,0 +1,36 @@
+package starlark
+
+import "github.com/canonical/starlark/internal/compile"
+
+// opcodeCounts holds the number of times each opcode was executed.
+type opcodeCounts [compile.OpcodeMax + 1]int64
+
+// EnableOpcodeStats causes the thread to count the bytecode instructions it
+// subsequently executes, by opcode. The counts are reported by OpcodeStats.
+//
+// Functions which are already executing when EnableOpcodeStats is called
+// are not counted until they return.
+func (thread *Thread) EnableOpcodeStats() {
+	if thread.opcodeCounts == nil {
+		thread.opcodeCounts = new(opcodeCounts)
+	}
+}
+
+// OpcodeStats returns the number of times each opcode has been executed
+// since EnableOpcodeStats was called, keyed by opcode name, for example
+// "call". Opcodes which were not executed are omitted. If counting is not
+// enabled, OpcodeStats returns nil.
+//
+// OpcodeStats must not be called while the thread is executing.
+func (thread *Thread) OpcodeStats() map[string]int64 {
+	if thread.opcodeCounts == nil {
+		return nil
+	}
+	stats := make(map[string]int64)
+	for op, count := range thread.opcodeCounts {
+		if count != 0 {
+			stats[compile.Opcode(op).String()] = count
+		}
+	}
+	return stats
+}
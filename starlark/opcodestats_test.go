@@ -0,0 +1,44 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestOpcodeStats(t *testing.T) {
+	const src = `
+def f():
+	pass
+
+def g():
+	for i in range(10):
+		f()
+`
+	globals, err := starlark.ExecFile(&starlark.Thread{}, "stats.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thread := &starlark.Thread{}
+	if stats := thread.OpcodeStats(); stats != nil {
+		t.Errorf("expected nil stats before enabling, got %v", stats)
+	}
+	thread.EnableOpcodeStats()
+	if _, err := starlark.Call(thread, globals["g"], nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := thread.OpcodeStats()
+	if calls := stats["call"]; calls != 11 {
+		t.Errorf("incorrect call count: expected 11 but got %d", calls)
+	}
+	if iterjmps := stats["iterjmp"]; iterjmps != 11 {
+		t.Errorf("incorrect iterjmp count: expected 11 but got %d", iterjmps)
+	}
+	for name, count := range stats {
+		if count <= 0 {
+			t.Errorf("unexpected count for %s: %d", name, count)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package starlark
+
+import "sync/atomic"
+
+// Pause asks the thread to suspend execution at the next instruction
+// boundary of a Starlark function, until Resume is called. A thread which
+// is in a call to a built-in function pauses once the call returns to
+// Starlark code. A paused thread holds no locks, so a host scheduler may use
+// Pause and Resume to time-slice many threads, for example pausing each
+// after it has taken a given number of steps.
+//
+// Cancelling a paused thread resumes it, and it promptly fails with the
+// cancellation error.
+//
+// Unlike most methods of Thread, it is safe to call Pause from any
+// goroutine, even if the thread is actively executing.
+func (thread *Thread) Pause() {
+	thread.pauseLock.Lock()
+	defer thread.pauseLock.Unlock()
+
+	if thread.resume == nil {
+		thread.resume = make(chan struct{})
+		atomic.StoreUint32(&thread.pauseRequested, 1)
+	}
+}
+
+// Resume resumes a thread suspended by Pause. If the thread has been asked
+// to pause but has not yet done so, the request is withdrawn.
+//
+// It is safe to call Resume from any goroutine.
+func (thread *Thread) Resume() {
+	thread.pauseLock.Lock()
+	defer thread.pauseLock.Unlock()
+
+	if thread.resume != nil {
+		atomic.StoreUint32(&thread.pauseRequested, 0)
+		close(thread.resume)
+		thread.resume = nil
+	}
+}
+
+// Paused reports whether the thread is currently suspended by Pause.
+//
+// It is safe to call Paused from any goroutine.
+func (thread *Thread) Paused() bool {
+	return atomic.LoadUint32(&thread.paused) != 0
+}
+
+// pausing reports whether the thread has been asked to pause.
+func (thread *Thread) pausing() bool {
+	return atomic.LoadUint32(&thread.pauseRequested) != 0
+}
+
+// waitResume suspends the thread until it is resumed or cancelled.
+func (thread *Thread) waitResume() error {
+	thread.pauseLock.Lock()
+	resume := thread.resume
+	thread.pauseLock.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	atomic.StoreUint32(&thread.paused, 1)
+	defer atomic.StoreUint32(&thread.paused, 0)
+
+	select {
+	case <-resume:
+		return nil
+	case <-(*threadContext)(thread).Done():
+		return thread.cancelled()
+	}
+}
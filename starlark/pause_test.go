@@ -0,0 +1,85 @@
+package starlark_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestPauseResume(t *testing.T) {
+	const src = `
+def f():
+	n = 0
+	for i in range(1000): n += i
+	return n
+result = f()
+`
+	waitPaused := func(t *testing.T, thread *starlark.Thread) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !thread.Paused() {
+			if time.Now().After(deadline) {
+				t.Fatal("thread did not pause")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Pause()
+		done := make(chan error, 1)
+		go func() {
+			_, err := starlark.ExecFile(thread, "pause.star", src, nil)
+			done <- err
+		}()
+		waitPaused(t, thread)
+
+		steps, _ := thread.Steps()
+		time.Sleep(10 * time.Millisecond)
+		if after, _ := thread.Steps(); after != steps {
+			t.Errorf("paused thread advanced from %d to %d steps", steps, after)
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("paused thread finished: %v", err)
+		default:
+		}
+
+		thread.Resume()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if thread.Paused() {
+			t.Error("thread still reported as paused")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Pause()
+		done := make(chan error, 1)
+		go func() {
+			_, err := starlark.ExecFile(thread, "pause.star", src, nil)
+			done <- err
+		}()
+		waitPaused(t, thread)
+
+		thread.Cancel("stop")
+		err := <-done
+		var cancelErr *starlark.CancellationError
+		if !errors.As(err, &cancelErr) {
+			t.Errorf("expected cancellation, got %v", err)
+		}
+	})
+
+	t.Run("withdrawn", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Pause()
+		thread.Resume()
+		if _, err := starlark.ExecFile(thread, "pause.star", src, nil); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
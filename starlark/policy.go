@@ -0,0 +1,99 @@
+package starlark
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Policy describes a combined budget for the execution of a thread, which
+// is exhausted as soon as any one of its limits is reached. For example, a
+// policy may allow at most 10 million steps, 64MiB of allocations or 200ms
+// of wall-clock time, whichever comes first.
+//
+// A policy is plain data, so limits may be logged, compared or loaded from
+// configuration and applied to a thread with a single call to SetPolicy.
+// A zero limit means that the resource is not limited.
+type Policy struct {
+	MaxSteps         int64         `json:"max_steps,omitempty"`
+	MaxAllocs        int64         `json:"max_allocs,omitempty"`
+	Timeout          time.Duration `json:"timeout,omitempty"`
+	MaxCPUTime       time.Duration `json:"max_cpu_time,omitempty"`
+	MaxCollectionLen int           `json:"max_collection_len,omitempty"`
+}
+
+// String returns a description of the policy's limits, for example
+// "steps <= 10000000, allocs <= 67108864, time <= 200ms".
+func (policy Policy) String() string {
+	var limits []string
+	if policy.MaxSteps > 0 {
+		limits = append(limits, fmt.Sprintf("steps <= %d", policy.MaxSteps))
+	}
+	if policy.MaxAllocs > 0 {
+		limits = append(limits, fmt.Sprintf("allocs <= %d", policy.MaxAllocs))
+	}
+	if policy.Timeout > 0 {
+		limits = append(limits, fmt.Sprintf("time <= %v", policy.Timeout))
+	}
+	if policy.MaxCPUTime > 0 {
+		limits = append(limits, fmt.Sprintf("cpu time <= %v", policy.MaxCPUTime))
+	}
+	if policy.MaxCollectionLen > 0 {
+		limits = append(limits, fmt.Sprintf("collection len <= %d", policy.MaxCollectionLen))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, ", ")
+}
+
+// SetPolicy applies each limit of policy to the thread, as if by SetMaxSteps,
+// SetMaxAllocs, SetMaxCPUTime and SetMaxCollectionLen. If the policy has a timeout, the
+// thread is cancelled with a TimeoutSafetyError once that much time has
+// passed since SetPolicy was called.
+//
+// SetPolicy must be called at most once, before execution begins.
+func (thread *Thread) SetPolicy(policy Policy) {
+	thread.policy = policy
+	if policy.MaxSteps > 0 {
+		thread.SetMaxSteps(policy.MaxSteps)
+	}
+	if policy.MaxAllocs > 0 {
+		thread.SetMaxAllocs(policy.MaxAllocs)
+	}
+	if policy.MaxCPUTime > 0 {
+		thread.SetMaxCPUTime(policy.MaxCPUTime)
+	}
+	if policy.MaxCollectionLen > 0 {
+		thread.SetMaxCollectionLen(policy.MaxCollectionLen)
+	}
+	if policy.Timeout > 0 {
+		timeout := policy.Timeout
+		timer := time.AfterFunc(timeout, func() {
+			thread.cancel(CancelTimeout, &TimeoutSafetyError{Timeout: timeout})
+		})
+
+		thread.contextLock.Lock()
+		thread.timeoutTimer = timer
+		thread.contextLock.Unlock()
+	}
+}
+
+// Policy returns the policy set by SetPolicy, if any.
+func (thread *Thread) Policy() Policy {
+	return thread.policy
+}
+
+// A TimeoutSafetyError reports that a thread ran for longer than the timeout
+// of its policy.
+type TimeoutSafetyError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutSafetyError) Error() string {
+	return fmt.Sprintf("timeout exceeded (%v)", e.Timeout)
+}
+
+func (e *TimeoutSafetyError) Is(err error) bool {
+	return err == ErrSafety
+}
@@ -0,0 +1,87 @@
+package starlark_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestPolicyString(t *testing.T) {
+	tests := []struct {
+		policy   starlark.Policy
+		expected string
+	}{{
+		policy:   starlark.Policy{},
+		expected: "unlimited",
+	}, {
+		policy: starlark.Policy{
+			MaxSteps:  10_000_000,
+			MaxAllocs: 64 << 20,
+			Timeout:   200 * time.Millisecond,
+		},
+		expected: "steps <= 10000000, allocs <= 67108864, time <= 200ms",
+	}, {
+		policy:   starlark.Policy{MaxCollectionLen: 10},
+		expected: "collection len <= 10",
+	}, {
+		policy:   starlark.Policy{MaxCPUTime: time.Second},
+		expected: "cpu time <= 1s",
+	}}
+	for _, test := range tests {
+		if s := test.policy.String(); s != test.expected {
+			t.Errorf("incorrect policy description: expected %q but got %q", test.expected, s)
+		}
+	}
+}
+
+func TestPolicyLimits(t *testing.T) {
+	const src = `
+def loop():
+	for i in range(1 << 30):
+		pass
+loop()
+`
+	tests := []struct {
+		name   string
+		policy starlark.Policy
+		kind   starlark.CancellationKind
+	}{{
+		name:   "steps",
+		policy: starlark.Policy{MaxSteps: 1000, Timeout: time.Hour},
+		kind:   starlark.CancelSteps,
+	}, {
+		name:   "timeout",
+		policy: starlark.Policy{MaxSteps: 1 << 40, Timeout: time.Millisecond},
+		kind:   starlark.CancelTimeout,
+	}, {
+		name:   "cpu-time",
+		policy: starlark.Policy{MaxSteps: 1 << 40, Timeout: time.Hour, MaxCPUTime: time.Millisecond},
+		kind:   starlark.CancelCPUTime,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := &starlark.Thread{}
+			thread.SetPolicy(test.policy)
+			if policy := thread.Policy(); policy != test.policy {
+				t.Errorf("incorrect policy: expected %v but got %v", test.policy, policy)
+			}
+
+			_, err := starlark.ExecFile(thread, "loop.star", src, nil)
+			if err == nil {
+				t.Fatal("expected cancellation")
+			}
+			if !errors.Is(err, starlark.ErrSafety) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			var cancelErr *starlark.CancellationError
+			if !errors.As(err, &cancelErr) {
+				t.Fatalf("expected cancellation error, got %v", err)
+			}
+			if cancelErr.Kind != test.kind {
+				t.Errorf("incorrect cancellation kind: expected %v but got %v", test.kind, cancelErr.Kind)
+			}
+		})
+	}
+}
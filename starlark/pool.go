@@ -0,0 +1,186 @@
+package starlark
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// A ResourcePool is a budget of steps and allocations shared by the threads
+// attached to it, which may execute concurrently. Resources counted by an
+// attached thread are also drawn from the pool, and from each of the pool's
+// ancestors, so that pools may be nested, for example to limit both each
+// tenant of a service and the service as a whole.
+//
+// A ResourcePool is safe for use by multiple goroutines.
+type ResourcePool struct {
+	parent *ResourcePool
+
+	mu     sync.Mutex
+	steps  poolCounter
+	allocs poolCounter
+}
+
+// poolCounter counts one kind of resource drawn from a pool.
+type poolCounter struct {
+	used SafeInteger
+	max  int64 // MaxInt64 if unlimited
+}
+
+// poolLimit returns the limit of a poolCounter given the max passed to
+// NewResourcePool.
+func poolLimit(max int64) int64 {
+	if max <= 0 {
+		return math.MaxInt64
+	}
+	return max
+}
+
+// NewResourcePool returns a new pool which allows at most maxSteps steps and
+// maxAllocs allocations to be counted by the threads attached to it. If a
+// limit is zero, negative or MaxInt64, that resource is not limited.
+func NewResourcePool(maxSteps, maxAllocs int64) *ResourcePool {
+	return &ResourcePool{
+		steps:  poolCounter{max: poolLimit(maxSteps)},
+		allocs: poolCounter{max: poolLimit(maxAllocs)},
+	}
+}
+
+// NewChild returns a new pool with the given limits, as for
+// NewResourcePool, whose resources are also drawn from pool.
+func (pool *ResourcePool) NewChild(maxSteps, maxAllocs int64) *ResourcePool {
+	child := NewResourcePool(maxSteps, maxAllocs)
+	child.parent = pool
+	return child
+}
+
+// NewChildFraction returns a new pool whose resources are also drawn from
+// pool, and which may use at most the given fraction of the steps and
+// allocations remaining to pool when it is created. This reserves headroom
+// for pool's other users: a subsystem given such a child, such as the
+// threads which load modules or perform work in parallel on behalf of a
+// script, cannot starve the script even though both share pool's budget.
+//
+// The fraction must be greater than zero and at most one. A resource which
+// is unlimited in pool and its ancestors is also unlimited in the child.
+func (pool *ResourcePool) NewChildFraction(fraction float64) *ResourcePool {
+	if !(fraction > 0 && fraction <= 1) {
+		panic("ResourcePool.NewChildFraction: fraction must be in (0, 1]")
+	}
+	share := func(resource poolResource) int64 {
+		remaining, limited := pool.remaining(resource)
+		if !limited {
+			return math.MaxInt64
+		}
+		return int64(float64(remaining) * fraction)
+	}
+	return &ResourcePool{
+		parent: pool,
+		steps:  poolCounter{max: share(poolSteps)},
+		allocs: poolCounter{max: share(poolAllocs)},
+	}
+}
+
+// Steps returns the number of steps drawn from the pool.
+func (pool *ResourcePool) Steps() (int64, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.steps.used.Int64()
+}
+
+// Allocs returns the allocations drawn from the pool.
+func (pool *ResourcePool) Allocs() (int64, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.allocs.used.Int64()
+}
+
+// SetResourcePool attaches the thread to pool, so that the steps and
+// allocations it counts are also drawn from pool. If pool is exhausted, the
+// thread is cancelled as if its own limit had been exceeded. Other threads
+// attached to the pool fail when they next draw from it.
+//
+// SetResourcePool must be called before execution begins.
+func (thread *Thread) SetResourcePool(pool *ResourcePool) {
+	thread.pool = pool
+}
+
+// ResourcePool returns the pool to which the thread is attached, if any.
+func (thread *Thread) ResourcePool() *ResourcePool {
+	return thread.pool
+}
+
+type poolResource int
+
+const (
+	poolSteps poolResource = iota
+	poolAllocs
+)
+
+func (pool *ResourcePool) counter(resource poolResource) *poolCounter {
+	if resource == poolSteps {
+		return &pool.steps
+	}
+	return &pool.allocs
+}
+
+var errPoolCountInvalidated = errors.New("resource pool count invalidated")
+
+// draw draws delta units of the given resource from the pool and its
+// ancestors. Either all pools are charged or, if any limit would be
+// exceeded, none is and an error is returned. If commit is false, no pool is
+// charged in either case.
+func (pool *ResourcePool) draw(resource poolResource, delta SafeInteger, commit bool) error {
+	// Pools are always locked from child to parent, so concurrent draws
+	// cannot deadlock.
+	for p := pool; p != nil; p = p.parent {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+	}
+
+	for p := pool; p != nil; p = p.parent {
+		counter := p.counter(resource)
+		next, ok := SafeAdd(counter.used, delta).Int64()
+		if !ok || next < 0 {
+			return errPoolCountInvalidated
+		}
+		if next > counter.max {
+			if resource == poolSteps {
+				return &StepsSafetyError{Current: counter.used, Max: counter.max}
+			}
+			return &AllocsSafetyError{Current: counter.used, Max: counter.max}
+		}
+	}
+	if commit {
+		for p := pool; p != nil; p = p.parent {
+			counter := p.counter(resource)
+			counter.used = SafeAdd(counter.used, delta)
+		}
+	}
+	return nil
+}
+
+// remaining returns the units of the given resource which may still be
+// drawn from the pool, the least allowed by it or any of its ancestors, and
+// whether any of them limits that resource.
+func (pool *ResourcePool) remaining(resource poolResource) (remaining int64, limited bool) {
+	remaining = math.MaxInt64
+	for p := pool; p != nil; p = p.parent {
+		p.mu.Lock()
+		counter := p.counter(resource)
+		if counter.max != math.MaxInt64 {
+			limited = true
+			left := int64(0)
+			if used, ok := counter.used.Int64(); ok && used < counter.max {
+				left = counter.max - used
+			}
+			if left < remaining {
+				remaining = left
+			}
+		}
+		p.mu.Unlock()
+	}
+	return remaining, limited
+}
@@ -0,0 +1,169 @@
+package starlark_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestResourcePoolSharedSteps(t *testing.T) {
+	const maxSteps = 10000
+	pool := starlark.NewResourcePool(maxSteps, 0)
+
+	const src = `
+def loop():
+	for i in range(1000000):
+		pass
+loop()
+`
+	const threads = 4
+	var wg sync.WaitGroup
+	errs := make([]error, threads)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			thread := &starlark.Thread{}
+			thread.SetResourcePool(pool)
+			_, errs[i] = starlark.ExecFile(thread, "loop.star", src, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("thread %d: expected cancellation", i)
+		} else if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("thread %d: unexpected error: %v", i, err)
+		}
+	}
+	if steps, ok := pool.Steps(); !ok || steps > maxSteps {
+		t.Errorf("pool exceeded its budget: %d > %d", steps, maxSteps)
+	}
+}
+
+func TestResourcePoolAccounting(t *testing.T) {
+	pool := starlark.NewResourcePool(0, 0)
+	thread1 := &starlark.Thread{}
+	thread1.SetResourcePool(pool)
+	thread2 := &starlark.Thread{}
+	thread2.SetResourcePool(pool)
+
+	if err := thread1.AddSteps(starlark.SafeInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread2.AddSteps(starlark.SafeInt(20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread1.AddAllocs(starlark.SafeInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread2.AddAllocs(starlark.SafeInt(50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread1.AddAllocs(starlark.SafeInt(-80)); err != nil {
+		t.Fatal(err)
+	}
+
+	if steps, ok := pool.Steps(); !ok || steps != 30 {
+		t.Errorf("incorrect pool steps: expected 30 but got %d", steps)
+	}
+	if allocs, ok := pool.Allocs(); !ok || allocs != 70 {
+		t.Errorf("incorrect pool allocs: expected 70 but got %d", allocs)
+	}
+}
+
+func TestResourcePoolHierarchy(t *testing.T) {
+	parent := starlark.NewResourcePool(0, 100)
+	child1 := parent.NewChild(0, 80)
+	child2 := parent.NewChild(0, 80)
+
+	thread1 := &starlark.Thread{}
+	thread1.SetResourcePool(child1)
+	thread2 := &starlark.Thread{}
+	thread2.SetResourcePool(child2)
+
+	if err := thread1.AddAllocs(starlark.SafeInt(60)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread2.CheckAllocs(starlark.SafeInt(60)); err == nil {
+		t.Error("expected parent pool to reject allocation")
+	}
+	if err := thread2.AddAllocs(starlark.SafeInt(60)); err == nil {
+		t.Error("expected parent pool to reject allocation")
+	} else if !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// A rejected draw charges no pool.
+	if allocs, _ := child2.Allocs(); allocs != 0 {
+		t.Errorf("rejected allocation was drawn from child pool: %d", allocs)
+	}
+	if allocs, _ := parent.Allocs(); allocs != 60 {
+		t.Errorf("incorrect parent allocs: expected 60 but got %d", allocs)
+	}
+}
+
+func TestResourcePoolChildFraction(t *testing.T) {
+	parent := starlark.NewResourcePool(1000, 0)
+	main := &starlark.Thread{}
+	main.SetResourcePool(parent)
+	if err := main.AddSteps(starlark.SafeInt(400)); err != nil {
+		t.Fatal(err)
+	}
+
+	child := parent.NewChildFraction(0.3)
+	worker := &starlark.Thread{}
+	worker.SetResourcePool(child)
+	if remaining, limited := worker.RemainingSteps(); !limited || remaining != 180 {
+		t.Errorf("expected child to be limited to 180 steps, got %d (limited: %v)", remaining, limited)
+	}
+	if _, limited := worker.RemainingAllocs(); limited {
+		t.Error("allocations should not be limited")
+	}
+
+	if err := worker.AddSteps(starlark.SafeInt(180)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := worker.AddSteps(starlark.SafeInt(1)); !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("expected child pool to reject steps, got %v", err)
+	}
+	if err := main.AddSteps(starlark.SafeInt(420)); err != nil {
+		t.Errorf("headroom not reserved for parent: %v", err)
+	}
+
+	// A share of an exhausted budget allows nothing, rather than being
+	// mistaken for no limit.
+	empty := &starlark.Thread{}
+	empty.SetResourcePool(parent.NewChildFraction(0.5))
+	if remaining, limited := empty.RemainingSteps(); !limited || remaining != 0 {
+		t.Errorf("expected no steps to remain, got %d (limited: %v)", remaining, limited)
+	}
+	if err := empty.AddSteps(starlark.SafeInt(1)); !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("expected exhausted child pool to reject steps, got %v", err)
+	}
+}
+
+func TestResourcePoolRestore(t *testing.T) {
+	pool := starlark.NewResourcePool(0, 0)
+	thread := &starlark.Thread{}
+	thread.SetResourcePool(pool)
+
+	snap := thread.Snapshot()
+	if err := thread.AddSteps(starlark.SafeInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.AddAllocs(starlark.SafeInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	thread.Restore(snap)
+
+	if steps, _ := pool.Steps(); steps != 0 {
+		t.Errorf("restored steps were not returned to pool: %d", steps)
+	}
+	if allocs, _ := pool.Allocs(); allocs != 0 {
+		t.Errorf("restored allocs were not returned to pool: %d", allocs)
+	}
+}
@@ -158,17 +158,23 @@ func (thread *Thread) endProfSpan() {
 		thread: thread,
 		time:   n * quantum,
 	}
-	ev.stack = ev.stackSpace[:0]
+	ev.stack = thread.appendProfStack(ev.stackSpace[:0])
+
+	profiler.events <- ev
+}
+
+// appendProfStack appends a copy of the thread's call stack to stack,
+// innermost frame first.
+func (thread *Thread) appendProfStack(stack []profFrame) []profFrame {
 	for i := range thread.stack {
 		fr := thread.frameAt(i)
-		ev.stack = append(ev.stack, profFrame{
+		stack = append(stack, profFrame{
 			pos: fr.Position(),
 			fn:  fr.Callable(),
 			pc:  fr.pc,
 		})
 	}
-
-	profiler.events <- ev
+	return stack
 }
 
 type profEvent struct {
@@ -187,174 +193,205 @@ type profFrame struct {
 // profile is the profiler goroutine.
 // It runs until StopProfiler is called.
 func profile(w io.Writer) {
-	// Field numbers from pprof protocol.
-	// See https://github.com/google/pprof/blob/master/proto/profile.proto
-	const (
-		Profile_sample_type    = 1  // repeated ValueType
-		Profile_sample         = 2  // repeated Sample
-		Profile_mapping        = 3  // repeated Mapping
-		Profile_location       = 4  // repeated Location
-		Profile_function       = 5  // repeated Function
-		Profile_string_table   = 6  // repeated string
-		Profile_time_nanos     = 9  // int64
-		Profile_duration_nanos = 10 // int64
-		Profile_period_type    = 11 // ValueType
-		Profile_period         = 12 // int64
-
-		ValueType_type = 1 // int64
-		ValueType_unit = 2 // int64
-
-		Sample_location_id = 1 // repeated uint64
-		Sample_value       = 2 // repeated int64
-		Sample_label       = 3 // repeated Label
-
-		Label_key      = 1 // int64
-		Label_str      = 2 // int64
-		Label_num      = 3 // int64
-		Label_num_unit = 4 // int64
-
-		Location_id         = 1 // uint64
-		Location_mapping_id = 2 // uint64
-		Location_address    = 3 // uint64
-		Location_line       = 4 // repeated Line
-
-		Line_function_id = 1 // uint64
-		Line_line        = 2 // int64
-
-		Function_id          = 1 // uint64
-		Function_name        = 2 // int64
-		Function_system_name = 3 // int64
-		Function_filename    = 4 // int64
-		Function_start_line  = 5 // int64
-	)
+	pw := newPprofWriter(w, "wall", "nanoseconds", quantum.Nanoseconds())
+
+	// Read profile events from the channel
+	// until it is closed by StopProfiler.
+	for e := range profiler.events {
+		pw.sample(e.time.Nanoseconds(), e.stack) // wall nanoseconds
+	}
 
+	profiler.done <- pw.close()
+}
+
+// Field numbers from pprof protocol.
+// See https://github.com/google/pprof/blob/master/proto/profile.proto
+const (
+	profile_sample_type    = 1  // repeated ValueType
+	profile_sample         = 2  // repeated Sample
+	profile_mapping        = 3  // repeated Mapping
+	profile_location       = 4  // repeated Location
+	profile_function       = 5  // repeated Function
+	profile_string_table   = 6  // repeated string
+	profile_time_nanos     = 9  // int64
+	profile_duration_nanos = 10 // int64
+	profile_period_type    = 11 // ValueType
+	profile_period         = 12 // int64
+
+	valueType_type = 1 // int64
+	valueType_unit = 2 // int64
+
+	sample_location_id = 1 // repeated uint64
+	sample_value       = 2 // repeated int64
+	sample_label       = 3 // repeated Label
+
+	label_key      = 1 // int64
+	label_str      = 2 // int64
+	label_num      = 3 // int64
+	label_num_unit = 4 // int64
+
+	location_id         = 1 // uint64
+	location_mapping_id = 2 // uint64
+	location_address    = 3 // uint64
+	location_line       = 4 // repeated Line
+
+	line_function_id = 1 // uint64
+	line_line        = 2 // int64
+
+	function_id          = 1 // uint64
+	function_name        = 2 // int64
+	function_system_name = 3 // int64
+	function_filename    = 4 // int64
+	function_start_line  = 5 // int64
+)
+
+// A pprofWriter streams samples of Starlark call stacks to w as a
+// gzip-compressed pprof protocol message.
+type pprofWriter struct {
+	bufw *bufio.Writer
+	gz   *gzip.Writer
+	enc  protoEncoder
+
+	stringIndex map[string]int64
+	functionId  map[uintptr]uint64
+	locationId  map[uintptr]uint64
+	startNano   int64
+}
+
+// newPprofWriter returns a writer of samples whose values have the given
+// type and unit, taken once per period of that unit.
+func newPprofWriter(w io.Writer, sampleType, unit string, period int64) *pprofWriter {
 	bufw := bufio.NewWriter(w) // write file in 4KB (not 240B flate-sized) chunks
 	gz := gzip.NewWriter(bufw)
-	enc := protoEncoder{w: gz}
-
-	// strings
-	stringIndex := make(map[string]int64)
-	str := func(s string) int64 {
-		i, ok := stringIndex[s]
-		if !ok {
-			i = int64(len(stringIndex))
-			enc.string(Profile_string_table, s)
-			stringIndex[s] = i
-		}
-		return i
+	pw := &pprofWriter{
+		bufw:        bufw,
+		gz:          gz,
+		enc:         protoEncoder{w: gz},
+		stringIndex: make(map[string]int64),
+		functionId:  make(map[uintptr]uint64),
+		locationId:  make(map[uintptr]uint64),
 	}
-	str("") // entry 0
-
-	// functions
-	//
-	// function returns the ID of a Callable for use in Line.FunctionId.
-	// The ID is the same as the function's logical address,
-	// which is supplied by the caller to avoid the need to recompute it.
-	functionId := make(map[uintptr]uint64)
-	function := func(fn Callable, addr uintptr) uint64 {
-		id, ok := functionId[addr]
-		if !ok {
-			id = uint64(addr)
-
-			var pos syntax.Position
-			if fn, ok := fn.(callableWithPosition); ok {
-				pos = fn.Position()
-			}
-
-			name := fn.Name()
-			if name == "<toplevel>" {
-				name = pos.Filename()
-			}
-
-			nameIndex := str(name)
-
-			fun := new(bytes.Buffer)
-			funenc := protoEncoder{w: fun}
-			funenc.uint(Function_id, id)
-			funenc.int(Function_name, nameIndex)
-			funenc.int(Function_system_name, nameIndex)
-			funenc.int(Function_filename, str(pos.Filename()))
-			funenc.int(Function_start_line, int64(pos.Line))
-			enc.bytes(Profile_function, fun.Bytes())
-
-			functionId[addr] = id
-		}
-		return id
+	pw.str("") // entry 0
+
+	valueType := new(bytes.Buffer)
+	vtenc := protoEncoder{w: valueType}
+	vtenc.int(valueType_type, pw.str(sampleType))
+	vtenc.int(valueType_unit, pw.str(unit))
+
+	// informational fields of Profile
+	pw.enc.bytes(profile_sample_type, valueType.Bytes())
+	pw.enc.int(profile_period, period)                    // magnitude of sampling period
+	pw.enc.bytes(profile_period_type, valueType.Bytes())  // dimension and unit of period
+	pw.enc.int(profile_time_nanos, time.Now().UnixNano()) // start (real) time of profile
+
+	pw.startNano = nanotime()
+	return pw
+}
+
+// str returns the index of s in the string table.
+func (pw *pprofWriter) str(s string) int64 {
+	i, ok := pw.stringIndex[s]
+	if !ok {
+		i = int64(len(pw.stringIndex))
+		pw.enc.string(profile_string_table, s)
+		pw.stringIndex[s] = i
 	}
+	return i
+}
 
-	// locations
-	//
-	// location returns the ID of the location denoted by fr.
-	// For Starlark frames, this is the Frame pc.
-	locationId := make(map[uintptr]uint64)
-	location := func(fr profFrame) uint64 {
-		fnAddr := profFuncAddr(fr.fn)
-
-		// For Starlark functions, the frame position
-		// represents the current PC value.
-		// Mix it into the low bits of the address.
-		// This is super hacky and may result in collisions
-		// in large functions or if functions are numerous.
-		// TODO(adonovan): fix: try making this cleaner by treating
-		// each bytecode segment as a Profile.Mapping.
-		pcAddr := fnAddr
-		if _, ok := fr.fn.(*Function); ok {
-			pcAddr = (pcAddr << 16) ^ uintptr(fr.pc)
+// function returns the ID of a Callable for use in Line.FunctionId.
+// The ID is the same as the function's logical address,
+// which is supplied by the caller to avoid the need to recompute it.
+func (pw *pprofWriter) function(fn Callable, addr uintptr) uint64 {
+	id, ok := pw.functionId[addr]
+	if !ok {
+		id = uint64(addr)
+
+		var pos syntax.Position
+		if fn, ok := fn.(callableWithPosition); ok {
+			pos = fn.Position()
 		}
 
-		id, ok := locationId[pcAddr]
-		if !ok {
-			id = uint64(pcAddr)
-
-			line := new(bytes.Buffer)
-			lineenc := protoEncoder{w: line}
-			lineenc.uint(Line_function_id, function(fr.fn, fnAddr))
-			lineenc.int(Line_line, int64(fr.pos.Line))
-			loc := new(bytes.Buffer)
-			locenc := protoEncoder{w: loc}
-			locenc.uint(Location_id, id)
-			locenc.uint(Location_address, uint64(pcAddr))
-			locenc.bytes(Location_line, line.Bytes())
-			enc.bytes(Profile_location, loc.Bytes())
-
-			locationId[pcAddr] = id
+		name := fn.Name()
+		if name == "<toplevel>" {
+			name = pos.Filename()
 		}
-		return id
-	}
 
-	wallNanos := new(bytes.Buffer)
-	wnenc := protoEncoder{w: wallNanos}
-	wnenc.int(ValueType_type, str("wall"))
-	wnenc.int(ValueType_unit, str("nanoseconds"))
+		nameIndex := pw.str(name)
 
-	// informational fields of Profile
-	enc.bytes(Profile_sample_type, wallNanos.Bytes())
-	enc.int(Profile_period, quantum.Nanoseconds())     // magnitude of sampling period
-	enc.bytes(Profile_period_type, wallNanos.Bytes())  // dimension and unit of period
-	enc.int(Profile_time_nanos, time.Now().UnixNano()) // start (real) time of profile
+		fun := new(bytes.Buffer)
+		funenc := protoEncoder{w: fun}
+		funenc.uint(function_id, id)
+		funenc.int(function_name, nameIndex)
+		funenc.int(function_system_name, nameIndex)
+		funenc.int(function_filename, pw.str(pos.Filename()))
+		funenc.int(function_start_line, int64(pos.Line))
+		pw.enc.bytes(profile_function, fun.Bytes())
 
-	startNano := nanotime()
+		pw.functionId[addr] = id
+	}
+	return id
+}
 
-	// Read profile events from the channel
-	// until it is closed by StopProfiler.
-	for e := range profiler.events {
-		sample := new(bytes.Buffer)
-		sampleenc := protoEncoder{w: sample}
-		sampleenc.int(Sample_value, e.time.Nanoseconds()) // wall nanoseconds
-		for _, fr := range e.stack {
-			sampleenc.uint(Sample_location_id, location(fr))
-		}
-		enc.bytes(Profile_sample, sample.Bytes())
+// location returns the ID of the location denoted by fr.
+// For Starlark frames, this is the Frame pc.
+func (pw *pprofWriter) location(fr profFrame) uint64 {
+	fnAddr := profFuncAddr(fr.fn)
+
+	// For Starlark functions, the frame position
+	// represents the current PC value.
+	// Mix it into the low bits of the address.
+	// This is super hacky and may result in collisions
+	// in large functions or if functions are numerous.
+	// TODO(adonovan): fix: try making this cleaner by treating
+	// each bytecode segment as a Profile.Mapping.
+	pcAddr := fnAddr
+	if _, ok := fr.fn.(*Function); ok {
+		pcAddr = (pcAddr << 16) ^ uintptr(fr.pc)
+	}
+
+	id, ok := pw.locationId[pcAddr]
+	if !ok {
+		id = uint64(pcAddr)
+
+		line := new(bytes.Buffer)
+		lineenc := protoEncoder{w: line}
+		lineenc.uint(line_function_id, pw.function(fr.fn, fnAddr))
+		lineenc.int(line_line, int64(fr.pos.Line))
+		loc := new(bytes.Buffer)
+		locenc := protoEncoder{w: loc}
+		locenc.uint(location_id, id)
+		locenc.uint(location_address, uint64(pcAddr))
+		locenc.bytes(location_line, line.Bytes())
+		pw.enc.bytes(profile_location, loc.Bytes())
+
+		pw.locationId[pcAddr] = id
 	}
+	return id
+}
 
+// sample records a sample with the given value for stack, whose innermost
+// frame is first.
+func (pw *pprofWriter) sample(value int64, stack []profFrame) {
+	sample := new(bytes.Buffer)
+	sampleenc := protoEncoder{w: sample}
+	sampleenc.int(sample_value, value)
+	for _, fr := range stack {
+		sampleenc.uint(sample_location_id, pw.location(fr))
+	}
+	pw.enc.bytes(profile_sample, sample.Bytes())
+}
+
+// close finalizes the profile, reporting any error writing it.
+func (pw *pprofWriter) close() error {
 	endNano := nanotime()
-	enc.int(Profile_duration_nanos, endNano-startNano)
+	pw.enc.int(profile_duration_nanos, endNano-pw.startNano)
 
-	err := gz.Close() // Close reports any prior write error
-	if flushErr := bufw.Flush(); err == nil {
+	err := pw.gz.Close() // Close reports any prior write error
+	if flushErr := pw.bufw.Flush(); err == nil {
 		err = flushErr
 	}
-	profiler.done <- err
+	return err
 }
 
 // nanotime returns the time in nanoseconds since process start.
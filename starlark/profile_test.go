@@ -79,3 +79,65 @@ fibonacci(100000)
 		t.Logf("stdout=%v", cmd.Stdout)
 	}
 }
+
+func TestStepProfile(t *testing.T) {
+	prof, err := os.CreateTemp(t.TempDir(), "step_profile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prof.Close()
+
+	thread := new(starlark.Thread)
+	if err := thread.StartStepProfile(prof); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.StartStepProfile(prof); err == nil {
+		t.Error("expected error starting step profiler twice")
+	}
+
+	const src = `
+def heavy(n):
+	x = 0
+	for i in range(n):
+		x += i
+	return x
+
+def light():
+	return 1
+
+heavy(10000)
+light()
+`
+	if _, err := starlark.ExecFile(thread, "foo.star", src, nil); err != nil {
+		_ = thread.StopStepProfile()
+		t.Fatal(err)
+	}
+	if err := thread.StopStepProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.StopStepProfile(); err == nil {
+		t.Error("expected error stopping step profiler twice")
+	}
+	prof.Sync()
+	cmd := exec.Command("go", "tool", "pprof", "-top", prof.Name())
+	cmd.Stderr = new(bytes.Buffer)
+	cmd.Stdout = new(bytes.Buffer)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("pprof failed: %v; output=<<%s>>", err, cmd.Stderr)
+	}
+
+	got := fmt.Sprint(cmd.Stdout)
+	for _, want := range []string{
+		"Type: steps",
+		"heavy",
+		"foo.star",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output did not contain %q", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("stderr=%v", cmd.Stderr)
+		t.Logf("stdout=%v", cmd.Stdout)
+	}
+}
@@ -0,0 +1,158 @@
+package starlark
+
+// This file defines the pure builtin, which memoises the results of calls
+// to functions within a thread.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pure is the builtin pure(fn), which marks fn as a pure function: one whose
+// result depends only on its arguments and which has no side effects. It is
+// not part of the Universe; clients may make it available to programs by
+// adding it to the predeclared environment.
+//
+// pure(fn) returns a callable which behaves as fn, except that the results
+// of calls are cached in the calling thread. When the callable is called
+// again by the same thread with equal arguments of the same types, the
+// cached result is returned without calling fn; only the steps taken to
+// hash and compare the arguments are charged. Arguments which are equal but
+// of different types, such as 1 and 1.0 or (1,) and (1.0,), do not share a
+// result. Results are frozen before being cached.
+//
+// Only calls whose arguments are all hashable are cached; other calls are
+// passed straight through to fn. Hashable values are not necessarily
+// immutable: the results of a call are reused even if a host value passed
+// to it has since changed. The memory used by the cache is charged to the
+// thread.
+var Pure = NewBuiltinWithSafety("pure", CPUSafe|MemSafe|TimeSafe|IOSafe, pure)
+
+func pure(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var fn Callable
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &fn); err != nil {
+		return nil, err
+	}
+	if err := thread.AddSteps(SafeInt(1)); err != nil {
+		return nil, err
+	}
+	result := &pureFunction{fn: fn}
+	if err := thread.AddAllocs(EstimateSize(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// A pureFunction is a callable whose results are cached per thread.
+type pureFunction struct {
+	fn Callable
+}
+
+var (
+	_ Callable    = &pureFunction{}
+	_ SafetyAware = &pureFunction{}
+)
+
+func (pf *pureFunction) Name() string          { return pf.fn.Name() }
+func (pf *pureFunction) String() string        { return fmt.Sprintf("<pure %s>", pf.fn.String()) }
+func (pf *pureFunction) Type() string          { return "pure_function" }
+func (pf *pureFunction) Freeze()               { pf.fn.Freeze() }
+func (pf *pureFunction) Truth() Bool           { return True }
+func (pf *pureFunction) Hash() (uint32, error) { return hashString(pf.fn.Name()), nil }
+func (pf *pureFunction) Safety() SafetyFlags   { return callableSafety(pf.fn) }
+
+// Unwrap returns the function whose results are cached.
+func (pf *pureFunction) Unwrap() Callable { return pf.fn }
+
+func (pf *pureFunction) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	key, keySize := pureCallKey(pf, args, kwargs)
+	if err := thread.AddAllocs(keySize); err != nil {
+		return nil, err
+	}
+
+	var result Value
+	var found bool
+	var err error
+	if thread.pureCache != nil {
+		result, found, err = thread.pureCache.SafeGet(thread, key)
+	} else {
+		_, err = safeHash(thread, key)
+	}
+	if err != nil {
+		if errors.Is(err, ErrSafety) || thread.cancelled() != nil {
+			return nil, err
+		}
+		// Calls with unhashable arguments are not cached.
+		return Call(thread, pf.fn, args, kwargs)
+	}
+	if found {
+		return result, nil
+	}
+
+	result, err = Call(thread, pf.fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	result.Freeze()
+
+	if thread.pureCache == nil {
+		if err := thread.AddAllocs(EstimateSize(&Dict{})); err != nil {
+			return nil, err
+		}
+		thread.pureCache = new(Dict)
+	}
+	if err := thread.pureCache.SafeSetKey(thread, key, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pureCallKey returns the key under which the result of a call to pf is
+// cached, and its size. As equal values may differ in type, the key
+// records the type signature of each argument.
+func pureCallKey(pf *pureFunction, args Tuple, kwargs []Tuple) (Tuple, SafeInteger) {
+	argsKey := append(Tuple(nil), args...)
+	kwargsKey := make(Tuple, 0, 2*len(kwargs))
+	for _, kwarg := range kwargs {
+		kwargsKey = append(kwargsKey, kwarg...)
+	}
+	size := SafeAdd(EstimateMakeSize(Tuple{}, SafeInt(4)), SliceTypeOverhead)
+	size = SafeAdd(size, SafeAdd(EstimateMakeSize(Tuple{}, SafeInt(len(argsKey))), SliceTypeOverhead))
+	size = SafeAdd(size, SafeAdd(EstimateMakeSize(Tuple{}, SafeInt(len(kwargsKey))), SliceTypeOverhead))
+
+	types := make(Tuple, 0, len(args)+len(kwargs))
+	var sig strings.Builder
+	addType := func(v Value) {
+		sig.Reset()
+		writeTypeSignature(&sig, v)
+		types = append(types, String(sig.String()))
+		size = SafeAdd(size, SafeAdd(EstimateMakeSize([]byte{}, SafeInt(sig.Len())), StringTypeOverhead))
+	}
+	for _, arg := range args {
+		addType(arg)
+	}
+	for _, kwarg := range kwargs {
+		addType(kwarg[1])
+	}
+	size = SafeAdd(size, SafeAdd(EstimateMakeSize(Tuple{}, SafeInt(len(types))), SliceTypeOverhead))
+	return Tuple{pf, argsKey, kwargsKey, types}, size
+}
+
+// writeTypeSignature writes the type of v to sig, followed by the type
+// signatures of its elements if v is a tuple, so that equal arguments whose
+// elements differ in type, such as (1,) and (1.0,), have different
+// signatures.
+func writeTypeSignature(sig *strings.Builder, v Value) {
+	sig.WriteString(v.Type())
+	if t, ok := v.(Tuple); ok {
+		sig.WriteByte('(')
+		for i, elem := range t {
+			if i > 0 {
+				sig.WriteByte(',')
+			}
+			writeTypeSignature(sig, elem)
+		}
+		sig.WriteByte(')')
+	}
+}
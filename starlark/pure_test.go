@@ -0,0 +1,154 @@
+package starlark_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/startest"
+)
+
+func TestPure(t *testing.T) {
+	calls := 0
+	count := starlark.NewBuiltinWithSafety("count", starlark.Safe, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		calls++
+		return starlark.MakeInt(calls), nil
+	})
+	predeclared := starlark.StringDict{
+		"count": count,
+		"pure":  starlark.Pure,
+	}
+	const src = `
+def f(x, y=None):
+    return [x, count()]
+
+g = pure(f)
+`
+	thread := &starlark.Thread{}
+	globals, err := starlark.ExecFile(thread, "pure.star", src, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := globals["g"]
+
+	call := func(args starlark.Tuple, kwargs []starlark.Tuple) starlark.Value {
+		result, err := starlark.Call(thread, g, args, kwargs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	first := call(starlark.Tuple{starlark.MakeInt(1)}, nil)
+	if calls != 1 {
+		t.Errorf("unexpected call count: got %d, want 1", calls)
+	}
+	if err := first.(*starlark.List).Append(starlark.None); err == nil {
+		t.Error("cached result was not frozen")
+	}
+
+	if second := call(starlark.Tuple{starlark.MakeInt(1)}, nil); second != first {
+		t.Errorf("cached result not returned: got %v, want %v", second, first)
+	}
+	if calls != 1 {
+		t.Errorf("unexpected call count: got %d, want 1", calls)
+	}
+
+	call(starlark.Tuple{starlark.MakeInt(2)}, nil)
+	call(starlark.Tuple{starlark.MakeInt(1)}, []starlark.Tuple{{starlark.String("y"), starlark.MakeInt(1)}})
+	call(starlark.Tuple{starlark.MakeInt(1)}, []starlark.Tuple{{starlark.String("y"), starlark.MakeInt(1)}})
+	if calls != 3 {
+		t.Errorf("unexpected call count: got %d, want 3", calls)
+	}
+
+	// Equal arguments of different types do not share a result.
+	call(starlark.Tuple{starlark.Float(2)}, nil)
+	call(starlark.Tuple{starlark.MakeInt(1)}, []starlark.Tuple{{starlark.String("y"), starlark.Float(1)}})
+	if calls != 5 {
+		t.Errorf("unexpected call count: got %d, want 5", calls)
+	}
+
+	unhashable := starlark.NewList(nil)
+	call(starlark.Tuple{unhashable}, nil)
+	call(starlark.Tuple{unhashable}, nil)
+	if calls != 7 {
+		t.Errorf("unexpected call count: got %d, want 7", calls)
+	}
+
+	otherThread := &starlark.Thread{}
+	if _, err := starlark.Call(otherThread, g, starlark.Tuple{starlark.MakeInt(1)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 8 {
+		t.Errorf("cache shared between threads: got %d calls, want 8", calls)
+	}
+}
+
+func TestPureNestedTypes(t *testing.T) {
+	predeclared := starlark.StringDict{"pure": starlark.Pure}
+	const src = `
+k = pure(lambda t: type(t[0]))
+first = k((1,))
+second = k((1.0,))
+`
+	thread := &starlark.Thread{}
+	globals, err := starlark.ExecFile(thread, "pure.star", src, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, second := globals["first"], globals["second"]; first != starlark.String("int") || second != starlark.String("float") {
+		t.Errorf("got %v and %v, want \"int\" and \"float\"", first, second)
+	}
+}
+
+func TestPureCachedSteps(t *testing.T) {
+	// Looking up a cached result charges the steps taken to hash and
+	// compare its arguments.
+	const n = 1000
+	fn := starlark.NewBuiltinWithSafety("fn", starlark.Safe, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	text := strings.Repeat("a", n*64)
+
+	thread := &starlark.Thread{}
+	pure, err := starlark.Call(thread, starlark.Pure, starlark.Tuple{fn}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		before, _ := thread.Steps()
+		args := starlark.Tuple{starlark.String(string([]byte(text)))}
+		if _, err := starlark.Call(thread, pure, args, nil); err != nil {
+			t.Fatal(err)
+		}
+		if after, _ := thread.Steps(); after-before < n {
+			t.Errorf("call %d charged %d steps, want at least %d", i, after-before, n)
+		}
+	}
+}
+
+func TestPureAllocs(t *testing.T) {
+	fn := starlark.NewBuiltinWithSafety("fn", starlark.Safe, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+
+	st := startest.From(t)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		pure, err := starlark.Call(thread, starlark.Pure, starlark.Tuple{fn}, nil)
+		if err != nil {
+			st.Fatal(err)
+		}
+		for i := 0; i < st.N; i++ {
+			args := starlark.Tuple{starlark.MakeInt(i), starlark.String("x")}
+			kwargs := []starlark.Tuple{{starlark.String("k"), starlark.MakeInt(i)}}
+			if _, err := starlark.Call(thread, pure, args, kwargs); err != nil {
+				st.Fatal(err)
+			}
+			if _, err := starlark.Call(thread, pure, args, kwargs); err != nil {
+				st.Fatal(err)
+			}
+		}
+		st.KeepAlive(thread)
+	})
+}
@@ -0,0 +1,189 @@
+package starlark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/canonical/starlark/syntax"
+)
+
+// A Reloader compiles and caches the programs of a set of script files,
+// recompiling them when their source changes. It enables the scripts served by
+// a long-running host to be updated without restarting it.
+//
+// Programs are obtained with Program. A subsequent call to Check or Watch
+// rereads every file previously requested, and any which have changed are
+// recompiled, revalidated and atomically swapped into the cache. If a changed
+// file fails to compile or to validate, the previous program is kept and the
+// error is reported.
+//
+// A Reloader must not be copied after first use. Its fields must not be
+// modified after first use.
+type Reloader struct {
+	// FS is the file system from which sources are read.
+	FS fs.FS
+
+	// Options are the options with which sources are parsed.
+	// If nil, syntax.LegacyFileOptions() is used.
+	Options *syntax.FileOptions
+
+	// Predeclared is the environment in which programs will be executed.
+	Predeclared StringDict
+
+	// Safety is the safety which every predeclared and universal callable
+	// referenced by a program must declare. See Program.CheckPredeclaredSafety.
+	Safety SafetyFlags
+
+	// BeforeSwap, if non-nil, is called after a changed file has been
+	// successfully recompiled and before its program is swapped into the
+	// cache. This allows an embedder to drain any in-flight threads executing
+	// the old program. If BeforeSwap returns an error, the old program is kept.
+	// BeforeSwap is called without the Reloader's lock held, so it may wait
+	// for threads which call Program.
+	BeforeSwap func(path string, old, new *Program) error
+
+	// OnError, if non-nil, is called by Check and Watch for each error
+	// encountered when reloading. As with BeforeSwap, it is called without
+	// the Reloader's lock held.
+	OnError func(err error)
+
+	checkMu sync.Mutex // serialises calls to Check
+
+	mu      sync.Mutex // guards entries
+	entries map[string]*reloadEntry
+}
+
+type reloadEntry struct {
+	src  []byte
+	prog *Program
+}
+
+// Program returns the current program for the given path, compiling it if
+// this is the first request. The file is read and compiled without the
+// Reloader's lock held, so concurrent requests for other paths are not
+// delayed.
+func (r *Reloader) Program(path string) (*Program, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[path]
+	r.mu.Unlock()
+	if ok {
+		return entry.prog, nil
+	}
+
+	src, err := fs.ReadFile(r.FS, path)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := r.compile(path, src)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Another call may have compiled the file meanwhile.
+	if entry, ok := r.entries[path]; ok {
+		return entry.prog, nil
+	}
+	if r.entries == nil {
+		r.entries = make(map[string]*reloadEntry)
+	}
+	r.entries[path] = &reloadEntry{src: src, prog: prog}
+	return prog, nil
+}
+
+func (r *Reloader) compile(path string, src []byte) (*Program, error) {
+	opts := r.Options
+	if opts == nil {
+		opts = syntax.LegacyFileOptions()
+	}
+	_, prog, err := SourceProgramOptions(opts, path, src, r.Predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	if err := prog.CheckPredeclaredSafety(r.Predeclared, r.Safety); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// Check rereads all files previously requested, reloading those which have
+// changed. It returns the paths of the reloaded files, in sorted order, and
+// the first error encountered, if any. A failure to reload one file does not
+// prevent others from being reloaded.
+func (r *Reloader) Check() ([]string, error) {
+	r.checkMu.Lock()
+	defer r.checkMu.Unlock()
+
+	r.mu.Lock()
+	entries := make(map[string]*reloadEntry, len(r.entries))
+	paths := make([]string, 0, len(r.entries))
+	for path, entry := range r.entries {
+		entries[path] = entry
+		paths = append(paths, path)
+	}
+	r.mu.Unlock()
+	sort.Strings(paths)
+
+	var reloaded []string
+	var firstErr error
+	for _, path := range paths {
+		changed, err := r.reload(path, entries[path])
+		if err != nil {
+			if r.OnError != nil {
+				r.OnError(err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if changed {
+			reloaded = append(reloaded, path)
+		}
+	}
+	return reloaded, firstErr
+}
+
+func (r *Reloader) reload(path string, entry *reloadEntry) (bool, error) {
+	src, err := fs.ReadFile(r.FS, path)
+	if err != nil {
+		return false, fmt.Errorf("reload %s: %w", path, err)
+	}
+	if bytes.Equal(src, entry.src) {
+		return false, nil
+	}
+	prog, err := r.compile(path, src)
+	if err != nil {
+		return false, fmt.Errorf("reload %s: %w", path, err)
+	}
+	if r.BeforeSwap != nil {
+		if err := r.BeforeSwap(path, entry.prog, prog); err != nil {
+			return false, fmt.Errorf("reload %s: %w", path, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[path] = &reloadEntry{src: src, prog: prog}
+	return true, nil
+}
+
+// Watch calls Check at the given interval until the context is done.
+// Errors are reported through OnError.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check()
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package starlark_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/syntax"
+)
+
+func TestReloader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.star": {Data: []byte("x = 1")},
+		"b.star": {Data: []byte("y = 2")},
+	}
+	var swapped []string
+	r := &starlark.Reloader{
+		FS:      fsys,
+		Options: &syntax.FileOptions{},
+		BeforeSwap: func(path string, old, new *starlark.Program) error {
+			if old == new {
+				t.Errorf("%s: swapped program with itself", path)
+			}
+			swapped = append(swapped, path)
+			return nil
+		},
+	}
+
+	a, err := r.Program("a.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := r.Program("a.star"); err != nil {
+		t.Fatal(err)
+	} else if again != a {
+		t.Error("program was not cached")
+	}
+	if _, err := r.Program("b.star"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, err := r.Check(); err != nil {
+		t.Fatal(err)
+	} else if len(reloaded) != 0 {
+		t.Errorf("unexpected reload of unchanged files: %v", reloaded)
+	}
+
+	fsys["a.star"] = &fstest.MapFile{Data: []byte("x = 3")}
+	reloaded, err := r.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded) != 1 || reloaded[0] != "a.star" {
+		t.Errorf("unexpected reloaded files: %v", reloaded)
+	}
+	if len(swapped) != 1 || swapped[0] != "a.star" {
+		t.Errorf("unexpected swaps: %v", swapped)
+	}
+
+	newA, err := r.Program("a.star")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals, err := newA.Init(&starlark.Thread{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := globals["x"]; x != starlark.MakeInt(3) {
+		t.Errorf("got x = %v, want 3", x)
+	}
+
+	fsys["a.star"] = &fstest.MapFile{Data: []byte("x = ")}
+	if _, err := r.Check(); err == nil {
+		t.Error("expected syntax error")
+	}
+	if prog, err := r.Program("a.star"); err != nil {
+		t.Fatal(err)
+	} else if prog != newA {
+		t.Error("broken program replaced last good program")
+	}
+}
+
+func TestReloaderSafety(t *testing.T) {
+	unsafe := starlark.NewBuiltin("unsafe", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	fsys := fstest.MapFS{
+		"safe.star":   {Data: []byte("x = len([])")},
+		"unsafe.star": {Data: []byte("def f():\n    unsafe()\n")},
+	}
+	r := &starlark.Reloader{
+		FS:          fsys,
+		Options:     &syntax.FileOptions{},
+		Predeclared: starlark.StringDict{"unsafe": unsafe},
+		Safety:      starlark.CPUSafe | starlark.MemSafe,
+	}
+	if _, err := r.Program("safe.star"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := r.Program("unsafe.star"); !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	fsys["safe.star"] = &fstest.MapFile{Data: []byte("unsafe()")}
+	if _, err := r.Check(); !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReloaderCallbacksUnlocked(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.star": {Data: []byte("x = 1")},
+		"b.star": {Data: []byte("y = 2")},
+	}
+	var r *starlark.Reloader
+	// Each callback waits for another goroutine, standing in for an
+	// in-flight thread, to obtain a program from the Reloader.
+	request := func(path string) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if _, err := r.Program(path); err != nil {
+				t.Error(err)
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlock: callback called with the Reloader's lock held")
+		}
+	}
+	var errs []error
+	r = &starlark.Reloader{
+		FS:      fsys,
+		Options: &syntax.FileOptions{},
+		BeforeSwap: func(path string, old, new *starlark.Program) error {
+			request(path)
+			return nil
+		},
+		OnError: func(err error) {
+			request("a.star")
+			errs = append(errs, err)
+		},
+	}
+	for _, path := range []string{"a.star", "b.star"} {
+		if _, err := r.Program(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys["a.star"] = &fstest.MapFile{Data: []byte("x = 2")}
+	fsys["b.star"] = &fstest.MapFile{Data: []byte("y = (")}
+	reloaded, err := r.Check()
+	if err == nil {
+		t.Error("expected error")
+	}
+	if len(reloaded) != 1 || reloaded[0] != "a.star" {
+		t.Errorf("unexpected reloaded files: %v", reloaded)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected one reported error, got %v", errs)
+	}
+}
+
+// blockingFS is a file system whose reads of one path wait until unblocked.
+type blockingFS struct {
+	files   fstest.MapFS
+	path    string
+	reading chan struct{}
+	unblock chan struct{}
+}
+
+func (fsys *blockingFS) Open(name string) (fs.File, error) {
+	if name == fsys.path {
+		close(fsys.reading)
+		<-fsys.unblock
+	}
+	return fsys.files.Open(name)
+}
+
+func TestReloaderCompileUnlocked(t *testing.T) {
+	fsys := &blockingFS{
+		files: fstest.MapFS{
+			"a.star":    {Data: []byte("x = 1")},
+			"slow.star": {Data: []byte("y = 2")},
+		},
+		path:    "slow.star",
+		reading: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+	r := &starlark.Reloader{FS: fsys, Options: &syntax.FileOptions{}}
+	if _, err := r.Program("a.star"); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := make(chan error)
+	go func() {
+		_, err := r.Program("slow.star")
+		slow <- err
+	}()
+	<-fsys.reading
+
+	// A cold read of one path does not delay requests for others.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := r.Program("a.star"); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Program blocked by a concurrent compilation")
+	}
+
+	close(fsys.unblock)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"reflect"
 )
 
+// SafeAppender appends to a slice on behalf of a thread, counting a step
+// per element and declaring each change in the slice's capacity. This
+// spares builtins from reimplementing the accounting of append's
+// amortized growth.
 type SafeAppender struct {
 	thread        *Thread
 	slice         reflect.Value
@@ -13,6 +17,10 @@ type SafeAppender struct {
 	allocs, steps SafeInteger
 }
 
+// NewSafeAppender returns a SafeAppender which appends to the slice
+// pointed to by slicePtr while abiding by the safety limits of thread.
+// The capacity already present in the slice is assumed to have been
+// declared.
 func NewSafeAppender(thread *Thread, slicePtr interface{}) *SafeAppender {
 	if slicePtr == nil {
 		panic("NewSafeAppender: expected pointer to slice, got nil")
@@ -44,6 +52,7 @@ func (sa *SafeAppender) Steps() SafeInteger {
 	return sa.steps
 }
 
+// Append appends values to the slice, as if by the append builtin.
 func (sa *SafeAppender) Append(values ...interface{}) error {
 	if sa.thread != nil {
 		if err := sa.thread.AddSteps(SafeInt(len(values))); err != nil {
@@ -53,11 +62,8 @@ func (sa *SafeAppender) Append(values ...interface{}) error {
 	sa.steps = SafeAdd(sa.steps, len(values))
 
 	cap := sa.slice.Cap()
-	newSize := sa.slice.Len() + len(values)
-	if newSize > cap && sa.thread != nil {
-		if err := sa.thread.CheckAllocs(SafeMul(newSize, sa.elemType.Size())); err != nil {
-			return err
-		}
+	if err := sa.checkGrow(len(values)); err != nil {
+		return err
 	}
 	slice := sa.slice
 	for _, value := range values {
@@ -72,19 +78,11 @@ func (sa *SafeAppender) Append(values ...interface{}) error {
 			slice = reflect.Append(slice, reflect.ValueOf(value))
 		}
 	}
-	if slice.Cap() != cap && sa.thread != nil {
-		oldSize := roundAllocSize(SafeMul(cap, sa.elemType.Size()))
-		newSize := roundAllocSize(SafeMul(slice.Cap(), sa.elemType.Size()))
-		delta := SafeSub(newSize, oldSize)
-		sa.allocs = SafeAdd(sa.allocs, delta)
-		if err := sa.thread.AddAllocs(delta); err != nil {
-			return err
-		}
-	}
-	sa.slice.Set(slice)
-	return nil
+	return sa.set(slice, cap)
 }
 
+// AppendSlice appends the elements of the slice values to the slice, as if
+// by the append builtin.
 func (sa *SafeAppender) AppendSlice(values interface{}) error {
 	if values == nil {
 		panic("SafeAppender.AppendSlice: expected slice, got nil")
@@ -100,24 +98,62 @@ func (sa *SafeAppender) AppendSlice(values interface{}) error {
 	}
 	sa.steps = SafeAdd(sa.steps, toAppend.Len())
 
-	len := sa.slice.Len()
 	cap := sa.slice.Cap()
-	toAppendLen := toAppend.Len()
-	newLen, ok := SafeAdd(len, toAppendLen).Int()
+	if err := sa.checkGrow(toAppend.Len()); err != nil {
+		return err
+	}
+
+	slice := reflect.AppendSlice(sa.slice, toAppend)
+	return sa.set(slice, cap)
+}
+
+// Grow grows the slice's capacity, if necessary, to guarantee space for
+// another n elements, declaring any change in capacity. Calling Grow
+// before a sequence of appends of known total length avoids repeated
+// reallocation.
+func (sa *SafeAppender) Grow(n int) error {
+	if n < 0 {
+		panic("SafeAppender.Grow: negative count")
+	}
+	len, cap := sa.slice.Len(), sa.slice.Cap()
+	newLen, ok := SafeAdd(len, n).Int()
 	if !ok {
 		return errors.New("slice length overflow")
 	}
-	if newLen > cap && sa.thread != nil {
-		// Consider up to twice the size for the allocation overshoot
-		allocation := SafeSub(SafeMul(newLen, 2), cap)
-		if err := sa.thread.CheckAllocs(SafeMul(allocation, sa.elemType.Size())); err != nil {
+	if newLen <= cap {
+		return nil
+	}
+	if sa.thread != nil {
+		if err := sa.thread.CheckAllocs(SafeMul(newLen, sa.elemType.Size())); err != nil {
 			return err
 		}
 	}
+	slice := reflect.MakeSlice(sa.slice.Type(), len, newLen)
+	reflect.Copy(slice, sa.slice)
+	return sa.set(slice, cap)
+}
 
-	slice := reflect.AppendSlice(sa.slice, toAppend)
-	if slice.Cap() != cap && sa.thread != nil {
-		oldSize := roundAllocSize(SafeMul(cap, sa.elemType.Size()))
+// checkGrow checks that the slice may grow to accommodate n more
+// elements. Like append, this may overshoot by up to twice the required
+// length, so that is the transient allocation checked.
+func (sa *SafeAppender) checkGrow(n int) error {
+	newLen, ok := SafeAdd(sa.slice.Len(), n).Int()
+	if !ok {
+		return errors.New("slice length overflow")
+	}
+	cap := sa.slice.Cap()
+	if newLen <= cap || sa.thread == nil {
+		return nil
+	}
+	allocation := SafeSub(SafeMul(newLen, 2), cap)
+	return sa.thread.CheckAllocs(SafeMul(allocation, sa.elemType.Size()))
+}
+
+// set replaces the underlying slice with slice, declaring its
+// change in capacity from oldCap.
+func (sa *SafeAppender) set(slice reflect.Value, oldCap int) error {
+	if slice.Cap() != oldCap && sa.thread != nil {
+		oldSize := roundAllocSize(SafeMul(oldCap, sa.elemType.Size()))
 		newSize := roundAllocSize(SafeMul(slice.Cap(), sa.elemType.Size()))
 		delta := SafeSub(newSize, oldSize)
 		sa.allocs = SafeAdd(sa.allocs, delta)
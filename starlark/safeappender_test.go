@@ -572,6 +572,78 @@ func TestSafeAppenderNil(t *testing.T) {
 	}
 }
 
+func TestSafeAppenderGrow(t *testing.T) {
+	t.Run("no-allocation", func(t *testing.T) {
+		storage := make([]int, 0, 16)
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMaxAllocs(0)
+		st.SetMaxSteps(0)
+		st.RunThread(func(thread *starlark.Thread) {
+			sa := starlark.NewSafeAppender(thread, &storage)
+			for i := 0; i < st.N; i++ {
+				if err := sa.Grow(cap(storage)); err != nil {
+					st.Error(err)
+				}
+			}
+		})
+	})
+
+	t.Run("then-append", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMinSteps(1)
+		st.SetMaxSteps(1)
+		st.RunThread(func(thread *starlark.Thread) {
+			slice := []int{}
+			sa := starlark.NewSafeAppender(thread, &slice)
+			if err := sa.Grow(st.N); err != nil {
+				st.Error(err)
+			}
+			if cap(slice) != st.N {
+				t.Errorf("expected capacity %d, got %d", st.N, cap(slice))
+			}
+			before := cap(slice)
+			for i := 0; i < st.N; i++ {
+				if err := sa.Append(i); err != nil {
+					st.Error(err)
+				}
+			}
+			if cap(slice) != before {
+				t.Errorf("unexpected reallocation after Grow")
+			}
+			st.KeepAlive(slice)
+		})
+	})
+
+	t.Run("preserves-contents", func(t *testing.T) {
+		slice := []int{1, 2, 3}
+		sa := starlark.NewSafeAppender(&starlark.Thread{}, &slice)
+		if err := sa.Grow(100); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := []int{1, 2, 3}; !reflect.DeepEqual(slice, expected) {
+			t.Errorf("expected %v, got %v", expected, slice)
+		}
+		if cap(slice) < 103 {
+			t.Errorf("expected capacity at least 103, got %d", cap(slice))
+		}
+	})
+
+	t.Run("over-allocation", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(100)
+		slice := []int{}
+		sa := starlark.NewSafeAppender(thread, &slice)
+		if err := sa.Grow(1000); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if cap(slice) != 0 {
+			t.Errorf("slice grew despite error")
+		}
+	})
+}
+
 func TestSafeAppenderAllocCounting(t *testing.T) {
 	t.Run("Append", func(t *testing.T) {
 		st := startest.From(t)
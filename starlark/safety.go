@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"math/bits"
+
+	"github.com/canonical/starlark/internal/compile"
 )
 
 // SafetyFlags represents a set of constraints on executed code.
@@ -89,6 +91,20 @@ type SafetyAware interface {
 	Safety() SafetyFlags
 }
 
+// A SafeCallable is a Callable which declares its safety and the
+// resources each call will use. Before Call invokes its CallInternal
+// method, it checks that the declared safety satisfies the requirements of
+// the thread, just as it does for a Builtin, then calls CheckCall with the
+// thread. CheckCall may declare the resources the call will use, for
+// example with AddSteps or AddAllocs, or verify them with CheckSteps or
+// CheckAllocs. If it returns an error, CallInternal is not invoked.
+type SafeCallable interface {
+	Callable
+	SafetyAware
+
+	CheckCall(thread *Thread) error
+}
+
 var _ SafetyAware = SafetyFlags(0)
 var _ SafetyAware = new(Function)
 var _ SafetyAware = new(Builtin)
@@ -109,8 +125,14 @@ func (set SafetyFlags) Contains(subset SafetyFlags) bool {
 
 var ErrSafety = errors.New("safety constraint enforced")
 
+// A SafetyFlagsError reports that a value's declared safety does not
+// contain the safety required of it.
 type SafetyFlagsError struct {
 	Missing SafetyFlags
+
+	// Declared and Required are the safety declared by the value and that
+	// required of it.
+	Declared, Required SafetyFlags
 }
 
 func (se SafetyFlagsError) Error() string {
@@ -124,7 +146,11 @@ func (se SafetyFlagsError) Is(err error) bool {
 // CheckContains returns an error if the provided flags are not a subset of this set.
 func (set SafetyFlags) CheckContains(subset SafetyFlags) error {
 	if difference := subset &^ set; difference != 0 {
-		return &SafetyFlagsError{difference}
+		return &SafetyFlagsError{
+			Missing:  difference,
+			Declared: set,
+			Required: subset,
+		}
 	}
 	return nil
 }
@@ -142,3 +168,209 @@ func CheckSafety(thread *Thread, value interface{}) error {
 	}
 	return thread.CheckPermits(safety)
 }
+
+// callableSafety returns the safety reported by a value, or NotSafe if it
+// does not report its safety.
+func callableSafety(v Value) SafetyFlags {
+	if v, ok := v.(SafetyAware); ok {
+		return v.Safety()
+	}
+	return NotSafe
+}
+
+// InferredSafety returns the safety of the function as inferred from the
+// callables it references by name. These are the predeclared, universal and
+// global callables named in its body, and in the bodies of the functions it
+// defines, and, transitively, those referenced by any global Starlark
+// functions it names.
+//
+// The inferred safety is an upper bound: callables which are not referenced
+// by name, such as those passed as arguments or obtained as attributes, are
+// checked only when called.
+func (fn *Function) InferredSafety() SafetyFlags {
+	safety := nativeSafe
+	walkReferencedCallables(fn, func(c Callable, _ CallFrame) error {
+		// Invalid declarations are left to be reported by Call.
+		if callableSafety := callableSafety(c); callableSafety.CheckValid() == nil {
+			safety &= callableSafety
+		}
+		return nil
+	})
+	return safety
+}
+
+// checkHostEntry returns an error if c is called directly by the host and
+// is, or wraps, a Starlark function which could not run to completion
+// under the thread's safety requirements. Calls made within Starlark code
+// are covered by the inference.
+func (thread *Thread) checkHostEntry(c Callable) error {
+	if len(thread.stack) != 0 || thread.requiredSafety == 0 {
+		return nil
+	}
+	if fn := enteredFunction(c); fn != nil {
+		return thread.checkInferredSafety(fn)
+	}
+	return nil
+}
+
+// enteredFunction returns the Starlark function which a call to c enters
+// directly, if any.
+func enteredFunction(c Callable) *Function {
+	switch c := c.(type) {
+	case *Function:
+		return c
+	case *pureFunction:
+		return enteredFunction(c.fn)
+	}
+	return nil
+}
+
+// checkInferredSafety returns an error if the thread would not permit a call
+// to some callable referenced by the given function. The error matches that
+// which Call would report on reaching the call, and its call stack holds the
+// position of the reference.
+//
+// The safety inferred for a function is cached by its module, as the
+// bindings of a module's globals do not change once it has been
+// initialized. The bytecode of the functions it may reach is therefore
+// walked only on the first check, and again if the check fails.
+func (thread *Thread) checkInferredSafety(fn *Function) error {
+	if fn.cachedInferredSafety().Contains(thread.requiredSafety) {
+		return nil
+	}
+
+	// Walk the function again to report the offending reference.
+	return walkReferencedCallables(fn, func(c Callable, frame CallFrame) error {
+		if err := thread.CheckPermits(callableSafety(c)); err != nil {
+			if b, ok := c.(*Builtin); ok {
+				err = fmt.Errorf("cannot call builtin '%s': %w", b.Name(), err)
+			} else {
+				err = fmt.Errorf("cannot call value of type '%s': %w", c.Type(), err)
+			}
+			return &EvalError{
+				Msg:       err.Error(),
+				CallStack: CallStack{frame},
+				cause:     err,
+			}
+		}
+		return nil
+	})
+}
+
+// cachedInferredSafety returns the inferred safety of fn, as for
+// InferredSafety, except that callables with invalid safety flags are
+// treated as NotSafe so that they are reported by checkInferredSafety.
+func (fn *Function) cachedInferredSafety() SafetyFlags {
+	if safety, ok := fn.module.inferredSafety.Load(fn.funcode); ok {
+		return safety.(SafetyFlags)
+	}
+
+	safety := nativeSafe
+	walkReferencedCallables(fn, func(c Callable, _ CallFrame) error {
+		callableSafety := callableSafety(c)
+		if callableSafety.CheckValid() != nil {
+			callableSafety = NotSafe
+		}
+		safety &= callableSafety
+		return nil
+	})
+	fn.module.inferredSafety.Store(fn.funcode, safety)
+	return safety
+}
+
+// checkLoadSafety returns an error if the module's declared safety, as
+// reported by thread.LoadSafety, does not contain that required by the
+// thread.
+func (thread *Thread) checkLoadSafety(module string) error {
+	if thread.LoadSafety == nil || thread.requiredSafety == 0 {
+		return nil
+	}
+	safety, err := thread.LoadSafety(thread, module)
+	if err != nil {
+		return err
+	}
+	if err := safety.CheckValid(); err != nil {
+		return err
+	}
+	return safety.CheckContains(thread.requiredSafety)
+}
+
+// walkReferencedCallables calls f with each non-Starlark callable
+// referenced by name by the given function, as described in
+// Function.InferredSafety, and the function and position of the reference,
+// stopping at the first error.
+func walkReferencedCallables(fn *Function, f func(Callable, CallFrame) error) error {
+	w := callableWalker{
+		seen: make(map[callableWalkerKey]bool),
+		f:    f,
+	}
+	return w.function(fn.module, fn.funcode)
+}
+
+type callableWalkerKey struct {
+	module  *module
+	funcode *compile.Funcode
+}
+
+type callableWalker struct {
+	seen map[callableWalkerKey]bool
+	f    func(Callable, CallFrame) error
+}
+
+func (w *callableWalker) function(module *module, funcode *compile.Funcode) error {
+	key := callableWalkerKey{module, funcode}
+	if w.seen[key] {
+		// Already visited, or in progress in the case of recursion.
+		return nil
+	}
+	w.seen[key] = true
+
+	return forEachInsn(funcode, func(pc uint32, op compile.Opcode, arg uint32) error {
+		var v Value
+		switch op {
+		case compile.PREDECLARED:
+			v = module.predeclared[funcode.Prog.Names[arg]]
+		case compile.UNIVERSAL:
+			v = Universe[funcode.Prog.Names[arg]]
+		case compile.GLOBAL:
+			v = module.globals[arg]
+		case compile.MAKEFUNC:
+			return w.function(module, funcode.Prog.Functions[arg])
+		default:
+			return nil
+		}
+		switch v := v.(type) {
+		case *Function:
+			return w.function(v.module, v.funcode)
+		case Callable:
+			return w.f(v, CallFrame{Name: funcode.Name, Pos: funcode.Position(pc)})
+		}
+		return nil
+	})
+}
+
+// forEachInsn calls f with each instruction of the given function, stopping
+// at the first error.
+func forEachInsn(fn *compile.Funcode, f func(pc uint32, op compile.Opcode, arg uint32) error) error {
+	code := fn.Code
+	for pc := uint32(0); pc < uint32(len(code)); {
+		insnPC := pc
+		op := compile.Opcode(code[pc])
+		pc++
+		var arg uint32
+		if op >= compile.OpcodeArgMin {
+			for s := uint(0); ; s += 7 {
+				b := code[pc]
+				pc++
+				arg |= uint32(b&0x7f) << s
+				if b < 0x80 {
+					break
+				}
+			}
+		}
+		if err := f(insnPC, op, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package starlark_test
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/canonical/starlark/starlark"
@@ -271,6 +272,91 @@ func TestCallableSafeExecution(t *testing.T) {
 	}
 }
 
+type undeclaredCallable struct{ dummyCallable }
+
+var _ starlark.Callable = undeclaredCallable{}
+
+func TestCallableUndeclaredSafety(t *testing.T) {
+	c := undeclaredCallable{}
+	if _, ok := starlark.Value(c).(starlark.SafetyAware); ok {
+		t.Fatal("undeclaredCallable must not implement SafetyAware")
+	}
+
+	thread := &starlark.Thread{}
+	if _, err := starlark.Call(thread, c, nil, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	thread.RequireSafety(starlark.CPUSafe)
+	if _, err := starlark.Call(thread, c, nil, nil); !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("expected safety error, got %v", err)
+	}
+}
+
+type checkedCallable struct {
+	dummyCallable
+	steps           int64
+	checked, called bool
+}
+
+var _ starlark.SafeCallable = &checkedCallable{}
+
+func (cc *checkedCallable) CheckCall(thread *starlark.Thread) error {
+	cc.checked = true
+	return thread.AddSteps(starlark.SafeInt(cc.steps))
+}
+
+func (cc *checkedCallable) CallInternal(*starlark.Thread, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	cc.called = true
+	return starlark.None, nil
+}
+
+func TestSafeCallableCheckCall(t *testing.T) {
+	t.Run("permitted", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.CPUSafe)
+		thread.SetMaxSteps(100)
+		c := &checkedCallable{steps: 10}
+		c.DeclareSafety(starlark.CPUSafe)
+		if _, err := starlark.Call(thread, c, nil, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !c.checked || !c.called {
+			t.Errorf("checked = %t, called = %t, want both true", c.checked, c.called)
+		}
+		if steps, _ := thread.Steps(); steps < c.steps {
+			t.Errorf("thread took %d steps, want at least %d", steps, c.steps)
+		}
+	})
+
+	t.Run("too-many-steps", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.CPUSafe)
+		thread.SetMaxSteps(100)
+		c := &checkedCallable{steps: 1000}
+		c.DeclareSafety(starlark.CPUSafe)
+		if _, err := starlark.Call(thread, c, nil, nil); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if c.called {
+			t.Error("CallInternal called after CheckCall failed")
+		}
+	})
+
+	t.Run("not-permitted", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.CPUSafe)
+		c := &checkedCallable{}
+		c.DeclareSafety(starlark.NotSafe)
+		if _, err := starlark.Call(thread, c, nil, nil); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if c.checked || c.called {
+			t.Errorf("checked = %t, called = %t, want both false", c.checked, c.called)
+		}
+	})
+}
+
 func TestNewBuiltinWithSafety(t *testing.T) {
 	fn := func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
 		return starlark.None, nil
@@ -301,6 +387,99 @@ func TestBindReceiverSafety(t *testing.T) {
 	}
 }
 
+func TestWrapWithSafety(t *testing.T) {
+	const prealloc = 128
+	legacy := starlark.NewBuiltin("legacy", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var n int
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &n); err != nil {
+			return nil, err
+		}
+		return starlark.NewList(make([]starlark.Value, n)), nil
+	})
+	wrapped := starlark.WrapWithSafety(legacy, starlark.MemSafe, prealloc, nil)
+
+	if name := wrapped.Name(); name != "legacy" {
+		t.Errorf("incorrect name: expected legacy but got %s", name)
+	}
+	if safety := wrapped.Safety(); safety != starlark.MemSafe {
+		t.Errorf("incorrect safety: expected %v but got %v", starlark.MemSafe, safety)
+	}
+
+	// callAllocs returns the allocations made by calling fn, less those
+	// made by the thread's first call.
+	nop := starlark.NewBuiltinWithSafety("nop", starlark.MemSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	callAllocs := func(t *testing.T, fn starlark.Callable, args starlark.Tuple) (starlark.Value, int64) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.MemSafe)
+		if _, err := starlark.Call(thread, nop, args, nil); err != nil {
+			t.Fatal(err)
+		}
+		base, _ := thread.Allocs()
+		result, err := starlark.Call(thread, fn, args, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocs, _ := thread.Allocs()
+		return result, allocs - base
+	}
+
+	t.Run("accounting", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.RequireSafety(starlark.MemSafe)
+		args := starlark.Tuple{starlark.MakeInt(100)}
+		if _, err := starlark.Call(thread, legacy, args, nil); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		result, allocs := callAllocs(t, wrapped, args)
+		want, _ := starlark.SafeAdd(starlark.EstimateSize(result), prealloc).Int64()
+		if allocs != want {
+			t.Errorf("unexpected allocations: expected %d but got %d", want, allocs)
+		}
+	})
+
+	t.Run("sizer", func(t *testing.T) {
+		sized := starlark.WrapWithSafety(legacy, starlark.MemSafe, 0, func(result interface{}) starlark.SafeInteger {
+			return starlark.SafeInt(1000)
+		})
+		if _, allocs := callAllocs(t, sized, starlark.Tuple{starlark.MakeInt(1)}); allocs != 1000 {
+			t.Errorf("unexpected allocations: expected 1000 but got %d", allocs)
+		}
+	})
+
+	t.Run("prealloc-exceeds-limit", func(t *testing.T) {
+		called := false
+		limited := starlark.WrapWithSafety(starlark.NewBuiltin("limited", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			called = true
+			return starlark.None, nil
+		}), starlark.MemSafe, prealloc, nil)
+
+		thread := &starlark.Thread{}
+		thread.SetMaxAllocs(prealloc - 1)
+		if _, err := starlark.Call(thread, limited, nil, nil); err == nil {
+			t.Error("expected error")
+		}
+		if called {
+			t.Error("wrapped builtin was called despite exceeding the limit")
+		}
+	})
+
+	t.Run("receiver", func(t *testing.T) {
+		recvOf := starlark.NewBuiltin("recv_of", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return b.Receiver(), nil
+		})
+		bound := starlark.WrapWithSafety(recvOf, starlark.MemSafe, 0, nil).BindReceiver(starlark.String("foo"))
+		result, err := starlark.Call(&starlark.Thread{}, bound, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != starlark.String("foo") {
+			t.Errorf("incorrect receiver: expected \"foo\" but got %v", result)
+		}
+	})
+}
+
 type dummySafetyAware struct {
 	safety starlark.SafetyFlags
 }
@@ -370,3 +549,172 @@ func TestCheckSafety(t *testing.T) {
 		})
 	}
 }
+
+func TestFunctionInferredSafety(t *testing.T) {
+	const safe = starlark.CPUSafe | starlark.MemSafe
+	safeFn := starlark.NewBuiltinWithSafety("safe_fn", starlark.Safe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	unsafeFn := starlark.NewBuiltinWithSafety("unsafe_fn", starlark.CPUSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return starlark.None, nil
+	})
+	predeclared := starlark.StringDict{
+		"safe_fn":   safeFn,
+		"unsafe_fn": unsafeFn,
+	}
+
+	const src = `
+def direct():
+	safe_fn()
+
+def indirect():
+	direct()
+	unsafe()
+
+def unsafe():
+	if False:
+		unsafe_fn()
+
+def nested():
+	return lambda: unsafe_fn()
+
+def recursive(n):
+	if n > 0:
+		recursive(n - 1)
+	return len([])
+`
+	globals, err := starlark.ExecFile(&starlark.Thread{}, "inferred_safety", src, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want starlark.SafetyFlags
+	}{
+		{"direct", starlark.Safe},
+		{"indirect", starlark.CPUSafe},
+		{"unsafe", starlark.CPUSafe},
+		{"nested", starlark.CPUSafe},
+		{"recursive", starlark.Safe},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := globals[test.name].(*starlark.Function)
+			if got := fn.InferredSafety(); got != test.want {
+				t.Errorf("got safety %v, want %v", got, test.want)
+			}
+
+			thread := &starlark.Thread{}
+			thread.RequireSafety(safe)
+			_, err := starlark.Call(thread, fn, starlark.Tuple{starlark.MakeInt(0)}[:fn.NumParams()], nil)
+			if test.want.Contains(safe) {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if !errors.Is(err, starlark.ErrSafety) {
+				t.Errorf("expected safety error before execution, got %v", err)
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		for _, name := range []string{"direct", "indirect"} {
+			fn := globals[name].(*starlark.Function)
+			for i := 0; i < 2; i++ {
+				thread := &starlark.Thread{}
+				thread.RequireSafety(safe)
+				_, err := starlark.Call(thread, fn, nil, nil)
+				if name == "direct" && err != nil {
+					t.Errorf("%s: unexpected error: %v", name, err)
+				} else if name == "indirect" && !errors.Is(err, starlark.ErrSafety) {
+					t.Errorf("%s: expected safety error, got %v", name, err)
+				}
+			}
+			if !starlark.InferredSafetyCached(fn) {
+				t.Errorf("%s: inferred safety not cached", name)
+			}
+		}
+	})
+
+	t.Run("rejected-before-execution", func(t *testing.T) {
+		const src = `
+safe_fn()
+unsafe_fn()
+`
+		var calls int
+		counted := starlark.NewBuiltinWithSafety("safe_fn", starlark.Safe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			calls++
+			return starlark.None, nil
+		})
+		thread := &starlark.Thread{}
+		thread.RequireSafety(safe)
+		env := starlark.StringDict{"safe_fn": counted, "unsafe_fn": unsafeFn}
+		if _, err := starlark.ExecFile(thread, "inferred_safety", src, env); !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("expected safety error, got %v", err)
+		}
+		if calls != 0 {
+			t.Errorf("program partially executed before rejection")
+		}
+	})
+}
+
+func TestLoadSafety(t *testing.T) {
+	const safe = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+
+	modules := map[string]starlark.SafetyFlags{
+		"safe.star":   safe,
+		"unsafe.star": starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe,
+	}
+	newThread := func(loaded *[]string) *starlark.Thread {
+		thread := &starlark.Thread{
+			Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+				*loaded = append(*loaded, module)
+				return starlark.StringDict{"x": starlark.MakeInt(1)}, nil
+			},
+			LoadSafety: func(thread *starlark.Thread, module string) (starlark.SafetyFlags, error) {
+				return modules[module], nil
+			},
+		}
+		thread.RequireSafety(starlark.IOSafe)
+		return thread
+	}
+
+	t.Run("permitted", func(t *testing.T) {
+		var loaded []string
+		thread := newThread(&loaded)
+		if _, err := starlark.ExecFile(thread, "load.star", `load("safe.star", "x")`, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded) != 1 {
+			t.Errorf("expected module to be loaded once, got %v", loaded)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		var loaded []string
+		thread := newThread(&loaded)
+		_, err := starlark.ExecFile(thread, "load.star", `load("unsafe.star", "x")`, nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if expected := "cannot load unsafe.star: "; !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got %q", expected, err.Error())
+		}
+		if len(loaded) != 0 {
+			t.Errorf("module was loaded despite its safety: %v", loaded)
+		}
+	})
+
+	t.Run("not-required", func(t *testing.T) {
+		var loaded []string
+		thread := newThread(&loaded)
+		thread = &starlark.Thread{Load: thread.Load, LoadSafety: thread.LoadSafety}
+		if _, err := starlark.ExecFile(thread, "load.star", `load("unsafe.star", "x")`, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
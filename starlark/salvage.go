@@ -0,0 +1,60 @@
+package starlark
+
+import "errors"
+
+// A SalvageFunc recovers a partial result from a checkpoint function whose
+// execution exhausted the thread's budget. The locals map holds the values
+// of the function's assigned local variables at the point execution
+// stopped and err is the error which stopped it.
+//
+// As the thread's budget is exhausted, a SalvageFunc cannot execute further
+// Starlark code on the thread.
+type SalvageFunc func(thread *Thread, locals StringDict, err error) (Value, error)
+
+// SetCheckpoint designates each Starlark function with the given name as a
+// checkpoint. If a step, memory or time limit is reached during a call to a
+// checkpoint, salvage is called as the call unwinds and its result is
+// returned in place of the error, allowing best-effort results to be
+// recovered rather than discarding all work.
+//
+// The budget remains exhausted after a checkpoint returns, so a caller which
+// is itself Starlark code will typically fail on its next step. Checkpoints
+// are therefore most useful for functions the embedder calls directly, for
+// example with Call.
+func (thread *Thread) SetCheckpoint(name string, salvage SalvageFunc) {
+	thread.checkpoint = name
+	thread.salvage = salvage
+}
+
+// isCheckpoint reports whether a call to fn should be salvaged if it fails
+// with err.
+func (thread *Thread) isCheckpoint(fn *Function, err error) bool {
+	if thread.salvage == nil || fn.Name() != thread.checkpoint {
+		return false
+	}
+	var cancelErr *CancellationError
+	if !errors.As(err, &cancelErr) {
+		return false
+	}
+	switch cancelErr.Kind {
+	case CancelSteps, CancelMemory, CancelTimeout, CancelCPUTime:
+		return true
+	default:
+		return false
+	}
+}
+
+// salvageFrame calls the thread's SalvageFunc with the locals of fr.
+func (thread *Thread) salvageFrame(fr *frame, err error) (Value, error) {
+	locals := make(StringDict, fr.NumLocals())
+	for i := 0; i < fr.NumLocals(); i++ {
+		binding, value := fr.Local(i)
+		if c, ok := value.(*cell); ok {
+			value = c.v
+		}
+		if value != nil {
+			locals[binding.Name] = value
+		}
+	}
+	return thread.salvage(thread, locals, err)
+}
@@ -0,0 +1,78 @@
+package starlark_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestCheckpointSalvage(t *testing.T) {
+	const src = `
+def render():
+	out = []
+	for i in range(1 << 30):
+		out.append(i)
+	return out
+
+def other():
+	return render()
+`
+	globals, err := starlark.ExecFile(&starlark.Thread{}, "salvage.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("checkpoint", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(1000)
+		var cause error
+		thread.SetCheckpoint("render", func(thread *starlark.Thread, locals starlark.StringDict, err error) (starlark.Value, error) {
+			cause = err
+			return locals["out"], nil
+		})
+		result, err := starlark.Call(thread, globals["render"], nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !errors.Is(cause, starlark.ErrSafety) {
+			t.Errorf("unexpected salvage cause: %v", cause)
+		}
+		if list, ok := result.(*starlark.List); !ok {
+			t.Errorf("expected list, got %v", result)
+		} else if list.Len() == 0 {
+			t.Error("expected partial results")
+		}
+	})
+
+	t.Run("caller", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(1000)
+		salvaged := false
+		thread.SetCheckpoint("render", func(thread *starlark.Thread, locals starlark.StringDict, err error) (starlark.Value, error) {
+			salvaged = true
+			return locals["out"], nil
+		})
+		_, err := starlark.Call(thread, globals["other"], nil, nil)
+		if !salvaged {
+			t.Error("checkpoint was not salvaged")
+		}
+		if err == nil {
+			t.Error("expected caller of checkpoint to fail")
+		} else if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("explicit-cancellation", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.Cancel("done")
+		thread.SetCheckpoint("render", func(thread *starlark.Thread, locals starlark.StringDict, err error) (starlark.Value, error) {
+			t.Error("unexpected salvage")
+			return starlark.None, nil
+		})
+		if _, err := starlark.Call(thread, globals["render"], nil, nil); err == nil {
+			t.Error("expected cancellation")
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package starlark
+
+import (
+	"math/big"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// A Sizer estimates the memory held by the result of an operation, so that
+// it may be reported with Thread.AddAllocs once the operation has completed.
+//
+// The standard sizers below each handle a single common result type and
+// agree with EstimateSize for it, without the cost of walking the value by
+// reflection. Given a result of any other type, they fall back to
+// EstimateSize.
+type Sizer func(result interface{}) SafeInteger
+
+var (
+	_ Sizer = SizeOfString
+	_ Sizer = SizeOfValueSlice
+	_ Sizer = SizeOfDictEntries
+	_ Sizer = SizeOfBigInt
+)
+
+// SizeOfString estimates the size of a string or String, including its
+// contents.
+func SizeOfString(result interface{}) SafeInteger {
+	switch s := result.(type) {
+	case string:
+		return SafeAdd(StringTypeOverhead, roundAllocSize(SafeInt(len(s))))
+	case String:
+		return SafeAdd(StringTypeOverhead, roundAllocSize(SafeInt(len(s))))
+	default:
+		return EstimateSize(result)
+	}
+}
+
+// SizeOfValueSlice estimates the size of a []Value or Tuple and its backing
+// array, excluding the values it refers to, which are assumed to have been
+// accounted for already.
+func SizeOfValueSlice(result interface{}) SafeInteger {
+	switch s := result.(type) {
+	case []Value:
+		return SafeAdd(SliceTypeOverhead, EstimateMakeSize([]Value{}, SafeInt(cap(s))))
+	case Tuple:
+		return SafeAdd(SliceTypeOverhead, EstimateMakeSize([]Value{}, SafeInt(cap(s))))
+	default:
+		return EstimateSize(result)
+	}
+}
+
+// SizeOfDictEntries estimates the memory a *Dict holds for its entries
+// beyond the Dict itself, excluding the keys and values they refer to.
+func SizeOfDictEntries(result interface{}) SafeInteger {
+	d, ok := result.(*Dict)
+	if !ok {
+		return EstimateSize(result)
+	}
+	return d.ht.entriesSize()
+}
+
+// SizeOfBigInt estimates the size of a *big.Int or Int, including its
+// digits.
+func SizeOfBigInt(result interface{}) SafeInteger {
+	switch x := result.(type) {
+	case *big.Int:
+		return sizeOfBigInt(x)
+	case Int:
+		return x.EstimateSize()
+	default:
+		return EstimateSize(result)
+	}
+}
+
+func sizeOfBigInt(x *big.Int) SafeInteger {
+	if x == nil {
+		return SafeInt(0)
+	}
+	digitsSize := SafeMul(cap(x.Bits()), unsafe.Sizeof(big.Word(0)))
+	return SafeAdd(roundAllocSize(SafeInt(unsafe.Sizeof(big.Int{}))), roundAllocSize(digitsSize))
+}
+
+// sizerRegistry holds the Sizers registered with RegisterSizer, as a
+// map[reflect.Type]Sizer which is replaced rather than modified, so that
+// EstimateSize may consult it without locking.
+var (
+	sizerRegistry     atomic.Value
+	sizerRegistryLock sync.Mutex
+)
+
+// RegisterSizer registers sizer to estimate the size of values of type t,
+// which is typically a host-defined Value. Once registered, EstimateSize
+// uses sizer wherever it meets a value of type t, whether passed to it
+// directly or reached through an interface or pointer, so that builtins
+// which report their results' sizes with EstimateSize account for host
+// values without further effort.
+//
+// The sizer must estimate the same memory as EstimateSize would, so it
+// must not itself call EstimateSize on a value of type t. Registering a
+// second sizer for the same type replaces the first; registering a nil
+// sizer removes it.
+//
+// RegisterSizer is typically called from an init function. It is safe to
+// call concurrently with EstimateSize.
+func RegisterSizer(t reflect.Type, sizer Sizer) {
+	if t == nil {
+		panic("RegisterSizer: nil type")
+	}
+
+	sizerRegistryLock.Lock()
+	defer sizerRegistryLock.Unlock()
+
+	old, _ := sizerRegistry.Load().(map[reflect.Type]Sizer)
+	registry := make(map[reflect.Type]Sizer, len(old)+1)
+	for t, sizer := range old {
+		registry[t] = sizer
+	}
+	if sizer == nil {
+		delete(registry, t)
+	} else {
+		registry[t] = sizer
+	}
+	sizerRegistry.Store(registry)
+}
+
+// registeredSizer returns the Sizer registered for values of type t, if any.
+func registeredSizer(t reflect.Type) (Sizer, bool) {
+	registry, _ := sizerRegistry.Load().(map[reflect.Type]Sizer)
+	if len(registry) == 0 {
+		return nil, false
+	}
+	sizer, ok := registry[t]
+	return sizer, ok
+}
+
+// estimateRegisteredSize estimates the size of v using its registered
+// Sizer, if any.
+func estimateRegisteredSize(v reflect.Value) (SafeInteger, bool) {
+	if !v.CanInterface() {
+		return SafeInt(0), false
+	}
+	sizer, ok := registeredSizer(v.Type())
+	if !ok {
+		return SafeInt(0), false
+	}
+	return sizer(v.Interface()), true
+}
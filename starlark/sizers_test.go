@@ -0,0 +1,152 @@
+package starlark_test
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestSizeOfString(t *testing.T) {
+	for _, s := range []string{"", "a", "hello, world", string(make([]byte, 1000))} {
+		if got, want := starlark.SizeOfString(s), starlark.EstimateSize(s); got != want {
+			t.Errorf("incorrect size of string of length %d: expected %v but got %v", len(s), want, got)
+		}
+		if got, want := starlark.SizeOfString(starlark.String(s)), starlark.EstimateSize(starlark.String(s)); got != want {
+			t.Errorf("incorrect size of String of length %d: expected %v but got %v", len(s), want, got)
+		}
+	}
+}
+
+func TestSizeOfValueSlice(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000} {
+		s := make([]starlark.Value, n, n+1)
+		if got, want := starlark.SizeOfValueSlice(s), starlark.EstimateSize(s); got != want {
+			t.Errorf("incorrect size of []Value of length %d: expected %v but got %v", n, want, got)
+		}
+		if got, want := starlark.SizeOfValueSlice(starlark.Tuple(s)), starlark.EstimateSize(starlark.Tuple(s)); got != want {
+			t.Errorf("incorrect size of Tuple of length %d: expected %v but got %v", n, want, got)
+		}
+	}
+}
+
+func TestSizeOfDictEntries(t *testing.T) {
+	for _, n := range []int{0, 1, 8, 100, 1000} {
+		// Presize the dict so that no discarded tables are charged.
+		thread := &starlark.Thread{}
+		d, err := starlark.SafeNewDict(thread, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := d.SafeSetKey(thread, starlark.MakeInt(i), starlark.None); err != nil {
+				t.Fatal(err)
+			}
+		}
+		allocs, _ := thread.Allocs()
+		want := starlark.SafeSub(allocs, starlark.EstimateSize(&starlark.Dict{}))
+		if got := starlark.SizeOfDictEntries(d); got != want {
+			t.Errorf("incorrect size of dict of length %d: expected %v but got %v", n, want, got)
+		}
+	}
+}
+
+func TestSizeOfBigInt(t *testing.T) {
+	for _, s := range []string{"0", "1", "-12345678901234567890", "1234567890123456789012345678901234567890"} {
+		x, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			t.Fatalf("cannot parse %s", s)
+		}
+		if got, want := starlark.SizeOfBigInt(x), starlark.EstimateSize(x); got != want {
+			t.Errorf("incorrect size of %s: expected %v but got %v", s, want, got)
+		}
+		i := starlark.MakeBigInt(x)
+		if got, want := starlark.SizeOfBigInt(i), starlark.EstimateSize(i); got != want {
+			t.Errorf("incorrect size of Int %s: expected %v but got %v", s, want, got)
+		}
+	}
+}
+
+// hostValue is a host-defined value whose memory is held outside the Go
+// heap, so that reflection cannot estimate it.
+type hostValue struct {
+	handle uintptr
+	size   int64
+}
+
+var _ starlark.Value = &hostValue{}
+
+// boxedHostValue is a host-defined value which is not a pointer, and so is
+// boxed when held in an interface.
+type boxedHostValue struct {
+	handle uintptr
+	size   int64
+}
+
+var _ starlark.Value = boxedHostValue{}
+
+func (bv boxedHostValue) String() string        { return "<boxedHostValue>" }
+func (bv boxedHostValue) Type() string          { return "boxedHostValue" }
+func (bv boxedHostValue) Freeze()               {}
+func (bv boxedHostValue) Truth() starlark.Bool  { return true }
+func (bv boxedHostValue) Hash() (uint32, error) { return 0, nil }
+
+func (hv *hostValue) String() string        { return "<hostValue>" }
+func (hv *hostValue) Type() string          { return "hostValue" }
+func (hv *hostValue) Freeze()               {}
+func (hv *hostValue) Truth() starlark.Bool  { return true }
+func (hv *hostValue) Hash() (uint32, error) { return 0, nil }
+
+func TestRegisterSizer(t *testing.T) {
+	hostValueType := reflect.TypeOf(&hostValue{})
+	starlark.RegisterSizer(hostValueType, func(result interface{}) starlark.SafeInteger {
+		return starlark.SafeInt(result.(*hostValue).size)
+	})
+	defer starlark.RegisterSizer(hostValueType, nil)
+
+	value := &hostValue{size: 1 << 20}
+	want := starlark.SafeInt(value.size)
+	if got := starlark.EstimateSize(value); got != want {
+		t.Errorf("incorrect size of registered value: expected %v but got %v", want, got)
+	}
+
+	tuple := starlark.Tuple{value}
+	want = starlark.SafeAdd(starlark.SizeOfValueSlice(tuple), value.size)
+	if got := starlark.EstimateSize(tuple); got != want {
+		t.Errorf("incorrect size of tuple holding registered value: expected %v but got %v", want, got)
+	}
+
+	// A value referenced more than once is counted once.
+	tuple = starlark.Tuple{value, value}
+	want = starlark.SafeAdd(starlark.SizeOfValueSlice(tuple), value.size)
+	if got := starlark.EstimateSize(tuple); got != want {
+		t.Errorf("incorrect size of tuple holding a registered value twice: expected %v but got %v", want, got)
+	}
+
+	starlark.RegisterSizer(hostValueType, nil)
+	if got := starlark.EstimateSize(value); got == starlark.SafeInt(value.size) {
+		t.Error("sizer still used after removal")
+	}
+}
+
+func TestRegisterSizerBoxed(t *testing.T) {
+	boxedType := reflect.TypeOf(boxedHostValue{})
+	starlark.RegisterSizer(boxedType, func(result interface{}) starlark.SafeInteger {
+		return starlark.SafeInt(result.(boxedHostValue).size)
+	})
+	defer starlark.RegisterSizer(boxedType, nil)
+
+	var value starlark.Value = boxedHostValue{size: 1 << 20}
+	shared := starlark.Tuple{value, value}
+	want := starlark.SafeAdd(starlark.SizeOfValueSlice(shared), 1<<20)
+	if got := starlark.EstimateSize(shared); got != want {
+		t.Errorf("incorrect size of tuple sharing a boxed value: expected %v but got %v", want, got)
+	}
+
+	separate := starlark.Tuple{boxedHostValue{size: 1 << 20}, boxedHostValue{size: 2 << 20}}
+	want = starlark.SafeAdd(starlark.SizeOfValueSlice(separate), 3<<20)
+	if got := starlark.EstimateSize(separate); got != want {
+		t.Errorf("incorrect size of tuple holding separate boxed values: expected %v but got %v", want, got)
+	}
+}
@@ -0,0 +1,66 @@
+package starlark
+
+// Small integers and one-byte strings are produced very frequently, for
+// example by iterating over a range or a string. Rather than boxing a new
+// copy of each, canonical instances are shared. Since these are allocated
+// once for the lifetime of the program, they are not charged to any thread.
+
+const (
+	minSmallInt = -128
+	maxSmallInt = 255
+)
+
+var (
+	smallInts   [maxSmallInt - minSmallInt + 1]Value
+	byteStrings [256]Value
+)
+
+func init() {
+	for i := range smallInts {
+		smallInts[i] = MakeInt(i + minSmallInt)
+	}
+	for i := range byteStrings {
+		byteStrings[i] = String([]byte{byte(i)})
+	}
+}
+
+// smallInt returns the canonical instance of x, if it has one.
+func smallInt(x int) (Value, bool) {
+	if x < minSmallInt || x > maxSmallInt {
+		return nil, false
+	}
+	return smallInts[x-minSmallInt], true
+}
+
+// byteString returns the canonical one-byte string holding b.
+func byteString(b byte) Value {
+	return byteStrings[b]
+}
+
+// intValue returns x as a Value, avoiding allocation where possible.
+func intValue(x int) Value {
+	if v, ok := smallInt(x); ok {
+		return v
+	}
+	return MakeInt(x)
+}
+
+// safeIntValue returns x as a Value. The thread is charged for the memory
+// used unless x has a canonical instance.
+func safeIntValue(thread *Thread, x int) (Value, error) {
+	if v, ok := smallInt(x); ok {
+		return v, nil
+	}
+	result := Value(MakeInt(x))
+	if thread != nil {
+		if err := thread.AddAllocs(EstimateSize(result)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// byteInt returns the canonical instance of the integer value of b.
+func byteInt(b byte) Value {
+	return smallInts[int(b)-minSmallInt]
+}
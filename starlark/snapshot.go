@@ -0,0 +1,62 @@
+package starlark
+
+// A ResourceSnapshot records the steps and allocations counted by a thread
+// at some point in its execution. See Thread.Snapshot.
+type ResourceSnapshot struct {
+	steps  SafeInteger
+	allocs SafeInteger
+}
+
+// Steps returns the step count recorded by the snapshot.
+func (snap ResourceSnapshot) Steps() (int64, bool) { return snap.steps.Int64() }
+
+// Allocs returns the allocation count recorded by the snapshot.
+func (snap ResourceSnapshot) Allocs() (int64, bool) { return snap.allocs.Int64() }
+
+// Snapshot returns the steps and allocations currently counted by this
+// thread, so that they may later be restored with Restore.
+//
+// It is safe to call Snapshot from any goroutine, even if the thread is
+// actively executing.
+func (thread *Thread) Snapshot() ResourceSnapshot {
+	thread.stepsLock.Lock()
+	steps := thread.steps
+	thread.stepsLock.Unlock()
+
+	thread.allocsLock.Lock()
+	allocs := thread.allocs
+	thread.allocsLock.Unlock()
+
+	return ResourceSnapshot{steps: steps, allocs: allocs}
+}
+
+// Restore resets the steps and allocations counted by this thread to those
+// recorded by snap, which must have been taken from this thread by
+// Snapshot. This allows the resources used by a speculative computation to
+// be discarded along with its result, for example so that a retried
+// computation is not charged twice.
+//
+// If the thread is attached to a ResourcePool, the resources discarded are
+// returned to the pool.
+//
+// Restore does not undo cancellation: if the thread was cancelled after
+// snap was taken, for example because a limit was exceeded, it remains so.
+//
+// Restore may be called by a builtin running on this thread, for example
+// to discard the cost of a speculative call, but must not be called from
+// another goroutine while the thread is executing.
+func (thread *Thread) Restore(snap ResourceSnapshot) {
+	thread.stepsLock.Lock()
+	if thread.pool != nil {
+		thread.pool.draw(poolSteps, SafeSub(snap.steps, thread.steps), true)
+	}
+	thread.steps = snap.steps
+	thread.stepsLock.Unlock()
+
+	thread.allocsLock.Lock()
+	if thread.pool != nil {
+		thread.pool.draw(poolAllocs, SafeSub(snap.allocs, thread.allocs), true)
+	}
+	thread.allocs = snap.allocs
+	thread.allocsLock.Unlock()
+}
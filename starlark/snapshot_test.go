@@ -0,0 +1,72 @@
+package starlark_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	thread := &starlark.Thread{}
+	if err := thread.AddSteps(starlark.SafeInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.AddAllocs(starlark.SafeInt(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := thread.Snapshot()
+	if steps, ok := snap.Steps(); !ok || steps != 10 {
+		t.Errorf("snapshot recorded incorrect steps: expected 10 but got %d", steps)
+	}
+	if allocs, ok := snap.Allocs(); !ok || allocs != 100 {
+		t.Errorf("snapshot recorded incorrect allocs: expected 100 but got %d", allocs)
+	}
+
+	if _, err := starlark.ExecFile(thread, "speculative.star", "x = [i for i in range(10)]", nil); err != nil {
+		t.Fatal(err)
+	}
+	if steps, _ := thread.Steps(); steps <= 10 {
+		t.Errorf("execution was not charged steps")
+	}
+	if allocs, _ := thread.Allocs(); allocs <= 100 {
+		t.Errorf("execution was not charged allocs")
+	}
+
+	thread.Restore(snap)
+	if steps, ok := thread.Steps(); !ok || steps != 10 {
+		t.Errorf("incorrect steps after restore: expected 10 but got %d", steps)
+	}
+	if allocs, ok := thread.Allocs(); !ok || allocs != 100 {
+		t.Errorf("incorrect allocs after restore: expected 100 but got %d", allocs)
+	}
+}
+
+func TestRestoreFromBuiltin(t *testing.T) {
+	speculate := starlark.NewBuiltin("speculate", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var fn starlark.Callable
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &fn); err != nil {
+			return nil, err
+		}
+		snap := thread.Snapshot()
+		if _, err := starlark.Call(thread, fn, nil, nil); err != nil {
+			return nil, err
+		}
+		thread.Restore(snap)
+		return starlark.None, nil
+	})
+
+	const src = `
+def work():
+	return [i for i in range(1000)]
+
+speculate(work)
+`
+	thread := &starlark.Thread{}
+	if _, err := starlark.ExecFile(thread, "speculate.star", src, starlark.StringDict{"speculate": speculate}); err != nil {
+		t.Fatal(err)
+	}
+	if steps, _ := thread.Steps(); steps >= 1000 {
+		t.Errorf("speculative steps were charged: got %d steps", steps)
+	}
+}
@@ -0,0 +1,75 @@
+package starlark
+
+// Usage records the resources counted by a thread.
+type Usage struct {
+	Steps  int64
+	Allocs int64
+}
+
+// softLimits holds the thresholds set by SetSoftLimits.
+type softLimits struct {
+	steps, allocs int64
+	fn            func(Usage)
+
+	// stepsCrossed and allocsCrossed record whether each threshold has
+	// been reported, and are guarded by stepsLock and allocsLock.
+	stepsCrossed, allocsCrossed bool
+}
+
+// SetSoftLimits arranges for fn to be called once when the steps counted by
+// this thread first exceed steps, and once when its allocations first exceed
+// allocs. Unlike the limits set by SetMaxSteps and SetMaxAllocs, reaching a
+// soft limit does not cancel the thread, so an embedder may use soft limits
+// to emit warnings and metrics before a script is stopped at its hard limit,
+// for example by setting them at 80% of the hard limits.
+//
+// A zero or negative limit is never reached. fn is called from the
+// goroutine which counts the steps or allocations, with no locks held,
+// and it must not block.
+func (thread *Thread) SetSoftLimits(steps, allocs int64, fn func(Usage)) {
+	thread.softLimits = &softLimits{
+		steps:  steps,
+		allocs: allocs,
+		fn:     fn,
+	}
+}
+
+// crossSoftSteps reports whether the thread's steps have just crossed its
+// soft limit. The caller must hold stepsLock.
+func (thread *Thread) crossSoftSteps() bool {
+	limits := thread.softLimits
+	if limits == nil || limits.stepsCrossed || limits.steps <= 0 {
+		return false
+	}
+	steps, ok := thread.steps.Int64()
+	if ok && steps <= limits.steps {
+		return false
+	}
+	limits.stepsCrossed = true
+	return true
+}
+
+// crossSoftAllocs reports whether the thread's allocations have just
+// crossed its soft limit. The caller must hold allocsLock.
+func (thread *Thread) crossSoftAllocs() bool {
+	limits := thread.softLimits
+	if limits == nil || limits.allocsCrossed || limits.allocs <= 0 {
+		return false
+	}
+	allocs, ok := thread.allocs.Int64()
+	if ok && allocs <= limits.allocs {
+		return false
+	}
+	limits.allocsCrossed = true
+	return true
+}
+
+// reportSoftLimit calls the thread's soft limit callback with its current
+// usage.
+func (thread *Thread) reportSoftLimit() {
+	steps, allocs := thread.totals()
+	usage := Usage{}
+	usage.Steps, _ = steps.Int64()
+	usage.Allocs, _ = allocs.Int64()
+	thread.softLimits.fn(usage)
+}
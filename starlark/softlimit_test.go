@@ -0,0 +1,87 @@
+package starlark_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestSoftLimits(t *testing.T) {
+	t.Run("steps", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(100)
+		var reports []starlark.Usage
+		thread.SetSoftLimits(80, 0, func(usage starlark.Usage) {
+			// The thread's counters may be read from the callback.
+			if steps, _ := thread.Steps(); steps != usage.Steps {
+				t.Errorf("reported %d steps, thread has %d", usage.Steps, steps)
+			}
+			reports = append(reports, usage)
+		})
+		for i := 0; i < 90; i++ {
+			if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+				t.Fatalf("unexpected cancellation: %v", err)
+			}
+		}
+		if len(reports) != 1 {
+			t.Fatalf("expected one report, got %d", len(reports))
+		}
+		if reports[0].Steps != 81 {
+			t.Errorf("reported at %d steps, expected 81", reports[0].Steps)
+		}
+		if err := thread.AddSteps(starlark.SafeInt(20)); err == nil {
+			t.Error("expected cancellation at hard limit")
+		}
+		if len(reports) != 1 {
+			t.Errorf("expected one report, got %d", len(reports))
+		}
+	})
+
+	t.Run("allocs", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		var reports []starlark.Usage
+		thread.SetSoftLimits(0, 1000, func(usage starlark.Usage) {
+			reports = append(reports, usage)
+		})
+		if err := thread.AddAllocs(starlark.SafeInt(1000)); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 0 {
+			t.Fatalf("unexpected report at limit: %v", reports)
+		}
+		if err := thread.AddAllocs(starlark.SafeInt(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := thread.AddAllocs(starlark.SafeInt(1)); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 1 {
+			t.Fatalf("expected one report, got %d", len(reports))
+		}
+		if reports[0].Allocs != 1001 {
+			t.Errorf("reported at %d allocs, expected 1001", reports[0].Allocs)
+		}
+	})
+
+	t.Run("script", func(t *testing.T) {
+		thread := &starlark.Thread{}
+		thread.SetMaxSteps(10000)
+		reported := false
+		thread.SetSoftLimits(8000, 0, func(usage starlark.Usage) {
+			reported = true
+		})
+		const src = `
+def spin():
+	for i in range(1 << 20): pass
+spin()
+`
+		_, err := starlark.ExecFile(thread, "soft.star", src, nil)
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Fatalf("expected safety error, got %v", err)
+		}
+		if !reported {
+			t.Error("soft limit was not reported before cancellation")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package starlark
+
+import "errors"
+
+// Spawn returns a new thread with the given name on which a builtin may
+// evaluate callbacks on behalf of thread, for example concurrently with
+// it. The child inherits the thread's required safety, its Print, Load and
+// LoadSafety functions, its file options, and its limits on collection
+// length and on the duration of builtin calls.
+//
+// The steps and allocations counted by the child are also counted by
+// thread, so the two share thread's budget and any ResourcePool to which
+// it is attached. If that budget is exhausted by either thread, both are
+// cancelled, and once thread is cancelled for any reason, the child is
+// cancelled as soon as it next counts a step or an allocation. The child's
+// usage is not recorded by thread's step or allocation profiles, which
+// sample thread's own stack; the child may be profiled separately. To
+// sub-allocate only part of the budget to the child, set its own limits,
+// for example:
+//
+//	child := thread.Spawn("worker")
+//	if remaining, limited := thread.RemainingSteps(); limited {
+//		child.SetMaxSteps(remaining / 4)
+//	}
+//
+// Spawn must be called from the goroutine executing thread, typically by
+// a builtin, or before thread begins execution. The child may then be used
+// from any goroutine.
+func (thread *Thread) Spawn(name string) *Thread {
+	return &Thread{
+		Name:             name,
+		Print:            thread.Print,
+		Load:             thread.Load,
+		LoadSafety:       thread.LoadSafety,
+		requiredSafety:   thread.requiredSafety,
+		maxCollectionLen: thread.maxCollectionLen,
+		builtinTimeout:   thread.builtinTimeout,
+		maxCallDuration:  thread.maxCallDuration,
+		fileOptions:      thread.fileOptions,
+		parent:           thread,
+	}
+}
+
+// Parent returns the thread which spawned this one, if any. See Spawn.
+func (thread *Thread) Parent() *Thread {
+	return thread.parent
+}
+
+// inheritedCancellation returns the kind and cause of a cancellation
+// which err reports, if it was returned by the thread's parent.
+func inheritedCancellation(kind CancellationKind, err error) (CancellationKind, error) {
+	var cancelErr *CancellationError
+	if errors.As(err, &cancelErr) {
+		return cancelErr.Kind, cancelErr.Err
+	}
+	return kind, err
+}
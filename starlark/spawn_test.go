@@ -0,0 +1,207 @@
+package starlark_test
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+func TestSpawnInheritance(t *testing.T) {
+	load := func(*starlark.Thread, string) (starlark.StringDict, error) { return nil, nil }
+	thread := &starlark.Thread{Load: load}
+	thread.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+
+	child := thread.Spawn("child")
+	if child.Name != "child" {
+		t.Errorf("unexpected name: got %q, want %q", child.Name, "child")
+	}
+	if child.Parent() != thread {
+		t.Error("child does not report its parent")
+	}
+	if thread.Parent() != nil {
+		t.Error("unexpected parent")
+	}
+	if got, want := child.RequiredSafety(), thread.RequiredSafety(); got != want {
+		t.Errorf("required safety not inherited: got %v, want %v", got, want)
+	}
+	if child.Load == nil {
+		t.Error("load function not inherited")
+	}
+}
+
+func TestSpawnSharedBudget(t *testing.T) {
+	thread := &starlark.Thread{}
+	thread.SetMaxSteps(1000)
+	thread.SetMaxAllocs(1000)
+
+	child := thread.Spawn("child")
+	if err := child.AddSteps(starlark.SafeInt(400)); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.AddAllocs(starlark.SafeInt(300)); err != nil {
+		t.Fatal(err)
+	}
+	if steps, _ := thread.Steps(); steps != 400 {
+		t.Errorf("parent steps: got %d, want 400", steps)
+	}
+	if allocs, _ := thread.Allocs(); allocs != 300 {
+		t.Errorf("parent allocs: got %d, want 300", allocs)
+	}
+	if remaining, limited := child.RemainingSteps(); !limited || remaining != 600 {
+		t.Errorf("child remaining steps: got %d (limited: %t), want 600", remaining, limited)
+	}
+
+	if err := child.ReleaseAllocs(starlark.SafeInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	if allocs, _ := thread.Allocs(); allocs != 200 {
+		t.Errorf("parent allocs after release: got %d, want 200", allocs)
+	}
+
+	if err := child.CheckSteps(starlark.SafeInt(700)); err == nil {
+		t.Error("expected error")
+	}
+	err := child.AddSteps(starlark.SafeInt(700))
+	if !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("expected safety error, got %v", err)
+	}
+	var cancelErr *starlark.CancellationError
+	if !errors.As(err, &cancelErr) || cancelErr.Kind != starlark.CancelSteps {
+		t.Errorf("expected step cancellation, got %v", err)
+	} else if errors.As(cancelErr.Err, new(*starlark.CancellationError)) {
+		t.Errorf("cancellation is nested: %v", err)
+	}
+	if err := thread.AddSteps(starlark.SafeInt(0)); err == nil {
+		t.Error("parent not cancelled")
+	}
+}
+
+func TestSpawnSubBudget(t *testing.T) {
+	thread := &starlark.Thread{}
+	thread.SetMaxSteps(1000)
+
+	child := thread.Spawn("child")
+	child.SetMaxSteps(100)
+	if err := child.AddSteps(starlark.SafeInt(101)); err == nil {
+		t.Error("expected error")
+	}
+	if steps, _ := thread.Steps(); steps != 0 {
+		t.Errorf("parent charged for rejected steps: got %d", steps)
+	}
+	if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+		t.Errorf("parent cancelled: %v", err)
+	}
+}
+
+func TestSpawnParentCancellation(t *testing.T) {
+	thread := &starlark.Thread{}
+	child := thread.Spawn("child")
+	thread.Cancel("done")
+
+	_, err := starlark.ExecFile(child, "spawn.star", "x = 1", nil)
+	var cancelErr *starlark.CancellationError
+	if !errors.As(err, &cancelErr) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if cancelErr.Kind != starlark.CancelExplicit {
+		t.Errorf("unexpected cancellation kind: %v", cancelErr.Kind)
+	}
+}
+
+func TestSpawnConcurrent(t *testing.T) {
+	const maxSteps = 10000
+	thread := &starlark.Thread{}
+	thread.SetMaxSteps(maxSteps)
+
+	const src = `
+def loop():
+	for i in range(1000000):
+		pass
+loop()
+`
+	const children = 4
+	var wg sync.WaitGroup
+	errs := make([]error, children)
+	for i := 0; i < children; i++ {
+		child := thread.Spawn("child")
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = starlark.ExecFile(child, "loop.star", src, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, starlark.ErrSafety) {
+			t.Errorf("child %d: expected safety error, got %v", i, err)
+		}
+	}
+	if err := thread.AddSteps(starlark.SafeInt(0)); !errors.Is(err, starlark.ErrSafety) {
+		t.Errorf("parent: expected safety error, got %v", err)
+	}
+}
+
+func TestSpawnProfiledParent(t *testing.T) {
+	// Children must not sample the stack of a profiled parent while it
+	// executes, which the race detector would report.
+	thread := &starlark.Thread{}
+	if err := thread.StartStepProfile(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	thread.EnableAllocProfile()
+
+	const children = 4
+	var wg sync.WaitGroup
+	errs := make([]error, children)
+	spawn := starlark.NewBuiltin("spawn", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		for i := 0; i < children; i++ {
+			child := thread.Spawn("child")
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 1000 && errs[i] == nil; j++ {
+					if errs[i] = child.AddSteps(starlark.SafeInt(1000)); errs[i] == nil {
+						errs[i] = child.AddAllocs(starlark.SafeInt(16))
+					}
+					if errs[i] == nil {
+						errs[i] = child.ReleaseAllocs(starlark.SafeInt(16))
+					}
+				}
+			}(i)
+		}
+		return starlark.None, nil
+	})
+	join := starlark.NewBuiltin("join", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		wg.Wait()
+		return starlark.None, nil
+	})
+
+	const src = `
+def work():
+	x = []
+	for i in range(10000):
+		x.append([i])
+spawn()
+work()
+join()
+`
+	predeclared := starlark.StringDict{"spawn": spawn, "join": join}
+	if _, err := starlark.ExecFile(thread, "profiled.star", src, predeclared); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.StopStepProfile(); err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("child %d: unexpected error: %v", i, err)
+		}
+	}
+	if steps, _ := thread.Steps(); steps < children*1000*1000 {
+		t.Errorf("children's steps not counted by parent: got %d", steps)
+	}
+}
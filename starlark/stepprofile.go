@@ -0,0 +1,77 @@
+package starlark
+
+import (
+	"fmt"
+	"io"
+)
+
+// stepQuantum is the number of steps counted between samples of the call
+// stack taken by the step profiler.
+const stepQuantum = 10
+
+// A stepProfile accumulates the steps counted by a thread and samples its
+// call stack once per stepQuantum steps.
+type stepProfile struct {
+	pw      *pprofWriter
+	pending int64
+	stack   []profFrame
+}
+
+// StartStepProfile enables profiling of the steps counted by the thread and
+// writes a profile in pprof format to w, so that the Starlark functions
+// which consume the thread's step budget can be found using standard tools
+// such as go tool pprof. The thread's call stack is sampled each time a
+// fixed number of steps has been counted. It must be followed by a call to
+// StopStepProfile to finalize the profile.
+//
+// StartStepProfile returns an error if step profiling was already enabled.
+// It must not be called concurrently with execution on this thread.
+func (thread *Thread) StartStepProfile(w io.Writer) error {
+	thread.stepsLock.Lock()
+	defer thread.stepsLock.Unlock()
+
+	if thread.stepProfile != nil {
+		return fmt.Errorf("step profiler already running")
+	}
+	thread.stepProfile = &stepProfile{
+		pw: newPprofWriter(w, "steps", "count", stepQuantum),
+	}
+	return nil
+}
+
+// StopStepProfile stops the step profiler started by a prior call to
+// StartStepProfile and finalizes the profile. It returns an error if the
+// profile could not be completed.
+func (thread *Thread) StopStepProfile() error {
+	thread.stepsLock.Lock()
+	defer thread.stepsLock.Unlock()
+
+	if thread.stepProfile == nil {
+		return fmt.Errorf("step profiler not running")
+	}
+	err := thread.stepProfile.pw.close()
+	thread.stepProfile = nil
+	return err
+}
+
+// recordSteps adds delta to the steps accumulated by the step profiler,
+// sampling the call stack if a quantum has been reached. The caller must
+// hold stepsLock.
+func (thread *Thread) recordSteps(delta SafeInteger) {
+	prof := thread.stepProfile
+	delta64, ok := delta.Int64()
+	if !ok {
+		return
+	}
+	prof.pending += delta64
+	if prof.pending < stepQuantum {
+		return
+	}
+
+	// Only record complete quanta.
+	n := prof.pending / stepQuantum
+	prof.pending -= n * stepQuantum
+
+	prof.stack = thread.appendProfStack(prof.stack[:0])
+	prof.pw.sample(n*stepQuantum, prof.stack)
+}
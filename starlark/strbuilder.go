@@ -0,0 +1,92 @@
+package starlark
+
+import "fmt"
+
+// A strBuilder is a mutable buffer used to build a string piece by piece.
+// Unlike repeated concatenation, appending to a strBuilder copies only the
+// appended bytes, and its memory is declared as its capacity grows, so its
+// cost is amortized over the appends which cause that growth.
+type strBuilder struct {
+	buf    SafeStringBuilder
+	frozen bool
+}
+
+var _ HasSafeAttrs = (*strBuilder)(nil)
+
+// strbuilder returns a new, empty strBuilder.
+func strbuilder(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if err := thread.AddAllocs(EstimateSize(&strBuilder{})); err != nil {
+		return nil, err
+	}
+	return &strBuilder{}, nil
+}
+
+func (sb *strBuilder) String() string        { return "<strbuilder>" }
+func (sb *strBuilder) Type() string          { return "strbuilder" }
+func (sb *strBuilder) Freeze()               { sb.frozen = true }
+func (sb *strBuilder) Truth() Bool           { return sb.buf.Len() > 0 }
+func (sb *strBuilder) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: strbuilder") }
+
+func (sb *strBuilder) SafeString(thread *Thread, out StringBuilder) error {
+	const safety = CPUSafe | MemSafe | TimeSafe | IOSafe
+	if err := CheckSafety(thread, safety); err != nil {
+		return err
+	}
+	_, err := out.WriteString(sb.String())
+	return err
+}
+
+func (sb *strBuilder) Attr(name string) (Value, error) {
+	return builtinAttr(sb, name, strbuilderMethods)
+}
+func (sb *strBuilder) AttrNames() []string { return builtinAttrNames(strbuilderMethods) }
+
+func (sb *strBuilder) SafeAttr(thread *Thread, name string) (Value, error) {
+	return safeBuiltinAttr(thread, sb, name, strbuilderMethods)
+}
+
+// writeString appends s to the buffer. The thread is charged a step per
+// byte appended and for any growth of the buffer's capacity.
+func (sb *strBuilder) writeString(thread *Thread, s string) error {
+	// The buffer outlives the call, so it must only refer to the thread
+	// while it is being written. Any previous error has already been
+	// reported and left the buffer unchanged.
+	sb.buf.thread = thread
+	sb.buf.err = nil
+	defer func() { sb.buf.thread = nil }()
+
+	_, err := sb.buf.WriteString(s)
+	return err
+}
+
+// strbuilder_append appends a string to the buffer.
+func strbuilder_append(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	var s string
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	recv := b.Receiver().(*strBuilder)
+	if recv.frozen {
+		return nil, nameErr(b, "cannot append to frozen strbuilder")
+	}
+	if err := recv.writeString(thread, s); err != nil {
+		return nil, err
+	}
+	return None, nil
+}
+
+// strbuilder_build returns the contents of the buffer as a string.
+func strbuilder_build(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	// The result shares the memory of the buffer, which is already
+	// accounted for, and which later appends never overwrite.
+	if err := thread.AddAllocs(StringTypeOverhead); err != nil {
+		return nil, err
+	}
+	return String(b.Receiver().(*strBuilder).buf.String()), nil
+}
@@ -74,6 +74,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 
 	"github.com/canonical/starlark/internal/compile"
@@ -380,6 +381,13 @@ type HasBinary interface {
 	Binary(op syntax.Token, y Value, side Side) (Value, error)
 }
 
+// A HasSafeBinary value is a HasBinary value whose binary operations
+// respect safety. SafeBinary is called in preference to Binary.
+type HasSafeBinary interface {
+	HasBinary
+	SafeBinary(thread *Thread, op syntax.Token, y Value, side Side) (Value, error)
+}
+
 type Side bool
 
 const (
@@ -705,18 +713,13 @@ func (s String) Freeze()               {} // immutable
 func (s String) Truth() Bool           { return len(s) > 0 }
 func (s String) Hash() (uint32, error) { return hashString(string(s)), nil }
 func (s String) Len() int              { return len(s) } // bytes
-func (s String) Index(i int) Value     { return s[i : i+1] }
+func (s String) Index(i int) Value     { return byteString(s[i]) }
 func (s String) SafeIndex(thread *Thread, i int) (Value, error) {
 	const safety = CPUSafe | MemSafe | TimeSafe | IOSafe
 	if err := CheckSafety(thread, safety); err != nil {
 		return nil, err
 	}
-	if thread != nil {
-		if err := thread.AddAllocs(StringTypeOverhead); err != nil {
-			return nil, err
-		}
-	}
-	return s[i : i+1], nil
+	return byteString(s[i]), nil
 }
 
 func (s String) Slice(start, end, step int) Value {
@@ -785,11 +788,9 @@ func (si stringElems) Iterate() Iterator     { return &stringElemsIterator{si: s
 func (si stringElems) Len() int              { return len(si.s) }
 func (si stringElems) Index(i int) Value {
 	if si.ords {
-		return MakeInt(int(si.s[i]))
+		return byteInt(si.s[i])
 	} else {
-		// TODO(adonovan): opt: preallocate canonical 1-byte strings
-		// to avoid interface allocation.
-		return si.s[i : i+1]
+		return byteString(si.s[i])
 	}
 }
 func (si stringElems) SafeIndex(thread *Thread, i int) (Value, error) {
@@ -797,22 +798,7 @@ func (si stringElems) SafeIndex(thread *Thread, i int) (Value, error) {
 	if err := CheckSafety(thread, safety); err != nil {
 		return nil, err
 	}
-	if si.ords {
-		result := Value(MakeInt(int(si.s[i])))
-		if thread != nil {
-			if err := thread.AddAllocs(EstimateSize(result)); err != nil {
-				return nil, err
-			}
-		}
-		return result, nil
-	} else {
-		if thread != nil {
-			if err := thread.AddAllocs(StringTypeOverhead); err != nil {
-				return nil, err
-			}
-		}
-		return si.s[i : i+1], nil
-	}
+	return si.Index(i), nil
 }
 
 type stringElemsIterator struct {
@@ -904,21 +890,29 @@ func (it *stringCodepointsIterator) Next(p *Value) bool {
 	}
 	r, sz := utf8.DecodeRuneInString(string(s))
 	if !it.si.ords {
-		if err := it.thread.AddAllocs(StringTypeOverhead); err != nil {
-			it.err = err
-			return false
-		}
-		if r == utf8.RuneError {
-			*p = String(r)
+		if r < utf8.RuneSelf {
+			*p = byteString(byte(r))
 		} else {
-			*p = s[:sz]
+			if err := it.thread.AddAllocs(StringTypeOverhead); err != nil {
+				it.err = err
+				return false
+			}
+			if r == utf8.RuneError {
+				*p = String(r)
+			} else {
+				*p = s[:sz]
+			}
 		}
 	} else {
-		if err := it.thread.AddAllocs(runeSize); err != nil {
-			it.err = err
-			return false
+		if v, ok := smallInt(int(r)); ok {
+			*p = v
+		} else {
+			if err := it.thread.AddAllocs(runeSize); err != nil {
+				it.err = err
+				return false
+			}
+			*p = MakeInt(int(r))
 		}
-		*p = MakeInt(int(r))
 	}
 	it.i += sz
 	return true
@@ -950,6 +944,11 @@ type module struct {
 	predeclared StringDict
 	globals     []Value
 	constants   []Value
+
+	// inferredSafety caches, for each *compile.Funcode entered from the
+	// host, the SafetyFlags inferred from the callables it references.
+	// See Thread.checkInferredSafety.
+	inferredSafety sync.Map
 }
 
 // makeGlobalDict returns a new, unfrozen StringDict containing all global
@@ -1049,7 +1048,9 @@ type Builtin struct {
 	fn   func(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	recv Value // for bound methods (e.g. "".startswith)
 
-	safety SafetyFlags
+	safety    SafetyFlags
+	maxAllocs int64
+	cost      *CostSpec
 }
 
 func (b *Builtin) Name() string { return b.name }
@@ -1078,13 +1079,52 @@ func (b *Builtin) String() string  { return toString(b) }
 func (b *Builtin) Receiver() Value { return b.recv }
 func (b *Builtin) Type() string    { return "builtin_function_or_method" }
 func (b *Builtin) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
+	if thread != nil && thread.builtinCosts != nil {
+		if err := thread.addBuiltinCost(b); err != nil {
+			return nil, err
+		}
+	}
+	if thread != nil && thread.allocAudit != nil && thread.allocAudit.sample() {
+		return thread.allocAudit.call(thread, b, args, kwargs)
+	}
+	return b.callWithBudget(thread, args, kwargs)
+}
+
+// callWithBudget calls the builtin, enforcing any limit set by SetMaxAllocs.
+func (b *Builtin) callWithBudget(thread *Thread, args Tuple, kwargs []Tuple) (result Value, err error) {
+	if b.maxAllocs <= 0 || thread == nil {
+		return b.fn(thread, b, args, kwargs)
+	}
+
+	// Pop the budget even if the builtin panics, so that the thread
+	// remains usable.
+	budget := thread.pushAllocBudget(b.maxAllocs)
+	defer func() {
+		if budgetErr := thread.popAllocBudget(budget); budgetErr != nil && err == nil {
+			result, err = nil, budgetErr
+		}
+	}()
 	return b.fn(thread, b, args, kwargs)
 }
+
 func (b *Builtin) Truth() Bool { return true }
 
 func (b *Builtin) Safety() SafetyFlags              { return b.safety }
 func (b *Builtin) DeclareSafety(safety SafetyFlags) { b.safety = safety }
 
+// SetMaxAllocs sets the maximum allocations which may be reported to the
+// thread during a single call to this builtin, including those made by any
+// functions it calls. Once the limit is exceeded, AddAllocs returns an error
+// and, if the builtin does not itself fail, its call fails. Unlike exceeding
+// the thread's own limit, this does not cancel the thread. If max is zero or
+// negative, the builtin is constrained only by the thread's limit.
+//
+// Methods obtained from a builtin by BindReceiver share its limit.
+func (b *Builtin) SetMaxAllocs(max int64) { b.maxAllocs = max }
+
+// MaxAllocs returns the limit set by SetMaxAllocs.
+func (b *Builtin) MaxAllocs() int64 { return b.maxAllocs }
+
 // NewBuiltin returns a new 'builtin_function_or_method' value with the specified name
 // and implementation.  It compares unequal with all other values.
 func NewBuiltin(name string, fn func(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)) *Builtin {
@@ -1102,6 +1142,48 @@ func NewBuiltinWithSafety(name string, safety SafetyFlags, fn func(*Thread, *Bui
 	return &Builtin{name: name, fn: fn, safety: safety}
 }
 
+// WrapWithSafety returns a new builtin with the same name and behaviour as
+// b, declaring the given safety, which reports the memory used by each call
+// on b's behalf. This allows existing builtins, which know nothing of
+// resource accounting, to be used by threads which require MemSafe without
+// being rewritten.
+//
+// Before each call, prealloc bytes are reported to the thread, so that a
+// call which would exceed the thread's limit fails before it begins. This
+// should cover the memory which b retains independently of its result.
+// After each successful call, the size of the result as estimated by sizer
+// is also reported; if sizer is nil, EstimateSize is used. The caller is
+// responsible for the accuracy of these estimates and of the other flags
+// declared.
+//
+// The new builtin shares any limit set on b by SetMaxAllocs.
+func WrapWithSafety(b *Builtin, safety SafetyFlags, prealloc uintptr, sizer Sizer) *Builtin {
+	if sizer == nil {
+		sizer = func(result interface{}) SafeInteger { return EstimateSize(result) }
+	}
+	fn := func(thread *Thread, w *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+		inner := b
+		if w.recv != b.recv {
+			inner = b.BindReceiver(w.recv)
+		}
+		if thread == nil {
+			return b.fn(thread, inner, args, kwargs)
+		}
+		if err := thread.AddAllocs(SafeInt(prealloc)); err != nil {
+			return nil, err
+		}
+		result, err := b.fn(thread, inner, args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+		if err := thread.AddAllocs(sizer(result)); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return &Builtin{name: b.name, fn: fn, recv: b.recv, safety: safety, maxAllocs: b.maxAllocs, cost: b.cost}
+}
+
 // BindReceiver returns a new Builtin value representing a method
 // closure, that is, a built-in function bound to a receiver value.
 //
@@ -1115,7 +1197,7 @@ func NewBuiltinWithSafety(name string, safety SafetyFlags, fn func(*Thread, *Bui
 //
 //	"abc".index("a")
 func (b *Builtin) BindReceiver(recv Value) *Builtin {
-	return &Builtin{name: b.name, fn: b.fn, recv: recv, safety: b.safety}
+	return &Builtin{name: b.name, fn: b.fn, recv: recv, safety: b.safety, maxAllocs: b.maxAllocs, cost: b.cost}
 }
 
 // A *Dict represents a Starlark dictionary.
@@ -1152,6 +1234,70 @@ func SafeNewDict(thread *Thread, size int) (*Dict, error) {
 	return dict, nil
 }
 
+// NewDictWithBudget returns a dict with space for at least size entries
+// before rehashing, whose memory is drawn from the thread's budget. The
+// memory of the dict and its table is declared before it is allocated, so
+// if size exceeds the thread's collection length limit or the table would
+// exceed its allocation limit, an error is returned and nothing is
+// allocated.
+func NewDictWithBudget(thread *Thread, size int) (*Dict, error) {
+	if err := budgetHashtable(thread, size, EstimateSize(&Dict{})); err != nil {
+		return nil, err
+	}
+	dict := new(Dict)
+	dict.ht.init(nil, size)
+	return dict, nil
+}
+
+// budgetHashtable declares to thread the memory of a value of the given
+// size, with a hashtable initialized to hold size entries.
+func budgetHashtable(thread *Thread, size int, valueSize SafeInteger) error {
+	if size < 0 {
+		return fmt.Errorf("negative size (%d)", size)
+	}
+	if err := thread.CheckCollectionLen(size); err != nil {
+		return err
+	}
+	tableSize, err := tableSize(size)
+	if err != nil {
+		return err
+	}
+	if err := thread.AddSteps(SafeInt(size)); err != nil {
+		return err
+	}
+	return thread.AddAllocs(SafeAdd(valueSize, tableSize))
+}
+
+// Cap returns the number of entries the dict may hold before its table
+// must grow.
+func (d *Dict) Cap() int { return d.ht.capacity() }
+
+// LoadFactor returns the mean number of entries in each bucket of the
+// dict's table, or zero if no table has been allocated.
+func (d *Dict) LoadFactor() float64 { return d.ht.loadFactor() }
+
+// maxDictReserve bounds the number of entries for which a dict is presized
+// from the length of its source. As the source may contain repeated keys,
+// its length is only an upper bound on the size of the dict, and reserving
+// much more space than will be used could exceed the thread's allocation
+// budget.
+const maxDictReserve = 256
+
+// reserve presizes the newly created dict d to hold size entries, up to
+// maxDictReserve, without rehashing.
+func (d *Dict) reserve(thread *Thread, size int) error {
+	if d.ht.table != nil {
+		return nil
+	}
+	if size > maxDictReserve {
+		size = maxDictReserve
+	}
+	if err := thread.AddSteps(SafeInt(size)); err != nil {
+		return err
+	}
+	return d.ht.init(thread, size)
+}
+
 func (d *Dict) Clear() error                                    { return d.ht.clear(nil) }
 func (d *Dict) Delete(k Value) (v Value, found bool, err error) { return d.ht.delete(nil, k) }
 func (d *Dict) Get(k Value) (v Value, found bool, err error)    { return d.ht.lookup(nil, k) }
@@ -1255,7 +1401,12 @@ func dictsEqual(x, y *Dict, depth int) (bool, error) {
 type List struct {
 	elems     []Value
 	frozen    bool
-	itercount uint32 // number of active iterators (ignored if frozen)
+	itercount uint32      // number of active iterators (ignored if frozen)
+	frozenAt  *freezeSite // where the list was frozen, if recorded
+
+	// shared records that elems is shared with a frozen list or a tuple,
+	// so must be copied by unshare before it is modified in place.
+	shared bool
 }
 
 // NewList returns a list containing the specified elements.
@@ -1265,6 +1416,7 @@ func NewList(elems []Value) *List { return &List{elems: elems} }
 func (l *List) Freeze() {
 	if !l.frozen {
 		l.frozen = true
+		l.frozenAt = newFreezeSite()
 		for _, elem := range l.elems {
 			elem.Freeze()
 		}
@@ -1275,7 +1427,7 @@ func (l *List) Freeze() {
 // verb+" list" should describe the operation.
 func (l *List) checkMutable(verb string) error {
 	if l.frozen {
-		return fmt.Errorf("cannot %s frozen list", verb)
+		return frozenError(verb, "list", l.frozenAt)
 	}
 	if l.itercount > 0 {
 		return fmt.Errorf("cannot %s list during iteration", verb)
@@ -1283,6 +1435,51 @@ func (l *List) checkMutable(verb string) error {
 	return nil
 }
 
+// newSharedList returns a new list with the given elements, which belong
+// to a frozen list or a tuple. Rather than copying them, the list shares
+// them until it is first modified, so that copying a frozen structure
+// costs only the list itself.
+func newSharedList(elems []Value) *List {
+	if len(elems) == 0 {
+		return &List{}
+	}
+	return &List{elems: elems[:len(elems):len(elems)], shared: true}
+}
+
+// immutableElems returns the elements of x if it is a frozen list or a
+// tuple, which may therefore be shared.
+func immutableElems(x Value) ([]Value, bool) {
+	switch x := x.(type) {
+	case *List:
+		if x.frozen {
+			return x.elems, true
+		}
+	case Tuple:
+		return x, true
+	}
+	return nil, false
+}
+
+// unshare gives the list its own copy of any elements shared by
+// newSharedList, reporting the copy to thread. It must be called after
+// checkMutable and before the elements are modified in place.
+func (l *List) unshare(thread *Thread) error {
+	if !l.shared {
+		return nil
+	}
+	if thread != nil {
+		if err := thread.AddSteps(SafeInt(len(l.elems))); err != nil {
+			return err
+		}
+		if err := thread.AddAllocs(EstimateMakeSize([]Value{}, SafeInt(len(l.elems)))); err != nil {
+			return err
+		}
+	}
+	l.elems = append([]Value(nil), l.elems...)
+	l.shared = false
+	return nil
+}
+
 func (l *List) SafeString(thread *Thread, sb StringBuilder) error {
 	return writeValue(thread, sb, l, nil)
 }
@@ -1390,6 +1587,9 @@ func (l *List) SetIndex(i int, v Value) error {
 	if err := l.checkMutable("assign to element of"); err != nil {
 		return err
 	}
+	if err := l.unshare(nil); err != nil {
+		return err
+	}
 	l.elems[i] = v
 	return nil
 }
@@ -1399,6 +1599,12 @@ func (l *List) SafeSetIndex(thread *Thread, i int, v Value) error {
 	if err := CheckSafety(thread, safety); err != nil {
 		return err
 	}
+	if err := l.checkMutable("assign to element of"); err != nil {
+		return err
+	}
+	if err := l.unshare(thread); err != nil {
+		return err
+	}
 	return l.SetIndex(i, v)
 }
 
@@ -1406,14 +1612,57 @@ func (l *List) Append(v Value) error {
 	if err := l.checkMutable("append to"); err != nil {
 		return err
 	}
+	if err := l.unshare(nil); err != nil {
+		return err
+	}
 	l.elems = append(l.elems, v)
 	return nil
 }
 
+// replaceSlice replaces the elements l[start:end] with values. The caller
+// must check that l is mutable, unshare it, and check that
+// 0 <= start <= end <= l.Len().
+//
+// A step is counted for each element written, including those of the tail of
+// the list which must be moved and those cleared when the list shrinks.
+// Growing the list counts any reallocation; shrinking it releases no memory.
+func (l *List) replaceSlice(thread *Thread, start, end int, values []Value) error {
+	n := len(l.elems)
+	newLen := n - (end - start) + len(values)
+	if err := thread.CheckCollectionLen(newLen); err != nil {
+		return err
+	}
+	steps := SafeAdd(n-end, len(values))
+	if newLen < n {
+		steps = SafeAdd(steps, n-newLen)
+	}
+	if err := thread.AddSteps(steps); err != nil {
+		return err
+	}
+	if growth := newLen - n; growth > 0 {
+		// The appended elements are placeholders, overwritten below.
+		elemsAppender := NewSafeAppender(thread, &l.elems)
+		if err := elemsAppender.AppendSlice(values[len(values)-growth:]); err != nil {
+			return err
+		}
+	}
+	copy(l.elems[start+len(values):], l.elems[end:n])
+	copy(l.elems[start:], values)
+	for i := newLen; i < n; i++ {
+		l.elems[i] = nil // aid GC
+	}
+	l.elems = l.elems[:newLen]
+	return nil
+}
+
 func (l *List) Clear() error {
 	if err := l.checkMutable("clear"); err != nil {
 		return err
 	}
+	if l.shared {
+		l.elems, l.shared = nil, false
+		return nil
+	}
 	for i := range l.elems {
 		l.elems[i] = nil // aid GC
 	}
@@ -1421,6 +1670,24 @@ func (l *List) Clear() error {
 	return nil
 }
 
+// clear empties the list as Clear does, but drops a large backing array
+// rather than retaining it, crediting its memory back to the thread.
+func (l *List) clear(thread *Thread) error {
+	if thread == nil || l.shared || cap(l.elems) < listReleaseCap {
+		return l.Clear()
+	}
+	if err := l.checkMutable("clear"); err != nil {
+		return err
+	}
+	size := EstimateMakeSize([]Value{}, SafeInt(cap(l.elems)))
+	l.elems = nil
+	return thread.ReleaseAllocs(size)
+}
+
+// listReleaseCap is the capacity above which clearing a list releases its
+// backing array. Smaller arrays are kept for reuse.
+const listReleaseCap = 64
+
 // A Tuple represents a Starlark tuple value.
 type Tuple []Value
 
@@ -1521,6 +1788,26 @@ func NewSet(size int) *Set {
 	return set
 }
 
+// NewSetWithBudget returns a set with space for at least size elements
+// before rehashing, whose memory is drawn from the thread's budget. As with
+// NewDictWithBudget, the memory is declared before it is allocated.
+func NewSetWithBudget(thread *Thread, size int) (*Set, error) {
+	if err := budgetHashtable(thread, size, EstimateSize(&Set{})); err != nil {
+		return nil, err
+	}
+	set := new(Set)
+	set.ht.init(nil, size)
+	return set, nil
+}
+
+// Cap returns the number of elements the set may hold before its table
+// must grow.
+func (s *Set) Cap() int { return s.ht.capacity() }
+
+// LoadFactor returns the mean number of elements in each bucket of the
+// set's table, or zero if no table has been allocated.
+func (s *Set) LoadFactor() float64 { return s.ht.loadFactor() }
+
 func (s *Set) Delete(k Value) (found bool, err error) { _, found, err = s.ht.delete(nil, k); return }
 func (s *Set) Clear() error                           { return s.ht.clear(nil) }
 func (s *Set) Has(k Value) (found bool, err error)    { _, found, err = s.ht.lookup(nil, k); return }
@@ -1779,6 +2066,12 @@ func safeToString(thread *Thread, v Value) (string, error) {
 	return buf.String(), nil
 }
 
+// SafeWriteValue writes the string representation of x to out, respecting
+// safety.
+func SafeWriteValue(thread *Thread, out StringBuilder, x Value) error {
+	return writeValue(thread, out, x, nil)
+}
+
 // writeValue writes x to out.
 //
 // path is used to detect cycles.
@@ -2238,30 +2531,97 @@ func (gi *guardedIterator) Safety() SafetyFlags {
 }
 func (gi *guardedIterator) BindThread(thread *Thread) { gi.thread = thread }
 
+// legacyIterator adapts an Iterator which is not safety-aware into a
+// SafeIterator with a declared safety.
+type legacyIterator struct {
+	iter   Iterator
+	safety SafetyFlags
+	thread *Thread
+	err    error
+}
+
+var _ SafeIterator = &legacyIterator{}
+
+// NewSafeIterator returns a SafeIterator which adapts iter, an Iterator
+// which is not safety-aware, declaring it to have the given safety.
+//
+// Once bound to a thread, each call to Next charges one step before the
+// underlying iterator is advanced, failing if the thread has been cancelled,
+// and charges the estimated size of the element produced as allocations.
+// This is conservative for iterators which produce existing values.
+func NewSafeIterator(iter Iterator, safety SafetyFlags) SafeIterator {
+	return &legacyIterator{iter: iter, safety: safety}
+}
+
+func (li *legacyIterator) Next(p *Value) bool {
+	if li.err != nil {
+		return false
+	}
+	if li.thread == nil {
+		return li.iter.Next(p)
+	}
+
+	if err := li.thread.AddSteps(SafeInt(1)); err != nil {
+		li.err = err
+		return false
+	}
+	var v Value
+	if !li.iter.Next(&v) {
+		return false
+	}
+	if err := li.thread.AddAllocs(EstimateSize(v)); err != nil {
+		li.err = err
+		return false
+	}
+	*p = v
+	return true
+}
+
+func (li *legacyIterator) Done() { li.iter.Done() }
+func (li *legacyIterator) Err() error {
+	if li.err != nil {
+		return li.err
+	}
+	return li.iter.Err()
+}
+func (li *legacyIterator) Safety() SafetyFlags       { return li.safety }
+func (li *legacyIterator) BindThread(thread *Thread) { li.thread = thread }
+
 // SafeIterate creates an iterator which is bound then to the given
 // thread. This iterator will check safety and respect sandboxing
 // bounds as required. As a convenience for functions that may have
 // a thread or not depending on external logic, if thread is nil
 // the iterator is still returned without its safety being checked.
+//
+// If x declares its safety by implementing SafetyAware but its
+// iterator is not a SafeIterator, the iterator is adapted as if by
+// NewSafeIterator.
 func SafeIterate(thread *Thread, x Value) (Iterator, error) {
 	if x, ok := x.(Iterable); ok {
 		iter := x.Iterate()
 
 		if thread != nil {
+			if _, ok := iter.(SafeIterator); !ok {
+				if safetyAware, ok := x.(SafetyAware); ok {
+					iter = NewSafeIterator(iter, safetyAware.Safety())
+				}
+			}
 			if safeIter, ok := iter.(SafeIterator); ok {
 				safeIter.BindThread(thread)
 				if err := thread.CheckPermits(safeIter); err != nil {
 					return nil, err
 				}
-				if !thread.Permits(NotSafe) {
+				if _, ok := safeIter.(*legacyIterator); !ok && !thread.Permits(NotSafe) {
 					safeIter = &guardedIterator{iter: safeIter}
 					safeIter.BindThread(thread)
 				}
-				return safeIter, nil
-			}
-			if err := thread.CheckPermits(NotSafe); err != nil {
+				iter = safeIter
+			} else if err := thread.CheckPermits(NotSafe); err != nil {
 				return nil, err
 			}
+			if thread.checkIterators != nil {
+				iter = thread.checkIterator(x, iter)
+			}
 		}
 
 		return iter, nil
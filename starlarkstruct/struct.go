@@ -164,8 +164,8 @@ type entry struct {
 }
 
 var (
-	_ starlark.HasSafeAttrs = (*Struct)(nil)
-	_ starlark.HasBinary    = (*Struct)(nil)
+	_ starlark.HasSafeAttrs  = (*Struct)(nil)
+	_ starlark.HasSafeBinary = (*Struct)(nil)
 )
 
 // ToStringDict adds a name/value entry to d for each field of the struct.
@@ -188,7 +188,7 @@ func (s *Struct) SafeString(thread *starlark.Thread, sb starlark.StringBuilder)
 			return err
 		}
 	default:
-		if _, err := sb.WriteString(s.constructor.String()); err != nil {
+		if err := starlark.SafeWriteValue(thread, sb, s.constructor); err != nil {
 			return err
 		}
 	}
@@ -207,7 +207,7 @@ func (s *Struct) SafeString(thread *starlark.Thread, sb starlark.StringBuilder)
 		if _, err := sb.WriteString(" = "); err != nil {
 			return err
 		}
-		if _, err := sb.WriteString(e.value.String()); err != nil {
+		if err := starlark.SafeWriteValue(thread, sb, e.value); err != nil {
 			return err
 		}
 	}
@@ -247,6 +247,14 @@ func (s *Struct) Freeze() {
 }
 
 func (x *Struct) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	return x.SafeBinary(nil, op, y, side)
+}
+
+func (x *Struct) SafeBinary(thread *starlark.Thread, op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	const safety = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+	if err := starlark.CheckSafety(thread, safety); err != nil {
+		return nil, err
+	}
 	if y, ok := y.(*Struct); ok && op == syntax.PLUS {
 		if side == starlark.Right {
 			x, y = y, x
@@ -260,15 +268,43 @@ func (x *Struct) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (
 				x.constructor, y.constructor)
 		}
 
-		z := make(starlark.StringDict, x.len()+y.len())
-		for _, e := range x.entries {
-			z[e.name] = e.value
-		}
-		for _, e := range y.entries {
-			z[e.name] = e.value
+		n := starlark.SafeAdd(x.len(), y.len())
+		if thread != nil {
+			if err := thread.AddSteps(n); err != nil {
+				return nil, err
+			}
+			resultSize := starlark.SafeAdd(
+				starlark.EstimateSize(&Struct{}),
+				starlark.EstimateMakeSize(entries{}, n),
+			)
+			if err := thread.AddAllocs(resultSize); err != nil {
+				return nil, err
+			}
 		}
 
-		return FromStringDict(x.constructor, z), nil
+		// Merge the sorted entries, preferring those of y.
+		z := &Struct{
+			constructor: x.constructor,
+			entries:     make(entries, 0, x.len()+y.len()),
+		}
+		i, j := 0, 0
+		for i < x.len() && j < y.len() {
+			switch xe, ye := x.entries[i], y.entries[j]; {
+			case xe.name < ye.name:
+				z.entries = append(z.entries, xe)
+				i++
+			case xe.name > ye.name:
+				z.entries = append(z.entries, ye)
+				j++
+			default:
+				z.entries = append(z.entries, ye)
+				i++
+				j++
+			}
+		}
+		z.entries = append(z.entries, x.entries[i:]...)
+		z.entries = append(z.entries, y.entries[j:]...)
+		return z, nil
 	}
 	return nil, nil // unhandled
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/canonical/starlark/starlarkstruct"
 	"github.com/canonical/starlark/starlarktest"
 	"github.com/canonical/starlark/startest"
+	"github.com/canonical/starlark/syntax"
 )
 
 func isStarlarkCancellation(err error) bool {
@@ -295,3 +296,74 @@ func TestStructResources(t *testing.T) {
 		st.KeepAlive(result)
 	})
 }
+
+func TestStructSafeBinary(t *testing.T) {
+	t.Run("nil-thread", func(t *testing.T) {
+		defer func() {
+			if err := recover(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+
+		struct_ := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{"foo": starlark.None})
+		if _, err := struct_.SafeBinary(nil, syntax.PLUS, struct_, starlark.Left); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("consistency", func(t *testing.T) {
+		x := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"a": starlark.MakeInt(1),
+			"b": starlark.MakeInt(2),
+		})
+		y := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"b": starlark.MakeInt(3),
+			"c": starlark.MakeInt(4),
+		})
+		thread := &starlark.Thread{}
+		safeResult, err := x.SafeBinary(thread, syntax.PLUS, y, starlark.Left)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsafeResult, err := x.Binary(syntax.PLUS, y, starlark.Left)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, err := starlark.Equal(safeResult, unsafeResult); err != nil {
+			t.Error(err)
+		} else if !eq {
+			t.Errorf("inconsistent SafeBinary implementation: expected %v and %v to be equal", safeResult, unsafeResult)
+		}
+		if s := safeResult.String(); s != "struct(a = 1, b = 3, c = 4)" {
+			t.Errorf("incorrect result: %s", s)
+		}
+	})
+
+	t.Run("resources", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMinSteps(3)
+		st.SetMaxSteps(3)
+		st.RunThread(func(thread *starlark.Thread) {
+			d := make(starlark.StringDict, st.N)
+			for i := 0; i < st.N; i++ {
+				key := fmt.Sprintf("%012d", i)
+				if err := thread.AddAllocs(starlark.EstimateSize(key)); err != nil {
+					st.Error(err)
+				}
+				d[key] = starlark.None
+			}
+			struct_, err := starlarkstruct.SafeFromStringDict(thread, starlarkstruct.Default, d)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(struct_)
+
+			result, err := starlark.SafeBinary(thread, syntax.PLUS, struct_, struct_)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		})
+	})
+}
@@ -0,0 +1,120 @@
+package startest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+// concurrentNs holds the values of st.N with which RunConcurrentThreads
+// runs its test body.
+var concurrentNs = []int{1, 16, 256}
+
+// RunConcurrentThreads stress-tests the resource accounting of a function
+// which has access to a Starlark thread. The function is run on k goroutines
+// at once, each with its own thread, all attached to a single shared
+// ResourcePool. Once they have finished, the resources drawn from the pool
+// must equal the sum of those counted by each thread, and each thread must
+// respect the limits set by SetMaxSteps, SetMinSteps and SetMaxAllocs.
+//
+// As memory cannot be measured per goroutine, only declared allocations
+// are checked. The function is called concurrently with itself, so it must
+// not call Fatal, Fatalf or FailNow; st.N must be treated as read-only.
+func (st *ST) RunConcurrentThreads(k int, fn func(*starlark.Thread)) {
+	if k <= 0 {
+		st.Errorf("RunConcurrentThreads: expected positive goroutine count, got %d", k)
+		return
+	}
+	if !st.safetyGiven {
+		st.requiredSafety = stSafe
+	}
+
+	for _, n := range concurrentNs {
+		st.N = n
+		st.alive = nil
+		st.aliveSites = nil
+		if !st.runConcurrently(k, fn) {
+			break
+		}
+	}
+	st.alive = nil
+	st.aliveSites = nil
+}
+
+// runConcurrently runs fn on k threads sharing a pool, reporting whether
+// the accounting checks passed.
+func (st *ST) runConcurrently(k int, fn func(*starlark.Thread)) bool {
+	pool := starlark.NewResourcePool(0, 0)
+	threads := make([]*starlark.Thread, k)
+	for i := range threads {
+		threads[i] = st.newThread()
+		threads[i].Name = fmt.Sprintf("concurrent-%d", i)
+		threads[i].SetResourcePool(pool)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for _, thread := range threads {
+		wg.Add(1)
+		go func(thread *starlark.Thread) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					st.Errorf("%s: panic: %v", thread.Name, r)
+				}
+			}()
+			<-start
+			fn(thread)
+		}(thread)
+	}
+	close(start)
+	wg.Wait()
+	if st.Failed() {
+		return false
+	}
+
+	stepSum, allocSum := starlark.SafeInt(0), starlark.SafeInt(0)
+	for _, thread := range threads {
+		steps, ok := thread.Steps()
+		if !ok {
+			st.Errorf("%s: step counter invalidated", thread.Name)
+			return false
+		}
+		allocs, ok := thread.Allocs()
+		if !ok {
+			st.Errorf("%s: alloc counter invalidated", thread.Name)
+			return false
+		}
+		stepSum = starlark.SafeAdd(stepSum, steps)
+		allocSum = starlark.SafeAdd(allocSum, allocs)
+
+		mean := func(x int64) int64 { return (x + int64(st.N)/2) / int64(st.N) }
+		if meanSteps := mean(steps); st.maxSteps >= 0 && meanSteps > st.maxSteps {
+			st.Errorf("%s: steps are above maximum (%d > %d)", thread.Name, meanSteps, st.maxSteps)
+		} else if meanSteps < st.minSteps {
+			st.Errorf("%s: steps are below minimum (%d < %d)", thread.Name, meanSteps, st.minSteps)
+		}
+		if meanAllocs := mean(allocs); st.requiredSafety.Contains(starlark.MemSafe) && st.maxAllocs >= 0 && meanAllocs > st.maxAllocs {
+			st.Errorf("%s: declared allocations are above maximum (%d > %d)", thread.Name, meanAllocs, st.maxAllocs)
+		}
+	}
+
+	poolSteps, ok := pool.Steps()
+	if !ok {
+		st.Error("pool step counter invalidated")
+		return false
+	}
+	poolAllocs, ok := pool.Allocs()
+	if !ok {
+		st.Error("pool alloc counter invalidated")
+		return false
+	}
+	if sum, _ := stepSum.Int64(); poolSteps != sum {
+		st.Errorf("pool steps differ from the sum over threads (%d != %d) with st.N = %d", poolSteps, sum, st.N)
+	}
+	if sum, _ := allocSum.Int64(); poolAllocs != sum {
+		st.Errorf("pool allocations differ from the sum over threads (%d != %d) with st.N = %d", poolAllocs, sum, st.N)
+	}
+	return !st.Failed()
+}
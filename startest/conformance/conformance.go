@@ -0,0 +1,215 @@
+// Package conformance provides baseline tests for the builtins of Starlark
+// library modules.
+//
+// A Suite checks that each builtin of a module declares the safety listed
+// for it, then calls each builtin declared CPUSafe or MemSafe with a range of
+// representative inputs, checking with startest that the resources it uses
+// are accounted for. Errors returned by a builtin, such as those caused by
+// arguments of the wrong type, are expected and ignored unless they report a
+// safety violation. This gives new modules coverage of their error paths and
+// common cases without writing tests by hand, but does not replace tests of
+// specific behaviour.
+//
+// The same calls may be run as benchmarks, so that changes to the resources
+// used by a module's builtins show up in the output of go test -bench.
+package conformance
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+	"github.com/canonical/starlark/startest"
+)
+
+// A Suite describes the module to be tested.
+type Suite struct {
+	// Module is the module under test.
+	Module *starlarkstruct.Module
+
+	// Safeties maps the name of each builtin of Module to its expected
+	// safety.
+	Safeties map[string]starlark.SafetyFlags
+
+	// Inputs optionally overrides, by builtin name, the arguments with
+	// which builtins are called. An empty list causes only the builtin's
+	// safety declaration to be checked.
+	Inputs map[string][]starlark.Tuple
+}
+
+// DefaultInputs returns the arguments with which builtins are called if no
+// inputs are specified for them. Each call returns fresh, frozen values.
+func DefaultInputs() []starlark.Tuple {
+	inputs := []starlark.Tuple{
+		{},
+		{starlark.None},
+		{starlark.True},
+		{starlark.MakeInt(0)},
+		{starlark.MakeInt(-1)},
+		{starlark.MakeInt64(1 << 62)},
+		{starlark.Float(1.5)},
+		{starlark.String("")},
+		{starlark.String("abc")},
+		{starlark.Bytes("abc")},
+		{starlark.NewList([]starlark.Value{starlark.MakeInt(1), starlark.String("a")})},
+		{starlark.Tuple{starlark.MakeInt(1), starlark.MakeInt(2)}},
+		{newDict(starlark.String("a"), starlark.MakeInt(1))},
+		{starlark.MakeInt(1), starlark.MakeInt(2)},
+		{starlark.String("a"), starlark.String("b")},
+		{starlark.String("a"), starlark.MakeInt(1)},
+	}
+	for _, input := range inputs {
+		input.Freeze()
+	}
+	return inputs
+}
+
+func newDict(key, value starlark.Value) *starlark.Dict {
+	dict := starlark.NewDict(1)
+	dict.SetKey(key, value)
+	return dict
+}
+
+// Run runs the suite as subtests of t.
+func (s *Suite) Run(t *testing.T) {
+	t.Run("safeties", s.testSafeties)
+
+	for _, c := range s.checkedBuiltins() {
+		c := c
+		t.Run(c.builtin.Name(), func(t *testing.T) {
+			for _, args := range c.inputs {
+				args := args
+				t.Run(args.String(), func(t *testing.T) {
+					testCall(t, c.builtin, c.required, args)
+				})
+			}
+		})
+	}
+}
+
+// Benchmark runs a sub-benchmark of b for each call made by Run, reporting
+// the steps, declared allocations and measured memory of each call per op,
+// as startest.ST.RunBenchmark does. Safety declarations are not checked.
+func (s *Suite) Benchmark(b *testing.B) {
+	for _, c := range s.checkedBuiltins() {
+		c := c
+		b.Run(c.builtin.Name(), func(b *testing.B) {
+			for _, args := range c.inputs {
+				args := args
+				b.Run(args.String(), func(b *testing.B) {
+					benchmarkCall(b, c.builtin, c.required, args)
+				})
+			}
+		})
+	}
+}
+
+// A checkedBuiltin is a builtin whose resource use is checked by the suite.
+type checkedBuiltin struct {
+	builtin  *starlark.Builtin
+	required starlark.SafetyFlags
+	inputs   []starlark.Tuple
+}
+
+// checkedBuiltins returns, in order of name, the builtins of the module
+// which are declared CPUSafe or MemSafe and have inputs with which to be
+// called.
+func (s *Suite) checkedBuiltins() []checkedBuiltin {
+	names := make([]string, 0, len(s.Module.Members))
+	for name, value := range s.Module.Members {
+		if _, ok := value.(*starlark.Builtin); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var checked []checkedBuiltin
+	for _, name := range names {
+		builtin := s.Module.Members[name].(*starlark.Builtin)
+		required := builtin.Safety() & (starlark.CPUSafe | starlark.MemSafe)
+		if required == 0 {
+			continue
+		}
+
+		inputs, ok := s.Inputs[name]
+		if !ok {
+			inputs = DefaultInputs()
+		}
+		if len(inputs) == 0 {
+			continue
+		}
+		checked = append(checked, checkedBuiltin{builtin, required, inputs})
+	}
+	return checked
+}
+
+func (s *Suite) testSafeties(t *testing.T) {
+	for name, value := range s.Module.Members {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+
+		if safety, ok := s.Safeties[name]; !ok {
+			t.Errorf("builtin %s.%s has no safety declaration", s.Module.Name, name)
+		} else if actual := builtin.Safety(); actual != safety {
+			t.Errorf("builtin %s.%s has incorrect safety: expected %v but got %v", s.Module.Name, name, safety, actual)
+		}
+	}
+
+	for name := range s.Safeties {
+		if _, ok := s.Module.Members[name]; !ok {
+			t.Errorf("safety declared for non-existent builtin %s.%s", s.Module.Name, name)
+		}
+	}
+}
+
+// maxN bounds the sum of st.N over the repetitions of each call. As a suite
+// makes many calls, each is measured less thoroughly than a hand-written
+// test would be.
+const maxN = 1024
+
+func schedule(prevN int, measured startest.Measured) int {
+	if measured.NSum >= maxN {
+		return 0
+	}
+	if prevN == 0 {
+		return 1
+	}
+	return prevN * 2
+}
+
+// testCall checks that calling builtin with args accounts for the resources
+// it uses.
+func testCall(t *testing.T, builtin *starlark.Builtin, required starlark.SafetyFlags, args starlark.Tuple) {
+	st := startest.From(t)
+	st.RequireSafety(required)
+	st.SetNSchedule(schedule)
+	st.RunThread(func(thread *starlark.Thread) {
+		callN(st, thread, builtin, args)
+	})
+}
+
+// benchmarkCall reports the resources used by calling builtin with args.
+func benchmarkCall(b *testing.B, builtin *starlark.Builtin, required starlark.SafetyFlags, args starlark.Tuple) {
+	st := startest.From(b)
+	st.RequireSafety(required)
+	st.SetNSchedule(schedule)
+	st.RunBenchmark(b, func(thread *starlark.Thread) {
+		callN(st, thread, builtin, args)
+	})
+}
+
+// callN calls builtin with args st.N times, keeping each result alive.
+func callN(st *startest.ST, thread *starlark.Thread, builtin *starlark.Builtin, args starlark.Tuple) {
+	for i := 0; i < st.N; i++ {
+		result, err := starlark.Call(thread, builtin, args, nil)
+		if errors.Is(err, starlark.ErrSafety) {
+			st.Errorf("%s%v: %v", builtin.Name(), args, err)
+			return
+		}
+		st.KeepAlive(result)
+	}
+}
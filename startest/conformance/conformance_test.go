@@ -0,0 +1,69 @@
+package conformance_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/starlark"
+	"github.com/canonical/starlark/starlarkstruct"
+	"github.com/canonical/starlark/startest/conformance"
+)
+
+func TestSuite(t *testing.T) {
+	const safe = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+
+	calls := 0
+	identity := starlark.NewBuiltinWithSafety("identity", safe, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		calls++
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+	unsafe := starlark.NewBuiltin("unsafe", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		t.Error("unsafe builtin called")
+		return starlark.None, nil
+	})
+
+	suite := &conformance.Suite{
+		Module: &starlarkstruct.Module{
+			Name: "test",
+			Members: starlark.StringDict{
+				"identity": identity,
+				"unsafe":   unsafe,
+			},
+		},
+		Safeties: map[string]starlark.SafetyFlags{
+			"identity": safe,
+			"unsafe":   starlark.NotSafe,
+		},
+	}
+	suite.Run(t)
+
+	if calls == 0 {
+		t.Error("safe builtin was not called")
+	}
+}
+
+func BenchmarkSuite(b *testing.B) {
+	const safe = starlark.CPUSafe | starlark.MemSafe | starlark.TimeSafe | starlark.IOSafe
+
+	identity := starlark.NewBuiltinWithSafety("identity", safe, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var x starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+			return nil, err
+		}
+		return x, nil
+	})
+
+	suite := &conformance.Suite{
+		Module: &starlarkstruct.Module{
+			Name:    "test",
+			Members: starlark.StringDict{"identity": identity},
+		},
+		Inputs: map[string][]starlark.Tuple{
+			"identity": {{starlark.String("a")}},
+		},
+	}
+	suite.Benchmark(b)
+}
@@ -0,0 +1,131 @@
+package startest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/canonical/starlark/resolve"
+	"github.com/canonical/starlark/starlark"
+)
+
+// RequireNoGlobalState requires that the tested code neither depends on
+// nor modifies package-level mutable state, such as the deprecated
+// resolver flags of the resolve package or the contents of
+// starlark.Universe. Before its resources are measured, the test is run
+// twice, each time with st.N set to 1 on a fresh thread and, for RunString,
+// in a freshly-compiled module. The test fails if either run changes such
+// state, or if the globals left by the two runs differ.
+func (st *ST) RequireNoGlobalState() {
+	st.requireNoGlobalState = true
+}
+
+// globalState records the package-level mutable state which could leak
+// between tests.
+type globalState struct {
+	flags    map[string]bool
+	universe map[string]string
+}
+
+func captureGlobalState() globalState {
+	state := globalState{
+		flags: map[string]bool{
+			"resolve.AllowSet":            resolve.AllowSet,
+			"resolve.AllowGlobalReassign": resolve.AllowGlobalReassign,
+			"resolve.AllowRecursion":      resolve.AllowRecursion,
+			"resolve.LoadBindsGlobally":   resolve.LoadBindsGlobally,
+		},
+		universe: make(map[string]string, len(starlark.Universe)),
+	}
+	for name, value := range starlark.Universe {
+		state.universe[name] = describeGlobal(value)
+	}
+	return state
+}
+
+// describeGlobal returns a description of value which changes if it is
+// replaced by a different value.
+func describeGlobal(value starlark.Value) string {
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr {
+		return fmt.Sprintf("%s at %#x", value.Type(), v.Pointer())
+	}
+	return fmt.Sprintf("%s %s", value.Type(), value.String())
+}
+
+// diff returns a description of the changes from state to other, or the
+// empty string if there are none.
+func (state globalState) diff(other globalState) string {
+	var changes []string
+	for name, before := range state.flags {
+		if after := other.flags[name]; after != before {
+			changes = append(changes, fmt.Sprintf("%s changed from %t to %t", name, before, after))
+		}
+	}
+	for name, before := range state.universe {
+		if after, ok := other.universe[name]; !ok {
+			changes = append(changes, fmt.Sprintf("Universe[%q] removed", name))
+		} else if after != before {
+			changes = append(changes, fmt.Sprintf("Universe[%q] replaced", name))
+		}
+	}
+	for name := range other.universe {
+		if _, ok := state.universe[name]; !ok {
+			changes = append(changes, fmt.Sprintf("Universe[%q] added", name))
+		}
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, ", ")
+}
+
+// checkNoGlobalState runs fn twice, as described by RequireNoGlobalState,
+// and reports whether the test may continue.
+func (st *ST) checkNoGlobalState(fn func(*starlark.Thread) (starlark.StringDict, error)) bool {
+	if !st.safetyGiven {
+		st.requiredSafety = stSafe
+	}
+	prevN := st.N
+	defer func() { st.N = prevN }()
+
+	var results [2]starlark.StringDict
+	for i := range results {
+		before := captureGlobalState()
+
+		st.alive = make([]interface{}, 0, 1)
+		st.aliveSites = make([]keepAliveSite, 0, 1)
+		st.N = 1
+		thread := st.newThread()
+		globals, err := fn(thread)
+		thread.Cancel("done")
+		st.alive = nil
+		st.aliveSites = nil
+		if err != nil {
+			st.reportCodeError(err)
+			return false
+		}
+		if st.Failed() {
+			return false
+		}
+
+		if diff := before.diff(captureGlobalState()); diff != "" {
+			st.Errorf("run %d modified global state: %s", i+1, diff)
+			return false
+		}
+		results[i] = globals
+	}
+
+	first, second := results[0], results[1]
+	for name, value := range first {
+		if other, ok := second[name]; !ok {
+			st.Errorf("global %s defined only by the first run", name)
+		} else if value.String() != other.String() {
+			st.Errorf("global %s differs between runs: %s != %s", name, value, other)
+		}
+	}
+	for name := range second {
+		if _, ok := first[name]; !ok {
+			st.Errorf("global %s defined only by the second run", name)
+		}
+	}
+	return !st.Failed()
+}
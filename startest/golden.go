@@ -0,0 +1,100 @@
+package startest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// UpdateGoldenEnv is the environment variable which, when set to a non-empty
+// value, causes RecordResources to overwrite golden files with the resources
+// measured instead of comparing against them.
+const UpdateGoldenEnv = "STARTEST_UPDATE_GOLDEN"
+
+// DefaultGoldenTolerance is the relative tolerance used by RecordResources
+// unless another is set with SetGoldenTolerance.
+const DefaultGoldenTolerance = 0.1
+
+// goldenResources is the content of a golden file: the mean resources used
+// per unit of st.N.
+type goldenResources struct {
+	Steps          int64 `json:"steps"`
+	DeclaredAllocs int64 `json:"declared_allocs"`
+	MeasuredAllocs int64 `json:"measured_allocs"`
+}
+
+// RecordResources optionally compares the mean steps, declared allocations
+// and measured memory per unit of st.N against those recorded in the golden
+// file at path. Each must lie within the golden tolerance of its recorded
+// value, or within one unit for small values.
+//
+// If the golden file does not exist, or if the environment variable named by
+// UpdateGoldenEnv is set, the measured resources are written to it instead.
+func (st *ST) RecordResources(path string) {
+	st.goldenPath = path
+}
+
+// SetGoldenTolerance optionally sets the relative tolerance used by
+// RecordResources, for example 0.05 for 5%. The default is
+// DefaultGoldenTolerance.
+func (st *ST) SetGoldenTolerance(tolerance float64) {
+	st.goldenTolerance = &tolerance
+}
+
+// checkGolden implements RecordResources for the given measurements.
+func (st *ST) checkGolden(means resourceMeans) {
+	measured := goldenResources{
+		Steps:          means.steps,
+		DeclaredAllocs: means.declaredAllocs,
+		MeasuredAllocs: means.measuredAllocs,
+	}
+
+	data, err := os.ReadFile(st.goldenPath)
+	if errors.Is(err, fs.ErrNotExist) || os.Getenv(UpdateGoldenEnv) != "" {
+		st.writeGolden(measured)
+		return
+	} else if err != nil {
+		st.Error(err)
+		return
+	}
+	var golden goldenResources
+	if err := json.Unmarshal(data, &golden); err != nil {
+		st.Errorf("cannot parse golden file %s: %v", st.goldenPath, err)
+		return
+	}
+
+	tolerance := DefaultGoldenTolerance
+	if st.goldenTolerance != nil {
+		tolerance = *st.goldenTolerance
+	}
+	check := func(name string, got, want int64) {
+		limit := math.Max(1, tolerance*float64(want))
+		if math.Abs(float64(got-want)) > limit {
+			st.Errorf("%s differ from golden file %s (%d, expected %d)", name, st.goldenPath, got, want)
+		}
+	}
+	check("steps", measured.Steps, golden.Steps)
+	check("declared allocations", measured.DeclaredAllocs, golden.DeclaredAllocs)
+	check("measured memory", measured.MeasuredAllocs, golden.MeasuredAllocs)
+}
+
+func (st *ST) writeGolden(measured goldenResources) {
+	data, err := json.MarshalIndent(measured, "", "\t")
+	if err != nil {
+		st.Error(err)
+		return
+	}
+	data = append(data, '\n')
+	if err := os.MkdirAll(filepath.Dir(st.goldenPath), 0o755); err != nil {
+		st.Error(err)
+		return
+	}
+	if err := os.WriteFile(st.goldenPath, data, 0o644); err != nil {
+		st.Error(err)
+		return
+	}
+	st.Logf("wrote golden file %s", st.goldenPath)
+}
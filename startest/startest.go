@@ -10,17 +10,24 @@
 // terms of this N, so for example, calling SetMaxAllocs(100) on a startest
 // instance will cause it to check that no more than 100 bytes are allocated
 // per given N. Tests are repeated with different values of N to reduce the
-// effect of noise on measurements.
+// effect of noise on measurements. To control the values of N used, use the
+// SetNSchedule method. To exclude one-time costs from measurements, use the
+// SetWarmup method.
 //
 // To create a new startest instance, use From. To test a string of Starlark
 // code, use the instances's RunString method. To directly test Starlark (or
-// something more expressible in Go), use the RunThread method. To simulate the
-// running environment of a Starlark script, use the AddValue, AddBuiltin and
-// AddLocal methods. All safety conditions are required by default; to instead
-// test a specific subset of safety conditions, use the RequireSafety method.
-// To test resource usage, use the SetMaxAllocs method. To count the memory
-// cost of a value in a test, use the KeepAlive method. The Error, Errorf,
-// Fatal, Fatalf, Log and Logf methods are inherited from the test's base.
+// something more expressible in Go), use the RunThread method. To report the
+// resources used by such a test as benchmark metrics, use the RunBenchmark
+// method. To stress-test resource accounting under concurrency, use the
+// RunConcurrentThreads method. To simulate the running environment of a
+// Starlark script, use the AddValue, AddBuiltin and AddLocal methods. All
+// safety conditions are required by default; to instead test a specific subset
+// of safety conditions, use the RequireSafety method. To check that a test is
+// isolated from package-level state, use the RequireNoGlobalState method. To
+// test resource usage, use the SetMaxAllocs method, or record it in a golden
+// file with the RecordResources method. To count the memory cost of a value in
+// a test, use the KeepAlive method. The Error, Errorf, Fatal, Fatalf, Log and
+// Logf methods are inherited from the test's base.
 //
 // When executing Starlark code, the startest instance can be accessed through
 // the global st. To access the exposed N, use st.n. To count the memory cost
@@ -37,7 +44,9 @@ import (
 	"math"
 	"runtime"
 	"runtime/metrics"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -58,16 +67,24 @@ type TestBase interface {
 }
 
 type ST struct {
-	ctx            context.Context
-	maxAllocs      int64
-	maxSteps       int64
-	minSteps       int64
-	alive          []interface{}
-	N              int
-	requiredSafety starlark.SafetyFlags
-	safetyGiven    bool
-	predecls       starlark.StringDict
-	locals         map[string]interface{}
+	ctx                  context.Context
+	maxAllocs            int64
+	maxSteps             int64
+	minSteps             int64
+	linearAllocs         *linearAllocs
+	nSchedule            func(prevN int, measured Measured) int
+	warmup               int
+	goldenPath           string
+	goldenTolerance      *float64
+	aliveMu              sync.Mutex
+	alive                []interface{}
+	aliveSites           []keepAliveSite
+	N                    int
+	requiredSafety       starlark.SafetyFlags
+	safetyGiven          bool
+	requireNoGlobalState bool
+	predecls             starlark.StringDict
+	locals               map[string]interface{}
 	TestBase
 }
 
@@ -112,6 +129,74 @@ func (st *ST) SetMinSteps(minSteps int64) {
 	st.minSteps = minSteps
 }
 
+// Measured describes the resources used by the repetitions of a test so far.
+type Measured struct {
+	// NSum is the sum of st.N over all repetitions.
+	NSum int
+
+	// Allocs is the memory measured over all repetitions, in bytes.
+	Allocs int64
+
+	// Elapsed is the wall-clock time taken by all repetitions.
+	Elapsed time.Duration
+}
+
+// SetNSchedule optionally replaces the heuristic which chooses how st.N grows
+// between repetitions of a test. Before each repetition, schedule is called
+// with the previous value of st.N, which is zero before the first, and the
+// resources measured so far; it returns the next value of st.N. If it returns
+// zero or a negative value, no further repetitions are run, though the test
+// is always run at least once.
+//
+// The limits on the total memory and time used by a test still apply.
+func (st *ST) SetNSchedule(schedule func(prevN int, measured Measured) int) {
+	st.nSchedule = schedule
+}
+
+// SetWarmup optionally sets a number of times to run a test, with st.N = 1,
+// before any measurement is taken. The resources used while warming up are
+// discarded, so one-time costs, such as filling a builtin's lazy cache, do
+// not contribute to the measured averages.
+func (st *ST) SetWarmup(n int) {
+	st.warmup = n
+}
+
+type linearAllocs struct {
+	slope, tolerance float64
+}
+
+// AssertLinearAllocs optionally requires that the memory measured when
+// running a test grows linearly in st.N with the given slope, in bytes per
+// unit of st.N, to within the given tolerance, also in bytes per unit of
+// st.N.
+//
+// The slope is estimated by a least-squares fit of the memory measured
+// against st.N over all repetitions of the test, so a constant overhead
+// (the intercept of the fit) does not affect the result. The fitted slope and
+// intercept are logged.
+func (st *ST) AssertLinearAllocs(slope, tolerance float64) {
+	st.linearAllocs = &linearAllocs{slope, tolerance}
+}
+
+// AssertCost optionally requires that the tested code uses resources as
+// declared by spec, where st.N is the size of its input: a number of
+// elements for steps and a number of bytes for allocations. The mean steps
+// per unit of st.N must lie between the floor and ceiling of
+// spec.StepsPerElement, and the allocation slope fitted as for
+// AssertLinearAllocs must be within 10% or one byte, whichever is greater,
+// of spec.AllocsPerByte.
+//
+// Specs declared by builtins are available from their Cost method.
+func (st *ST) AssertCost(spec starlark.CostSpec) {
+	if spec.StepsPerElement != 0 {
+		st.SetMinSteps(int64(math.Floor(spec.StepsPerElement)))
+		st.SetMaxSteps(int64(math.Ceil(spec.StepsPerElement)))
+	}
+	if spec.AllocsPerByte != 0 {
+		st.AssertLinearAllocs(spec.AllocsPerByte, math.Max(1, spec.AllocsPerByte/10))
+	}
+}
+
 // RequireSafety optionally sets the required safety of tested code.
 func (st *ST) RequireSafety(safety starlark.SafetyFlags) {
 	st.requiredSafety |= safety
@@ -171,6 +256,7 @@ func (st *ST) RunString(code string) (ok bool) {
 
 	options := &syntax.FileOptions{
 		Set:             true,
+		StrBuilder:      true,
 		While:           true,
 		TopLevelControl: true,
 		GlobalReassign:  true,
@@ -192,17 +278,31 @@ func (st *ST) RunString(code string) (ok bool) {
 	st.AddLocal("Reporter", st) // Set starlarktest reporter outside of RunThread
 	st.AddValue("assert", assert)
 
-	_, mod, err := starlark.SourceProgramOptions(options, "startest.RunString", code, func(name string) bool {
+	isPredeclared := func(name string) bool {
 		_, ok := st.predecls[name]
 		return ok
-	})
+	}
+	_, mod, err := starlark.SourceProgramOptions(options, "startest.RunString", code, isPredeclared)
 	if err != nil {
 		st.Error(err)
 		return false
 	}
 
+	if st.requireNoGlobalState {
+		ok := st.checkNoGlobalState(func(thread *starlark.Thread) (starlark.StringDict, error) {
+			_, mod, err := starlark.SourceProgramOptions(options, "startest.RunString", code, isPredeclared)
+			if err != nil {
+				return nil, err
+			}
+			return mod.Init(thread, st.predecls)
+		})
+		if !ok {
+			return false
+		}
+	}
+
 	var codeErr error
-	st.RunThread(func(thread *starlark.Thread) {
+	st.runThread(func(thread *starlark.Thread) {
 		// Continue RunThread's test loop
 		if codeErr != nil {
 			return
@@ -210,31 +310,91 @@ func (st *ST) RunString(code string) (ok bool) {
 		_, codeErr = mod.Init(thread, st.predecls)
 	})
 	if codeErr != nil {
-		st.Error(codeErr)
+		st.reportCodeError(codeErr)
 	}
 	return codeErr == nil
 }
 
+// reportCodeError reports an error returned by Starlark code. If the error
+// was caused by a safety violation, the report includes the position of the
+// offending call and the safety declared by the callee versus that required.
+func (st *ST) reportCodeError(err error) {
+	safetyErr := &starlark.SafetyFlagsError{}
+	if !errors.As(err, &safetyErr) {
+		st.Error(err)
+		return
+	}
+
+	var pos string
+	evalErr := &starlark.EvalError{}
+	if errors.As(err, &evalErr) {
+		// Skip the frames of builtins, which have no position.
+		for i := len(evalErr.CallStack) - 1; i >= 0; i-- {
+			if frame := evalErr.CallStack[i]; frame.Pos.Line > 0 {
+				pos = frame.Pos.String() + ": "
+				break
+			}
+		}
+	}
+	st.Errorf("%s%v (declared %v, required %v, missing %v)", pos, err, safetyErr.Declared, safetyErr.Required, safetyErr.Missing)
+}
+
 // RunThread tests a function which has access to a Starlark thread.
 func (st *ST) RunThread(fn func(*starlark.Thread)) {
-	if !st.safetyGiven {
-		st.requiredSafety = stSafe
+	if st.requireNoGlobalState && !st.checkNoGlobalState(runWithoutGlobals(fn)) {
+		return
 	}
+	st.runThread(fn)
+}
 
-	thread := &starlark.Thread{}
-	thread.SetParentContext(st.ctx)
-	thread.EnsureStack(100)
-	thread.RequireSafety(st.requiredSafety)
-	thread.Print = func(_ *starlark.Thread, msg string) {
-		st.Log(msg)
+// runWithoutGlobals adapts fn for checkNoGlobalState.
+func runWithoutGlobals(fn func(*starlark.Thread)) func(*starlark.Thread) (starlark.StringDict, error) {
+	return func(thread *starlark.Thread) (starlark.StringDict, error) {
+		fn(thread)
+		return nil, nil
 	}
-	for k, v := range st.locals {
-		thread.SetLocal(k, v)
+}
+
+// RunBenchmark tests a function which has access to a Starlark thread, as
+// RunThread does, and reports the mean steps, declared allocations and
+// measured memory per unit of st.N as metrics of b. This allows the
+// resource costs of code to be tracked by benchmarks over time.
+//
+// The metrics are reported per op, where each op is a unit of st.N. As the
+// test determines its own values of st.N, b.N is ignored and the reported
+// time per op is not meaningful.
+func (st *ST) RunBenchmark(b *testing.B, fn func(*starlark.Thread)) {
+	if st.requireNoGlobalState && !st.checkNoGlobalState(runWithoutGlobals(fn)) {
+		return
+	}
+	means, ok := st.runThread(fn)
+	if !ok {
+		return
 	}
+	b.ReportMetric(float64(means.steps), "steps/op")
+	b.ReportMetric(float64(means.declaredAllocs), "declared-bytes/op")
+	b.ReportMetric(float64(means.measuredAllocs), "measured-bytes/op")
+}
+
+// resourceMeans records the mean resources used per unit of st.N.
+type resourceMeans struct {
+	steps, declaredAllocs, measuredAllocs int64
+}
 
+// runThread implements RunThread, returning the mean resources used if
+// measurement succeeded.
+func (st *ST) runThread(fn func(*starlark.Thread)) (means resourceMeans, ok bool) {
+	if !st.safetyGiven {
+		st.requiredSafety = stSafe
+	}
+
+	thread := st.newThread()
+	if !st.warmUp(thread, fn) {
+		return resourceMeans{}, false
+	}
 	stats := st.measureExecution(thread, fn)
 	if st.Failed() {
-		return
+		return resourceMeans{}, false
 	}
 
 	mean := func(x int64) int64 { return (x + stats.nSum/2) / stats.nSum }
@@ -242,18 +402,21 @@ func (st *ST) RunThread(fn func(*starlark.Thread)) {
 	allocs64, ok := thread.Allocs()
 	if !ok {
 		st.Error("alloc counter invalidated")
-		return
+		return resourceMeans{}, false
 	}
 	meanDeclaredAllocs := mean(allocs64)
 	steps64, ok := thread.Steps()
 	if !ok {
 		st.Error("step counter invalidated")
-		return
+		return resourceMeans{}, false
 	}
 	meanSteps := mean(steps64)
 
+	triage := false
 	if st.maxAllocs != math.MaxInt64 && st.maxAllocs >= 0 && meanMeasuredAllocs > st.maxAllocs {
 		st.Errorf("measured memory is above maximum (%d > %d)", meanMeasuredAllocs, st.maxAllocs)
+		st.reportKeptAlive(stats)
+		triage = true
 	}
 	if st.requiredSafety.Contains(starlark.MemSafe) {
 		if meanDeclaredAllocs > st.maxAllocs {
@@ -267,8 +430,17 @@ func (st *ST) RunThread(fn func(*starlark.Thread)) {
 		}
 		if stats.allocSum > allocs && (stats.allocSum-allocs)*2 >= stats.nSum {
 			st.Errorf("measured memory is above declared allocations (%d > %d)", meanMeasuredAllocs, meanDeclaredAllocs)
+			st.reportKeptAlive(stats)
+			triage = true
 		}
 	}
+	if triage {
+		st.triage(fn)
+	}
+
+	if st.linearAllocs != nil {
+		st.checkLinearAllocs(stats.samples)
+	}
 
 	if st.maxSteps != math.MaxInt64 && st.maxSteps >= 0 && meanSteps > st.maxSteps {
 		st.Errorf("steps are above maximum (%d > %d)", meanSteps, st.maxSteps)
@@ -281,16 +453,191 @@ func (st *ST) RunThread(fn func(*starlark.Thread)) {
 			st.Errorf("execution uses CPU time which is not accounted for")
 		}
 	}
+
+	means = resourceMeans{
+		steps:          meanSteps,
+		declaredAllocs: meanDeclaredAllocs,
+		measuredAllocs: meanMeasuredAllocs,
+	}
+	if st.goldenPath != "" {
+		st.checkGolden(means)
+	}
+	return means, true
+}
+
+// newThread returns a thread configured to run the test.
+func (st *ST) newThread() *starlark.Thread {
+	thread := &starlark.Thread{}
+	thread.SetParentContext(st.ctx)
+	thread.EnsureStack(100)
+	thread.RequireSafety(st.requiredSafety)
+	thread.Print = func(_ *starlark.Thread, msg string) {
+		st.Log(msg)
+	}
+	for k, v := range st.locals {
+		thread.SetLocal(k, v)
+	}
+	return thread
 }
 
 // KeepAlive causes the memory of the passed objects to be measured.
 func (st *ST) KeepAlive(values ...interface{}) {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	st.keepAlive(keepAliveSite{pc: pcs[0]}, values)
+}
+
+func (st *ST) keepAlive(site keepAliveSite, values []interface{}) {
+	st.aliveMu.Lock()
+	defer st.aliveMu.Unlock()
+
 	st.alive = append(st.alive, values...)
+	for range values {
+		st.aliveSites = append(st.aliveSites, site)
+	}
+}
+
+// A keepAliveSite records where a value was kept alive: either a position in
+// Starlark code or the program counter of a Go caller.
+type keepAliveSite struct {
+	pos syntax.Position
+	pc  uintptr
+}
+
+func (site keepAliveSite) String() string {
+	if site.pos.IsValid() {
+		return site.pos.String()
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{site.pc}).Next()
+	if frame.File == "" {
+		return "<unknown>"
+	}
+	return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+}
+
+// reportKeptAlive logs the estimated memory kept alive from each call site
+// during the final repetition of a test, largest first.
+func (st *ST) reportKeptAlive(stats runStats) {
+	if len(stats.alive) == 0 || stats.lastN == 0 {
+		return
+	}
+
+	type siteUsage struct {
+		site   string
+		values int
+		bytes  starlark.SafeInteger
+	}
+	usages := make(map[string]*siteUsage)
+	var order []*siteUsage
+	for i, value := range stats.alive {
+		site := stats.aliveSites[i].String()
+		usage, ok := usages[site]
+		if !ok {
+			usage = &siteUsage{site: site}
+			usages[site] = usage
+			order = append(order, usage)
+		}
+		usage.values++
+		usage.bytes = starlark.SafeAdd(usage.bytes, starlark.EstimateSize(value))
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		bytesI, _ := order[i].bytes.Int64()
+		bytesJ, _ := order[j].bytes.Int64()
+		return bytesI > bytesJ
+	})
+
+	st.Log("memory kept alive per unit of st.N:")
+	for _, usage := range order {
+		bytes, ok := starlark.SafeDiv(usage.bytes, stats.lastN).Int64()
+		if !ok {
+			st.Logf("  %s: invalid size (%d values)", usage.site, usage.values)
+			continue
+		}
+		st.Logf("  %s: ~%d bytes (%d values)", usage.site, bytes, usage.values)
+	}
 }
 
 type runStats struct {
 	nSum, allocSum int64
 	stepsRequired  bool
+	samples        []allocSample
+
+	// alive and aliveSites record the values kept alive during the final
+	// repetition, which used st.N = lastN.
+	alive      []interface{}
+	aliveSites []keepAliveSite
+	lastN      int64
+}
+
+// An allocSample records the memory measured during a single repetition of
+// a test.
+type allocSample struct {
+	n, allocs int64
+}
+
+// checkLinearAllocs fits the given samples to a line and checks its slope
+// against that required by AssertLinearAllocs.
+func (st *ST) checkLinearAllocs(samples []allocSample) {
+	slope, intercept, ok := fitLine(samples)
+	if !ok {
+		st.Errorf("too few distinct values of N to fit allocations (%d samples)", len(samples))
+		return
+	}
+	st.Logf("fitted allocations: %.2f bytes per N + %.2f bytes", slope, intercept)
+	if math.Abs(slope-st.linearAllocs.slope) > st.linearAllocs.tolerance {
+		st.Errorf("allocation slope is outside tolerance (%.2f not within %.2f of %.2f)", slope, st.linearAllocs.tolerance, st.linearAllocs.slope)
+	}
+}
+
+// fitLine returns the slope and intercept of the least-squares line through
+// the given samples. It reports !ok if the samples do not contain at least
+// two distinct values of n.
+func fitLine(samples []allocSample) (slope, intercept float64, ok bool) {
+	if len(samples) < 2 {
+		return 0, 0, false
+	}
+	var meanN, meanAllocs float64
+	for _, sample := range samples {
+		meanN += float64(sample.n)
+		meanAllocs += float64(sample.allocs)
+	}
+	meanN /= float64(len(samples))
+	meanAllocs /= float64(len(samples))
+
+	var covariance, variance float64
+	for _, sample := range samples {
+		dn := float64(sample.n) - meanN
+		covariance += dn * (float64(sample.allocs) - meanAllocs)
+		variance += dn * dn
+	}
+	if variance == 0 {
+		return 0, 0, false
+	}
+	slope = covariance / variance
+	return slope, meanAllocs - slope*meanN, true
+}
+
+// warmUp runs fn the number of times set by SetWarmup, discarding the
+// resources it counts. It reports whether the test may continue.
+func (st *ST) warmUp(thread *starlark.Thread, fn func(*starlark.Thread)) bool {
+	if st.warmup <= 0 {
+		return true
+	}
+
+	snap := thread.Snapshot()
+	for i := 0; i < st.warmup; i++ {
+		st.alive = make([]interface{}, 0, 1)
+		st.aliveSites = make([]keepAliveSite, 0, 1)
+		st.N = 1
+		fn(thread)
+		st.alive = nil
+		st.aliveSites = nil
+		if st.Failed() {
+			return false
+		}
+	}
+	thread.Restore(snap)
+	return true
 }
 
 func (st *ST) measureExecution(thread *starlark.Thread, fn func(*starlark.Thread)) runStats {
@@ -300,6 +647,9 @@ func (st *ST) measureExecution(thread *starlark.Thread, fn func(*starlark.Thread
 
 	nSum := int64(0)
 	allocSum, valueTrackerAllocs := starlark.SafeInt(0), starlark.SafeInt(0)
+	var samples []allocSample
+	var lastAlive []interface{}
+	var lastAliveSites []keepAliveSite
 
 	startTime := time.Now()
 	prevN, elapsed := int64(0), time.Duration(0)
@@ -315,7 +665,17 @@ func (st *ST) measureExecution(thread *starlark.Thread, fn func(*starlark.Thread
 		}
 
 		var n int64
-		if nSum != 0 {
+		if st.nSchedule != nil {
+			allocSum64, _ := allocSum.Int64()
+			n = int64(st.nSchedule(int(prevN), Measured{
+				NSum:    int(nSum),
+				Allocs:  allocSum64,
+				Elapsed: elapsed,
+			}))
+			if n <= 0 && nSum != 0 {
+				break
+			}
+		} else if nSum != 0 {
 			n = prevN * 2
 
 			allocsPerN := starlark.SafeDiv(allocSum, nSum)
@@ -347,13 +707,17 @@ func (st *ST) measureExecution(thread *starlark.Thread, fn func(*starlark.Thread
 		}
 
 		var alive []interface{}
+		var aliveSites []keepAliveSite
 		if st.requiredSafety.Contains(starlark.MemSafe) {
 			alive = make([]interface{}, 0, n)
+			aliveSites = make([]keepAliveSite, 0, n)
 		} else {
 			alive = make([]interface{}, 0, 1)
+			aliveSites = make([]keepAliveSite, 0, 1)
 		}
 
 		st.alive = alive
+		st.aliveSites = aliveSites
 		st.N = int(n)
 
 		beforeAllocs := readMemoryUsage(st.requiredSafety.Contains(starlark.MemSafe))
@@ -361,25 +725,45 @@ func (st *ST) measureExecution(thread *starlark.Thread, fn func(*starlark.Thread
 		afterAllocs := readMemoryUsage(st.requiredSafety.Contains(starlark.MemSafe))
 
 		runtime.KeepAlive(alive)
+		runtime.KeepAlive(aliveSites)
 
 		if st.Failed() {
 			return runStats{}
 		}
 
-		// If st.alive was reallocated, the cost of its new memory block is
-		// included in the measurement. This overhead must be discounted
-		// when reasoning about the measurement.
+		// If st.alive or st.aliveSites was reallocated, the cost of its new
+		// memory block is included in the measurement. This overhead must be
+		// discounted when reasoning about the measurement.
+		sampleOverhead := starlark.SafeInt(0)
 		if cap(st.alive) != cap(alive) {
-			valueTrackerAllocs = starlark.SafeAdd(valueTrackerAllocs, starlark.EstimateMakeSize([]interface{}{}, starlark.SafeInt(cap(st.alive))))
+			sampleOverhead = starlark.EstimateMakeSize([]interface{}{}, starlark.SafeInt(cap(st.alive)))
 		}
+		if cap(st.aliveSites) != cap(aliveSites) {
+			sitesOverhead := starlark.EstimateMakeSize([]keepAliveSite{}, starlark.SafeInt(cap(st.aliveSites)))
+			sampleOverhead = starlark.SafeAdd(sampleOverhead, sitesOverhead)
+		}
+		valueTrackerAllocs = starlark.SafeAdd(valueTrackerAllocs, sampleOverhead)
 		if afterAllocs > beforeAllocs {
 			allocSum = starlark.SafeAdd(allocSum, starlark.SafeSub(afterAllocs, beforeAllocs))
 		}
+		if st.linearAllocs != nil {
+			sampleAllocs, ok := starlark.SafeSub(starlark.SafeSub(afterAllocs, beforeAllocs), sampleOverhead).Int64()
+			if !ok {
+				st.Error("alloc count invalidated")
+				return runStats{}
+			}
+			if sampleAllocs < 0 {
+				sampleAllocs = 0
+			}
+			samples = append(samples, allocSample{n: n, allocs: sampleAllocs})
+		}
 
 		nSum += n
 		prevN = n
 		elapsed = time.Since(startTime)
+		lastAlive, lastAliveSites = st.alive, st.aliveSites
 		st.alive = nil
+		st.aliveSites = nil
 	}
 
 	if allocSum64, ok := allocSum.Int64(); !ok {
@@ -404,6 +788,10 @@ func (st *ST) measureExecution(thread *starlark.Thread, fn func(*starlark.Thread
 		nSum:          nSum,
 		allocSum:      allocSum64,
 		stepsRequired: stepsRequired,
+		samples:       samples,
+		alive:         lastAlive,
+		aliveSites:    lastAliveSites,
+		lastN:         prevN,
 	}
 }
 
@@ -535,8 +923,9 @@ func st_keep_alive(thread *starlark.Thread, b *starlark.Builtin, args starlark.T
 		return nil, err
 	}
 	recv := b.Receiver().(*ST)
+	site := keepAliveSite{pos: thread.CallFrame(1).Pos}
 	for _, arg := range args {
-		recv.KeepAlive(arg)
+		recv.keepAlive(site, []interface{}{arg})
 	}
 
 	return starlark.None, nil
@@ -3,11 +3,15 @@ package startest_test
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/canonical/starlark/resolve"
 	"github.com/canonical/starlark/starlark"
 	"github.com/canonical/starlark/startest"
 )
@@ -390,7 +394,7 @@ func TestRequireSafety(t *testing.T) {
 		})
 
 		t.Run("safety=unsafe", func(t *testing.T) {
-			const expected = "cannot call builtin 'fn': feature disabled by safety constraints"
+			const expected = "startest.RunString:1:1: cannot call builtin 'fn': feature disabled by safety constraints (declared NotSafe, required (CPUSafe|MemSafe|IOSafe), missing (CPUSafe|MemSafe|IOSafe))"
 
 			fn := starlark.NewBuiltin("fn", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
 				return starlark.None, nil
@@ -409,8 +413,32 @@ func TestRequireSafety(t *testing.T) {
 			}
 		})
 
+		t.Run("safety=partial", func(t *testing.T) {
+			const expected = "startest.RunString:2:2: cannot call builtin 'fn': feature disabled by safety constraints (declared MemSafe, required (CPUSafe|MemSafe), missing CPUSafe)"
+
+			fn := starlark.NewBuiltinWithSafety("fn", starlark.MemSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+				return starlark.None, nil
+			})
+
+			dummy := &dummyBase{}
+			st := startest.From(dummy)
+			st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+			st.AddBuiltin(fn)
+			ok := st.RunString(`
+				def f():
+					fn()
+				f()
+			`)
+			if ok {
+				t.Errorf("RunString returned true")
+			}
+			if errLog := dummy.Errors(); errLog != expected {
+				t.Errorf("unexpected error(s): %#v", errLog)
+			}
+		})
+
 		t.Run("safety=undeclared", func(t *testing.T) {
-			const expected = "cannot call builtin 'fn': feature disabled by safety constraints"
+			const expected = "startest.RunString:1:1: cannot call builtin 'fn': feature disabled by safety constraints (declared NotSafe, required (CPUSafe|MemSafe|TimeSafe|IOSafe), missing (CPUSafe|MemSafe|TimeSafe|IOSafe))"
 			fn := starlark.NewBuiltin("fn", func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
 				return starlark.None, nil
 			})
@@ -588,7 +616,10 @@ func TestRequireSafetyDefault(t *testing.T) {
 
 		t.Run("method=RunString", func(t *testing.T) {
 			safetyTest(t, func(safety starlark.SafetyFlags) {
-				const expected = "cannot call builtin 'fn': feature disabled by safety constraints"
+				expected := fmt.Sprintf(
+					"startest.RunString:1:1: cannot call builtin 'fn': feature disabled by safety constraints (declared %v, required %v, missing %v)",
+					safety, startest.STSafe, startest.STSafe&^safety,
+				)
 
 				fn := starlark.NewBuiltinWithSafety("fn", safety, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
 					return starlark.None, nil
@@ -1211,3 +1242,516 @@ func TestStartestSteps(t *testing.T) {
 		`)
 	})
 }
+
+func TestAssertLinearAllocs(t *testing.T) {
+	t.Run("fit=linear", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.NotSafe)
+		st.AssertLinearAllocs(1088, 128)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				st.KeepAlive(make([]byte, 1024))
+			}
+		})
+	})
+
+	t.Run("fit=wrong-slope", func(t *testing.T) {
+		const expected = "allocation slope is outside tolerance"
+
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireSafety(starlark.NotSafe)
+		st.AssertLinearAllocs(0, 16)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				st.KeepAlive(make([]byte, 1024))
+			}
+		})
+		if !st.Failed() {
+			t.Error("expected failure")
+		}
+		if errLog := dummy.Errors(); !strings.HasPrefix(errLog, expected) {
+			t.Errorf("unexpected error(s): %#v", errLog)
+		}
+		if logs := dummy.Logs(); !strings.HasPrefix(logs, "fitted allocations: ") {
+			t.Errorf("unexpected log(s): %#v", logs)
+		}
+	})
+
+	t.Run("fit=constant", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.NotSafe)
+		st.AssertLinearAllocs(0, 64)
+		st.RunThread(func(thread *starlark.Thread) {
+			st.KeepAlive(make([]byte, 1024))
+		})
+	})
+}
+
+func TestAssertCost(t *testing.T) {
+	clone := starlark.NewBuiltin("clone", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var s string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &s); err != nil {
+			return nil, err
+		}
+		if err := thread.AddSteps(starlark.SafeInt(len(s))); err != nil {
+			return nil, err
+		}
+		if err := thread.AddAllocs(starlark.EstimateMakeSize([]byte{}, starlark.SafeInt(len(s)))); err != nil {
+			return nil, err
+		}
+		return starlark.String([]byte(s)), nil
+	})
+	clone.DeclareCost(starlark.CostSpec{StepsPerElement: 1, AllocsPerByte: 1})
+
+	input := strings.Repeat("x", 1<<20)
+	test := func(st *startest.ST) {
+		st.RequireSafety(starlark.NotSafe)
+		st.SetNSchedule(func(prevN int, measured startest.Measured) int {
+			if prevN >= 1<<16 {
+				return 0
+			}
+			return prevN*2 + 1
+		})
+		st.RunThread(func(thread *starlark.Thread) {
+			result, err := starlark.Call(thread, clone, starlark.Tuple{starlark.String(input[:st.N])}, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		})
+	}
+
+	t.Run("spec=declared", func(t *testing.T) {
+		spec, ok := clone.Cost()
+		if !ok {
+			t.Fatal("builtin has no cost")
+		}
+		st := startest.From(t)
+		st.AssertCost(spec)
+		test(st)
+	})
+
+	t.Run("spec=wrong", func(t *testing.T) {
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.AssertCost(starlark.CostSpec{StepsPerElement: 3, AllocsPerByte: 4})
+		test(st)
+		if !st.Failed() {
+			t.Error("expected failure")
+		}
+		errLog := dummy.Errors()
+		for _, expected := range []string{"steps are below minimum", "allocation slope is outside tolerance"} {
+			if !strings.Contains(errLog, expected) {
+				t.Errorf("expected error containing %q, got %#v", expected, errLog)
+			}
+		}
+	})
+}
+
+func TestRunBenchmark(t *testing.T) {
+	result := testing.Benchmark(func(b *testing.B) {
+		st := startest.From(b)
+		st.RequireSafety(starlark.MemSafe | starlark.CPUSafe)
+		st.RunBenchmark(b, func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				if err := thread.AddSteps(starlark.SafeInt(10)); err != nil {
+					st.Error(err)
+				}
+				if err := thread.AddAllocs(starlark.SafeInt(160)); err != nil {
+					st.Error(err)
+				}
+				st.KeepAlive(make([]byte, 100))
+			}
+		})
+	})
+	if result.N == 0 {
+		t.Fatal("benchmark failed")
+	}
+
+	if steps := result.Extra["steps/op"]; steps != 10 {
+		t.Errorf("incorrect steps reported: expected 10 but got %v", steps)
+	}
+	if allocs := result.Extra["declared-bytes/op"]; allocs != 160 {
+		t.Errorf("incorrect declared allocations reported: expected 160 but got %v", allocs)
+	}
+	if allocs := result.Extra["measured-bytes/op"]; allocs < 100 || allocs > 160 {
+		t.Errorf("incorrect measured allocations reported: expected about 128 but got %v", allocs)
+	}
+}
+
+func TestKeepAliveAttribution(t *testing.T) {
+	t.Run("go", func(t *testing.T) {
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireSafety(starlark.MemSafe)
+		st.SetMaxAllocs(0)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				st.KeepAlive(make([]byte, 1024))
+			}
+		})
+		if !st.Failed() {
+			t.Error("expected failure")
+		}
+		logs := dummy.Logs()
+		if !strings.Contains(logs, "memory kept alive per unit of st.N:") {
+			t.Errorf("breakdown not logged: %#v", logs)
+		}
+		if !strings.Contains(logs, "startest_test.go:") {
+			t.Errorf("Go call site not reported: %#v", logs)
+		}
+	})
+
+	t.Run("starlark", func(t *testing.T) {
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireSafety(starlark.MemSafe)
+		st.SetMaxAllocs(0)
+		st.RunString(`
+			for _ in st.ntimes():
+				st.keep_alive("a" * 1024)
+				st.keep_alive(None)
+		`)
+		if !st.Failed() {
+			t.Error("expected failure")
+		}
+		logs := dummy.Logs()
+		large := strings.Index(logs, "startest.RunString:2:")
+		small := strings.Index(logs, "startest.RunString:3:")
+		if large < 0 || small < 0 {
+			t.Errorf("Starlark call sites not reported: %#v", logs)
+		} else if large > small {
+			t.Errorf("call sites not ordered by size: %#v", logs)
+		}
+	})
+}
+
+func TestSetNSchedule(t *testing.T) {
+	t.Run("fixed", func(t *testing.T) {
+		var ns []int
+		st := startest.From(t)
+		st.RequireSafety(starlark.NotSafe)
+		st.SetNSchedule(func(prevN int, measured startest.Measured) int {
+			if prevN >= 4 {
+				return 0
+			}
+			return prevN + 1
+		})
+		st.RunThread(func(thread *starlark.Thread) {
+			ns = append(ns, st.N)
+		})
+		if expected := []int{1, 2, 3, 4}; !reflect.DeepEqual(ns, expected) {
+			t.Errorf("unexpected values of N: expected %v but got %v", expected, ns)
+		}
+	})
+
+	t.Run("measured", func(t *testing.T) {
+		var calls []startest.Measured
+		st := startest.From(t)
+		st.RequireSafety(starlark.NotSafe)
+		st.SetNSchedule(func(prevN int, measured startest.Measured) int {
+			calls = append(calls, measured)
+			if len(calls) > 2 {
+				return 0
+			}
+			return 10
+		})
+		st.RunThread(func(thread *starlark.Thread) {})
+		if len(calls) != 3 {
+			t.Fatalf("expected schedule to be called 3 times, got %d", len(calls))
+		}
+		for i, expected := range []int{0, 10, 20} {
+			if calls[i].NSum != expected {
+				t.Errorf("call %d: expected NSum %d but got %d", i, expected, calls[i].NSum)
+			}
+		}
+	})
+
+	t.Run("at-least-once", func(t *testing.T) {
+		runs := 0
+		st := startest.From(t)
+		st.RequireSafety(starlark.NotSafe)
+		st.SetNSchedule(func(int, startest.Measured) int { return 0 })
+		st.RunThread(func(thread *starlark.Thread) {
+			runs++
+		})
+		if runs != 1 {
+			t.Errorf("expected a single run, got %d", runs)
+		}
+	})
+}
+
+func TestSetWarmup(t *testing.T) {
+	// The first run of a body with a lazy cache is far more expensive than
+	// any other.
+	newBody := func(st *startest.ST) func(thread *starlark.Thread) {
+		var cache []byte
+		return func(thread *starlark.Thread) {
+			if cache == nil {
+				if err := thread.AddSteps(starlark.SafeInt(1000)); err != nil {
+					st.Error(err)
+				}
+				cache = make([]byte, 1024)
+			}
+			for i := 0; i < st.N; i++ {
+				if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+					st.Error(err)
+				}
+			}
+		}
+	}
+	once := func(prevN int, measured startest.Measured) int {
+		if prevN > 0 {
+			return 0
+		}
+		return 10
+	}
+
+	t.Run("warmup=0", func(t *testing.T) {
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetNSchedule(once)
+		st.SetMaxSteps(1)
+		st.RunThread(newBody(st))
+		if !st.Failed() {
+			t.Error("expected failure")
+		}
+	})
+
+	t.Run("warmup=1", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetNSchedule(once)
+		st.SetMinSteps(1)
+		st.SetMaxSteps(1)
+		st.SetWarmup(1)
+		st.RunThread(newBody(st))
+	})
+}
+
+func TestRecordResources(t *testing.T) {
+	run := func(st *startest.ST, stepsPerN int) {
+		st.RequireSafety(starlark.CPUSafe)
+		st.RunThread(func(thread *starlark.Thread) {
+			if err := thread.AddSteps(starlark.SafeInt(st.N * stepsPerN)); err != nil {
+				st.Error(err)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "testdata", "steps.golden")
+
+	t.Run("write", func(t *testing.T) {
+		st := startest.From(t)
+		st.RecordResources(path)
+		run(st, 100)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"steps": 100`) {
+			t.Errorf("unexpected golden file: %s", data)
+		}
+	})
+
+	t.Run("match", func(t *testing.T) {
+		st := startest.From(t)
+		st.RecordResources(path)
+		run(st, 105)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		const expected = "steps differ from golden file"
+
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RecordResources(path)
+		st.SetGoldenTolerance(0.01)
+		run(st, 105)
+		if !st.Failed() {
+			t.Error("expected failure")
+		}
+		if errLog := dummy.Errors(); !strings.HasPrefix(errLog, expected) {
+			t.Errorf("unexpected error(s): %#v", errLog)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		t.Setenv(startest.UpdateGoldenEnv, "1")
+		st := startest.From(t)
+		st.RecordResources(path)
+		run(st, 200)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"steps": 200`) {
+			t.Errorf("golden file not updated: %s", data)
+		}
+	})
+}
+
+func TestTriage(t *testing.T) {
+	leak := starlark.NewBuiltinWithSafety("leak", startest.STSafe, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := thread.AddAllocs(starlark.SafeInt(16)); err != nil {
+			return nil, err
+		}
+		return starlark.Bytes(make([]byte, 4096)), nil
+	})
+
+	dummy := &dummyBase{}
+	st := startest.From(dummy)
+	st.RequireSafety(starlark.MemSafe)
+	st.RunThread(func(thread *starlark.Thread) {
+		if err := thread.AddAllocs(starlark.SafeInt(64)); err != nil {
+			st.Error(err)
+		}
+		for i := 0; i < st.N; i++ {
+			result, err := starlark.Call(thread, leak, nil, nil)
+			if err != nil {
+				st.Error(err)
+			}
+			st.KeepAlive(result)
+		}
+	})
+	if !st.Failed() {
+		t.Fatal("expected failure")
+	}
+
+	logs := dummy.Logs()
+	for _, expected := range []string{
+		"triage: rerun with st.N = 16",
+		"triage: allocations declared per site:",
+		"  leak (<host>): 256 bytes",
+		"  <host>: 64 bytes",
+		"triage: builtin calls which allocated more than they declared:",
+		"  leak: measured ",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("expected %q in logs: %#v", expected, logs)
+		}
+	}
+	if strings.Contains(logs, "<invalid>") {
+		t.Errorf("invalid site logged: %#v", logs)
+	}
+}
+
+func TestRunConcurrentThreads(t *testing.T) {
+	t.Run("consistent", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.SetMinSteps(1)
+		st.SetMaxSteps(1)
+		st.SetMaxAllocs(16)
+		st.RunConcurrentThreads(8, func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				if err := thread.AddSteps(starlark.SafeInt(1)); err != nil {
+					st.Error(err)
+				}
+				if err := thread.AddAllocs(starlark.SafeInt(16)); err != nil {
+					st.Error(err)
+				}
+			}
+		})
+	})
+
+	t.Run("starlark", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireSafety(starlark.CPUSafe | starlark.MemSafe)
+		st.RunConcurrentThreads(4, func(thread *starlark.Thread) {
+			_, err := starlark.ExecFile(thread, "concurrent.star", `[str(i) * 3 for i in range(st_n)]`, starlark.StringDict{
+				"st_n": starlark.MakeInt(st.N),
+			})
+			if err != nil {
+				st.Error(err)
+			}
+		})
+	})
+
+	t.Run("over-budget", func(t *testing.T) {
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireSafety(starlark.CPUSafe)
+		st.SetMaxSteps(1)
+		st.RunConcurrentThreads(2, func(thread *starlark.Thread) {
+			thread.AddSteps(starlark.SafeInt(2 * st.N))
+		})
+		if !st.Failed() {
+			t.Error("expected failure")
+		} else if errs := dummy.Errors(); !strings.Contains(errs, "steps are above maximum (2 > 1)") {
+			t.Errorf("unexpected errors: %s", errs)
+		}
+	})
+}
+
+func TestRequireNoGlobalState(t *testing.T) {
+	t.Run("isolated", func(t *testing.T) {
+		st := startest.From(t)
+		st.RequireNoGlobalState()
+		st.RunString(`
+			x = [1, 2, 3]
+			for _ in st.ntimes():
+				st.keep_alive(x + x)
+		`)
+		st.RunThread(func(thread *starlark.Thread) {
+			for i := 0; i < st.N; i++ {
+				list := starlark.NewList(nil)
+				if err := thread.AddAllocs(starlark.EstimateSize(list)); err != nil {
+					st.Error(err)
+				}
+				st.KeepAlive(list)
+			}
+		})
+	})
+
+	t.Run("resolver-flag", func(t *testing.T) {
+		initial := resolve.AllowSet
+		defer func() { resolve.AllowSet = initial }()
+
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireNoGlobalState()
+		st.RunThread(func(thread *starlark.Thread) {
+			resolve.AllowSet = !resolve.AllowSet
+		})
+		expected := fmt.Sprintf("run 1 modified global state: resolve.AllowSet changed from %t to %t", initial, !initial)
+		if errLog := dummy.Errors(); errLog != expected {
+			t.Errorf("unexpected error(s): %s", errLog)
+		}
+	})
+
+	t.Run("universe", func(t *testing.T) {
+		defer delete(starlark.Universe, "leaked")
+
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireNoGlobalState()
+		st.RunThread(func(thread *starlark.Thread) {
+			starlark.Universe["leaked"] = starlark.None
+		})
+		if errLog, expected := dummy.Errors(), `run 1 modified global state: Universe["leaked"] added`; errLog != expected {
+			t.Errorf("unexpected error(s): %s", errLog)
+		}
+	})
+
+	t.Run("differing-results", func(t *testing.T) {
+		calls := 0
+		counter := starlark.NewBuiltinWithSafety("counter", startest.STSafe, func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			calls++
+			return starlark.MakeInt(calls), nil
+		})
+
+		dummy := &dummyBase{}
+		st := startest.From(dummy)
+		st.RequireNoGlobalState()
+		st.AddBuiltin(counter)
+		st.RunString(`
+			x = counter()
+		`)
+		if errLog, expected := dummy.Errors(), "global x differs between runs: 1 != 2"; errLog != expected {
+			t.Errorf("unexpected error(s): %s", errLog)
+		}
+	})
+}
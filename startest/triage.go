@@ -0,0 +1,83 @@
+package startest
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/canonical/starlark/starlark"
+)
+
+// triageN is the value of st.N with which a test is rerun to diagnose a
+// failed memory check.
+const triageN = 16
+
+// triage diagnoses a failed memory check by rerunning fn once, with st.N
+// fixed at triageN, on a fresh thread which profiles the allocations
+// declared and audits every builtin call. The declarations recorded are
+// logged alongside the failure, so that a check which fails only
+// intermittently may be diagnosed from a single failing run.
+func (st *ST) triage(fn func(*starlark.Thread)) {
+	thread := st.newThread()
+	thread.EnableAllocProfile()
+	var audits []starlark.AllocationAudit
+	thread.EnableAllocationAudit(1, func(audit starlark.AllocationAudit) {
+		audits = append(audits, audit)
+	})
+
+	alive := make([]interface{}, 0, triageN)
+	aliveSites := make([]keepAliveSite, 0, triageN)
+	st.alive = alive
+	st.aliveSites = aliveSites
+	st.N = triageN
+
+	before := readMemoryUsage(true)
+	fn(thread)
+	after := readMemoryUsage(true)
+
+	runtime.KeepAlive(alive)
+	runtime.KeepAlive(aliveSites)
+	st.alive = nil
+	st.aliveSites = nil
+
+	declared, ok := thread.Allocs()
+	if !ok {
+		st.Log("triage: alloc counter invalidated")
+		return
+	}
+	measured := after - before
+	if measured < 0 {
+		measured = 0
+	}
+	st.Logf("triage: rerun with st.N = %d declared %d bytes and measured %d bytes", triageN, declared, measured)
+
+	st.Log("triage: allocations declared per site:")
+	profile := thread.AllocProfile()
+	if len(profile) == 0 {
+		st.Log("  none")
+	}
+	for _, entry := range profile {
+		st.Logf("  %s: %d bytes", allocSiteString(entry.Site), entry.Allocs)
+	}
+
+	if len(audits) > 0 {
+		st.Log("triage: builtin calls which allocated more than they declared:")
+		for _, audit := range audits {
+			st.Logf("  %v", audit)
+		}
+	}
+}
+
+// allocSiteString describes the site at which allocations were declared.
+// Allocations declared while no Starlark code was executing, whether
+// directly by the host or by a builtin it called, have no position, and
+// those declared directly by the host have no name either.
+func allocSiteString(site starlark.AllocSite) string {
+	switch {
+	case site.Pos.IsValid():
+		return fmt.Sprintf("%s (%v)", site.Name, site.Pos)
+	case site.Name != "":
+		return site.Name + " (<host>)"
+	default:
+		return "<host>"
+	}
+}
@@ -0,0 +1,54 @@
+package syntax
+
+// This file defines functions to extract documentation from syntax trees.
+
+import "strings"
+
+// A DocString is a string literal which documents a file or function.
+type DocString struct {
+	Value string   // the unquoted text of the literal
+	Pos   Position // position of the literal
+}
+
+// DocString returns the docstring of the file, which is the string
+// literal, if any, forming its first statement.
+func (x *File) DocString() (DocString, bool) { return docStringOf(x.Stmts) }
+
+// DocString returns the docstring of the function, which is the string
+// literal, if any, forming the first statement of its body.
+func (x *DefStmt) DocString() (DocString, bool) { return docStringOf(x.Body) }
+
+func docStringOf(body []Stmt) (DocString, bool) {
+	if len(body) == 0 {
+		return DocString{}, false
+	}
+	expr, ok := body[0].(*ExprStmt)
+	if !ok {
+		return DocString{}, false
+	}
+	lit, ok := expr.X.(*Literal)
+	if !ok || lit.Token != STRING {
+		return DocString{}, false
+	}
+	return DocString{Value: lit.Value.(string), Pos: lit.TokenPos}, true
+}
+
+// LeadingComments returns the whole-line comments which immediately precede
+// the given node. The file must have been parsed with RetainComments.
+func LeadingComments(n Node) []Comment {
+	if comments := n.Comments(); comments != nil {
+		return comments.Before
+	}
+	return nil
+}
+
+// CommentText returns the text of the given comments, with the leading '#'
+// and a single following space removed from each, joined by newlines.
+func CommentText(comments []Comment) string {
+	lines := make([]string, len(comments))
+	for i, c := range comments {
+		text := strings.TrimPrefix(c.Text, "#")
+		lines[i] = strings.TrimPrefix(text, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,46 @@
+package syntax_test
+
+import (
+	"testing"
+
+	"github.com/canonical/starlark/syntax"
+)
+
+func TestDocStrings(t *testing.T) {
+	const src = `"module doc"
+
+# Leading comment
+# over two lines.
+def f():
+    "f doc"
+    pass
+
+def g():
+    x = "not a doc"
+`
+	f, err := (&syntax.FileOptions{}).Parse("a.star", src, syntax.RetainComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc, ok := f.DocString(); !ok || doc.Value != "module doc" || doc.Pos.Line != 1 {
+		t.Errorf("file docstring: got %+v, %t", doc, ok)
+	}
+
+	fdef := f.Stmts[1].(*syntax.DefStmt)
+	if doc, ok := fdef.DocString(); !ok || doc.Value != "f doc" || doc.Pos.Line != 6 {
+		t.Errorf("f docstring: got %+v, %t", doc, ok)
+	}
+	comments := syntax.LeadingComments(fdef)
+	if got, want := syntax.CommentText(comments), "Leading comment\nover two lines."; got != want {
+		t.Errorf("f comments: got %q, want %q", got, want)
+	}
+
+	gdef := f.Stmts[2].(*syntax.DefStmt)
+	if doc, ok := gdef.DocString(); ok {
+		t.Errorf("g docstring: got %+v, want none", doc)
+	}
+	if comments := syntax.LeadingComments(gdef); len(comments) != 0 {
+		t.Errorf("g comments: got %v, want none", comments)
+	}
+}
@@ -4,7 +4,13 @@
 
 package syntax
 
-import _ "unsafe" // for linkname
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	_ "unsafe" // for linkname
+)
 
 // FileOptions specifies various per-file options that affect static
 // aspects of an individual file such as parsing, name resolution, and
@@ -23,13 +29,88 @@ import _ "unsafe" // for linkname
 type FileOptions struct {
 	// resolver
 	Set               bool // allow references to the 'set' built-in function
+	StrBuilder        bool // allow references to the 'strbuilder' built-in function
 	While             bool // allow 'while' statements
 	TopLevelControl   bool // allow if/for/while statements at top-level
 	GlobalReassign    bool // allow reassignment to top-level names
 	LoadBindsGlobally bool // load creates global not file-local bindings (deprecated)
+	SliceAssign       bool // allow assignment to slices and 'del' of slices
+	StarAssign        bool // allow starred targets in assignments, as in 'a, *rest = seq'
 
 	// compiler
 	Recursion bool // disable recursion check for functions in this file
+
+	// Dialects, if non-nil, enables per-file dialect selection. A file
+	// whose leading comments contain a pragma of the form
+	//
+	//	# starlark: dialect=NAME
+	//
+	// is parsed, resolved and compiled using Dialects[NAME] in place of
+	// these options. Naming an unknown dialect is a syntax error.
+	// Files without a pragma use these options.
+	Dialects map[string]*FileOptions
+}
+
+// StandardDialects returns a new map of the standard dialects, suitable for
+// use as [FileOptions.Dialects]:
+//
+//   - "v1" is the core language, as given by the zero FileOptions;
+//   - "v2" additionally enables set, while loops, top-level control flow
+//     and global reassignment.
+func StandardDialects() map[string]*FileOptions {
+	return map[string]*FileOptions{
+		"v1": {},
+		"v2": {
+			Set:             true,
+			While:           true,
+			TopLevelControl: true,
+			GlobalReassign:  true,
+		},
+	}
+}
+
+const dialectPragma = "starlark:"
+
+// dialect returns the options selected by the dialect pragma
+// of the given source, if any.
+func (opts *FileOptions) dialect(filename string, src []byte) (*FileOptions, error) {
+	line := int32(0)
+	for len(src) > 0 {
+		line++
+		var text []byte
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			text, src = src[:i], src[i+1:]
+		} else {
+			text, src = src, nil
+		}
+		text = bytes.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+		if text[0] != '#' {
+			break // pragmas must precede the first statement
+		}
+		pragma := strings.TrimSpace(string(text[1:]))
+		if !strings.HasPrefix(pragma, dialectPragma) {
+			continue
+		}
+		setting := strings.TrimSpace(pragma[len(dialectPragma):])
+		name := strings.TrimPrefix(setting, "dialect=")
+		if name == setting {
+			continue
+		}
+		pos := MakePosition(&filename, line, 1)
+		if dialect, ok := opts.Dialects[name]; ok {
+			return dialect, nil
+		}
+		names := make([]string, 0, len(opts.Dialects))
+		for name := range opts.Dialects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, Error{pos, fmt.Sprintf("unknown dialect %q (want one of %s)", name, strings.Join(names, ", "))}
+	}
+	return opts, nil
 }
 
 // TODO(adonovan): provide a canonical flag parser for FileOptions.
@@ -21,6 +21,7 @@ type Mode uint
 
 const (
 	RetainComments Mode = 1 << iota // retain comments in AST; see Node.Comments
+	RecoverErrors                   // continue parsing after syntax errors; see ErrorList
 )
 
 // Parse calls the Parse method of LegacyFileOptions().
@@ -38,20 +39,38 @@ func Parse(filename string, src interface{}, mode Mode) (f *File, err error) {
 // The type of the argument for the src parameter must be string,
 // []byte, io.Reader, or FilePortion.
 // If src == nil, Parse parses the file specified by filename.
+//
+// If opts.Dialects is non-nil, the file may select a dialect using a
+// pragma; see [FileOptions.Dialects].
+//
+// If mode includes RecoverErrors, a syntax error in a top-level statement
+// does not end parsing. Instead, the parser skips to the next line that
+// begins at column 1 and continues from there, even if the erroneous
+// statement has unclosed brackets. In this case Parse returns
+// the statements it could parse together with an ErrorList holding every
+// error encountered, in order.
 func (opts *FileOptions) Parse(filename string, src interface{}, mode Mode) (f *File, err error) {
 	in, err := newScanner(filename, src, mode&RetainComments != 0)
 	if err != nil {
 		return nil, err
 	}
-	p := parser{options: opts, in: in}
+	if opts.Dialects != nil {
+		if opts, err = opts.dialect(filename, in.rest); err != nil {
+			return nil, err
+		}
+	}
+	p := parser{options: opts, in: in, recoverErrors: mode&RecoverErrors != 0}
 	defer p.in.recover(&err)
 
-	p.nextToken() // read first lookahead token
+	p.nextTokenOrRecover() // read first lookahead token
 	f = p.parseFile()
 	if f != nil {
 		f.Path = filename
 	}
 	p.assignComments(f)
+	if len(p.errors) > 0 {
+		return f, p.errors
+	}
 	return f, nil
 }
 
@@ -140,12 +159,19 @@ type parser struct {
 	in      *scanner
 	tok     Token
 	tokval  tokenValue
+
+	recoverErrors bool      // continue after syntax errors in top-level statements
+	errors        ErrorList // errors from which the parser has recovered
+
+	inTargets bool       // elements of lists and tuples may be starred
+	starred   *UnaryExpr // first starred element parsed while inTargets
 }
 
 // nextToken advances the scanner and returns the position of the
 // previous token.
 func (p *parser) nextToken() Position {
 	oldpos := p.tokval.pos
+	p.tok = ILLEGAL // in case the scanner reports an error
 	p.tok = p.in.nextToken(&p.tokval)
 	// enable to see the token stream
 	if debug {
@@ -162,11 +188,73 @@ func (p *parser) parseFile() *File {
 			p.nextToken()
 			continue
 		}
-		stmts = p.parseStmt(stmts)
+		if p.recoverErrors {
+			stmts = p.parseStmtOrRecover(stmts)
+		} else {
+			stmts = p.parseStmt(stmts)
+		}
 	}
 	return &File{Options: p.options, Stmts: stmts}
 }
 
+// parseStmtOrRecover parses a top-level statement. If a syntax error occurs,
+// it is recorded and the parser resumes at the start of the next top-level
+// line. If the error was found at the first token of such a line, as when
+// a bracket is left unclosed, the parser resumes from that token.
+func (p *parser) parseStmtOrRecover(stmts []Stmt) (result []Stmt) {
+	start := p.tokval.pos
+	defer func() {
+		if p.recoverError(recover()) {
+			result = stmts
+			p.inTargets, p.starred = false, nil
+			if pos := p.tokval.pos; p.tok != ILLEGAL && pos.Col == 1 && pos != start {
+				p.in.resetToTopLevel()
+			} else {
+				p.in.skipToTopLevel()
+				p.nextTokenOrRecover()
+			}
+		}
+	}()
+	return p.parseStmt(stmts)
+}
+
+// nextTokenOrRecover reads the next token. If error recovery is enabled,
+// lines on which the scanner reports an error are skipped.
+func (p *parser) nextTokenOrRecover() {
+	if !p.recoverErrors {
+		p.nextToken()
+		return
+	}
+	for {
+		ok := func() (ok bool) {
+			defer func() {
+				if p.recoverError(recover()) {
+					p.in.skipToTopLevel()
+				}
+			}()
+			p.nextToken()
+			return true
+		}()
+		if ok {
+			return
+		}
+	}
+}
+
+// recoverError records a recovered syntax error, reporting whether e was
+// one. Any other non-nil panic is propagated.
+func (p *parser) recoverError(e interface{}) bool {
+	switch e := e.(type) {
+	case nil:
+		return false
+	case Error:
+		p.errors = append(p.errors, e)
+		return true
+	default:
+		panic(e)
+	}
+}
+
 func (p *parser) parseStmt(stmts []Stmt) []Stmt {
 	if p.tok == DEF {
 		return append(stmts, p.parseDefStmt())
@@ -260,24 +348,37 @@ func (p *parser) parseWhileStmt() Stmt {
 
 // Equivalent to 'exprlist' production in Python grammar.
 //
-// loop_variables = primary_with_suffix (COMMA primary_with_suffix)* COMMA?
+// loop_variables = loop_variable (COMMA loop_variable)* COMMA?
+// loop_variable = '*'? primary_with_suffix
 func (p *parser) parseForLoopVariables() Expr {
+	inTargets, starred := p.inTargets, p.starred
+	p.inTargets = true
+
 	// Avoid parseExpr because it would consume the IN token
 	// following x in "for x in y: ...".
-	v := p.parsePrimaryWithSuffix()
-	if p.tok != COMMA {
-		return v
+	v := p.parseForLoopVariable()
+	if p.tok == COMMA {
+		list := []Expr{v}
+		for p.tok == COMMA {
+			p.nextToken()
+			if terminatesExprList(p.tok) {
+				break
+			}
+			list = append(list, p.parseForLoopVariable())
+		}
+		v = &TupleExpr{List: list}
 	}
 
-	list := []Expr{v}
-	for p.tok == COMMA {
-		p.nextToken()
-		if terminatesExprList(p.tok) {
-			break
-		}
-		list = append(list, p.parsePrimaryWithSuffix())
+	p.inTargets, p.starred = inTargets, starred
+	return v
+}
+
+func (p *parser) parseForLoopVariable() Expr {
+	if p.tok != STAR {
+		return p.parsePrimaryWithSuffix()
 	}
-	return &TupleExpr{List: list}
+	pos := p.nextToken() // consume STAR
+	return p.starredTarget(pos, p.parsePrimaryWithSuffix())
 }
 
 // simple_stmt = small_stmt (SEMI small_stmt)* SEMI? NEWLINE
@@ -304,6 +405,7 @@ func (p *parser) parseSimpleStmt(stmts []Stmt, consumeNL bool) []Stmt {
 // small_stmt = RETURN expr?
 //
 //	| PASS | BREAK | CONTINUE
+//	| DEL expr
 //	| LOAD ...
 //	| expr ('=' | '+=' | '-=' | '*=' | '/=' | '%=' | '&=' | '|=' | '^=' | '<<=' | '>>=') expr   // assign
 //	| expr
@@ -322,12 +424,17 @@ func (p *parser) parseSmallStmt() Stmt {
 		pos := p.nextToken() // consume it
 		return &BranchStmt{Token: tok, TokenPos: pos}
 
+	case DEL:
+		pos := p.nextToken() // consume DEL
+		x := p.parseExpr(false)
+		return &DelStmt{Del: pos, X: x}
+
 	case LOAD:
 		return p.parseLoadStmt()
 	}
 
 	// Assignment
-	x := p.parseExpr(false)
+	x, starred := p.parseTargets()
 	switch p.tok {
 	case EQ, PLUS_EQ, MINUS_EQ, STAR_EQ, SLASH_EQ, SLASHSLASH_EQ, PERCENT_EQ, AMP_EQ, PIPE_EQ, CIRCUMFLEX_EQ, LTLT_EQ, GTGT_EQ:
 		op := p.tok
@@ -335,6 +442,9 @@ func (p *parser) parseSmallStmt() Stmt {
 		rhs := p.parseExpr(false)
 		return &AssignStmt{OpPos: pos, Op: op, LHS: x, RHS: rhs}
 	}
+	if starred != nil {
+		p.in.error(starred.OpPos, "starred expression is only allowed as an assignment target")
+	}
 
 	// Expression statement (e.g. function call, doc string).
 	return &ExprStmt{X: x}
@@ -509,7 +619,7 @@ func (p *parser) parseParams() []Expr {
 // In many cases we must use parseTest to avoid ambiguity such as
 // f(x, y) vs. f((x, y)).
 func (p *parser) parseExpr(inParens bool) Expr {
-	x := p.parseTest()
+	x := p.parseElem()
 	if p.tok != COMMA {
 		return x
 	}
@@ -519,6 +629,56 @@ func (p *parser) parseExpr(inParens bool) Expr {
 	return &TupleExpr{List: exprs}
 }
 
+// parseTargets parses the expression at the start of a statement, which
+// may be the left-hand side of an assignment and so, unlike parseExpr, may
+// contain starred elements, including within parenthesized tuples and
+// lists. It returns the first starred element, if any.
+// target_list = elem (',' elem)* ','?
+func (p *parser) parseTargets() (Expr, *UnaryExpr) {
+	inTargets, starred := p.inTargets, p.starred
+	p.inTargets, p.starred = true, nil
+
+	x := p.parseElem()
+	if p.tok == COMMA {
+		// tuple
+		exprs := []Expr{x}
+		for p.tok == COMMA {
+			pos := p.nextToken()
+			if terminatesExprList(p.tok) {
+				p.in.error(pos, "unparenthesized tuple with trailing comma")
+				break
+			}
+			exprs = append(exprs, p.parseElem())
+		}
+		x = &TupleExpr{List: exprs}
+	}
+
+	first := p.starred
+	p.inTargets, p.starred = inTargets, starred
+	return x, first
+}
+
+// parseElem parses an element of a list or tuple, which may be starred
+// if it is part of an assignment target.
+// elem = '*'? test
+func (p *parser) parseElem() Expr {
+	if p.tok != STAR || !p.inTargets {
+		return p.parseTest()
+	}
+	pos := p.nextToken() // consume STAR
+	return p.starredTarget(pos, p.parseTest())
+}
+
+// starredTarget returns the starred target *x, recording it if it is the
+// first starred element of the current targets.
+func (p *parser) starredTarget(pos Position, x Expr) *UnaryExpr {
+	starred := &UnaryExpr{OpPos: pos, Op: STAR, X: x}
+	if p.starred == nil {
+		p.starred = starred
+	}
+	return starred
+}
+
 // parseExprs parses a comma-separated list of expressions, starting with the comma.
 // It is used to parse tuples and list elements.
 // expr_list = (',' expr)* ','?
@@ -531,7 +691,7 @@ func (p *parser) parseExprs(exprs []Expr, allowTrailingComma bool) []Expr {
 			}
 			break
 		}
-		exprs = append(exprs, p.parseTest())
+		exprs = append(exprs, p.parseElem())
 	}
 	return exprs
 }
@@ -879,7 +1039,7 @@ func (p *parser) parseList() Expr {
 		return &ListExpr{Lbrack: lbrack, Rbrack: rbrack}
 	}
 
-	x := p.parseTest()
+	x := p.parseElem()
 
 	if p.tok == FOR {
 		// list comprehension
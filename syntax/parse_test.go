@@ -7,6 +7,7 @@ package syntax_test
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"go/build"
 	"os"
@@ -142,6 +143,10 @@ func TestStmtParseTrees(t *testing.T) {
 			`(ReturnStmt Result=(TupleExpr List=(1 2)))`},
 		{`return`,
 			`(ReturnStmt)`},
+		{`del x[1:2]`,
+			`(DelStmt X=(SliceExpr X=x Lo=1 Hi=2))`},
+		{`a, *b = c`,
+			`(AssignStmt Op== LHS=(TupleExpr List=(a (UnaryExpr Op=* X=b))) RHS=c)`},
 		{`for i in "abc": break`,
 			`(ForStmt Vars=i X="abc" Body=((BranchStmt Token=break)))`},
 		{`for i in "abc": continue`,
@@ -485,3 +490,126 @@ func BenchmarkParse(b *testing.B) {
 		}
 	}
 }
+
+func TestDialectPragma(t *testing.T) {
+	opts := &syntax.FileOptions{Dialects: syntax.StandardDialects()}
+	for _, test := range []struct {
+		src  string
+		want *syntax.FileOptions
+		err  string
+	}{
+		{src: "x = 1", want: opts},
+		{src: "# starlark: dialect=v1\nx = 1", want: opts.Dialects["v1"]},
+		{src: "\n# Copyright\n\n#starlark:dialect=v2\nx = 1", want: opts.Dialects["v2"]},
+		{src: "x = 1\n# starlark: dialect=v2", want: opts},
+		{src: "# starlark: dialect=v3\nx = 1", err: `a.star:1:1: unknown dialect "v3" (want one of v1, v2)`},
+	} {
+		f, err := opts.Parse("a.star", test.src, 0)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: got error %v, want %s", test.src, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.src, err)
+			continue
+		}
+		if f.Options != test.want {
+			t.Errorf("%q: got options %+v, want %+v", test.src, f.Options, test.want)
+		}
+	}
+
+	// Without Dialects, pragmas are ordinary comments.
+	f, err := (&syntax.FileOptions{}).Parse("a.star", "# starlark: dialect=v3\nx = 1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Options.Set {
+		t.Error("pragma applied without Dialects")
+	}
+}
+
+func TestParseRecoverErrors(t *testing.T) {
+	const src = `x = 1
+def f(:
+    return 1
+y = (2
+z = 3
+w = $
+if True:
+    v = 4
+u = 5 +
+`
+	f, err := syntax.LegacyFileOptions().Parse("a.star", src, syntax.RecoverErrors)
+	if f == nil {
+		t.Fatalf("no partial file returned: %v", err)
+	}
+	var errs syntax.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want ErrorList", err)
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"a.star:2:8: got ':', want ')'",
+		"a.star:5:2: got identifier, want ')'",
+		"a.star:6:5: unexpected input character '$'",
+		"a.star:9:8: got newline, want primary expression",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors:\ngot  %q\nwant %q", got, want)
+	}
+
+	var names []string
+	for _, stmt := range f.Stmts {
+		switch stmt := stmt.(type) {
+		case *syntax.AssignStmt:
+			names = append(names, stmt.LHS.(*syntax.Ident).Name)
+		case *syntax.IfStmt:
+			names = append(names, "if")
+		}
+	}
+	if want := []string{"x", "z", "if"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("statements: got %v, want %v", names, want)
+	}
+
+	// Without RecoverErrors, parsing stops at the first error.
+	if _, err := syntax.LegacyFileOptions().Parse("a.star", src, 0); err == nil || err.Error() != want[0] {
+		t.Errorf("got error %v, want %s", err, want[0])
+	}
+}
+
+func TestParseRecoverErrorsAtLineStart(t *testing.T) {
+	// Errors reported by the scanner at the first character of a line
+	// discard that line.
+	const src = `)
+x = [1
+$
+y = 2
+`
+	f, err := syntax.LegacyFileOptions().Parse("a.star", src, syntax.RecoverErrors)
+	if f == nil {
+		t.Fatalf("no partial file returned: %v", err)
+	}
+	var errs syntax.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want ErrorList", err)
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"a.star:1:1: unexpected ')'",
+		"a.star:3:1: unexpected input character '$'",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors:\ngot  %q\nwant %q", got, want)
+	}
+	if len(f.Stmts) != 1 || f.Stmts[0].(*syntax.AssignStmt).LHS.(*syntax.Ident).Name != "y" {
+		t.Errorf("got %d statements, want only y", len(f.Stmts))
+	}
+}
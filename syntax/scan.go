@@ -84,6 +84,7 @@ const (
 	BREAK
 	CONTINUE
 	DEF
+	DEL
 	ELIF
 	ELSE
 	FOR
@@ -167,6 +168,7 @@ var tokenNames = [...]string{
 	BREAK:         "break",
 	CONTINUE:      "continue",
 	DEF:           "def",
+	DEL:           "del",
 	ELIF:          "elif",
 	ELSE:          "else",
 	FOR:           "for",
@@ -309,6 +311,20 @@ type Error struct {
 
 func (e Error) Error() string { return e.Pos.String() + ": " + e.Msg }
 
+// An ErrorList is a list of errors reported while parsing a file in
+// RecoverErrors mode.
+type ErrorList []Error
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", list[0], len(list)-1)
+}
+
 // errorf is called to report an error.
 // errorf does not return: it panics.
 func (sc *scanner) error(pos Position, s string) {
@@ -337,6 +353,38 @@ func (sc *scanner) recover(err *error) {
 	}
 }
 
+// skipToTopLevel discards input up to the start of the next line which
+// begins at column 1 with neither space nor a comment, and resets the
+// scanner to scan a new top-level statement from there. At least the
+// remainder of the current line is always discarded, unless the scanner
+// is at the start of a line it has yet to scan.
+func (sc *scanner) skipToTopLevel() {
+	if !sc.lineStart {
+		for !sc.eof() && sc.readRune() != '\n' {
+		}
+	}
+	for !sc.eof() {
+		switch sc.peekRune() {
+		case ' ', '\t', '\n', '#':
+			for !sc.eof() && sc.readRune() != '\n' {
+			}
+			continue
+		}
+		break
+	}
+	sc.resetToTopLevel()
+	sc.lineStart = true
+}
+
+// resetToTopLevel discards the nesting and indentation state of the
+// scanner, so that the tokens which follow are scanned as part of a new
+// top-level statement.
+func (sc *scanner) resetToTopLevel() {
+	sc.depth = 0
+	sc.indentstk = sc.indentstk[:1]
+	sc.dents = 0
+}
+
 // eof reports whether the input has reached end of file.
 func (sc *scanner) eof() bool {
 	return len(sc.rest) == 0 && !sc.readLine()
@@ -1090,6 +1138,7 @@ var keywordToken = map[string]Token{
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"def":      DEF,
+	"del":      DEL,
 	"elif":     ELIF,
 	"else":     ELSE,
 	"for":      FOR,
@@ -1109,7 +1158,6 @@ var keywordToken = map[string]Token{
 	"async":    ILLEGAL,
 	"await":    ILLEGAL,
 	"class":    ILLEGAL,
-	"del":      ILLEGAL,
 	"except":   ILLEGAL,
 	"finally":  ILLEGAL,
 	"from":     ILLEGAL,
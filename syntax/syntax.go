@@ -92,6 +92,7 @@ type Stmt interface {
 func (*AssignStmt) stmt() {}
 func (*BranchStmt) stmt() {}
 func (*DefStmt) stmt()    {}
+func (*DelStmt) stmt()    {}
 func (*ExprStmt) stmt()   {}
 func (*ForStmt) stmt()    {}
 func (*WhileStmt) stmt()  {}
@@ -201,6 +202,20 @@ func (x *BranchStmt) Span() (start, end Position) {
 	return x.TokenPos, x.TokenPos.add(x.Token.String())
 }
 
+// A DelStmt deletes part of a value:
+//
+//	del x[i:j]
+type DelStmt struct {
+	commentsRef
+	Del Position
+	X   Expr
+}
+
+func (x *DelStmt) Span() (start, end Position) {
+	_, end = x.X.Span()
+	return x.Del, end
+}
+
 // A ReturnStmt returns from a function.
 type ReturnStmt struct {
 	commentsRef
@@ -49,6 +49,9 @@ func Walk(n Node, f func(Node) bool) {
 		Walk(n.X, f)
 		walkStmts(n.Body, f)
 
+	case *DelStmt:
+		Walk(n.X, f)
+
 	case *ReturnStmt:
 		if n.Result != nil {
 			Walk(n.Result, f)